@@ -0,0 +1,140 @@
+// Command consul-target-lint validates consul:// target URLs.
+//
+// The targets are passed as arguments or, if none are passed, read from stdin,
+// one per line. Empty lines and lines starting with # are ignored.
+// For every problem a line in the format:
+//
+//	<target>: error|warning: <message>
+//
+// is printed. The exit code is 1 if a target has errors, 2 on usage errors.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/simplesurance/grpcconsulresolver/consul"
+)
+
+type severity string
+
+const (
+	severityError   severity = "error"
+	severityWarning severity = "warning"
+)
+
+type finding struct {
+	severity severity
+	msg      string
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [TARGET]...\n", os.Args[0])
+		fmt.Fprintln(flag.CommandLine.Output(), "Validates consul:// target URLs, if no TARGET is passed they are read from stdin.")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	targets := flag.Args()
+	if len(targets) == 0 {
+		var err error
+		targets, err = readTargets(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "reading stdin failed:", err)
+			os.Exit(2)
+		}
+	}
+
+	var failed bool
+	for _, target := range targets {
+		for _, f := range lint(target) {
+			fmt.Printf("%s: %s: %s\n", redactToken(target), f.severity, f.msg)
+			if f.severity == severityError {
+				failed = true
+			}
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// tokenParam matches the token option of a target URL.
+var tokenParam = regexp.MustCompile(`(?i)([?&]token=)[^&#]*`)
+
+// redactToken returns target with the value of the token option replaced,
+// to not expose it in the output.
+func redactToken(target string) string {
+	return tokenParam.ReplaceAllString(target, "${1}[redacted]")
+}
+
+func readTargets(r io.Reader) ([]string, error) {
+	var result []string
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		result = append(result, line)
+	}
+
+	return result, sc.Err()
+}
+
+// lint returns the problems found in target.
+func lint(target string) []finding {
+	u, err := url.Parse(target)
+	if err != nil {
+		return []finding{{severityError, fmt.Sprintf("invalid URL encoding: %s", errors.Unwrap(err))}}
+	}
+
+	if u.Opaque != "" {
+		return []finding{{severityError, fmt.Sprintf("target has no path, use %s:///%s", u.Scheme, u.Opaque)}}
+	}
+
+	if u.Host != "" && strings.Trim(u.Path, "/") == "" {
+		return []finding{{
+			severityError,
+			fmt.Sprintf("service name is missing, the host part (%q) is the address of the Consul server, use %s:///%s to query the default Consul server",
+				u.Host, u.Scheme, u.Host),
+		}}
+	}
+
+	query, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return []finding{{severityError, fmt.Sprintf("invalid query encoding: %s", err)}}
+	}
+
+	var result []finding
+	if _, err := consul.ParseTarget(target); err != nil {
+		var unsupportedErr *consul.UnsupportedOptionError
+		if errors.As(err, &unsupportedErr) {
+			result = append(result, finding{severityError, fmt.Sprintf("unsupported option '%s', options are case-insensitive, see the package documentation for the supported ones", unsupportedErr.Name)})
+		} else {
+			result = append(result, finding{severityError, err.Error()})
+		}
+	}
+
+	for key, values := range query {
+		if len(values) > 1 {
+			result = append(result, finding{severityWarning, fmt.Sprintf("option '%s' is set %d times, only the last value (%q) is used", key, len(values), values[len(values)-1])})
+		}
+
+		if lower := strings.ToLower(key); lower != key {
+			result = append(result, finding{severityWarning, fmt.Sprintf("option '%s' is not lowercase, write it as '%s'", key, lower)})
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	tests := []struct {
+		target       string
+		wantSeverity severity
+		wantMsg      string
+	}{
+		{"consul:///user-service?health=healthy", "", ""},
+		{"consul://user-service", severityError, "service name is missing"},
+		{"consul:user-service", severityError, "use consul:///user-service"},
+		{"consul:///user%zz", severityError, "invalid URL encoding"},
+		{"consul:///user-service?tags=%zz", severityError, "invalid query encoding"},
+		{"consul:///user-service?weight=1", severityError, "unsupported option 'weight'"},
+		{"consul:///user-service?health=sometimes", severityError, "unsupported health parameter value"},
+		{"consul:///user-service?dc=a&dc=b", severityWarning, `only the last value ("b") is used`},
+		{"consul:///user-service?Tags=a", severityWarning, "write it as 'tags'"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			findings := lint(tt.target)
+			if tt.wantMsg == "" {
+				if len(findings) != 0 {
+					t.Errorf("lint() returned %+v, expected no findings", findings)
+				}
+				return
+			}
+
+			if len(findings) != 1 {
+				t.Fatalf("lint() returned %+v, expected 1 finding", findings)
+			}
+
+			if findings[0].severity != tt.wantSeverity || !strings.Contains(findings[0].msg, tt.wantMsg) {
+				t.Errorf("lint() returned %+v, expected %s containing %q", findings[0], tt.wantSeverity, tt.wantMsg)
+			}
+		})
+	}
+}
+
+func TestRedactToken(t *testing.T) {
+	for _, tc := range []struct {
+		target string
+		want   string
+	}{
+		{"consul:///svc?token=secret", "consul:///svc?token=[redacted]"},
+		{"consul:///svc?tags=a&TOKEN=secret&dc=x", "consul:///svc?tags=a&TOKEN=[redacted]&dc=x"},
+		{"consul:///svc?tags=token", "consul:///svc?tags=token"},
+	} {
+		if got := redactToken(tc.target); got != tc.want {
+			t.Errorf("redactToken(%q) returned %q, expected %q", tc.target, got, tc.want)
+		}
+	}
+}
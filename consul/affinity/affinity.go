@@ -0,0 +1,147 @@
+// Package affinity provides a gRPC load balancer that sends requests with the
+// same affinity key to the same Consul service instance.
+//
+// Keys are mapped to the service instances, identified by the node name and
+// service ID the consul resolver attaches to the addresses, with
+// [rendezvous hashing]. When an instance is added, only the keys that are
+// mapped to it move. When an instance is removed, only the keys that were
+// mapped to it move. An instance that is re-registered with a different
+// address keeps its keys.
+// Addresses without an instance key are hashed by their address.
+//
+// The balancer is registered with the name [Name] and uses the value of the
+// [DefaultHeader] metadata header as key. It can be selected with the service
+// config:
+//
+//	grpc.Dial("consul://127.0.0.1:8500/user-service",
+//		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"consul_affinity":{}}]}`),
+//	)
+//
+// [rendezvous hashing]: https://en.wikipedia.org/wiki/Rendezvous_hashing
+package affinity
+
+import (
+	"context"
+	"math/rand"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/consul"
+	"github.com/simplesurance/grpcconsulresolver/internal/keyhash"
+)
+
+// Name is the name of the balancer registered by the package.
+const Name = "consul_affinity"
+
+// DefaultHeader is the metadata header that contains the affinity key of
+// requests sent via the balancer registered by the package.
+const DefaultHeader = "x-affinity-key"
+
+func init() {
+	balancer.Register(NewBuilder(Name, MetadataKey(DefaultHeader)))
+}
+
+// KeyFunc returns the affinity key of a request. ctx is the context of the
+// RPC and method its full method name.
+// Requests with an empty key are sent to a random instance.
+type KeyFunc func(ctx context.Context, method string) string
+
+// MetadataKey returns a KeyFunc that uses the first value of the outgoing
+// metadata header as key.
+func MetadataKey(header string) KeyFunc {
+	return func(ctx context.Context, _ string) string {
+		md, _ := metadata.FromOutgoingContext(ctx)
+		if v := md.Get(header); len(v) > 0 {
+			return v[0]
+		}
+
+		return ""
+	}
+}
+
+// NewBuilder returns a balancer builder with the name that determines the
+// affinity key of requests with key. It can be registered with
+// [balancer.Register] to use a different key than the balancer registered
+// by the package.
+func NewBuilder(name string, key KeyFunc) balancer.Builder {
+	return base.NewBalancerBuilder(name, &pickerBuilder{key: key}, base.Config{HealthCheck: true})
+}
+
+type pickerBuilder struct {
+	key KeyFunc
+}
+
+type instance struct {
+	sc   balancer.SubConn
+	id   string
+	addr string
+}
+
+func (b *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	instances := make([]instance, 0, len(info.ReadySCs))
+	for sc, sci := range info.ReadySCs {
+		instances = append(instances, instance{sc: sc, id: instanceID(sci.Address), addr: sci.Address.Addr})
+	}
+
+	return &picker{key: b.key, instances: instances}
+}
+
+// instanceID returns the Consul instance key of addr, or its address if it
+// is unknown.
+func instanceID(addr resolver.Address) string {
+	if k, ok := consul.InstanceKey(addr); ok {
+		return k
+	}
+
+	return addr.Addr
+}
+
+type picker struct {
+	key       KeyFunc
+	instances []instance
+}
+
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	key := p.key(info.Ctx, info.FullMethodName)
+	if key == "" {
+		return balancer.PickResult{SubConn: p.instances[rand.Intn(len(p.instances))].sc}, nil
+	}
+
+	return balancer.PickResult{SubConn: p.instances[rendezvous(key, p.instances)].sc}, nil
+}
+
+// rendezvous returns the index of the instance with the highest score for
+// key. Ties are broken by the instance ID and the address, the result does
+// not depend on the order of instances.
+func rendezvous(key string, instances []instance) int {
+	var best int
+	var bestScore uint64
+
+	for i, inst := range instances {
+		s := score(key, inst.id)
+		if i == 0 || s > bestScore || (s == bestScore && less(inst, instances[best])) {
+			best, bestScore = i, s
+		}
+	}
+
+	return best
+}
+
+func less(a, b instance) bool {
+	if a.id != b.id {
+		return a.id < b.id
+	}
+
+	return a.addr < b.addr
+}
+
+func score(key, id string) uint64 {
+	return keyhash.Sum64(key, id)
+}
@@ -0,0 +1,187 @@
+package affinity
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/consul"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+// lookup resolves instances via a fake Consul server, to get addresses with
+// the attributes set by the consul resolver.
+func lookup(t *testing.T, instances map[string]string) []resolver.Address {
+	t.Helper()
+
+	var entries []*consulapi.ServiceEntry
+	for id, addr := range instances {
+		entries = append(entries, &consulapi.ServiceEntry{
+			Service: &consulapi.AgentService{ID: id, Address: addr, Port: 80},
+		})
+	}
+
+	return lookupEntries(t, entries)
+}
+
+// lookupEntries resolves entries via a fake Consul server.
+func lookupEntries(t *testing.T, entries []*consulapi.ServiceEntry) []resolver.Address {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Consul-Index", "1")
+		_ = json.NewEncoder(w).Encode(entries)
+	}))
+	t.Cleanup(srv.Close)
+
+	addrs, err := consul.Lookup(context.Background(), fmt.Sprintf("consul://%s/user-service", srv.Listener.Addr()))
+	if err != nil {
+		t.Fatal("Lookup() failed:", err)
+	}
+
+	return addrs
+}
+
+func buildPicker(addrs []resolver.Address) balancer.Picker {
+	info := base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{}}
+	for _, a := range addrs {
+		info.ReadySCs[&fakeSubConn{addr: a.Addr}] = base.SubConnInfo{Address: a}
+	}
+
+	return (&pickerBuilder{key: MetadataKey(DefaultHeader)}).Build(info)
+}
+
+// pickAll returns the address of the instance picked for each key.
+func pickAll(t *testing.T, p balancer.Picker, keys int) []string {
+	t.Helper()
+
+	result := make([]string, keys)
+	for i := range result {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), DefaultHeader, fmt.Sprintf("session-%d", i))
+
+		res, err := p.Pick(balancer.PickInfo{Ctx: ctx, FullMethodName: "/svc/Method"})
+		if err != nil {
+			t.Fatal("Pick() failed:", err)
+		}
+
+		result[i] = res.SubConn.(*fakeSubConn).addr
+	}
+
+	return result
+}
+
+func TestKeysAreStable(t *testing.T) {
+	p := buildPicker(lookup(t, map[string]string{"a": "10.0.0.1", "b": "10.0.0.2", "c": "10.0.0.3"}))
+
+	first := pickAll(t, p, 100)
+	second := pickAll(t, p, 100)
+
+	perInstance := map[string]int{}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Errorf("key %d was picked for %s and %s", i, first[i], second[i])
+		}
+		perInstance[first[i]]++
+	}
+
+	if len(perInstance) != 3 {
+		t.Errorf("keys were distributed to %v, expected all 3 instances", perInstance)
+	}
+}
+
+func TestOnlyKeysOfAddedInstanceMove(t *testing.T) {
+	before := pickAll(t, buildPicker(lookup(t, map[string]string{"a": "10.0.0.1", "b": "10.0.0.2", "c": "10.0.0.3"})), 200)
+	after := pickAll(t, buildPicker(lookup(t, map[string]string{"a": "10.0.0.1", "b": "10.0.0.2", "c": "10.0.0.3", "d": "10.0.0.4"})), 200)
+
+	var moved int
+	for i := range before {
+		if before[i] == after[i] {
+			continue
+		}
+
+		moved++
+		if after[i] != "10.0.0.4:80" {
+			t.Errorf("key %d moved from %s to %s, expected only moves to the added instance", i, before[i], after[i])
+		}
+	}
+
+	if moved == 0 {
+		t.Error("no key moved to the added instance")
+	}
+}
+
+func TestKeysFollowServiceID(t *testing.T) {
+	before := pickAll(t, buildPicker(lookup(t, map[string]string{"a": "10.0.0.1", "b": "10.0.0.2", "c": "10.0.0.3"})), 100)
+	after := pickAll(t, buildPicker(lookup(t, map[string]string{"a": "10.0.0.1", "b": "10.0.0.2", "c": "10.0.0.9"})), 100)
+
+	for i := range before {
+		want := before[i]
+		if want == "10.0.0.3:80" {
+			want = "10.0.0.9:80"
+		}
+
+		if after[i] != want {
+			t.Errorf("key %d was picked for %s after instance c changed its address, expected %s", i, after[i], want)
+		}
+	}
+}
+
+func TestSameServiceIDOnDifferentNodes(t *testing.T) {
+	var entries []*consulapi.ServiceEntry
+	for i := 1; i <= 3; i++ {
+		entries = append(entries, &consulapi.ServiceEntry{
+			Node:    &consulapi.Node{Node: fmt.Sprintf("node-%d", i)},
+			Service: &consulapi.AgentService{ID: "user-service", Address: fmt.Sprintf("10.0.0.%d", i), Port: 80},
+		})
+	}
+	addrs := lookupEntries(t, entries)
+
+	first := pickAll(t, buildPicker(addrs), 100)
+
+	perInstance := map[string]int{}
+	for _, a := range first {
+		perInstance[a]++
+	}
+
+	if len(perInstance) != 3 {
+		t.Errorf("keys were distributed to %v, expected all 3 instances", perInstance)
+	}
+
+	for n := 0; n < 10; n++ {
+		next := pickAll(t, buildPicker(addrs), 100)
+		for i := range first {
+			if first[i] != next[i] {
+				t.Fatalf("key %d was picked for %s and %s after rebuilding the picker", i, first[i], next[i])
+			}
+		}
+	}
+}
+
+func TestEqualIDsArePickedDeterministically(t *testing.T) {
+	instances := []instance{
+		{id: "x", addr: "10.0.0.2:80"},
+		{id: "x", addr: "10.0.0.1:80"},
+	}
+
+	if i := rendezvous("key", instances); instances[i].addr != "10.0.0.1:80" {
+		t.Errorf("rendezvous() picked %s, expected the lower address 10.0.0.1:80", instances[i].addr)
+	}
+
+	instances[0], instances[1] = instances[1], instances[0]
+	if i := rendezvous("key", instances); instances[i].addr != "10.0.0.1:80" {
+		t.Errorf("rendezvous() picked %s after reordering, expected the lower address 10.0.0.1:80", instances[i].addr)
+	}
+}
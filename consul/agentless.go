@@ -0,0 +1,23 @@
+package consul
+
+import "fmt"
+
+// validateAgentless returns an error if t can not be resolved by querying
+// Consul servers directly, without a local Consul agent.
+// servers are the addresses passed to [WithAgentless].
+func (t *Target) validateAgentless(servers []string) error {
+	if t.DC == "" {
+		return fmt.Errorf("%w: the dc option is required in agentless mode", ErrMissingDatacenter)
+	}
+
+	// the name of the local node is retrieved from the agent
+	if t.LocalNode != LocalNodeUndefined {
+		return &UnsupportedOptionError{Name: "local-node"}
+	}
+
+	if t.ConsulSRV && len(servers) != 0 {
+		return &UnsupportedOptionError{Name: "consul-srv"}
+	}
+
+	return nil
+}
@@ -0,0 +1,103 @@
+package consul
+
+import (
+	"errors"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestAgentlessValidatesTarget(t *testing.T) {
+	tests := []struct {
+		target  string
+		servers []string
+		wantErr error
+	}{
+		{"consul:///user-service", nil, ErrMissingDatacenter},
+		{"consul:///user-service?dc=dc1&local-node=first", nil, &UnsupportedOptionError{Name: "local-node"}},
+		{"consul://consul.internal/user-service?dc=dc1&consul-srv=true", []string{"10.0.0.1:8500"}, &UnsupportedOptionError{Name: "consul-srv"}},
+		{"consul://consul.internal/user-service?dc=dc1&consul-srv=true", nil, nil},
+		{"consul:///user-service?dc=dc1", []string{"10.0.0.1:8500"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			target, err := ParseTarget(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = target.validateAgentless(tt.servers)
+
+			var optErr *UnsupportedOptionError
+			switch want := tt.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Errorf("validateAgentless() returned %v, want nil", err)
+				}
+			case *UnsupportedOptionError:
+				if !errors.As(err, &optErr) || optErr.Name != want.Name {
+					t.Errorf("validateAgentless() returned %v, want %v", err, want)
+				}
+			default:
+				if !errors.Is(err, want) {
+					t.Errorf("validateAgentless() returned %v, want %v", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAgentlessAllowsStaleReads(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{{Address: "10.0.0.1", Port: 1}})
+
+	target, err := ParseTarget("consul:///user-service?dc=dc1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		opts      []BuilderOption
+		wantStale bool
+	}{
+		{"agent", nil, false},
+		{"agentless", []BuilderOption{WithAgentless("10.0.0.1:8500", "10.0.0.2:8500")}, true},
+		{
+			"agentless consistent",
+			[]BuilderOption{WithAgentless(), WithQueryOptions(consul.QueryOptions{RequireConsistent: true})},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts builderOptions
+			for _, o := range tt.opts {
+				o(&opts)
+			}
+			opts.clock = newFakeClock()
+
+			r, err := newConsulResolver(mocks.NewClientConn(), target, &opts)
+			if err != nil {
+				t.Fatal("newConsulResolver() failed:", err)
+			}
+			defer r.Close()
+
+			r.poll()
+			if stale := health.LastQueryOptions().AllowStale; stale != tt.wantStale {
+				t.Errorf("query allowed stale reads: %t, want %t", stale, tt.wantStale)
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package consul
+
+import (
+	"context"
+	"maps"
+	"slices"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/resolver"
+)
+
+// TLSServerNameMetaKey is the key of the service meta field that contains
+// the server name used to verify the TLS certificate of the instance.
+// It is set as [resolver.Address.ServerName] of the address of the instance.
+const TLSServerNameMetaKey = "tls_server_name"
+
+// VersionMetaKey is the key of the service meta field that contains the
+// semantic version of an instance. It is matched against the version target
+// option.
+const VersionMetaKey = "version"
+
+// DrainingMetaKey is the key of the service meta field that marks an instance
+// as draining. Instances where the field is set to a true value, as accepted
+// by [strconv.ParseBool], are not resolved.
+const DrainingMetaKey = "draining"
+
+// PortMetaKeyPrefix is the prefix of the keys of service meta fields that
+// contain named ports of an instance, e.g. port_grpc. The port-name target
+// option selects which one is resolved instead of the service port.
+const PortMetaKeyPrefix = "port_"
+
+// ConnectViaMetaKey is the key of the service meta field that contains the
+// address of a proxy or gateway connections to the instance must be
+// established through, e.g. a mesh gateway or jump host. It is attached to
+// the address of the instance and can be retrieved by custom dialers with
+// [ConnectViaFromDialContext].
+const ConnectViaMetaKey = "connect_via"
+
+// attributeKey is the type of the keys of the attributes the resolver
+// attaches to addresses.
+type attributeKey int
+
+const (
+	serviceIDAttributeKey attributeKey = iota
+	registrationIndexesAttributeKey
+	datacenterAttributeKey
+	checkStatusesAttributeKey
+	enrichmentAttributeKey
+	taggedAddressesAttributeKey
+	localNodeAttributeKey
+	connectViaAttributeKey
+	weightAttributeKey
+	dualStackAttributeKey
+	priorityAttributeKey
+	confirmedAttributeKey
+	warmUpAttributeKey
+	splitWeightAttributeKey
+	protocolAttributeKey
+	serviceEntryAttributeKey
+	instanceKeyAttributeKey
+)
+
+// RegistrationIndexes are the Raft indexes of the registration of a Consul
+// service instance.
+type RegistrationIndexes struct {
+	// CreateIndex is the index when the instance was registered.
+	CreateIndex uint64
+	// ModifyIndex is the index when the registration was modified the
+	// last time.
+	ModifyIndex uint64
+}
+
+// CheckStatuses maps the names of the health checks of a Consul service
+// instance and its node to their status, e.g. "passing" or "warning".
+type CheckStatuses map[string]string
+
+// Equal returns true if o is a CheckStatuses with the same checks and
+// statuses. It allows comparing attributes containing the map.
+func (s CheckStatuses) Equal(o any) bool {
+	os, ok := o.(CheckStatuses)
+	return ok && maps.Equal(s, os)
+}
+
+// TaggedAddresses maps the tags of the tagged addresses of a Consul service
+// instance, e.g. "lan_ipv4" or "wan", to the addresses.
+type TaggedAddresses map[string]consul.ServiceAddress
+
+// Equal returns true if o is a TaggedAddresses with the same tags and
+// addresses. It allows comparing attributes containing the map.
+func (a TaggedAddresses) Equal(o any) bool {
+	oa, ok := o.(TaggedAddresses)
+	return ok && maps.Equal(a, oa)
+}
+
+// checkSeverity orders check statuses, when checks have the same name the
+// status with the highest severity is kept.
+var checkSeverity = map[string]int{
+	consul.HealthPassing:  1,
+	consul.HealthWarning:  2,
+	consul.HealthCritical: 3,
+	consul.HealthMaint:    4,
+}
+
+func checkStatuses(checks consul.HealthChecks) CheckStatuses {
+	result := make(CheckStatuses, len(checks))
+
+	for _, c := range checks {
+		if cur, exists := result[c.Name]; exists && checkSeverity[cur] >= checkSeverity[c.Status] {
+			continue
+		}
+		result[c.Name] = c.Status
+	}
+
+	return result
+}
+
+// balancerAttributes returns the attributes for the
+// [resolver.Address.BalancerAttributes] field of the address of e.
+// If withChecks is true, the statuses of the checks of e are included.
+func balancerAttributes(e *consul.ServiceEntry, withChecks bool) *attributes.Attributes {
+	var result *attributes.Attributes
+
+	if e.Service.ID != "" {
+		result = result.WithValue(serviceIDAttributeKey, e.Service.ID)
+		result = result.WithValue(instanceKeyAttributeKey, instanceKey(e))
+	}
+
+	if e.Service.CreateIndex != 0 {
+		result = result.WithValue(registrationIndexesAttributeKey, RegistrationIndexes{
+			CreateIndex: e.Service.CreateIndex,
+			ModifyIndex: e.Service.ModifyIndex,
+		})
+	}
+
+	if w := weight(e); w > 0 {
+		result = result.WithValue(weightAttributeKey, w)
+	}
+
+	if withChecks {
+		result = result.WithValue(checkStatusesAttributeKey, checkStatuses(e.Checks))
+	}
+
+	return result
+}
+
+// addressAttributes returns the attributes for the
+// [resolver.Address.Attributes] field of the address of e. They are passed
+// to the dialer and transport credentials of the connection.
+func addressAttributes(e *consul.ServiceEntry) *attributes.Attributes {
+	var result *attributes.Attributes
+
+	if len(e.Service.TaggedAddresses) != 0 {
+		result = result.WithValue(taggedAddressesAttributeKey, TaggedAddresses(maps.Clone(e.Service.TaggedAddresses)))
+	}
+
+	if via := e.Service.Meta[ConnectViaMetaKey]; via != "" {
+		result = result.WithValue(connectViaAttributeKey, via)
+	}
+
+	if p := e.Service.Meta[ProtocolMetaKey]; p != "" {
+		result = result.WithValue(protocolAttributeKey, strings.ToLower(p))
+	}
+
+	return result
+}
+
+// weight returns the Consul service weight of e, the warning weight if its
+// checks are in the warning state and the passing weight otherwise. It returns
+// 0 if no weights are registered.
+func weight(e *consul.ServiceEntry) int {
+	if e.Checks.AggregatedStatus() == consul.HealthWarning {
+		return e.Service.Weights.Warning
+	}
+
+	return e.Service.Weights.Passing
+}
+
+// datacenter returns the datacenter of the node of e, or of the service if
+// the node is unknown.
+func datacenter(e *consul.ServiceEntry) string {
+	if e.Node != nil && e.Node.Datacenter != "" {
+		return e.Node.Datacenter
+	}
+
+	return e.Service.Datacenter
+}
+
+// instanceKey returns a key that identifies the instance of e across nodes.
+func instanceKey(e *consul.ServiceEntry) string {
+	if e.Node == nil {
+		return e.Service.ID
+	}
+
+	return e.Node.Node + "/" + e.Service.ID
+}
+
+// ServiceID returns the ID of the Consul service instance addr was resolved
+// from.
+// Service IDs are only unique per node, instances on different nodes can
+// have the same ID. [InstanceKey] identifies instances across nodes.
+func ServiceID(addr resolver.Address) (string, bool) {
+	id, ok := addr.BalancerAttributes.Value(serviceIDAttributeKey).(string)
+	return id, ok
+}
+
+// InstanceKey returns a key that identifies the Consul service instance addr
+// was resolved from across nodes. It consists of the name of the node and
+// the service ID of the instance, separated by a slash.
+// Balancers can use it to map requests to instances when instances on
+// different nodes have the same service ID.
+func InstanceKey(addr resolver.Address) (string, bool) {
+	k, ok := addr.BalancerAttributes.Value(instanceKeyAttributeKey).(string)
+	return k, ok
+}
+
+// RegistrationIndexesOf returns the registration indexes of the Consul
+// service instance addr was resolved from.
+// They allow to order instances by their registration time, e.g. to prefer
+// the oldest instances.
+func RegistrationIndexesOf(addr resolver.Address) (RegistrationIndexes, bool) {
+	idx, ok := addr.BalancerAttributes.Value(registrationIndexesAttributeKey).(RegistrationIndexes)
+	return idx, ok
+}
+
+// Datacenter returns the datacenter of the Consul service instance addr was
+// resolved from.
+// It is only available for addresses of targets with the failover-dcs
+// option. It allows interceptors to record cross-datacenter traffic and
+// balancers to prefer instances in the local datacenter.
+func Datacenter(addr resolver.Address) (string, bool) {
+	dc, ok := addr.BalancerAttributes.Value(datacenterAttributeKey).(string)
+	return dc, ok
+}
+
+// Weight returns the Consul service weight of the instance addr was resolved
+// from, the warning weight if its checks are in the warning state and the
+// passing weight otherwise.
+// It is not available for instances without weights and for instances whose
+// weight is 0.
+func Weight(addr resolver.Address) (int, bool) {
+	w, ok := addr.BalancerAttributes.Value(weightAttributeKey).(int)
+	return w, ok
+}
+
+// Priority returns the priority of the instance addr was resolved from, the
+// index of the first tag of the priority-tags target option the instance
+// carries. 0 is the highest priority.
+// It is only available for targets with the priority-tags option.
+func Priority(addr resolver.Address) (int, bool) {
+	p, ok := addr.BalancerAttributes.Value(priorityAttributeKey).(int)
+	return p, ok
+}
+
+// priority returns the index of the first of priorityTags in tags, or
+// len(priorityTags) if tags contains none of them.
+func priority(tags, priorityTags []string) int {
+	for i, pt := range priorityTags {
+		if slices.Contains(tags, pt) {
+			return i
+		}
+	}
+
+	return len(priorityTags)
+}
+
+// CheckStatusesOf returns the statuses of the health checks of the Consul
+// service instance addr was resolved from.
+// They are only available when the builder was created with
+// [WithCheckStatusAttribute].
+func CheckStatusesOf(addr resolver.Address) (CheckStatuses, bool) {
+	s, ok := addr.BalancerAttributes.Value(checkStatusesAttributeKey).(CheckStatuses)
+	return s, ok
+}
+
+// Enrichment returns the value the [EnrichFunc] configured with
+// [WithEnricher] returned for addr, nil if none is available.
+func Enrichment(addr resolver.Address) any {
+	v, _ := addr.BalancerAttributes.Value(enrichmentAttributeKey).(enrichedValue)
+	return v.value
+}
+
+// TaggedAddressesOf returns the tagged addresses of the Consul service
+// instance addr was resolved from.
+func TaggedAddressesOf(addr resolver.Address) (TaggedAddresses, bool) {
+	a, ok := addr.Attributes.Value(taggedAddressesAttributeKey).(TaggedAddresses)
+	return a, ok
+}
+
+// TaggedAddressesFromDialContext returns the tagged addresses of the Consul
+// service instance a connection is established to. It can be called with
+// the context passed to the dialer configured with
+// [google.golang.org/grpc.WithContextDialer] or to transport credentials,
+// to connect to an alternate address of the instance.
+func TaggedAddressesFromDialContext(ctx context.Context) (TaggedAddresses, bool) {
+	a, ok := credentials.ClientHandshakeInfoFromContext(ctx).Attributes.Value(taggedAddressesAttributeKey).(TaggedAddresses)
+	return a, ok
+}
+
+// ConnectVia returns the address of the proxy or gateway from the
+// [ConnectViaMetaKey] service meta field of the instance addr was resolved
+// from.
+func ConnectVia(addr resolver.Address) (string, bool) {
+	via, ok := addr.Attributes.Value(connectViaAttributeKey).(string)
+	return via, ok
+}
+
+// ConnectViaFromDialContext returns the address of the proxy or gateway from
+// the [ConnectViaMetaKey] service meta field of the instance a connection is
+// established to. It can be called with the context passed to the dialer
+// configured with [google.golang.org/grpc.WithContextDialer], to establish
+// the connection through the proxy.
+func ConnectViaFromDialContext(ctx context.Context) (string, bool) {
+	via, ok := credentials.ClientHandshakeInfoFromContext(ctx).Attributes.Value(connectViaAttributeKey).(string)
+	return via, ok
+}
@@ -0,0 +1,344 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+// resolveOnce builds a resolver for target with a builder created with
+// opts, waits for the first state update and returns the resolved addresses.
+func resolveOnce(t *testing.T, target string, entries []*consul.ServiceEntry, opts ...BuilderOption) []resolver.Address {
+	t.Helper()
+
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespEntries(entries)
+
+	u, err := url.Parse(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc := mocks.NewClientConn()
+	r, err := NewBuilder(opts...).Build(resolver.Target{URL: *u}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+	defer r.Close()
+
+	for cc.UpdateStateCallCnt() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	return cc.Addrs()
+}
+
+func TestServiceIDAttribute(t *testing.T) {
+	addrs := resolveOnce(t, "consul:///user-service", []*consul.ServiceEntry{
+		{
+			Service: &consul.AgentService{
+				ID:      "user-service-1",
+				Address: "127.0.0.1",
+				Port:    1,
+			},
+		},
+	})
+
+	if len(addrs) != 1 {
+		t.Fatalf("resolved to %d addresses, expected 1", len(addrs))
+	}
+
+	id, ok := ServiceID(addrs[0])
+	if !ok || id != "user-service-1" {
+		t.Errorf("ServiceID() returned %q, %t, expected user-service-1, true", id, ok)
+	}
+
+	if _, ok := ServiceID(resolver.Address{Addr: "127.0.0.1:1"}); ok {
+		t.Error("ServiceID() of address without attributes returned ok")
+	}
+}
+
+func TestInstanceKeyAttribute(t *testing.T) {
+	addrs := resolveOnce(t, "consul:///user-service", []*consul.ServiceEntry{
+		{
+			Node: &consul.Node{Node: "node-1"},
+			Service: &consul.AgentService{
+				ID:      "user-service",
+				Address: "127.0.0.1",
+				Port:    1,
+			},
+		},
+		{
+			Node: &consul.Node{Node: "node-2"},
+			Service: &consul.AgentService{
+				ID:      "user-service",
+				Address: "127.0.0.2",
+				Port:    1,
+			},
+		},
+	})
+
+	if len(addrs) != 2 {
+		t.Fatalf("resolved to %d addresses, expected 2", len(addrs))
+	}
+
+	keys := map[string]bool{}
+	for _, a := range addrs {
+		k, ok := InstanceKey(a)
+		if !ok {
+			t.Fatalf("InstanceKey() of %s returned not ok", a.Addr)
+		}
+		keys[k] = true
+	}
+
+	if !keys["node-1/user-service"] || !keys["node-2/user-service"] {
+		t.Errorf("InstanceKey() returned %v, expected node-1/user-service and node-2/user-service", keys)
+	}
+}
+
+func TestRegistrationIndexesAttribute(t *testing.T) {
+	addrs := resolveOnce(t, "consul:///user-service", []*consul.ServiceEntry{
+		{
+			Service: &consul.AgentService{
+				ID:          "user-service-1",
+				Address:     "127.0.0.1",
+				Port:        1,
+				CreateIndex: 10,
+				ModifyIndex: 20,
+			},
+		},
+	})
+
+	idx, ok := RegistrationIndexesOf(addrs[0])
+	if !ok || idx.CreateIndex != 10 || idx.ModifyIndex != 20 {
+		t.Errorf("RegistrationIndexesOf() returned %+v, %t, expected {10 20}, true", idx, ok)
+	}
+}
+
+func TestServerNameFromMeta(t *testing.T) {
+	addrs := resolveOnce(t, "consul:///user-service", []*consul.ServiceEntry{
+		{
+			Service: &consul.AgentService{
+				Address: "127.0.0.1",
+				Port:    1,
+				Meta:    map[string]string{TLSServerNameMetaKey: "users.internal"},
+			},
+		},
+		{
+			Service: &consul.AgentService{
+				Address: "127.0.0.2",
+				Port:    1,
+			},
+		},
+	})
+
+	for _, addr := range addrs {
+		want := ""
+		if addr.Addr == "127.0.0.1:1" {
+			want = "users.internal"
+		}
+
+		if addr.ServerName != want {
+			t.Errorf("ServerName of %s is %q, expected %q", addr.Addr, addr.ServerName, want)
+		}
+	}
+}
+
+func TestDatacenterAttribute(t *testing.T) {
+	entries := []*consul.ServiceEntry{
+		{
+			Node: &consul.Node{Datacenter: "eu-west"},
+			Service: &consul.AgentService{
+				Address: "127.0.0.1",
+				Port:    1,
+			},
+		},
+		{
+			Service: &consul.AgentService{
+				Address:    "127.0.0.2",
+				Port:       1,
+				Datacenter: "us-east",
+			},
+		},
+	}
+
+	addrs := resolveOnce(t, "consul:///user-service?failover-dcs=us-east", entries)
+	for i, want := range []string{"eu-west", "us-east"} {
+		if dc, ok := Datacenter(addrs[i]); !ok || dc != want {
+			t.Errorf("Datacenter() of %s returned %q, %t, expected %s, true", addrs[i].Addr, dc, ok, want)
+		}
+	}
+
+	addrs = resolveOnce(t, "consul:///user-service", entries)
+	if dc, ok := Datacenter(addrs[0]); ok {
+		t.Errorf("Datacenter() of address of a target without failover-dcs returned %q, expected none", dc)
+	}
+}
+
+func TestCheckStatusesAttribute(t *testing.T) {
+	entries := []*consul.ServiceEntry{
+		{
+			Service: &consul.AgentService{
+				ID:      "user-service-1",
+				Address: "127.0.0.1",
+				Port:    1,
+			},
+			Checks: consul.HealthChecks{
+				{Name: "Serf Health Status", Status: consul.HealthPassing},
+				{Name: "replication-lag", Status: consul.HealthWarning, ServiceID: "user-service-1"},
+				{Name: "replication-lag", Status: consul.HealthPassing, ServiceID: "user-service-1"},
+			},
+		},
+	}
+
+	addrs := resolveOnce(t, "consul:///user-service?health=fallbackToUnhealthy", entries, WithCheckStatusAttribute())
+
+	statuses, ok := CheckStatusesOf(addrs[0])
+	want := CheckStatuses{"Serf Health Status": consul.HealthPassing, "replication-lag": consul.HealthWarning}
+	if !ok || !statuses.Equal(want) {
+		t.Errorf("CheckStatusesOf() returned %v, %t, expected %v, true", statuses, ok, want)
+	}
+
+	addrs = resolveOnce(t, "consul:///user-service?health=fallbackToUnhealthy", entries)
+	if _, ok := CheckStatusesOf(addrs[0]); ok {
+		t.Error("CheckStatusesOf() returned ok for a builder without WithCheckStatusAttribute()")
+	}
+}
+
+func TestServiceEntryAttribute(t *testing.T) {
+	entry := consul.ServiceEntry{
+		Node: &consul.Node{Node: "node-1", Datacenter: "dc1"},
+		Service: &consul.AgentService{
+			ID:      "user-service-1",
+			Address: "127.0.0.1",
+			Port:    1,
+			Meta:    map[string]string{"version": "1.2.0"},
+		},
+		Checks: consul.HealthChecks{
+			{Name: "grpc", Status: consul.HealthPassing, Output: "serving", ModifyIndex: 10},
+		},
+	}
+
+	addrs := resolveOnce(t, "consul:///user-service", []*consul.ServiceEntry{&entry}, WithServiceEntryAttribute())
+
+	got, ok := ServiceEntryOf(addrs[0])
+	if !ok {
+		t.Fatal("ServiceEntryOf() returned false")
+	}
+	if got.Node.Node != "node-1" || got.Service.Meta["version"] != "1.2.0" {
+		t.Errorf("ServiceEntryOf() returned %+v, expected the entry of the instance", got)
+	}
+	if got.Checks[0].Status != consul.HealthPassing || got.Checks[0].Output != "" {
+		t.Errorf("ServiceEntryOf() returned check %+v, expected the passing status without output", got.Checks[0])
+	}
+	if entry.Checks[0].Output != "serving" {
+		t.Error("the check output of the queried entry was modified")
+	}
+
+	changed := entry
+	changed.Checks = consul.HealthChecks{
+		{Name: "grpc", Status: consul.HealthPassing, Output: "still serving", ModifyIndex: 11},
+	}
+	changedAddrs := resolveOnce(t, "consul:///user-service", []*consul.ServiceEntry{&changed}, WithServiceEntryAttribute())
+	if !addrs[0].Equal(changedAddrs[0]) {
+		t.Error("addresses differ when only the check output changed")
+	}
+
+	addrs = resolveOnce(t, "consul:///user-service", []*consul.ServiceEntry{&entry})
+	if _, ok := ServiceEntryOf(addrs[0]); ok {
+		t.Error("ServiceEntryOf() returned ok for a builder without WithServiceEntryAttribute()")
+	}
+}
+
+func TestDialAttributes(t *testing.T) {
+	tagged := map[string]consul.ServiceAddress{
+		"lan_ipv4": {Address: "10.0.0.1", Port: 8080},
+		"wan_ipv4": {Address: "203.0.113.1", Port: 18080},
+	}
+
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{
+			Address:         "10.0.0.1",
+			Port:            8080,
+			TaggedAddresses: tagged,
+			Meta:            map[string]string{ConnectViaMetaKey: "gateway.internal:8443"},
+		},
+	})
+
+	addrs, err := Lookup(context.Background(), "consul:///user-service")
+	if err != nil {
+		t.Fatal("Lookup() failed:", err)
+	}
+
+	got, ok := TaggedAddressesOf(addrs[0])
+	if !ok || !got.Equal(TaggedAddresses(tagged)) {
+		t.Errorf("TaggedAddressesOf() returned %v, %t, expected %v, true", got, ok, tagged)
+	}
+
+	if via, ok := ConnectVia(addrs[0]); via != "gateway.internal:8443" || !ok {
+		t.Errorf("ConnectVia() returned %q, %t, expected gateway.internal:8443, true", via, ok)
+	}
+
+	type dialAttrs struct {
+		tagged TaggedAddresses
+		via    string
+	}
+
+	dialed := make(chan dialAttrs, 1)
+	conn, err := grpc.Dial("consul:///user-service",
+		grpc.WithResolvers(NewBuilder()),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			a, _ := TaggedAddressesFromDialContext(ctx)
+			via, _ := ConnectViaFromDialContext(ctx)
+			select {
+			case dialed <- dialAttrs{tagged: a, via: via}:
+			default:
+			}
+			return nil, errors.New("dialing is not supported in the test")
+		}),
+	)
+	if err != nil {
+		t.Fatal("Dial() failed:", err)
+	}
+	defer conn.Close()
+
+	conn.Connect()
+
+	select {
+	case got := <-dialed:
+		if !got.tagged.Equal(TaggedAddresses(tagged)) {
+			t.Errorf("TaggedAddressesFromDialContext() returned %v, expected %v", got.tagged, tagged)
+		}
+		if got.via != "gateway.internal:8443" {
+			t.Errorf("ConnectViaFromDialContext() returned %q, expected gateway.internal:8443", got.via)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dialer was not called")
+	}
+}
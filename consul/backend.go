@@ -0,0 +1,74 @@
+package consul
+
+import (
+	"context"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// DiscoveryBackend provides the instances of services to a resolver. The
+// resolver runs the watch loop, filters, orders and passes the instances to
+// the gRPC channel, the backend only fetches them.
+// The default backend queries the health endpoint of the Consul HTTP API.
+// Alternative backends, e.g. for prepared queries or test fakes, can be
+// configured with [WithDiscoveryBackend].
+type DiscoveryBackend interface {
+	// Fetch returns the instances described by req and the index of the
+	// result. If index is not 0, it blocks until the result has an index
+	// that differs from it, req.Options.WaitTime expired or ctx is done.
+	// Backends that do not support blocking return immediately, the
+	// resolver then delays repeated queries with the same index.
+	Fetch(ctx context.Context, index uint64, req FetchRequest) ([]*consul.ServiceEntry, uint64, error)
+}
+
+// FetchRequest describes the instances a [DiscoveryBackend] returns.
+type FetchRequest struct {
+	// Service is the name of the service.
+	Service string
+	// Tags are the tags instances must have, empty for all instances.
+	Tags []string
+	// PassingOnly is true if only instances with passing checks are
+	// returned.
+	PassingOnly bool
+	// Options are the Consul query options of the resolver, like the
+	// token, filter, namespace, partition, consistency mode and the
+	// maximum wait time. The WaitIndex and context are not set.
+	Options consul.QueryOptions
+}
+
+// DiscoveryBackendFunc returns the [DiscoveryBackend] for the resolver of
+// target.
+type DiscoveryBackendFunc func(target *Target) (DiscoveryBackend, error)
+
+// healthBackend is the [DiscoveryBackend] that queries the Consul health
+// endpoint.
+type healthBackend struct {
+	health consulHealthEndpoint
+}
+
+func (b *healthBackend) Fetch(ctx context.Context, index uint64, req FetchRequest) ([]*consul.ServiceEntry, uint64, error) {
+	q := req.Options
+	q.WaitIndex = index
+
+	entries, meta, err := b.health.ServiceMultipleTags(req.Service, req.Tags, req.PassingOnly, q.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return entries, meta.LastIndex, nil
+}
+
+// fetch queries the instances of the service of c with the backend. The
+// wait index and context are taken from q.
+func (c *consulResolver) fetch(tags []string, passingOnly bool, q *consul.QueryOptions) ([]*consul.ServiceEntry, uint64, error) {
+	req := FetchRequest{
+		Service:     c.service,
+		Tags:        tags,
+		PassingOnly: passingOnly,
+		Options:     *q,
+	}
+	req.Options.WaitIndex = 0
+	req.Options = *req.Options.WithContext(nil)
+
+	return c.backend.Fetch(q.Context(), q.WaitIndex, req)
+}
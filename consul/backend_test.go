@@ -0,0 +1,95 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+// fakeBackend is a DiscoveryBackend that returns fixed entries and blocks
+// queries for the current index until its context is done.
+type fakeBackend struct {
+	entries []*consul.ServiceEntry
+
+	mu   sync.Mutex
+	reqs []FetchRequest
+}
+
+func (b *fakeBackend) Fetch(ctx context.Context, index uint64, req FetchRequest) ([]*consul.ServiceEntry, uint64, error) {
+	b.mu.Lock()
+	b.reqs = append(b.reqs, req)
+	b.mu.Unlock()
+
+	if index == 1 {
+		<-ctx.Done()
+		return nil, 0, ctx.Err()
+	}
+
+	return b.entries, 1, nil
+}
+
+func TestDiscoveryBackend(t *testing.T) {
+	backend := fakeBackend{
+		entries: []*consul.ServiceEntry{
+			{Service: &consul.AgentService{Address: "10.0.0.1", Port: 1}},
+			{Service: &consul.AgentService{Address: "10.0.0.2", Port: 1, Meta: map[string]string{DrainingMetaKey: "true"}}},
+		},
+	}
+
+	var gotTarget *Target
+	b := NewBuilder(WithDiscoveryBackend(func(target *Target) (DiscoveryBackend, error) {
+		gotTarget = target
+		return &backend, nil
+	}))
+
+	cc := mocks.NewClientConn()
+	r, err := b.Build(resolver.Target{URL: url.URL{Path: "/backend-test", RawQuery: "tags=primary"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err)
+	}
+	defer r.Close()
+
+	if gotTarget == nil || gotTarget.Service != "backend-test" {
+		t.Errorf("backend func was called with target %+v, expected the target of the service backend-test", gotTarget)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cc.UpdateStateCallCnt() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("addresses of the backend were not passed to the channel")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if got := addrStrings(cc.Addrs()); len(got) != 1 || got[0] != "10.0.0.1:1" {
+		t.Errorf("resolved to %v, expected the not draining instance 10.0.0.1:1", got)
+	}
+
+	backend.mu.Lock()
+	req := backend.reqs[0]
+	backend.mu.Unlock()
+	if req.Service != "backend-test" || len(req.Tags) != 1 || req.Tags[0] != "primary" || !req.PassingOnly {
+		t.Errorf("backend was queried with %+v, expected the service, tags and passing only", req)
+	}
+}
+
+func TestDiscoveryBackendError(t *testing.T) {
+	errBackend := errors.New("backend unavailable")
+
+	b := NewBuilder(WithDiscoveryBackend(func(*Target) (DiscoveryBackend, error) {
+		return nil, errBackend
+	}))
+
+	_, err := b.Build(resolver.Target{URL: url.URL{Path: "/backend-test"}}, mocks.NewClientConn(), resolver.BuildOptions{})
+	if !errors.Is(err, errBackend) {
+		t.Errorf("Build() returned %v, expected %v", err, errBackend)
+	}
+}
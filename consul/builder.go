@@ -11,6 +11,22 @@
 //
 //	consul://[<consul-server>]/<serviceName>[?<OPT>[&<OPT>]...]
 //
+// In Consul Enterprise, the service can be qualified with its namespace and
+// admin partition by using <partition>/<namespace>/<serviceName> as path.
+// Empty partition or namespace segments use the defaults of the Consul
+// client. With the dotted-namespace option, <serviceName>.<namespace> is
+// accepted too, service names that contain dots must then be specified in
+// the first format, e.g. consul:////my.service.
+//
+// Traffic can be split between multiple services with a composite target,
+// whose path lists the services with their weights:
+//
+//	consul://[<consul-server>]/<serviceName>@<weight>[+<serviceName>@<weight>]...[?<OPT>[&<OPT>]...]
+//
+// The options apply to all services. The addresses of all services are
+// passed to the gRPC channel, with their share of the weight available via
+// [SplitWeight].
+//
 // OPT is one of:
 //
 //   - scheme=http|https specifies if the connection to Consul is established
@@ -24,10 +40,164 @@
 //     Default: healthy
 //   - token=<string> includes the token in API-Requests to Consul.
 //   - dc=<string> specifies DC for service search.
+//   - segment=<string> only resolves to instances running on nodes in the
+//     given Consul network segment.
+//   - tls-verify=true|false specifies if the TLS certificate of the Consul
+//     server is verified. Setting it to false has the same effect as setting
+//     the CONSUL_HTTP_SSL_VERIFY environment variable to false, but only for
+//     the target. If verification is disabled via the environment variable,
+//     tls-verify=true does not enable it.
+//     Default: true
+//   - ca-bundle=<path> adds the PEM-encoded CA certificates in the file to
+//     the certificate pool used to verify the Consul server.
+//   - consul-srv=true|false specifies that consul-server is a DNS name with SRV
+//     records pointing to the Consul servers. Connections are established
+//     to the servers in turn. Default: false
+//   - filter=<expression> only resolves to instances matching the
+//     [Consul filter expression]. The expression is validated when the
+//     resolver is built.
+//   - version=<constraint> only resolves to instances whose version service
+//     meta field contains a semantic version that satisfies the constraint,
+//     e.g. ^1.4 or >= 1.2, < 2. Instances without a valid version are not
+//     resolved.
+//   - overrides-key=<key> watches the Consul KV key and applies the
+//     JSON-encoded [Overrides] it contains to the tags, health and filter
+//     settings of the target. When the key is deleted, the settings from the
+//     target URL are used again.
+//   - proxy=<url> connects to Consul via the HTTP, HTTPS or SOCKS5 proxy.
+//     Default: the proxy from the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+//     environment variables
+//   - port-name=<name> resolves to the port in the port_<name> service meta
+//     field instead of the service port. Instances without a valid port in
+//     the field are not resolved.
+//   - prefer-node-address=true|false resolves instances to the address of
+//     their node instead of the registered service address, for services
+//     whose address is only reachable from within the node, e.g. behind NAT.
+//     Default: false
+//   - require-service-address=true|false skips instances without a
+//     registered service address instead of resolving them to the address of
+//     their node. It can not be combined with prefer-node-address.
+//     Default: false
+//   - port-zero=skip|error|use-meta-port defines how instances registered
+//     with port 0 are resolved. skip does not resolve them, error fails the
+//     resolution of the service and use-meta-port resolves them to the port
+//     in their port service meta field. It does not apply with port-name.
+//     Default: instances are resolved to port 0
+//   - local-node=first|only prefers instances running on the node of the
+//     Consul agent the resolver is connected to. first orders their
+//     addresses first, only resolves to them if any are available and
+//     otherwise to all instances.
+//   - translate-wan-addrs=true|false specifies if instances in remote
+//     datacenters are resolved to the WAN addresses the Consul agent
+//     translates their addresses to, when translate_wan_addrs is enabled in
+//     its configuration. If false, their LAN addresses are resolved.
+//     Default: true
+//   - gate-check=<check-id> only passes addresses to the gRPC channel while
+//     all Consul health checks with the ID are passing, e.g. a check that
+//     signals that database migrations finished. While they are not
+//     passing, the channel keeps the previously passed addresses.
+//   - kind=typical|connect-proxy|mesh-gateway|ingress-gateway only resolves
+//     to instances of the Consul service kind. typical excludes proxies and
+//     gateways.
+//     Default: instances of all kinds are resolved
+//   - dual-stack=true|false passes the addresses of both IP families of
+//     instances to the gRPC channel, when their service or node has
+//     lan_ipv4 and lan_ipv6, or wan_ipv4 and wan_ipv6, tagged addresses.
+//     They are passed as addresses of the same [resolver.Endpoint], to let
+//     endpoint-aware balancers connect to the family that works. The
+//     addresses of the channel contain only the resolved address of each
+//     instance.
+//     Default: false
+//   - priority-tags=<tag>[,<tag>]... labels the addresses of instances with
+//     the index of the first of the tags they carry, e.g. 0 for instances
+//     with the tag primary and 1 for instances with the tag secondary for
+//     priority-tags=primary,secondary. Instances without any of the tags
+//     get the index after the last tag. The priority can be retrieved with
+//     [Priority], the consul/priority package provides a balancer that only
+//     uses instances of the next priority when none of a higher one is
+//     available.
+//   - preserve-order=true|false passes the addresses to the gRPC channel in
+//     the order returned by Consul, e.g. sorted by round trip time with the
+//     Near query option, instead of sorting them by address. A changed
+//     order alone does not cause an update of the channel. With
+//     local-node=first, the addresses of the local node are still ordered
+//     first.
+//     Default: false
+//   - check-types=<type>[,<type>]... only considers the health checks of
+//     the types, e.g. grpc and http, for the health of instances. Checks of
+//     other types, like script or alias checks that some platforms register
+//     automatically, and node checks like serfHealth are ignored. Instances
+//     without checks of the types are healthy, unless they are in
+//     maintenance mode.
+//     Default: all checks are considered
+//   - slow-start=<duration> reduces the weight of instances that newly
+//     appeared and increases it in 10 steps to their full weight during
+//     the duration, e.g. 1m, so backends with cold caches do not receive
+//     their full share of traffic immediately. The instances of the first
+//     resolution of the service are not considered new. The fraction of
+//     the full weight is returned by [WarmUpFactor], it is considered by
+//     the balancer of the consul/ringhash package.
+//     Default: disabled
+//   - max-instances=<n> is the maximum number of instances the service is
+//     expected to resolve to, as guard against accidentally registering a
+//     large number of instances under the service name. When the service
+//     resolves to more instances, an alert is logged and the
+//     max-instances-policy applies.
+//     Default: no limit
+//   - max-instances-policy=hold|truncate defines how a service with more
+//     than max-instances instances is resolved. hold keeps the addresses
+//     that were passed to the gRPC channel before, or reports an error if
+//     none were passed yet. truncate passes the first max-instances
+//     addresses, in the order they would be passed to the channel.
+//     Default: hold
+//   - min-healthy-fraction=<fraction> is the minimum fraction of the
+//     registered instances, e.g. 0.3, that must have passing checks. When
+//     fewer instances are passing, the service also resolves to instances
+//     with warning and critical checks, to not overload the few passing
+//     ones. It replaces the behavior of the health option.
+//     Default: disabled
+//   - failure-tolerance=<duration> is the duration the health checks of an
+//     instance must be failing, e.g. 30s, before the instance is considered
+//     unhealthy. Shorter failures of aggressively configured checks do not
+//     remove the instance. Instances that are failing when the resolver
+//     queries the service the first time and instances in maintenance mode
+//     are not tolerated.
+//     Default: disabled
+//   - failover-dcs=<dc>[,<dc>]... are datacenters the service is resolved
+//     in when no instance is available in the datacenter of the target.
+//     A resolver is run per datacenter and the addresses of the first
+//     datacenter in the order dc, failover-dcs that has instances are
+//     passed to the gRPC channel. The datacenter of each address is
+//     returned by [Datacenter]. It is not supported for composite targets.
+//     Default: no failover
+//   - strict=true|false reports an error wrapping [ErrServiceNotFound]
+//     with the gRPC status code NotFound to the gRPC channel when no
+//     instance of the service is registered, e.g. because the service
+//     name is misspelled. The channel still receives the empty address
+//     list.
+//     Default: false
+//   - wait=<duration> is the maximum duration the blocking queries of the
+//     resolver wait for changes, e.g. 1m. It is limited like the wait time
+//     configured via [WithWaitTime].
+//     Default: the wait time configured via [WithWaitTime], or 10m
+//   - dotted-namespace=true|false parses service paths in the format
+//     <serviceName>.<namespace>. When disabled, dots are part of the
+//     service name.
+//     Default: false
+//   - dial-timeout=<duration>, tls-handshake-timeout=<duration> and
+//     response-header-timeout=<duration> set the timeouts of the HTTP
+//     connections to Consul, e.g. 10s. The response header timeout is
+//     extended by the wait time of blocking queries.
+//     Default: the timeouts configured via [WithHTTPTimeouts] or the
+//     defaults of the Consul client
 //
 // If an OPT is defined multiple times, only the value of the last occurrence
 // is used.
 //
+// Errors caused by an invalid target URL wrap one of the exported Err*
+// variables or are of type [*UnsupportedOptionError], they can be inspected
+// with [errors.Is] and [errors.As].
+//
 // The resolver can also be configured via the standard [Consul Environment Variables].
 // The supported environment variables and their defaults depend on the version
 // of the [github.com/hashicorp/consul/api] package.
@@ -39,27 +209,585 @@
 //
 // [Blocking Consul queries]: https://developer.hashicorp.com/consul/api-docs/features/blocking
 // [Consul Environment Variables]: https://developer.hashicorp.com/consul/commands#environment-variables
+// [Consul filter expression]: https://developer.hashicorp.com/consul/api-docs/features/filtering
 package consul
 
 import (
-	"errors"
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	consul "github.com/hashicorp/consul/api"
 	"google.golang.org/grpc/resolver"
 )
 
-type resolverBuilder struct{}
+type resolverBuilder struct {
+	scheme  string
+	target  *Target
+	profile *Target
+	opts    builderOptions
+}
+
+// builderOptions are settings that apply to all resolvers created by a
+// builder.
+type builderOptions struct {
+	statsHandler      StatsHandler
+	multiplexer       *multiplexer
+	republishInterval time.Duration
+	updateGate        func(old, updated resolver.State) bool
+	queryDefaults     *consul.QueryOptions
+	tokenFunc         func(service string) string
+	monitorInterval   time.Duration
+	lazyStart         bool
+	clock             Clock
+	readyFunc         func(service string)
+	waitTime          time.Duration
+	sortByServiceID   bool
+	checkStatuses     bool
+	stuckThreshold    time.Duration
+	stuckRefresh      bool
+	enrichFunc        EnrichFunc
+	enrichTimeout     time.Duration
+	enrichTTL         time.Duration
+	// resolveNowInterval is the minimum time between queries
+	// triggered by ResolveNow.
+	resolveNowInterval time.Duration
+	// quarantine and maxQuarantine are the durations addresses reported
+	// via ReportAddressFailure are quarantined.
+	quarantine    time.Duration
+	maxQuarantine time.Duration
+	timeouts      HTTPTimeouts
+	pool          ConnectionPool
+	tokenSource   TokenSource
+	// selfCheckInterval is the interval of the catalog self-check.
+	selfCheckInterval time.Duration
+	// confirmation enables the confirmation attribute, maxAddressAge
+	// is the duration after which unconfirmed addresses are refreshed.
+	confirmation  bool
+	maxAddressAge time.Duration
+	// retryAttempts and retryDuration limit how long failing queries
+	// are retried.
+	retryAttempts int
+	retryDuration time.Duration
+	// serviceEntries enables the service entry attribute.
+	serviceEntries bool
+	// agentless makes the resolvers query Consul servers directly,
+	// servers are their addresses.
+	agentless bool
+	servers   []string
+	// sharedHealth is nil if health queries are not shared.
+	sharedHealth *sharedHealth
+	removalFunc  RemovalFunc
+	backendFunc  DiscoveryBackendFunc
+	// updateJitter is the maximum delay of updates that add addresses.
+	updateJitter time.Duration
+	// nomad makes the resolvers query Nomad instead of Consul.
+	nomad bool
+}
+
+// BuilderOption configures a builder.
+type BuilderOption func(*builderOptions)
+
+// WithStatsHandler configures a handler that receives events about the
+// operation of all resolvers created by the builder.
+func WithStatsHandler(h StatsHandler) BuilderOption {
+	return func(o *builderOptions) {
+		o.statsHandler = h
+	}
+}
+
+// WithMultiplexedWatches makes the resolvers created by the builder share
+// a pool of concurrency goroutines that run their queries, instead of
+// running one goroutine with a blocking query per resolver.
+// It reduces the number of goroutines and of concurrent connections to the
+// Consul agent in processes that watch many services.
+//
+// At most concurrency queries are in flight at the same time. Blocking
+// queries wait at most waitTime for changes, afterwards the next resolver is
+// queried. Changes of a service can therefore be noticed with a delay of up to
+// (number of resolvers / concurrency) * waitTime.
+func WithMultiplexedWatches(concurrency int, waitTime time.Duration) BuilderOption {
+	m := newMultiplexer(concurrency, waitTime)
+
+	return func(o *builderOptions) {
+		o.multiplexer = m
+	}
+}
+
+// WithStateRepublishInterval makes the resolvers created by the builder pass
+// their current addresses to the gRPC channel again when they were not
+// updated for the interval, even if they did not change.
+// It can recover channels whose load balancer ignored an update. Blocking
+// queries wait at most for the interval for changes.
+func WithStateRepublishInterval(interval time.Duration) BuilderOption {
+	return func(o *builderOptions) {
+		o.republishInterval = interval
+	}
+}
+
+// WithDiscoveryBackend makes the resolvers created by the builder fetch the
+// instances of their services with the [DiscoveryBackend] returned by fn,
+// instead of querying the Consul health endpoint. fn is called when a
+// resolver is built, errors it returns are returned by the builder.
+// The resolvers still apply the target options to the instances, e.g. the
+// version constraint, port selection and the health filter. Options that
+// query other Consul endpoints, like overrides-key, gate-check or
+// local-node, still use the Consul agent of the target.
+func WithDiscoveryBackend(fn DiscoveryBackendFunc) BuilderOption {
+	return func(o *builderOptions) {
+		o.backendFunc = fn
+	}
+}
+
+// WithUpdateJitter makes the resolvers created by the builder delay updates
+// that add addresses by a random duration of up to max, before passing them
+// to the gRPC channel. It spreads the connections of a large fleet of
+// clients to new instances over time, instead of all clients connecting in
+// the same instant after a deployment.
+// The first addresses of a service and updates that only remove addresses
+// are passed without delay. Changes that happen during the delay are passed
+// with the next update.
+func WithUpdateJitter(max time.Duration) BuilderOption {
+	return func(o *builderOptions) {
+		o.updateJitter = max
+	}
+}
+
+// WithUpdateGate configures a function that is called before the resolvers
+// created by the builder pass new addresses to the gRPC channel. old is the
+// last state that was passed to the channel, new the one that is about to be
+// passed. If gate returns false, the update is skipped.
+//
+// A skipped update is offered to gate again when the next query returns,
+// which happens when the service changes or the blocking query times out.
+// [WithStateRepublishInterval] can be used to offer it more often.
+//
+// gate is called from the resolver goroutines and must be safe for
+// concurrent use.
+func WithUpdateGate(gate func(old, updated resolver.State) bool) BuilderOption {
+	return func(o *builderOptions) {
+		o.updateGate = gate
+	}
+}
+
+// WithQueryOptions sets defaults for the Consul queries of all resolvers
+// created by the builder.
+// The AllowStale, RequireConsistent, UseCache, MaxAge, StaleIfError, Near and
+// Filter fields of opts are used, other fields are ignored. A filter
+// expression in the target replaces the Filter of opts.
+func WithQueryOptions(opts consul.QueryOptions) BuilderOption {
+	return func(o *builderOptions) {
+		o.queryDefaults = &consul.QueryOptions{
+			AllowStale:        opts.AllowStale,
+			RequireConsistent: opts.RequireConsistent,
+			UseCache:          opts.UseCache,
+			MaxAge:            opts.MaxAge,
+			StaleIfError:      opts.StaleIfError,
+			Near:              opts.Near,
+			Filter:            opts.Filter,
+		}
+	}
+}
+
+// WithTokenFunc configures a function that returns the ACL token used by
+// the resolvers created by the builder for the service.
+// It is called when a resolver is built for a target without a token.
+// If it returns an empty string, the token from the environment is used.
+func WithTokenFunc(fn func(service string) string) BuilderOption {
+	return func(o *builderOptions) {
+		o.tokenFunc = fn
+	}
+}
+
+// WithTokenSource configures a source of ACL tokens that expire, e.g.
+// tokens acquired via a Consul auth method, for the resolvers created by the
+// builder. It is used for targets without a token, instead of
+// [WithTokenFunc].
+//
+// The resolvers acquire a token before their first query and renew it after
+// 90% of its lifetime passed. Blocking queries do not wait beyond the
+// renewal time. When Consul rejects a token with a 403 response, a new
+// token is acquired and the query is retried once. If a renewal fails, the
+// current token is used until it expires.
+func WithTokenSource(src TokenSource) BuilderOption {
+	return func(o *builderOptions) {
+		o.tokenSource = src
+	}
+}
+
+// WithCatalogSelfCheck enables a diagnostic mode in which the resolvers
+// created by the builder compare the instances returned by the Consul health
+// and catalog endpoints every interval. Instances that are only returned by
+// one of them are logged and reported as [*CatalogMismatch] events, e.g.
+// instances whose health checks were not registered correctly.
+// The self-check sends 2 additional queries per interval and resolver, it is
+// not supported by resolvers of [NewNomadBuilder].
+func WithCatalogSelfCheck(interval time.Duration) BuilderOption {
+	return func(o *builderOptions) {
+		o.selfCheckInterval = interval
+	}
+}
+
+// WithRetryBudget limits how long the resolvers created by the builder retry
+// failing queries, for batch jobs that should abort quickly when the service
+// discovery is misconfigured instead of waiting for it.
+// When attempts consecutive queries failed or queries failed without a
+// successful one in between for d, the resolver reports an error wrapping
+// [ErrRetryBudgetExhausted] with the gRPC status code FailedPrecondition to
+// the channel and stops. A value of 0 disables the limit.
+func WithRetryBudget(attempts int, d time.Duration) BuilderOption {
+	return func(o *builderOptions) {
+		o.retryAttempts = attempts
+		o.retryDuration = d
+	}
+}
+
+// WithAddressConfirmation makes the resolvers created by the builder record
+// when Consul returned their addresses the last time, it can be retrieved
+// with [ConfirmedAt].
+//
+// If maxAge is positive, the resolvers refresh their addresses when Consul
+// did not return them for longer than maxAge. The idle connections to Consul
+// are then closed and the running blocking query is replaced by a
+// non-blocking one, to not rely on a blocking query that might hang on a
+// broken connection. maxAge should be longer than the wait time of the
+// blocking queries, which return the unchanged addresses when it expired.
+func WithAddressConfirmation(maxAge time.Duration) BuilderOption {
+	return func(o *builderOptions) {
+		o.confirmation = true
+		o.maxAddressAge = maxAge
+	}
+}
+
+// WithConnectionMonitor makes the resolvers created by the builder check
+// their connection to Consul every interval by requesting the Raft leader.
+// When a check fails, the idle connections to Consul are closed and the
+// running blocking query is restarted on a new connection.
+// The results are reported as [*ConnectionChecked] events to the
+// [StatsHandler].
+func WithConnectionMonitor(interval time.Duration) BuilderOption {
+	return func(o *builderOptions) {
+		o.monitorInterval = interval
+	}
+}
+
+// WithLazyStart makes the resolvers created by the builder defer querying
+// Consul until the gRPC channel calls ResolveNow or the first RPC is made on
+// a channel that uses [LazyStartUnaryClientInterceptor] or
+// [LazyStartStreamClientInterceptor].
+// It avoids opening blocking queries for channels that are created when a
+// process starts but are rarely used.
+//
+// gRPC only calls ResolveNow after the resolver reported addresses or an
+// error, channels of lazily started resolvers must therefore be dialed with
+// the interceptors. RPCs wait until the first query finished.
+func WithLazyStart() BuilderOption {
+	return func(o *builderOptions) {
+		o.lazyStart = true
+	}
+}
+
+// WithClock makes the resolvers created by the builder use clock instead of
+// the system time, e.g. to simulate timeouts and retries in tests.
+func WithClock(clock Clock) BuilderOption {
+	return func(o *builderOptions) {
+		o.clock = clock
+	}
+}
+
+// WithReadyFunc configures a function that is called once per resolver
+// created by the builder, after addresses were passed to the gRPC channel
+// successfully the first time.
+// It allows to start warm-up steps after the service discovery was
+// established. fn is called from the resolver goroutines and must not block.
+func WithReadyFunc(fn func(service string)) BuilderOption {
+	return func(o *builderOptions) {
+		o.readyFunc = fn
+	}
+}
+
+// WithWaitTime sets the maximum duration the blocking queries of the
+// resolvers created by the builder wait for changes. Consul limits it to 10
+// minutes, which is also the default.
+// Shorter wait times detect broken connections to Consul faster but
+// increase the number of requests. The wait time is also limited by
+// [WithMultiplexedWatches] and [WithStateRepublishInterval].
+// The wait option of a target overrides d for its resolver.
+// After a query failed, the wait time is shortened to 30 seconds and doubled
+// with every successful query until d is reached again.
+func WithWaitTime(d time.Duration) BuilderOption {
+	return func(o *builderOptions) {
+		o.waitTime = d
+	}
+}
+
+// WithSortByServiceID makes the resolvers created by the builder sort and
+// compare addresses by the node and ID of their Consul service instance,
+// as returned by [InstanceKey], instead of the address. An instance whose
+// address changed is then not counted as a removed and an added address in
+// the [StateUpdated] events and [ResolverHealth]. Addresses are passed to the
+// gRPC channel in the order of their instance keys, addresses with the same
+// key are ordered by the address.
+func WithSortByServiceID() BuilderOption {
+	return func(o *builderOptions) {
+		o.sortByServiceID = true
+	}
+}
+
+// WithCheckStatusAttribute makes the resolvers created by the builder
+// attach the statuses of the health checks of instances and their nodes to
+// the addresses. They can be retrieved with [CheckStatusesOf], e.g. by
+// balancers that avoid instances with a warning status of a specific check.
+//
+// Addresses are passed to the gRPC channel again whenever the status of a
+// check changes.
+func WithCheckStatusAttribute() BuilderOption {
+	return func(o *builderOptions) {
+		o.checkStatuses = true
+	}
+}
+
+// WithServiceEntryAttribute makes the resolvers created by the builder
+// attach the Consul service entries of instances to the addresses. They can
+// be retrieved with [ServiceEntryOf], e.g. by custom balancers that need the
+// full registration of instances.
+//
+// Addresses are passed to the gRPC channel again whenever the registration
+// of an instance or the status of one of its checks changes.
+func WithServiceEntryAttribute() BuilderOption {
+	return func(o *builderOptions) {
+		o.serviceEntries = true
+	}
+}
+
+// WithAgentless makes the resolvers created by the builder query Consul
+// servers directly, for environments without a local Consul agent, like
+// Kubernetes with Consul Dataplane.
+//
+// Connections are established to servers in turn, if connecting to one
+// fails the others are tried. When a query fails, idle connections are
+// closed, the retry is then sent to the next server. If no servers are
+// passed, the consul-server of the target is used, it can be a load balancer
+// in front of the servers or a DNS name with SRV records when the consul-srv
+// option is set. The TLS certificates of the servers are verified for
+// [TLSConfig.ServerName] of the target or the consul-server.
+//
+// Queries allow stale reads, they can then be answered by all servers
+// instead of only the leader, unless RequireConsistent is set via
+// [WithQueryOptions].
+// Targets must specify the dc option, because there is no agent whose
+// datacenter is used by default. In Consul Enterprise they should also
+// specify the partition. The local-node option is not supported.
+func WithAgentless(servers ...string) BuilderOption {
+	return func(o *builderOptions) {
+		o.agentless = true
+		o.servers = servers
+	}
+}
+
+// WithSharedHealthQueries makes the resolvers created by the builder share
+// their Consul health queries. Resolvers that watch the same service via the
+// same Consul agent, with the same token and options, run a single query
+// instead of one per resolver. The tags of the targets are matched by the
+// resolvers instead of by Consul, so targets that only differ in their tags,
+// e.g. consul:///user-service?tags=primary and
+// consul:///user-service?tags=backup, share their queries too.
+//
+// It reduces the load on Consul in processes that dial the same service
+// with different tag filters, but transfers all instances of the service
+// in each query.
+func WithSharedHealthQueries() BuilderOption {
+	return func(o *builderOptions) {
+		o.sharedHealth = newSharedHealth()
+	}
+}
+
+// WithRemovalFunc configures a function that the resolvers created by the
+// builder call with the addresses they remove from their gRPC channels and
+// the reasons, before the channels receive the update. It allows
+// applications to drain sessions pinned to the instances proactively.
+//
+// To distinguish unhealthy from deregistered instances, Consul returns
+// instances regardless of their health and the resolvers filter them.
+// fn is called from the resolver goroutines and must not block.
+func WithRemovalFunc(fn RemovalFunc) BuilderOption {
+	return func(o *builderOptions) {
+		o.removalFunc = fn
+	}
+}
+
+// WithStuckWatchDetection makes the resolvers created by the builder detect
+// watches that might have stopped receiving updates. A watch is considered
+// stuck when Consul returned the same index for longer than threshold while
+// the service resolved to at least 1 address. A warning is then logged and a
+// [*WatchStuck] event emitted. If refresh is true, the resolver also closes
+// idle connections to the agent and runs a new non-blocking query.
+//
+// The index of a service that does not change stays the same, threshold
+// should be considerably longer than the time between changes of the
+// service, e.g. hours.
+func WithStuckWatchDetection(threshold time.Duration, refresh bool) BuilderOption {
+	return func(o *builderOptions) {
+		o.stuckThreshold = threshold
+		o.stuckRefresh = refresh
+	}
+}
+
+// WithEnricher configures a function that attaches data from an external
+// source to the addresses of the resolvers created by the builder, before
+// they are passed to the gRPC channel. The data can be retrieved with
+// [Enrichment].
+//
+// fn is called for new addresses and for addresses whose result is older
+// than ttl, when Consul returned the addresses of the service. The resolver
+// waits at most for timeout for the calls to return, 0 disables the timeout.
+// When a call fails or times out, the previous result of the address is
+// used, addresses without a result are passed without one.
+func WithEnricher(fn EnrichFunc, timeout, ttl time.Duration) BuilderOption {
+	return func(o *builderOptions) {
+		o.enrichFunc = fn
+		o.enrichTimeout = timeout
+		o.enrichTTL = ttl
+	}
+}
+
+// WithResolveNowInterval sets the minimum time between queries that the
+// resolvers created by the builder run when the gRPC channel calls
+// ResolveNow, e.g. after connections to the instances failed. Calls within
+// interval after the last such query are deferred until it expired and
+// coalesced into one query, each deferral is reported as a
+// [*ResolveNowDeferred] event.
+// It prevents channels whose connections fail repeatedly from sending a
+// query to Consul per reconnect attempt. The blocking queries that watch the
+// service are not affected. Default: no minimum interval.
+func WithResolveNowInterval(interval time.Duration) BuilderOption {
+	return func(o *builderOptions) {
+		o.resolveNowInterval = interval
+	}
+}
+
+// WithAddressQuarantine makes the resolvers created by the builder stop
+// passing addresses that were reported as failing via [ReportAddressFailure]
+// to the gRPC channel, until Consul noticed the failure. An address is
+// quarantined for d after its first failure, the duration is doubled with
+// every further failure up to maxD. When no failure of an address was
+// reported for maxD, it starts again with d.
+// If all addresses of a service are quarantined, all of them are passed.
+// Quarantines are reported as [*AddressQuarantined] events.
+func WithAddressQuarantine(d, maxD time.Duration) BuilderOption {
+	return func(o *builderOptions) {
+		o.quarantine = d
+		o.maxQuarantine = maxD
+	}
+}
+
+// WithHTTPTimeouts sets the timeouts of the HTTP connections to Consul of
+// the resolvers created by the builder, e.g. longer ones for connections
+// to remote Consul servers via WAN links. Timeouts in the target URL take
+// precedence over them.
+func WithHTTPTimeouts(t HTTPTimeouts) BuilderOption {
+	return func(o *builderOptions) {
+		o.timeouts = t
+	}
+}
+
+// WithConnectionPool configures the pool of the HTTP connections to Consul
+// of the resolvers created by the builder. Each resolver has its own pool.
+// Processes whose resolvers often run short queries, e.g. with
+// [WithConnectionMonitor] or [WithResolveNowInterval], can keep more and
+// longer idle connections to reuse them, instead of establishing new TCP
+// and TLS connections to the agent.
+func WithConnectionPool(p ConnectionPool) BuilderOption {
+	return func(o *builderOptions) {
+		o.pool = p
+	}
+}
 
 const scheme = "consul"
 
 // NewBuilder returns a builder for a consul resolver.
-func NewBuilder() resolver.Builder {
-	return &resolverBuilder{}
+func NewBuilder(opts ...BuilderOption) resolver.Builder {
+	b := resolverBuilder{scheme: scheme}
+	b.applyOpts(opts)
+
+	return &b
+}
+
+func (b *resolverBuilder) applyOpts(opts []BuilderOption) {
+	for _, o := range opts {
+		o(&b.opts)
+	}
 }
 
-func extractOpts(opts url.Values) (scheme string, tags []string, health healthFilter, token string, dc string, err error) {
+// NewTargetBuilder returns a builder for the URL scheme urlScheme that
+// resolves every target to the service described by target.
+// The target URL passed to [google.golang.org/grpc.Dial] is ignored apart
+// from its scheme, e.g. "payments:///".
+//
+// It can be used to configure resolvers via a configuration file instead of
+// target URLs:
+//
+//	var target consul.Target
+//	json.Unmarshal(cfg, &target)
+//	b, err := consul.NewTargetBuilder("payments", &target)
+//	resolver.Register(b)
+func NewTargetBuilder(urlScheme string, target *Target, opts ...BuilderOption) (resolver.Builder, error) {
+	t := target.clone()
+	t.normalize()
+
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+
+	t.setDefaults()
+
+	b := resolverBuilder{scheme: urlScheme, target: t}
+	b.applyOpts(opts)
+
+	return &b, nil
+}
+
+// NewProfileBuilder returns a builder for the URL scheme urlScheme that
+// applies the settings of profile to the targets it resolves.
+// Target URLs have the same format as consul:// URLs, e.g.
+// "consul-payments:///billing?tags=primary". Settings in the target URL
+// take precedence over the ones in profile, the Service of profile is
+// ignored.
+//
+// It allows to keep the environment-specific settings, like the Consul
+// server, datacenter and TLS configuration, in one place instead of in every
+// target URL:
+//
+//	b, err := consul.NewProfileBuilder("consul-payments", &consul.Target{
+//		ConsulAddr: "consul.payments.internal:8501",
+//		Scheme:     "https",
+//		DC:         "eu-1",
+//		TLS:        consul.TLSConfig{CAFile: "/etc/payments/consul-ca.pem"},
+//	}, consul.WithTokenFunc(paymentsToken))
+//	resolver.Register(b)
+//	grpc.Dial("consul-payments:///billing")
+func NewProfileBuilder(urlScheme string, profile *Target, opts ...BuilderOption) (resolver.Builder, error) {
+	p := profile.clone()
+	p.Service = ""
+	p.normalize()
+
+	// the service is defined by the target URLs, validate the other
+	// settings of the profile with a placeholder
+	check := *p
+	check.Service = urlScheme
+	if err := check.validate(); err != nil {
+		return nil, err
+	}
+
+	b := resolverBuilder{scheme: urlScheme, profile: p}
+	b.applyOpts(opts)
+
+	return &b, nil
+}
+
+func extractOpts(opts url.Values, t *Target) error {
 	for key, values := range opts {
 		if len(values) == 0 {
 			continue
@@ -68,72 +796,293 @@ func extractOpts(opts url.Values) (scheme string, tags []string, health healthFi
 
 		switch strings.ToLower(key) {
 		case "scheme":
-			scheme = strings.ToLower(value)
-			if scheme != "http" && scheme != "https" {
-				return "", nil, healthFilterUndefined, "", "", fmt.Errorf("unsupported scheme '%s'", value)
-			}
+			t.Scheme = value
 		case "tags":
-			tags = strings.Split(value, ",")
+			t.Tags = strings.Split(value, ",")
 		case "dc":
-			dc = value
+			t.DC = value
+		case "segment":
+			t.Segment = value
+		case "filter":
+			t.Filter = value
+		case "consul-srv":
+			srv, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%w '%s' for '%s': %w", ErrInvalidOptionValue, value, key, err)
+			}
+			t.ConsulSRV = srv
+		case "version":
+			t.Version = value
+		case "overrides-key":
+			t.OverridesKey = value
+		case "proxy":
+			t.Proxy = value
+		case "port-name":
+			t.PortName = value
+		case "prefer-node-address":
+			prefer, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%w '%s' for '%s': %w", ErrInvalidOptionValue, value, key, err)
+			}
+			t.PreferNodeAddress = prefer
+		case "require-service-address":
+			require, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%w '%s' for '%s': %w", ErrInvalidOptionValue, value, key, err)
+			}
+			t.RequireServiceAddress = require
+		case "port-zero":
+			policy, err := parsePortZeroPolicy(value)
+			if err != nil {
+				return err
+			}
+			t.PortZero = policy
+		case "local-node":
+			policy, err := parseLocalNodePolicy(value)
+			if err != nil {
+				return err
+			}
+			t.LocalNode = policy
+		case "translate-wan-addrs":
+			translate, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%w '%s' for '%s': %w", ErrInvalidOptionValue, value, key, err)
+			}
+			t.DisableWANTranslation = !translate
+		case "gate-check":
+			t.GateCheck = value
+		case "kind":
+			kind, err := parseKindFilter(value)
+			if err != nil {
+				return err
+			}
+			t.Kind = kind
+		case "priority-tags":
+			t.PriorityTags = strings.Split(value, ",")
+		case "slow-start":
+			d, err := parseTimeout(key, value)
+			if err != nil {
+				return err
+			}
+			t.SlowStart = d
+		case "check-types":
+			t.CheckTypes = strings.Split(strings.ToLower(value), ",")
+		case "dial-timeout":
+			d, err := parseTimeout(key, value)
+			if err != nil {
+				return err
+			}
+			t.Timeouts.Dial = d
+		case "tls-handshake-timeout":
+			d, err := parseTimeout(key, value)
+			if err != nil {
+				return err
+			}
+			t.Timeouts.TLSHandshake = d
+		case "response-header-timeout":
+			d, err := parseTimeout(key, value)
+			if err != nil {
+				return err
+			}
+			t.Timeouts.ResponseHeader = d
+		case "dual-stack":
+			dualStack, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%w '%s' for '%s': %w", ErrInvalidOptionValue, value, key, err)
+			}
+			t.DualStack = dualStack
+		case "max-instances":
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%w '%s' for '%s': %w", ErrInvalidOptionValue, value, key, err)
+			}
+			t.MaxInstances = n
+		case "max-instances-policy":
+			policy, err := parseMaxInstancesPolicy(value)
+			if err != nil {
+				return err
+			}
+			t.MaxInstancesPolicy = policy
+		case "min-healthy-fraction":
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("%w '%s' for '%s': %w", ErrInvalidOptionValue, value, key, err)
+			}
+			t.MinHealthyFraction = f
+		case "failure-tolerance":
+			d, err := parseTimeout(key, value)
+			if err != nil {
+				return err
+			}
+			t.FailureTolerance = d
+		case "failover-dcs":
+			t.FailoverDCs = strings.Split(value, ",")
+		case "strict":
+			strict, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%w '%s' for '%s': %w", ErrInvalidOptionValue, value, key, err)
+			}
+			t.Strict = strict
+		case "wait":
+			d, err := parseTimeout(key, value)
+			if err != nil {
+				return err
+			}
+			t.WaitTime = d
+		case "preserve-order":
+			preserve, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%w '%s' for '%s': %w", ErrInvalidOptionValue, value, key, err)
+			}
+			t.PreserveOrder = preserve
 		case "health":
-			switch strings.ToLower(value) {
-			case "healthy":
-				health = healthFilterOnlyHealthy
-			case "fallbacktounhealthy":
-				health = healthFilterFallbackToUnhealthy
-			default:
-				return "", nil, healthFilterUndefined, "", "", fmt.Errorf("unsupported health parameter value: '%s'", value)
+			health, err := parseHealthFilter(value)
+			if err != nil {
+				return err
 			}
+			t.Health = health
 		case "token":
-			token = value
+			t.Token = value
+		case "tls-verify":
+			verify, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%w '%s' for '%s': %w", ErrInvalidOptionValue, value, key, err)
+			}
+			t.TLS.InsecureSkipVerify = !verify
+		case "ca-bundle":
+			t.TLS.CABundleFile = value
+		case "dotted-namespace":
+			dotted, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("%w '%s' for '%s': %w", ErrInvalidOptionValue, value, key, err)
+			}
+			t.DottedNamespace = dotted
 		default:
-			return "", nil, healthFilterUndefined, "", "", fmt.Errorf("unsupported parameter: '%s'", key)
+			return &UnsupportedOptionError{Name: key}
 		}
 	}
 
-	return scheme, tags, health, token, dc, err
+	return nil
 }
 
-func parseEndpoint(url *url.URL) (serviceName, scheme string, tags []string, health healthFilter, token string, dc string, err error) {
-	const defHealthFilter = healthFilterOnlyHealthy
+// parseTimeout parses the value of the timeout option key.
+func parseTimeout(key, value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%w '%s' for '%s': %w", ErrInvalidOptionValue, value, key, err)
+	}
+
+	if d < 0 {
+		return 0, fmt.Errorf("%w '%s' for '%s': must not be negative", ErrInvalidOptionValue, value, key)
+	}
+
+	return d, nil
+}
+
+// parseEndpoint parses the target URL. If profile is not nil, its settings
+// are used for the settings that are not specified in the URL.
+func parseEndpoint(url *url.URL, profile *Target) (*Target, error) {
+	var t Target
+	if profile != nil {
+		t = *profile.clone()
+	}
+
+	if url.Host != "" {
+		t.ConsulAddr = url.Host
+	}
+
+	// the dotted-namespace option defines how the path is parsed
+	if err := extractOpts(url.Query(), &t); err != nil {
+		return nil, err
+	}
 
 	// url.Path contains a leading "/", when the URL is in the form
 	// scheme://host/path, remove it
-	serviceName = strings.TrimPrefix(url.Path, "/")
-	if serviceName == "" {
-		return "", "", nil, health, "", "", errors.New("path is missing in url")
+	if err := t.parseServicePath(strings.TrimPrefix(url.Path, "/")); err != nil {
+		return nil, err
 	}
 
-	scheme, tags, health, token, dc, err = extractOpts(url.Query())
+	t.normalize()
+
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+
+	t.setDefaults()
+
+	return &t, nil
+}
+
+// ParseTarget parses a consul:// target URL with the same rules that are
+// applied by the resolver.
+// Options that are not specified in the URL are set to their defaults or
+// left empty if the default is determined by the Consul client.
+func ParseTarget(target string) (*Target, error) {
+	u, err := url.Parse(target)
 	if err != nil {
-		return "", "", nil, health, "", "", err
+		return nil, redactURLError(err)
 	}
 
-	if health == healthFilterUndefined {
-		health = defHealthFilter
+	if u.Scheme != scheme {
+		return nil, fmt.Errorf("%w '%s', expecting '%s'", ErrUnsupportedURLScheme, u.Scheme, scheme)
 	}
 
-	return serviceName, scheme, tags, health, token, dc, nil
+	return parseEndpoint(u, nil)
 }
 
-func (*resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
-	serviceName, scheme, tags, health, token, dc, err := parseEndpoint(&target.URL)
-	if err != nil {
-		return nil, err
+func (b *resolverBuilder) Build(target resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	var t *Target
+
+	if b.target != nil {
+		t = b.target
+	} else {
+		services, err := parseCompositePath(target.URL.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		if services != nil {
+			return b.buildComposite(target, services, cc)
+		}
+
+		t, err = b.parseTarget(&target.URL)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(t.FailoverDCs) != 0 {
+		return b.buildFailover(target, t, cc)
 	}
 
-	r, err := newConsulResolver(cc, scheme, target.URL.Host, serviceName, tags, health, token, dc)
+	r, err := newConsulResolver(cc, t, &b.opts)
 	if err != nil {
 		return nil, err
 	}
 
+	r.dialTarget = target.URL.String()
 	r.start()
 
 	return r, nil
 }
 
+// parseTarget parses the target URL with the profile of the builder.
+func (b *resolverBuilder) parseTarget(u *url.URL) (*Target, error) {
+	t, err := parseEndpoint(u, b.profile)
+	if err != nil {
+		return nil, err
+	}
+
+	if b.opts.nomad {
+		if err := t.validateNomad(); err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
 // Scheme returns the URI scheme for the resolver
-func (*resolverBuilder) Scheme() string {
-	return scheme
+func (b *resolverBuilder) Scheme() string {
+	return b.scheme
 }
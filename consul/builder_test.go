@@ -1,6 +1,7 @@
 package consul
 
 import (
+	"errors"
 	"net/url"
 	"reflect"
 	"testing"
@@ -17,157 +18,367 @@ func mustParseURL(t *testing.T, strURL string) *url.URL {
 
 func TestParseEndpoint(t *testing.T) {
 	tests := []struct {
-		endpoint         *url.URL
-		wantServiceName  string
-		wantScheme       string
-		wantTags         []string
-		wantErr          bool
-		wantHealthFilter healthFilter
-		wantToken        string
-		wantDC           string
+		endpoint   *url.URL
+		wantTarget *Target
+		wantErr    bool
 	}{
 		{
 			mustParseURL(t, "consul://127.0.01:8500/user-service-rpc?scheme=https&tags=primary,backup&health=healthy&token=Olj1SIrsGXB_1orYMT71RVCs6FYwGZ_l&dc=welcome-dc"),
-			"user-service-rpc",
-			"https",
-			[]string{"primary", "backup"},
+			&Target{
+				ConsulAddr: "127.0.01:8500",
+				Service:    "user-service-rpc",
+				Scheme:     "https",
+				Tags:       []string{"primary", "backup"},
+				Health:     HealthFilterOnlyHealthy,
+				Token:      "Olj1SIrsGXB_1orYMT71RVCs6FYwGZ_l",
+				DC:         "welcome-dc",
+			},
 			false,
-			healthFilterOnlyHealthy,
-			"Olj1SIrsGXB_1orYMT71RVCs6FYwGZ_l",
-			"welcome-dc",
 		},
 
 		{
 			mustParseURL(t, "consul://127.0.0.1/user-service-rpc?tags=pri-mary,backup&scheme=http&health=fallbackToUnhealthy"),
-			"user-service-rpc",
-			"http",
-			[]string{"pri-mary", "backup"},
+			&Target{
+				ConsulAddr: "127.0.0.1",
+				Service:    "user-service-rpc",
+				Scheme:     "http",
+				Tags:       []string{"pri-mary", "backup"},
+				Health:     HealthFilterFallbackToUnhealthy,
+			},
 			false,
-			healthFilterFallbackToUnhealthy,
-			"",
-			"",
 		},
 
 		{
 			mustParseURL(t, "consul://localhost/user-service-rpc"),
-			"user-service-rpc",
-			"",
-			nil,
+			&Target{
+				ConsulAddr: "localhost",
+				Service:    "user-service-rpc",
+				Health:     HealthFilterOnlyHealthy,
+			},
 			false,
-			healthFilterOnlyHealthy,
-			"",
-			"",
 		},
 
 		{
 			mustParseURL(t, "consul://consul/user-service-rpc?health=blablub"),
-			"",
-			"",
 			nil,
 			true,
-			healthFilterUndefined,
-			"",
-			"",
 		},
 
 		{
 			mustParseURL(t, "consul://consul:8500/user-service-rpc?scheme=ftp"),
-			"",
-			"",
 			nil,
 			true,
-			healthFilterUndefined,
-			"",
-			"",
 		},
 
 		{
 			mustParseURL(t, "consul://[::1]/user-service-rpc?scheme=http?tags=primary"),
-			"",
-			"",
 			nil,
 			true,
-			healthFilterUndefined,
-			"",
-			"",
 		},
 
 		{
 			mustParseURL(t, "consul://localhost/user-service-rpc?unsupportedparam=yo"),
-			"",
-			"",
 			nil,
 			true,
-			healthFilterUndefined,
-			"",
-			"",
 		},
 
 		{
 			mustParseURL(t, "consul://127.0.01:8500/user-service-rpc?scheme=http&scheme=https&tags=primary,backup&health=healthy&tags=secondary&health=fallbacktounhealthy"),
-			"user-service-rpc",
-			"https",
-			[]string{"secondary"},
+			&Target{
+				ConsulAddr: "127.0.01:8500",
+				Service:    "user-service-rpc",
+				Scheme:     "https",
+				Tags:       []string{"secondary"},
+				Health:     HealthFilterFallbackToUnhealthy,
+			},
 			false,
-			healthFilterFallbackToUnhealthy,
-			"",
-			"",
 		},
 
 		{
 			mustParseURL(t, "consul://127.0.01:8500/user-service-rpc?dc=i-will-be-here"),
-			"user-service-rpc",
-			"",
+			&Target{
+				ConsulAddr: "127.0.01:8500",
+				Service:    "user-service-rpc",
+				Health:     HealthFilterOnlyHealthy,
+				DC:         "i-will-be-here",
+			},
+			false,
+		},
+
+		{
+			mustParseURL(t, "consul://127.0.01:8500/user-service-rpc?segment=alpha"),
+			&Target{
+				ConsulAddr: "127.0.01:8500",
+				Service:    "user-service-rpc",
+				Health:     HealthFilterOnlyHealthy,
+				Segment:    "alpha",
+			},
+			false,
+		},
+
+		{
+			mustParseURL(t, "consul://127.0.01:8500/user-service-rpc?filter=Service.Meta.version+%3D%3D+%222%22"),
+			&Target{
+				ConsulAddr: "127.0.01:8500",
+				Service:    "user-service-rpc",
+				Health:     HealthFilterOnlyHealthy,
+				Filter:     `Service.Meta.version == "2"`,
+			},
+			false,
+		},
+
+		{
+			mustParseURL(t, "consul://127.0.01:8500/user-service-rpc?filter=Service.Meta.version+%3D%3D"),
 			nil,
+			true,
+		},
+
+		{
+			mustParseURL(t, "consul://127.0.01:8500/team-a/billing/user-service-rpc"),
+			&Target{
+				ConsulAddr: "127.0.01:8500",
+				Service:    "user-service-rpc",
+				Namespace:  "billing",
+				Partition:  "team-a",
+				Health:     HealthFilterOnlyHealthy,
+			},
+			false,
+		},
+
+		{
+			mustParseURL(t, "consul://127.0.01:8500/user-service-rpc.billing?dotted-namespace=true"),
+			&Target{
+				ConsulAddr:      "127.0.01:8500",
+				Service:         "user-service-rpc",
+				Namespace:       "billing",
+				DottedNamespace: true,
+				Health:          HealthFilterOnlyHealthy,
+			},
+			false,
+		},
+
+		{
+			mustParseURL(t, "consul://127.0.01:8500/my.service"),
+			&Target{
+				ConsulAddr: "127.0.01:8500",
+				Service:    "my.service",
+				Health:     HealthFilterOnlyHealthy,
+			},
+			false,
+		},
+
+		{
+			mustParseURL(t, "consul://127.0.01:8500///user.service"),
+			&Target{
+				ConsulAddr: "127.0.01:8500",
+				Service:    "user.service",
+				Health:     HealthFilterOnlyHealthy,
+			},
+			false,
+		},
+
+		{
+			mustParseURL(t, "consul://127.0.01:8500/user-service-rpc?scheme=https&tls-verify=false"),
+			&Target{
+				ConsulAddr: "127.0.01:8500",
+				Service:    "user-service-rpc",
+				Scheme:     "https",
+				Health:     HealthFilterOnlyHealthy,
+				TLS:        TLSConfig{InsecureSkipVerify: true},
+			},
 			false,
-			healthFilterOnlyHealthy,
-			"",
-			"i-will-be-here",
+		},
+
+		{
+			mustParseURL(t, "consul://127.0.01:8500/user-service-rpc?tls-verify=maybe"),
+			nil,
+			true,
 		},
 
 		{
 			mustParseURL(t, ""),
-			"",
-			"",
 			nil,
 			true,
-			healthFilterUndefined,
-			"",
-			"",
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.endpoint.String(), func(t *testing.T) {
-			serviceName, scheme, tags, healthFilter, token, dc, err := parseEndpoint(tt.endpoint)
+			target, err := parseEndpoint(tt.endpoint, nil)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("parseEndpoint() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 
-			if serviceName != tt.wantServiceName {
-				t.Errorf("parseEndpoint() gotServiceName = %v, want %v", serviceName, tt.wantServiceName)
+			if !reflect.DeepEqual(target, tt.wantTarget) {
+				t.Errorf("parseEndpoint() got = %+v, want %+v", target, tt.wantTarget)
 			}
+		})
+	}
+}
 
-			if scheme != tt.wantScheme {
-				t.Errorf("parseEndpoint() gotScheme = %v, want %v", scheme, tt.wantScheme)
-			}
+func TestParseTarget(t *testing.T) {
+	target, err := ParseTarget("consul://localhost:8500/user-service?tags=primary")
+	if err != nil {
+		t.Fatal("ParseTarget() failed:", err)
+	}
 
-			if !reflect.DeepEqual(tt.wantTags, tags) {
-				t.Errorf("parseEndpoint() gotTags = %+v, want %+v", tags, tt.wantTags)
-			}
+	want := &Target{
+		ConsulAddr: "localhost:8500",
+		Service:    "user-service",
+		Tags:       []string{"primary"},
+		Health:     HealthFilterOnlyHealthy,
+	}
+	if !reflect.DeepEqual(target, want) {
+		t.Errorf("ParseTarget() got = %+v, want %+v", target, want)
+	}
+
+	_, err = ParseTarget("dns://localhost:8500/user-service")
+	if !errors.Is(err, ErrUnsupportedURLScheme) {
+		t.Errorf("ParseTarget() error = %v, want %v", err, ErrUnsupportedURLScheme)
+	}
+}
 
-			if healthFilter != tt.wantHealthFilter {
-				t.Errorf("parseEndpoint() gotHealthFilter = %v, want %v", healthFilter, tt.wantHealthFilter)
+func TestParseEndpointErrors(t *testing.T) {
+	tests := []struct {
+		endpoint *url.URL
+		wantErr  error
+	}{
+		{mustParseURL(t, "consul://localhost"), ErrMissingService},
+		{mustParseURL(t, "consul://localhost/billing/svc"), ErrInvalidServicePath},
+		{mustParseURL(t, "consul://localhost/team-a/billing/"), ErrMissingService},
+		{mustParseURL(t, "consul://localhost/svc?scheme=ftp"), ErrUnsupportedScheme},
+		{mustParseURL(t, "consul://localhost/svc?health=blablub"), ErrInvalidHealthFilter},
+		{mustParseURL(t, "consul://localhost/svc?filter=Service.ID+%3D%3D"), ErrInvalidFilter},
+		{mustParseURL(t, "consul://localhost/svc?tls-verify=maybe"), ErrInvalidOptionValue},
+		{mustParseURL(t, "consul://localhost/svc?version=%5Ex.y"), ErrInvalidVersionConstraint},
+		{mustParseURL(t, "consul:///svc?consul-srv=true"), ErrInvalidOptionValue},
+		{mustParseURL(t, "consul:///svc?prefer-node-address=true&require-service-address=true"), ErrInvalidOptionValue},
+		{mustParseURL(t, "consul:///svc?require-service-address=maybe"), ErrInvalidOptionValue},
+		{mustParseURL(t, "consul:///svc?port-zero=ignore"), ErrInvalidOptionValue},
+		{mustParseURL(t, "consul:///svc?local-node=always"), ErrInvalidOptionValue},
+		{mustParseURL(t, "consul:///svc?proxy=ftp%3A%2F%2Fproxy"), ErrInvalidOptionValue},
+		{mustParseURL(t, "consul:///svc?proxy=proxy.internal%3A3128"), ErrInvalidOptionValue},
+		{mustParseURL(t, "consul:///svc?wait=soon"), ErrInvalidOptionValue},
+		{mustParseURL(t, "consul:///svc?failover-dcs=us-east,"), ErrInvalidOptionValue},
+		{mustParseURL(t, "consul:///svc?wait=-1m"), ErrInvalidOptionValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint.String(), func(t *testing.T) {
+			_, err := parseEndpoint(tt.endpoint, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("parseEndpoint() error = %v, want %v", err, tt.wantErr)
 			}
+		})
+	}
+
+	t.Run("unsupportedOption", func(t *testing.T) {
+		_, err := parseEndpoint(mustParseURL(t, "consul://localhost/svc?unsupportedparam=yo"), nil)
+
+		var optErr *UnsupportedOptionError
+		if !errors.As(err, &optErr) {
+			t.Fatalf("parseEndpoint() error = %v, want UnsupportedOptionError", err)
+		}
+
+		if optErr.Name != "unsupportedparam" {
+			t.Errorf("UnsupportedOptionError.Name = %s, want unsupportedparam", optErr.Name)
+		}
+	})
+}
+
+func TestNewTargetBuilderCopiesAndNormalizesTarget(t *testing.T) {
+	target := Target{
+		Service: "billing",
+		Scheme:  "HTTPS",
+		Tags:    []string{"primary"},
+	}
+
+	b, err := NewTargetBuilder("payments", &target)
+	if err != nil {
+		t.Fatal("NewTargetBuilder() failed:", err)
+	}
+
+	target.Tags[0] = "secondary"
+
+	bt := b.(*resolverBuilder).target
+	if bt.Tags[0] != "primary" {
+		t.Errorf("builder target tags changed to %v after modifying the passed target", bt.Tags)
+	}
+
+	if bt.Scheme != "https" {
+		t.Errorf("builder target scheme is %q, expected https", bt.Scheme)
+	}
+}
+
+func TestProfileBuilderAppliesProfile(t *testing.T) {
+	profile := Target{
+		ConsulAddr: "consul.payments.internal:8501",
+		Scheme:     "HTTPS",
+		DC:         "eu-1",
+		Namespace:  "payments",
+		Tags:       []string{"primary"},
+		TLS:        TLSConfig{CAFile: "/etc/payments/consul-ca.pem"},
+	}
+
+	b, err := NewProfileBuilder("consul-payments", &profile)
+	if err != nil {
+		t.Fatal("NewProfileBuilder() failed:", err)
+	}
 
-			if token != tt.wantToken {
-				t.Errorf("parseEndpoint() gotToken = %s, want %s", token, tt.wantToken)
+	for _, tt := range []struct {
+		endpoint string
+		want     Target
+	}{
+		{
+			endpoint: "consul-payments:///billing",
+			want: Target{
+				ConsulAddr: "consul.payments.internal:8501",
+				Service:    "billing",
+				Namespace:  "payments",
+				Scheme:     "https",
+				Tags:       []string{"primary"},
+				Health:     HealthFilterOnlyHealthy,
+				DC:         "eu-1",
+				TLS:        TLSConfig{CAFile: "/etc/payments/consul-ca.pem"},
+			},
+		},
+		{
+			endpoint: "consul-payments://localhost:8500/billing.ledger?dc=eu-2&tags=canary&health=fallbackToUnhealthy&dotted-namespace=true",
+			want: Target{
+				ConsulAddr:      "localhost:8500",
+				Service:         "billing",
+				Namespace:       "ledger",
+				DottedNamespace: true,
+				Scheme:          "https",
+				Tags:            []string{"canary"},
+				Health:          HealthFilterFallbackToUnhealthy,
+				DC:              "eu-2",
+				TLS:             TLSConfig{CAFile: "/etc/payments/consul-ca.pem"},
+			},
+		},
+	} {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			got, err := parseEndpoint(mustParseURL(t, tt.endpoint), b.(*resolverBuilder).profile)
+			if err != nil {
+				t.Fatal("parseEndpoint() failed:", err)
 			}
 
-			if dc != tt.wantDC {
-				t.Errorf("parseEndpoint() gotDC = %s, want %s", dc, tt.wantDC)
+			if !reflect.DeepEqual(got, &tt.want) {
+				t.Errorf("parseEndpoint() got = %+v, want %+v", got, &tt.want)
 			}
 		})
 	}
+
+	profile.Tags[0] = "secondary"
+	if tags := b.(*resolverBuilder).profile.Tags; tags[0] != "primary" {
+		t.Errorf("profile tags changed to %v after modifying the passed profile", tags)
+	}
+}
+
+func TestProfileBuilderRejectsInvalidProfile(t *testing.T) {
+	_, err := NewProfileBuilder("consul-payments", &Target{Scheme: "ftp"})
+	if !errors.Is(err, ErrUnsupportedScheme) {
+		t.Errorf("NewProfileBuilder() returned error %v, expected ErrUnsupportedScheme", err)
+	}
 }
@@ -0,0 +1,49 @@
+package consul
+
+import (
+	"slices"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// isMaintenanceCheck returns true if hc is the check Consul registers while
+// a node or service is in maintenance mode.
+func isMaintenanceCheck(hc *consul.HealthCheck) bool {
+	return hc.CheckID == consul.NodeMaint || strings.HasPrefix(hc.CheckID, consul.ServiceMaintPrefix)
+}
+
+// filterCheckTypes returns copies of the entries that only contain the
+// health checks of the types and the maintenance checks. The checks of other
+// types are not considered for the health of the instances.
+func filterCheckTypes(entries []*consul.ServiceEntry, types []string) []*consul.ServiceEntry {
+	result := make([]*consul.ServiceEntry, 0, len(entries))
+
+	for _, e := range entries {
+		checks := make(consul.HealthChecks, 0, len(e.Checks))
+		for _, hc := range e.Checks {
+			if isMaintenanceCheck(hc) || slices.Contains(types, strings.ToLower(hc.Type)) {
+				checks = append(checks, hc)
+			}
+		}
+
+		filtered := *e
+		filtered.Checks = checks
+		result = append(result, &filtered)
+	}
+
+	return result
+}
+
+// filterPassing returns the entries whose health checks are all passing.
+func filterPassing(entries []*consul.ServiceEntry) []*consul.ServiceEntry {
+	result := make([]*consul.ServiceEntry, 0, len(entries))
+
+	for _, e := range entries {
+		if e.Checks.AggregatedStatus() == consul.HealthPassing {
+			result = append(result, e)
+		}
+	}
+
+	return result
+}
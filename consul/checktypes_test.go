@@ -0,0 +1,63 @@
+package consul
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestCheckTypes(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	entry := func(addr string, checks ...*consul.HealthCheck) *consul.ServiceEntry {
+		return &consul.ServiceEntry{
+			Service: &consul.AgentService{Address: addr, Port: 1},
+			Checks:  checks,
+		}
+	}
+	check := func(id, typ, status string) *consul.HealthCheck {
+		return &consul.HealthCheck{CheckID: id, Name: id, Type: typ, Status: status}
+	}
+
+	health.SetRespEntries([]*consul.ServiceEntry{
+		entry("10.0.0.1",
+			check("serfHealth", "", consul.HealthCritical),
+			check("grpc", "grpc", consul.HealthPassing),
+			check("script", "script", consul.HealthCritical),
+		),
+		entry("10.0.0.2", check("grpc", "grpc", consul.HealthCritical)),
+		entry("10.0.0.3", check("alias", "alias", consul.HealthCritical)),
+		entry("10.0.0.4",
+			check("grpc", "grpc", consul.HealthPassing),
+			check(consul.ServiceMaintPrefix+"web", "", consul.HealthCritical),
+		),
+	})
+
+	addrs, err := Lookup(context.Background(), "consul:///check-types-test?check-types=GRPC,http")
+	if err != nil {
+		t.Fatal("Lookup() failed:", err)
+	}
+
+	if got, want := addrStrings(addrs), []string{"10.0.0.1:1", "10.0.0.3:1"}; !slices.Equal(got, want) {
+		t.Errorf("resolved to %v, expected %v", got, want)
+	}
+
+	addrs, err = Lookup(context.Background(), "consul:///check-types-test?check-types=grpc&health=fallbackToUnhealthy")
+	if err != nil {
+		t.Fatal("Lookup() failed:", err)
+	}
+
+	if got, want := addrStrings(addrs), []string{"10.0.0.1:1", "10.0.0.3:1"}; !slices.Equal(got, want) {
+		t.Errorf("resolved to %v with health=fallbackToUnhealthy, expected %v", got, want)
+	}
+}
@@ -0,0 +1,59 @@
+package consul
+
+import "time"
+
+// Clock provides the current time and timers to the resolvers.
+// It can be replaced with [WithClock] to simulate the passing of time in
+// tests.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// After returns a channel that receives the current time after d.
+	After(d time.Duration) <-chan time.Time
+	// AfterFunc calls f in its own goroutine after d.
+	AfterFunc(d time.Duration, f func()) Timer
+	// NewTicker returns a ticker that sends the current time every d.
+	NewTicker(d time.Duration) Ticker
+}
+
+// Timer is a timer created by [Clock.AfterFunc].
+type Timer interface {
+	// Stop prevents the function from being called, it returns false if
+	// it was already called or the timer was stopped.
+	Stop() bool
+}
+
+// Ticker is a ticker created by [Clock.NewTicker].
+type Ticker interface {
+	// C returns the channel on which the ticks are delivered.
+	C() <-chan time.Time
+	// Stop turns off the ticker.
+	Stop()
+}
+
+// realClock is the [Clock] that uses the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) AfterFunc(d time.Duration, f func()) Timer {
+	return time.AfterFunc(d, f)
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	*time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.Ticker.C
+}
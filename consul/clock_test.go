@@ -0,0 +1,210 @@
+package consul
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+// fakeClock is a [Clock] whose time only changes when Advance is called.
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeTimer
+}
+
+type fakeTimer struct {
+	clock    *fakeClock
+	deadline time.Time
+	period   time.Duration
+	ch       chan time.Time
+	fn       func()
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeClock) addTimer(t *fakeTimer) *fakeTimer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t.clock = c
+	t.deadline = c.now.Add(t.deadline.Sub(time.Time{}))
+	c.waiters = append(c.waiters, t)
+
+	return t
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	return c.addTimer(&fakeTimer{deadline: time.Time{}.Add(d), ch: make(chan time.Time, 1)}).ch
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	return c.addTimer(&fakeTimer{deadline: time.Time{}.Add(d), fn: f})
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker {
+	return fakeTicker{c.addTimer(&fakeTimer{deadline: time.Time{}.Add(d), period: d, ch: make(chan time.Time, 1)})}
+}
+
+// Advance moves the time forward by d and fires the timers that expired.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+
+	var pending []*fakeTimer
+	for _, t := range c.waiters {
+		for !t.deadline.After(c.now) {
+			if t.fn != nil {
+				go t.fn()
+			} else {
+				select {
+				case t.ch <- c.now:
+				default:
+				}
+			}
+
+			if t.period == 0 {
+				break
+			}
+			t.deadline = t.deadline.Add(t.period)
+		}
+
+		if t.period != 0 || t.deadline.After(c.now) {
+			pending = append(pending, t)
+		}
+	}
+
+	c.waiters = pending
+}
+
+// Timers returns the number of timers that did not fire yet.
+func (c *fakeClock) Timers() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.waiters)
+}
+
+func (t *fakeTimer) remove() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+
+	for i, w := range t.clock.waiters {
+		if w == t {
+			t.clock.waiters = append(t.clock.waiters[:i], t.clock.waiters[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+func (t *fakeTimer) Stop() bool {
+	return t.remove()
+}
+
+type fakeTicker struct {
+	*fakeTimer
+}
+
+func (t fakeTicker) C() <-chan time.Time {
+	return t.ch
+}
+
+func (t fakeTicker) Stop() {
+	t.remove()
+}
+
+func TestSubscribeRetryUsesClock(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespError(errors.New("unavailable"))
+
+	clock := newFakeClock()
+	ch, stop, err := Subscribe("consul:///clock-test", WithClock(clock))
+	if err != nil {
+		t.Fatal("Subscribe() failed:", err)
+	}
+	defer stop()
+
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	health.SetRespError(nil)
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: 1},
+	})
+
+	clock.Advance(subscribeMinRetryInterval - time.Millisecond)
+	select {
+	case addrs := <-ch:
+		t.Fatalf("received addresses %v before the retry interval expired", addrs)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(time.Millisecond)
+	select {
+	case addrs := <-ch:
+		if len(addrs) != 1 || addrs[0] != "127.0.0.1:1" {
+			t.Errorf("received addresses %v, expected [127.0.0.1:1]", addrs)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no addresses received after the retry interval expired")
+	}
+}
+
+func TestResolverHealthUsesClock(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	clock := newFakeClock()
+	target, err := ParseTarget("consul:///clock-health-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newConsulResolver(mocks.NewClientConn(), target, &builderOptions{clock: clock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	r.status.querySucceeded(1)
+	if h := r.health(); !h.Healthy || !h.LastSuccess.Equal(clock.Now()) {
+		t.Errorf("resolver health is %+v, expected healthy with LastSuccess %s", h, clock.Now())
+	}
+
+	r.status.queryStarting()
+	clock.Advance(2 * r.waitTime)
+	if h := r.health(); h.Healthy {
+		t.Errorf("resolver health is %+v, expected unhealthy when the query runs for 2 * wait time", h)
+	}
+}
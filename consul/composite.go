@@ -0,0 +1,203 @@
+package consul
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// compositeService is a service of a composite target and the weight of the
+// traffic it receives.
+type compositeService struct {
+	path   string
+	weight int
+}
+
+// parseCompositePath parses the path of a composite target in the format
+// <service-path>@<weight>[+<service-path>@<weight>]...
+// It returns nil if path is not a composite target.
+func parseCompositePath(path string) ([]compositeService, error) {
+	path = strings.TrimPrefix(path, "/")
+	if !strings.ContainsAny(path, "@+") {
+		return nil, nil
+	}
+
+	parts := strings.Split(path, "+")
+	result := make([]compositeService, 0, len(parts))
+
+	for _, p := range parts {
+		svc, w, found := strings.Cut(p, "@")
+		if !found || svc == "" {
+			return nil, fmt.Errorf("%w '%s': services of composite targets must be in the format <service>@<weight>",
+				ErrInvalidServicePath, p)
+		}
+
+		weight, err := strconv.Atoi(w)
+		if err != nil || weight <= 0 {
+			return nil, fmt.Errorf("%w '%s': weight of '%s' must be a positive integer", ErrInvalidServicePath, w, svc)
+		}
+
+		result = append(result, compositeService{path: svc, weight: weight})
+	}
+
+	return result, nil
+}
+
+// buildComposite builds a resolver for a composite target. It runs a
+// resolver per service and passes the addresses of all of them to cc.
+func (b *resolverBuilder) buildComposite(target resolver.Target, services []compositeService, cc resolver.ClientConn) (resolver.Resolver, error) {
+	c := compositeResolver{cc: cc}
+
+	targets := make([]*Target, 0, len(services))
+	for _, s := range services {
+		u := target.URL
+		u.Path = "/" + s.path
+
+		t, err := b.parseTarget(&u)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(t.FailoverDCs) != 0 {
+			return nil, fmt.Errorf("%w: failover-dcs is not supported for composite targets", ErrInvalidOptionValue)
+		}
+
+		targets = append(targets, t)
+	}
+
+	for i, t := range targets {
+		child := compositeChild{parent: &c, weight: services[i].weight}
+
+		r, err := newConsulResolver(&child, t, &b.opts)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+
+		r.dialTarget = target.URL.String()
+		child.resolver = r
+		c.children = append(c.children, &child)
+	}
+
+	for _, child := range c.children {
+		child.resolver.start()
+	}
+
+	return &c, nil
+}
+
+// compositeResolver resolves a composite target. It merges the addresses of
+// the resolvers of its services and attaches their split weights to them.
+type compositeResolver struct {
+	cc       resolver.ClientConn
+	children []*compositeChild
+
+	// mu serializes the updates of cc and protects the states of the
+	// children.
+	mu sync.Mutex
+}
+
+func (c *compositeResolver) ResolveNow(opts resolver.ResolveNowOptions) {
+	for _, child := range c.children {
+		child.resolver.ResolveNow(opts)
+	}
+}
+
+func (c *compositeResolver) Close() {
+	for _, child := range c.children {
+		child.resolver.Close()
+	}
+}
+
+// updateLocked passes the merged addresses of the children to cc. Until all
+// children resolved their service or failed, nothing is passed, to not
+// send all traffic to the services that were resolved first.
+func (c *compositeResolver) updateLocked() error {
+	var state resolver.State
+	var resolved bool
+	var lastErr error
+
+	for _, child := range c.children {
+		if child.state == nil {
+			if child.err == nil {
+				return nil
+			}
+
+			lastErr = child.err
+			continue
+		}
+		resolved = true
+
+		share := float64(child.weight) / float64(max(1, len(child.state.Addresses)))
+		for _, a := range child.state.Addresses {
+			a.BalancerAttributes = a.BalancerAttributes.WithValue(splitWeightAttributeKey, share)
+			state.Addresses = append(state.Addresses, a)
+		}
+		state.Endpoints = append(state.Endpoints, child.state.Endpoints...)
+	}
+
+	if !resolved {
+		c.cc.ReportError(lastErr)
+		return nil
+	}
+
+	return c.cc.UpdateState(state)
+}
+
+// compositeChild is the [resolver.ClientConn] of the resolver of a service of
+// a composite target.
+type compositeChild struct {
+	parent   *compositeResolver
+	weight   int
+	resolver *consulResolver
+
+	// state and err are protected by parent.mu.
+	state *resolver.State
+	err   error
+}
+
+func (c *compositeChild) UpdateState(state resolver.State) error {
+	c.parent.mu.Lock()
+	defer c.parent.mu.Unlock()
+
+	c.state = &state
+
+	return c.parent.updateLocked()
+}
+
+// ReportError records err. It is only reported to the gRPC channel if none
+// of the services were resolved, otherwise the addresses of the services are
+// kept.
+func (c *compositeChild) ReportError(err error) {
+	c.parent.mu.Lock()
+	defer c.parent.mu.Unlock()
+
+	c.err = err
+	if c.state == nil {
+		_ = c.parent.updateLocked()
+	}
+}
+
+func (c *compositeChild) NewAddress(addresses []resolver.Address) {
+	_ = c.UpdateState(resolver.State{Addresses: addresses})
+}
+
+func (c *compositeChild) NewServiceConfig(string) {}
+
+func (c *compositeChild) ParseServiceConfig(sc string) *serviceconfig.ParseResult {
+	return c.parent.cc.ParseServiceConfig(sc)
+}
+
+// SplitWeight returns the weight of the traffic of a composite target, e.g.
+// consul:///svc-v1@90+svc-v2@10, that the address should receive. It is the
+// weight of the service of the address divided by the number of its
+// addresses, e.g. 30 for each of 3 addresses of svc-v1.
+// It is only available for addresses of composite targets.
+func SplitWeight(addr resolver.Address) (float64, bool) {
+	w, ok := addr.BalancerAttributes.Value(splitWeightAttributeKey).(float64)
+	return w, ok
+}
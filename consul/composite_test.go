@@ -0,0 +1,111 @@
+package consul
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+// serviceHealthClients dispatches the queries to the mock of the queried
+// service.
+type serviceHealthClients map[string]*mocks.ConsulHealthClient
+
+func (s serviceHealthClients) ServiceMultipleTags(service string, tags []string, passingOnly bool, q *consul.QueryOptions) ([]*consul.ServiceEntry, *consul.QueryMeta, error) {
+	return s[service].ServiceMultipleTags(service, tags, passingOnly, q)
+}
+
+func TestParseCompositePath(t *testing.T) {
+	services, err := parseCompositePath("/svc-v1@90+svc-v2@10")
+	if err != nil {
+		t.Fatal("parseCompositePath() failed:", err)
+	}
+
+	want := []compositeService{{path: "svc-v1", weight: 90}, {path: "svc-v2", weight: 10}}
+	if len(services) != len(want) || services[0] != want[0] || services[1] != want[1] {
+		t.Errorf("parsed %+v, want %+v", services, want)
+	}
+
+	if services, err := parseCompositePath("/svc-v1"); services != nil || err != nil {
+		t.Errorf("parseCompositePath() of non composite path returned %+v, %v, want nil", services, err)
+	}
+
+	for _, path := range []string{"/svc-v1+svc-v2", "/svc-v1@90+svc-v2", "/svc-v1@0+svc-v2@10", "/@5", "/svc-v1@x"} {
+		if _, err := parseCompositePath(path); !errors.Is(err, ErrInvalidServicePath) {
+			t.Errorf("parseCompositePath(%q) returned %v, want %v", path, err, ErrInvalidServicePath)
+		}
+	}
+
+	if _, err := ParseTarget("consul:///svc-v1@90+svc-v2@10"); !errors.Is(err, ErrInvalidServicePath) {
+		t.Errorf("ParseTarget() of composite target returned %v, want %v", err, ErrInvalidServicePath)
+	}
+}
+
+func TestCompositeTargetSplitsWeights(t *testing.T) {
+	v1 := mocks.NewConsulHealthClient()
+	v1.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "10.0.0.1", Port: 1},
+		{Address: "10.0.0.2", Port: 1},
+		{Address: "10.0.0.3", Port: 1},
+	})
+	v2 := mocks.NewConsulHealthClient()
+	v2.SetRespServiceEntries([]*consul.AgentService{{Address: "10.0.1.1", Port: 1}})
+
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return serviceHealthClients{"svc-v1": v1, "svc-v2": v2}, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	cc := mocks.NewClientConn()
+	r, err := NewBuilder().Build(resolver.Target{URL: url.URL{Path: "/svc-v1@90+svc-v2@10"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err)
+	}
+	defer r.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(cc.Addrs()) != 4 {
+		if time.Now().After(deadline) {
+			t.Fatalf("resolved to %v, expected the 4 addresses of both services", addrStrings(cc.Addrs()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	want := map[string]float64{"10.0.0.1:1": 30, "10.0.0.2:1": 30, "10.0.0.3:1": 30, "10.0.1.1:1": 10}
+	for _, a := range cc.Addrs() {
+		w, ok := SplitWeight(a)
+		if !ok || w != want[a.Addr] {
+			t.Errorf("split weight of %s is %v (%t), want %v", a.Addr, w, ok, want[a.Addr])
+		}
+	}
+}
+
+func TestCompositeTargetWaitsForAllServices(t *testing.T) {
+	cc := mocks.NewClientConn()
+	c := compositeResolver{cc: cc}
+	v1 := compositeChild{parent: &c, weight: 1}
+	v2 := compositeChild{parent: &c, weight: 1}
+	c.children = []*compositeChild{&v1, &v2}
+
+	if err := v1.UpdateState(resolver.State{Addresses: []resolver.Address{{Addr: "10.0.0.1:1"}}}); err != nil {
+		t.Fatal("UpdateState() failed:", err)
+	}
+	if cnt := cc.UpdateStateCallCnt(); cnt != 0 {
+		t.Fatalf("UpdateState was called %d times before all services were resolved, expected 0", cnt)
+	}
+
+	v2.ReportError(errors.New("query failed"))
+	if got := addrStrings(cc.Addrs()); len(got) != 1 || got[0] != "10.0.0.1:1" {
+		t.Errorf("resolved to %v, expected the address of the resolved service", got)
+	}
+	if err := cc.LastReportedError(); err != nil {
+		t.Errorf("error %v was reported while a service was resolved", err)
+	}
+}
@@ -0,0 +1,216 @@
+package consul
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// TokenOrigin describes where the ACL token of a resolver comes from.
+type TokenOrigin int
+
+const (
+	// TokenOriginNone is the origin of resolvers that do not send a
+	// token.
+	TokenOriginNone TokenOrigin = iota
+	// TokenOriginTarget is the origin of the token from the target.
+	TokenOriginTarget
+	// TokenOriginTokenSource is the origin of tokens from the
+	// [TokenSource] configured with [WithTokenSource].
+	TokenOriginTokenSource
+	// TokenOriginTokenFunc is the origin of the token returned by the
+	// function configured with [WithTokenFunc].
+	TokenOriginTokenFunc
+	// TokenOriginEnvFile is the origin of the token read from the file
+	// in the CONSUL_HTTP_TOKEN_FILE environment variable.
+	TokenOriginEnvFile
+	// TokenOriginEnv is the origin of the token from the
+	// CONSUL_HTTP_TOKEN or NOMAD_TOKEN environment variable.
+	TokenOriginEnv
+)
+
+func (o TokenOrigin) String() string {
+	switch o {
+	case TokenOriginNone:
+		return "none"
+	case TokenOriginTarget:
+		return "target"
+	case TokenOriginTokenSource:
+		return "token-source"
+	case TokenOriginTokenFunc:
+		return "token-func"
+	case TokenOriginEnvFile:
+		return "environment-file"
+	case TokenOriginEnv:
+		return "environment"
+	default:
+		return fmt.Sprintf("TokenOrigin(%d)", int(o))
+	}
+}
+
+// ResolverConfig is the effective configuration of an active resolver, after
+// the settings of its target, environment variables and builder options
+// were merged. It does not contain secrets.
+type ResolverConfig struct {
+	// Target is the target URL of the resolver without the token.
+	Target string
+	// Service, Namespace and Partition identify the resolved service.
+	Service   string
+	Namespace string
+	Partition string
+	// Address is the address of the Consul agent or server the queries
+	// are sent to, the DNS name of its SRV records if ConsulSRV is true
+	// or the address of the Nomad agent.
+	Address   string
+	ConsulSRV bool
+	// Servers are the addresses of the Consul servers configured with
+	// [WithAgentless], they are used instead of Address.
+	Servers []string
+	// Scheme is http or https.
+	Scheme string
+	// Datacenter is the datacenter of the service, empty for the
+	// datacenter of the Consul agent, or the Nomad region.
+	Datacenter string
+	// Proxy is the URL of the HTTP proxy requests are sent through.
+	Proxy string
+	// TokenOrigin describes where the ACL token comes from.
+	TokenOrigin TokenOrigin
+	// TLSVerify is false if the certificate of Consul is not verified.
+	// CAFile and TLSServerName are the CA file and server name used to
+	// verify it.
+	TLSVerify     bool
+	CAFile        string
+	TLSServerName string
+	// Agentless is true if the resolver queries Consul servers directly.
+	Agentless bool
+	// AllowStale and RequireConsistent are the consistency modes of the
+	// queries.
+	AllowStale        bool
+	RequireConsistent bool
+	// WaitTime is the maximum duration blocking queries wait for
+	// changes.
+	WaitTime time.Duration
+	// Tags, Health and Filter are the current query settings, they can
+	// differ from the target when overrides are applied.
+	Tags   []string
+	Health HealthFilter
+	Filter string
+}
+
+// newResolverConfig returns the effective configuration of a resolver that
+// queries Consul with the client configuration cfg. The defaults from the
+// environment are merged like by [consul.NewClient].
+func newResolverConfig(cfg *consul.Config, target *Target, opts *builderOptions, tokenFromFunc bool) ResolverConfig {
+	def := consul.DefaultConfig()
+
+	result := ResolverConfig{
+		Service:       target.Service,
+		Namespace:     target.Namespace,
+		Partition:     target.Partition,
+		Address:       cfg.Address,
+		ConsulSRV:     target.ConsulSRV,
+		Servers:       slices.Clone(opts.servers),
+		Scheme:        cfg.Scheme,
+		Datacenter:    cfg.Datacenter,
+		Proxy:         redactProxyURL(target.Proxy),
+		TLSVerify:     !cfg.TLSConfig.InsecureSkipVerify && !def.TLSConfig.InsecureSkipVerify,
+		CAFile:        cfg.TLSConfig.CAFile,
+		TLSServerName: cfg.TLSConfig.Address,
+		Agentless:     opts.agentless,
+	}
+
+	if result.Address == "" {
+		result.Address = def.Address
+	}
+	if result.Scheme == "" {
+		result.Scheme = def.Scheme
+	}
+	if proto, addr, found := strings.Cut(result.Address, "://"); found {
+		if proto == "https" {
+			result.Scheme = proto
+		}
+		result.Address = addr
+	}
+	if result.CAFile == "" {
+		result.CAFile = def.TLSConfig.CAFile
+	}
+	if result.TLSServerName == "" {
+		result.TLSServerName = def.TLSConfig.Address
+	}
+	if result.Namespace == "" {
+		result.Namespace = def.Namespace
+	}
+	if result.Partition == "" {
+		result.Partition = def.Partition
+	}
+
+	switch {
+	case target.Token != "":
+		result.TokenOrigin = TokenOriginTarget
+	case opts.tokenSource != nil:
+		result.TokenOrigin = TokenOriginTokenSource
+	case tokenFromFunc:
+		result.TokenOrigin = TokenOriginTokenFunc
+	case def.TokenFile != "":
+		result.TokenOrigin = TokenOriginEnvFile
+	case def.Token != "":
+		result.TokenOrigin = TokenOriginEnv
+	}
+
+	return result
+}
+
+// applyNomad replaces the settings of c that the Nomad client takes from the
+// environment.
+func (c *ResolverConfig) applyNomad(n *nomadClient, target *Target) {
+	c.Scheme, c.Address, _ = strings.Cut(n.baseURL, "://")
+	if c.Namespace == "" {
+		c.Namespace = n.namespace
+	}
+	c.Datacenter = n.region
+	c.Partition = ""
+
+	c.TokenOrigin = TokenOriginNone
+	if target.Token != "" {
+		c.TokenOrigin = TokenOriginTarget
+	} else if n.token != "" {
+		c.TokenOrigin = TokenOriginEnv
+	}
+}
+
+func (c *consulResolver) effectiveConfig() ResolverConfig {
+	result := c.config
+	result.Target = c.redactedTarget
+	result.WaitTime = c.waitTime
+
+	c.mu.Lock()
+	result.Tags = slices.Clone(c.settings.tags)
+	result.Health = c.settings.healthFilter
+	result.Filter = c.settings.filter
+	c.mu.Unlock()
+
+	return result
+}
+
+// ResolversConfig returns the effective configuration of all resolvers that
+// were built and not closed yet, ordered by their targets.
+// It allows operators to verify which Consul address, datacenter and token
+// source a client really uses.
+func ResolversConfig() []ResolverConfig {
+	resolvers := activeResolvers.all()
+
+	result := make([]ResolverConfig, 0, len(resolvers))
+	for _, r := range resolvers {
+		result = append(result, r.effectiveConfig())
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Target < result[j].Target
+	})
+
+	return result
+}
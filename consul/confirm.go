@@ -0,0 +1,88 @@
+package consul
+
+import (
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// confirmation is the time when Consul returned the addresses of a resolver
+// the last time. All addresses of a resolver share the same confirmation,
+// its time changes with every successful query, without changing the
+// addresses that were passed to the gRPC channel.
+type confirmation struct {
+	unixNano atomic.Int64
+}
+
+func (c *confirmation) set(t time.Time) {
+	c.unixNano.Store(t.UnixNano())
+}
+
+// get returns the time of the confirmation, it is zero if no query
+// succeeded yet.
+func (c *confirmation) get() time.Time {
+	ns := c.unixNano.Load()
+	if ns == 0 {
+		return time.Time{}
+	}
+
+	return time.Unix(0, ns)
+}
+
+// Equal returns true if o is the same confirmation.
+func (c *confirmation) Equal(o any) bool {
+	oc, ok := o.(*confirmation)
+	return ok && c == oc
+}
+
+// ConfirmedAt returns the time when Consul returned the instance addr was
+// resolved from the last time. It is updated by every successful query of
+// the resolver, also by the ones that did not change the addresses.
+// It is only available when the builder was created with
+// [WithAddressConfirmation].
+func ConfirmedAt(addr resolver.Address) (time.Time, bool) {
+	c, ok := addr.BalancerAttributes.Value(confirmedAttributeKey).(*confirmation)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	t := c.get()
+	return t, !t.IsZero()
+}
+
+// addressAgeWatcher refreshes the addresses with a non-blocking query on a
+// new connection when Consul did not return them for longer than
+// maxAddressAge, e.g. because a blocking query hangs on a broken
+// connection.
+func (c *consulResolver) addressAgeWatcher() {
+	defer c.wgStop.Done()
+
+	for {
+		wait := c.maxAddressAge
+		if at := c.confirmed.get(); !at.IsZero() {
+			age := c.clock.Now().Sub(at)
+			if age >= c.maxAddressAge {
+				c.log.warningf("grpc-consul-resolver: addresses of service '%s' were not confirmed by consul for %s, refreshing them",
+					c.service, age)
+				c.status.tracef("addresses were not confirmed for %s, refreshing them", age)
+				c.refreshAddresses()
+			} else {
+				wait -= age
+			}
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-c.clock.After(wait):
+		}
+	}
+}
+
+// refreshAddresses closes the idle connections to Consul and restarts the
+// running query as non-blocking query.
+func (c *consulResolver) refreshAddresses() {
+	c.refreshPending.Store(true)
+	c.reconnect()
+}
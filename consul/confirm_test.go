@@ -0,0 +1,140 @@
+package consul
+
+import (
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestConfirmedAtIsUpdatedByUnchangedResults(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: 1},
+	})
+
+	target, err := ParseTarget("consul:///confirm-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := newFakeClock()
+	cc := mocks.NewClientConn()
+	r, err := newConsulResolver(cc, target, &builderOptions{
+		clock:        clock,
+		confirmation: true,
+	})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	r.poll()
+	addrs := cc.Addrs()
+	if len(addrs) != 1 {
+		t.Fatalf("resolved to %+v, expected 1 address", addrs)
+	}
+
+	if at, ok := ConfirmedAt(addrs[0]); !ok || !at.Equal(clock.Now()) {
+		t.Errorf("ConfirmedAt() returned %s, %t, expected %s", at, ok, clock.Now())
+	}
+
+	// a new index prevents that poll() waits on the fake clock because
+	// the mock responds too fast with the same data
+	health.SetRespIndex(2)
+	clock.Advance(time.Minute)
+	r.poll()
+
+	if cnt := cc.UpdateStateCallCnt(); cnt != 1 {
+		t.Errorf("UpdateState was called %d times, expected 1, the confirmation must not change the addresses", cnt)
+	}
+
+	if at, ok := ConfirmedAt(addrs[0]); !ok || !at.Equal(clock.Now()) {
+		t.Errorf("ConfirmedAt() returned %s, %t after the second query, expected %s", at, ok, clock.Now())
+	}
+}
+
+func TestMaxAddressAgeRefreshesAddresses(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: 1},
+	})
+	health.SetRespIndex(10)
+
+	target, err := ParseTarget("consul:///confirm-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := newFakeClock()
+	r, err := newConsulResolver(mocks.NewClientConn(), target, &builderOptions{
+		clock: clock,
+		// republishing the unchanged addresses after every query
+		// prevents that poll() waits on the fake clock because the
+		// mock responds too fast with the same index
+		republishInterval: time.Nanosecond,
+		confirmation:      true,
+		maxAddressAge:     time.Hour,
+	})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	r.poll()
+
+	r.wgStop.Add(1)
+	go r.addressAgeWatcher()
+
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	clock.Advance(30 * time.Minute)
+	r.poll()
+	if wi := health.LastQueryOptions().WaitIndex; wi != 10 {
+		t.Fatalf("query before maxAge passed had WaitIndex %d, expected a blocking query with index 10", wi)
+	}
+
+	// the addresses were confirmed after 30min, the watcher checks them
+	// again after 1h
+	clock.Advance(31 * time.Minute)
+	for clock.Timers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if r.refreshPending.Load() {
+		t.Fatal("addresses were refreshed before maxAge passed")
+	}
+
+	clock.Advance(30 * time.Minute)
+	for !r.refreshPending.Load() {
+		time.Sleep(time.Millisecond)
+	}
+
+	r.poll()
+	if wi := health.LastQueryOptions().WaitIndex; wi != 0 {
+		t.Errorf("query after maxAge passed had WaitIndex %d, expected a non-blocking query", wi)
+	}
+
+	clock.Advance(time.Second)
+	r.poll()
+	if wi := health.LastQueryOptions().WaitIndex; wi != 10 {
+		t.Errorf("query after the refresh had WaitIndex %d, expected a blocking query with index 10", wi)
+	}
+}
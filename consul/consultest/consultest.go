@@ -0,0 +1,156 @@
+// Package consultest runs a Consul development agent for integration tests
+// of gRPC clients that use the consul resolver.
+package consultest
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/consul"
+)
+
+// startTimeout is the maximum duration to wait for the agent to elect
+// itself as leader.
+const startTimeout = 30 * time.Second
+
+// Agent is a running Consul development agent.
+type Agent struct {
+	// Addr is the address of the HTTP API of the agent, in the form
+	// host:port.
+	Addr string
+
+	client *api.Client
+}
+
+// StartDevAgent starts a Consul agent in development mode, listening on
+// free ports of 127.0.0.1, and registers the fixtures.
+// The test is skipped if the consul binary is not found in the PATH. The
+// agent is stopped when the test finished.
+func StartDevAgent(t testing.TB, fixtures ...*api.AgentServiceRegistration) *Agent {
+	t.Helper()
+
+	bin, err := exec.LookPath("consul")
+	if err != nil {
+		t.Skip("consul binary not found in PATH, skipping test")
+	}
+
+	ports := make([]int, 4)
+	for i := range ports {
+		ports[i] = freePort(t)
+	}
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(ports[0]))
+	config := fmt.Sprintf(
+		"ports { http = %d, serf_lan = %d, serf_wan = %d, server = %d, dns = -1, grpc = -1, grpc_tls = -1 }",
+		ports[0], ports[1], ports[2], ports[3],
+	)
+
+	cmd := exec.Command(bin, "agent", "-dev",
+		"-bind", "127.0.0.1",
+		"-client", "127.0.0.1",
+		"-hcl", config,
+	)
+	if err := cmd.Start(); err != nil {
+		t.Fatal("starting consul agent failed:", err)
+	}
+
+	t.Cleanup(func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	})
+
+	client, err := api.NewClient(&api.Config{Address: addr})
+	if err != nil {
+		t.Fatal("creating consul client failed:", err)
+	}
+
+	a := Agent{Addr: addr, client: client}
+	a.waitForLeader(t)
+
+	for _, f := range fixtures {
+		a.Register(t, f)
+	}
+
+	return &a
+}
+
+func freePort(t testing.TB) int {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("finding a free port failed:", err)
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+func (a *Agent) waitForLeader(t testing.TB) {
+	t.Helper()
+
+	deadline := time.Now().Add(startTimeout)
+	for {
+		leader, err := a.client.Status().Leader()
+		if err == nil && leader != "" {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("consul agent did not elect a leader within %s, last error: %v", startTimeout, err)
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Register registers the service instance at the agent.
+// Instances without checks are healthy.
+func (a *Agent) Register(t testing.TB, reg *api.AgentServiceRegistration) {
+	t.Helper()
+
+	if err := a.client.Agent().ServiceRegister(reg); err != nil {
+		t.Fatalf("registering service %q failed: %v", reg.Name, err)
+	}
+}
+
+// Deregister removes the service instance with the ID from the agent.
+func (a *Agent) Deregister(t testing.TB, serviceID string) {
+	t.Helper()
+
+	if err := a.client.Agent().ServiceDeregister(serviceID); err != nil {
+		t.Fatalf("deregistering service %q failed: %v", serviceID, err)
+	}
+}
+
+// Client returns a Consul API client for the agent.
+func (a *Agent) Client() *api.Client {
+	return a.client
+}
+
+// Builder returns a consul resolver builder that resolves targets without a
+// Consul server address, like consul:///my-service, via the agent.
+// It can be passed to [google.golang.org/grpc.WithResolvers].
+func (a *Agent) Builder(opts ...consul.BuilderOption) resolver.Builder {
+	return &agentBuilder{Builder: consul.NewBuilder(opts...), addr: a.Addr}
+}
+
+type agentBuilder struct {
+	resolver.Builder
+	addr string
+}
+
+func (b *agentBuilder) Build(target resolver.Target, cc resolver.ClientConn, opts resolver.BuildOptions) (resolver.Resolver, error) {
+	if target.URL.Host == "" {
+		target.URL.Host = b.addr
+	}
+
+	return b.Builder.Build(target, cc, opts)
+}
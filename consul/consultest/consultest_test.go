@@ -0,0 +1,99 @@
+package consultest
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+
+	"github.com/simplesurance/grpcconsulresolver/consul"
+)
+
+type nopClientConn struct{}
+
+func (nopClientConn) UpdateState(resolver.State) error { return nil }
+func (nopClientConn) ReportError(error)                {}
+func (nopClientConn) NewAddress([]resolver.Address)    {}
+func (nopClientConn) NewServiceConfig(string)          {}
+func (nopClientConn) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{}
+}
+
+func TestBuilderUsesAgentAddr(t *testing.T) {
+	a := Agent{Addr: "127.0.0.1:1"}
+
+	tests := []struct {
+		target     string
+		wantTarget string
+	}{
+		{"consul:///builder-test", "consul://127.0.0.1:1/builder-test?health=healthy"},
+		{"consul://10.0.0.1:8500/builder-test", "consul://10.0.0.1:8500/builder-test?health=healthy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			u, err := url.Parse(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := a.Builder().Build(resolver.Target{URL: *u}, nopClientConn{}, resolver.BuildOptions{})
+			if err != nil {
+				t.Fatal("Build() failed:", err)
+			}
+			defer r.Close()
+
+			h := consul.ResolversHealth()
+			if len(h) != 1 || h[0].Target != tt.wantTarget {
+				t.Errorf("ResolversHealth() returned %+v, expected one resolver with target %q", h, tt.wantTarget)
+			}
+		})
+	}
+}
+
+func TestDevAgent(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	a := StartDevAgent(t, &api.AgentServiceRegistration{
+		Name:    "dev-agent-test",
+		Address: "127.0.0.1",
+		Port:    p,
+	})
+
+	conn, err := grpc.Dial("consul:///dev-agent-test",
+		grpc.WithResolvers(a.Builder()),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatal("Dial() failed:", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true)); err != nil {
+		t.Fatal("health check failed:", err)
+	}
+}
@@ -0,0 +1,28 @@
+package consul
+
+import (
+	"google.golang.org/grpc"
+)
+
+// defaultServiceConfig configures the round_robin load balancer, to
+// distribute calls over all addresses of a service.
+const defaultServiceConfig = `{"loadBalancingConfig": [{"round_robin": {}}]}`
+
+// DialOptions returns the options to dial the consul:// target with
+// [google.golang.org/grpc.Dial].
+// The options register a resolver built with opts for the channel, configure
+// the round_robin load balancer as default and set the authority to the name
+// of the service.
+// Transport credentials are not included and must be passed additionally.
+func DialOptions(target string, opts ...BuilderOption) ([]grpc.DialOption, error) {
+	t, err := ParseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return []grpc.DialOption{
+		grpc.WithResolvers(NewBuilder(opts...)),
+		grpc.WithDefaultServiceConfig(defaultServiceConfig),
+		grpc.WithAuthority(t.Service),
+	}, nil
+}
@@ -0,0 +1,75 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestDialOptions(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	consulHealth := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return consulHealth, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	consulHealth.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: p},
+	})
+
+	const target = "consul:///dial-test"
+	opts, err := DialOptions(target)
+	if err != nil {
+		t.Fatal("DialOptions() failed:", err)
+	}
+
+	conn, err := grpc.Dial(target, append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))...)
+	if err != nil {
+		t.Fatal("Dial() failed:", err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}, grpc.WaitForReady(true))
+	if err != nil {
+		t.Fatal("health check failed:", err)
+	}
+
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("health status is %s, expected SERVING", resp.Status)
+	}
+}
+
+func TestDialOptionsInvalidTarget(t *testing.T) {
+	if _, err := DialOptions("dns:///localhost"); !errors.Is(err, ErrUnsupportedURLScheme) {
+		t.Errorf("DialOptions() returned error %v, expected ErrUnsupportedURLScheme", err)
+	}
+}
@@ -0,0 +1,90 @@
+package consul
+
+import (
+	"net"
+	"slices"
+	"strconv"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+)
+
+// wanTaggedAddress is the key of the tagged addresses that contain the WAN
+// address of a service or node.
+const wanTaggedAddress = "wan"
+
+// altAddrs are the addresses of the other IP family of an instance, in the
+// host:port form.
+type altAddrs []string
+
+// Equal returns true if o is an altAddrs with the same addresses. It allows
+// comparing attributes containing the slice.
+func (a altAddrs) Equal(o any) bool {
+	oa, ok := o.(altAddrs)
+	return ok && slices.Equal(a, oa)
+}
+
+// dualStackAddrs returns the addresses of the other IP family of the
+// instance of e that is resolved to host, from the lan_ipv4, lan_ipv6,
+// wan_ipv4 and wan_ipv6 tagged addresses of its service or, if node is
+// true, of its node. The tagged addresses of the network host belongs to are
+// used. nil is returned if host is not an IP address.
+func dualStackAddrs(e *consul.ServiceEntry, host string, port int, node bool) altAddrs {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+
+	tagged := func(key string) string {
+		return e.Service.TaggedAddresses[key].Address
+	}
+	if node {
+		tagged = func(key string) string {
+			if e.Node == nil {
+				return ""
+			}
+			return e.Node.TaggedAddresses[key]
+		}
+	}
+
+	network := lanTaggedAddress
+	if tagged(wanTaggedAddress) == host && tagged(lanTaggedAddress) != host {
+		network = wanTaggedAddress
+	}
+
+	var result altAddrs
+	for _, key := range []string{network + "_ipv4", network + "_ipv6"} {
+		alt := net.ParseIP(tagged(key))
+		if alt == nil || (alt.To4() == nil) == (ip.To4() == nil) {
+			continue
+		}
+
+		result = append(result, net.JoinHostPort(alt.String(), strconv.Itoa(port)))
+	}
+
+	return result
+}
+
+// endpoints returns an endpoint per address, containing the address and the
+// addresses of the other IP family of its instance.
+func endpoints(addresses []resolver.Address) []resolver.Endpoint {
+	result := make([]resolver.Endpoint, 0, len(addresses))
+
+	for _, a := range addresses {
+		ep := resolver.Endpoint{
+			Addresses:  []resolver.Address{a},
+			Attributes: a.BalancerAttributes,
+		}
+
+		alts, _ := a.BalancerAttributes.Value(dualStackAttributeKey).(altAddrs)
+		for _, alt := range alts {
+			altAddr := a
+			altAddr.Addr = alt
+			ep.Addresses = append(ep.Addresses, altAddr)
+		}
+
+		result = append(result, ep)
+	}
+
+	return result
+}
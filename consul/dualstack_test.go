@@ -0,0 +1,108 @@
+package consul
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestDualStackAddrs(t *testing.T) {
+	e := &consul.ServiceEntry{
+		Node: &consul.Node{
+			Address: "10.0.0.1",
+			TaggedAddresses: map[string]string{
+				"lan_ipv4": "10.0.0.1",
+				"lan_ipv6": "fd00::1",
+			},
+		},
+		Service: &consul.AgentService{
+			Address: "203.0.113.2",
+			TaggedAddresses: map[string]consul.ServiceAddress{
+				"lan":      {Address: "172.17.0.2"},
+				"lan_ipv4": {Address: "172.17.0.2"},
+				"lan_ipv6": {Address: "fd00::2"},
+				"wan":      {Address: "203.0.113.2"},
+				"wan_ipv4": {Address: "203.0.113.2"},
+				"wan_ipv6": {Address: "2001:db8::2"},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name string
+		host string
+		node bool
+		want altAddrs
+	}{
+		{"lan ipv4", "172.17.0.2", false, altAddrs{"[fd00::2]:80"}},
+		{"lan ipv6", "fd00::2", false, altAddrs{"172.17.0.2:80"}},
+		{"wan", "203.0.113.2", false, altAddrs{"[2001:db8::2]:80"}},
+		{"node", "10.0.0.1", true, altAddrs{"[fd00::1]:80"}},
+		{"hostname", "svc.example.com", false, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := dualStackAddrs(e, tc.host, 80, tc.node); !slices.Equal(got, tc.want) {
+				t.Errorf("dualStackAddrs() returned %v, expected %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDualStackEndpoints(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{
+			ID:      "dual",
+			Address: "10.0.0.1",
+			Port:    8080,
+			TaggedAddresses: map[string]consul.ServiceAddress{
+				"lan_ipv4": {Address: "10.0.0.1", Port: 8080},
+				"lan_ipv6": {Address: "fd00::1", Port: 8080},
+			},
+		},
+		{ID: "v4-only", Address: "10.0.0.2", Port: 8080},
+	})
+
+	cc := mocks.NewClientConn()
+	target := resolver.Target{URL: url.URL{Path: "/user-service", RawQuery: "dual-stack=true"}}
+	r, err := NewBuilder().Build(target, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err)
+	}
+	defer r.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cc.UpdateStateCallCnt() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("addresses were not passed to the ClientConn")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if got := addrStrings(cc.Addrs()); !slices.Equal(got, []string{"10.0.0.1:8080", "10.0.0.2:8080"}) {
+		t.Errorf("addresses are %v, expected only the resolved address of each instance", got)
+	}
+
+	var got [][]string
+	for _, ep := range cc.Endpoints() {
+		got = append(got, addrStrings(ep.Addresses))
+	}
+
+	want := [][]string{{"10.0.0.1:8080", "[fd00::1]:8080"}, {"10.0.0.2:8080"}}
+	if !slices.EqualFunc(got, want, slices.Equal[[]string]) {
+		t.Errorf("endpoints have the addresses %v, expected %v", got, want)
+	}
+}
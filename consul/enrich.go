@@ -0,0 +1,175 @@
+package consul
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// EnrichFunc returns data about the instance of service at addr from an
+// external source, e.g. its capacity from an inventory service.
+// The returned value is attached to the address and can be retrieved with
+// [Enrichment]. Values are compared with their Equal(any) bool method if
+// they implement it, otherwise with [reflect.DeepEqual].
+//
+// The function is called concurrently for multiple addresses and must
+// return when ctx is canceled.
+type EnrichFunc func(ctx context.Context, service string, addr resolver.Address) (any, error)
+
+// enricher runs an EnrichFunc for the addresses of a resolver and caches
+// its results.
+type enricher struct {
+	fn      EnrichFunc
+	timeout time.Duration
+	ttl     time.Duration
+	clock   Clock
+	log     *logSampler
+
+	mu sync.Mutex
+	// cache contains the results of fn by the addressKey of the
+	// address.
+	cache map[string]*enrichment
+}
+
+// enrichedValue is the attribute value of the result of an EnrichFunc.
+// Attribute values are compared with == unless they implement Equal, it
+// allows fn to return maps and slices.
+type enrichedValue struct {
+	value any
+}
+
+// Equal returns true if o is an enrichedValue with an equal value.
+func (v enrichedValue) Equal(o any) bool {
+	ov, ok := o.(enrichedValue)
+	if !ok {
+		return false
+	}
+
+	if eq, ok := v.value.(interface{ Equal(any) bool }); ok {
+		return eq.Equal(ov.value)
+	}
+
+	return reflect.DeepEqual(v.value, ov.value)
+}
+
+type enrichment struct {
+	value   any
+	valid   bool
+	fetched time.Time
+	// pending is true while fn is running for the address.
+	pending bool
+}
+
+func newEnricher(fn EnrichFunc, timeout, ttl time.Duration, clock Clock, log *logSampler) *enricher {
+	return &enricher{
+		fn:      fn,
+		timeout: timeout,
+		ttl:     ttl,
+		clock:   clock,
+		log:     log,
+		cache:   map[string]*enrichment{},
+	}
+}
+
+// enrich attaches the results of fn to addrs. fn is called concurrently for
+// all addresses without a cached result or with an expired one, enrich waits
+// at most for the timeout for them to return. If fn fails or did not return
+// in time, the previous result is used. Addresses without any result are
+// returned unchanged.
+func (e *enricher) enrich(ctx context.Context, service string, addrs []resolver.Address, key addressKey) []resolver.Address {
+	if len(addrs) == 0 {
+		return addrs
+	}
+
+	if e.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	now := e.clock.Now()
+	keys := make([]string, len(addrs))
+	var wg sync.WaitGroup
+
+	e.mu.Lock()
+	for i, addr := range addrs {
+		keys[i] = key(addr)
+
+		ent := e.cache[keys[i]]
+		if ent == nil {
+			ent = &enrichment{}
+			e.cache[keys[i]] = ent
+		}
+
+		if ent.pending || (ent.valid && now.Sub(ent.fetched) < e.ttl) {
+			continue
+		}
+
+		ent.pending = true
+		wg.Add(1)
+		go func(addr resolver.Address, ent *enrichment) {
+			defer wg.Done()
+
+			v, err := e.fn(ctx, service, addr)
+
+			e.mu.Lock()
+			defer e.mu.Unlock()
+
+			ent.pending = false
+			if err != nil {
+				e.log.warningf("grpc-consul-resolver: enriching address %s of service %s failed: %s",
+					addr.Addr, service, err)
+				return
+			}
+
+			ent.value = v
+			ent.valid = true
+			ent.fetched = e.clock.Now()
+		}(addr, ent)
+	}
+	e.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	result := make([]resolver.Address, len(addrs))
+	for i, addr := range addrs {
+		if ent := e.cache[keys[i]]; ent.valid {
+			addr.BalancerAttributes = addr.BalancerAttributes.WithValue(enrichmentAttributeKey, enrichedValue{ent.value})
+		}
+		result[i] = addr
+	}
+
+	e.pruneLocked(keys)
+
+	return result
+}
+
+// pruneLocked removes the results of addresses that are not in keys from
+// the cache.
+func (e *enricher) pruneLocked(keys []string) {
+	current := make(map[string]struct{}, len(keys))
+	for _, k := range keys {
+		current[k] = struct{}{}
+	}
+
+	for k, ent := range e.cache {
+		if _, exists := current[k]; !exists && !ent.pending {
+			delete(e.cache, k)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+type fakeCapacityService struct {
+	mu       sync.Mutex
+	capacity map[string]int
+	err      error
+	calls    int
+}
+
+func (s *fakeCapacityService) enrich(_ context.Context, _ string, addr resolver.Address) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+
+	return s.capacity[addr.Addr], nil
+}
+
+func (s *fakeCapacityService) set(addr string, capacity int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.capacity[addr] = capacity
+	s.err = err
+}
+
+func (s *fakeCapacityService) callCnt() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.calls
+}
+
+func TestEnricherCachesResults(t *testing.T) {
+	clock := newFakeClock()
+	svc := fakeCapacityService{capacity: map[string]int{"10.0.0.1:80": 5}}
+	e := newEnricher(svc.enrich, time.Second, time.Minute, clock, &logSampler{clock: clock})
+
+	addrs := []resolver.Address{{Addr: "10.0.0.1:80"}}
+
+	enrich := func() any {
+		t.Helper()
+
+		res := e.enrich(context.Background(), "capacity-test", addrs, addrKey)
+		if len(res) != 1 {
+			t.Fatalf("enrich returned %d addresses, expected 1", len(res))
+		}
+		return Enrichment(res[0])
+	}
+
+	if v := enrich(); v != 5 {
+		t.Errorf("got enrichment %v, expected 5", v)
+	}
+
+	svc.set("10.0.0.1:80", 7, nil)
+	if v := enrich(); v != 5 {
+		t.Errorf("got enrichment %v before the ttl expired, expected cached value 5", v)
+	}
+	if cnt := svc.callCnt(); cnt != 1 {
+		t.Errorf("enrich function was called %d times, expected 1", cnt)
+	}
+
+	clock.Advance(time.Minute)
+	if v := enrich(); v != 7 {
+		t.Errorf("got enrichment %v after the ttl expired, expected 7", v)
+	}
+
+	svc.set("10.0.0.1:80", 9, errors.New("capacity service unavailable"))
+	clock.Advance(time.Minute)
+	if v := enrich(); v != 7 {
+		t.Errorf("got enrichment %v after the enrich function failed, expected the previous value 7", v)
+	}
+}
+
+func TestEnricherTimeout(t *testing.T) {
+	clock := newFakeClock()
+	fn := func(ctx context.Context, _ string, _ resolver.Address) (any, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	e := newEnricher(fn, 10*time.Millisecond, time.Minute, clock, &logSampler{clock: clock})
+
+	res := e.enrich(context.Background(), "timeout-test", []resolver.Address{{Addr: "10.0.0.1:80"}}, addrKey)
+	if len(res) != 1 {
+		t.Fatalf("enrich returned %d addresses, expected 1", len(res))
+	}
+
+	if v := Enrichment(res[0]); v != nil {
+		t.Errorf("got enrichment %v for an address whose enrich call timed out, expected none", v)
+	}
+}
+
+func TestEnrichmentMapIsComparable(t *testing.T) {
+	clock := newFakeClock()
+	var zone string
+	fn := func(context.Context, string, resolver.Address) (any, error) {
+		return map[string]string{"zone": zone}, nil
+	}
+	e := newEnricher(fn, time.Second, time.Minute, clock, &logSampler{clock: clock})
+
+	enrich := func(z string) []resolver.Address {
+		zone = z
+		clock.Advance(time.Minute)
+		return e.enrich(context.Background(), "map-test", []resolver.Address{{Addr: "10.0.0.1:80"}}, addrKey)
+	}
+
+	first := enrich("a")
+	if v, ok := Enrichment(first[0]).(map[string]string); !ok || v["zone"] != "a" {
+		t.Errorf("got enrichment %v, expected map with zone a", Enrichment(first[0]))
+	}
+
+	if !addressesEqual(first, enrich("a")) {
+		t.Error("addresses with equal enrichment maps are not equal")
+	}
+
+	if addressesEqual(first, enrich("b")) {
+		t.Error("addresses with different enrichment maps are equal")
+	}
+}
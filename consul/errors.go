@@ -0,0 +1,79 @@
+package consul
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrMissingService is returned when the target URL does not contain
+	// a service name.
+	ErrMissingService = errors.New("path is missing in url")
+
+	// ErrInvalidServicePath is returned when the path of the target URL
+	// is not in one of the supported service name formats.
+	ErrInvalidServicePath = errors.New("invalid service path")
+
+	// ErrUnsupportedURLScheme is returned by [ParseTarget] when the
+	// target URL does not use the consul scheme.
+	ErrUnsupportedURLScheme = errors.New("unsupported url scheme")
+
+	// ErrUnsupportedScheme is returned when the scheme option is neither
+	// http nor https.
+	ErrUnsupportedScheme = errors.New("unsupported scheme")
+
+	// ErrInvalidHealthFilter is returned when the health option has an
+	// unsupported value.
+	ErrInvalidHealthFilter = errors.New("unsupported health parameter value")
+
+	// ErrInvalidOptionValue is returned when a target option has a value
+	// that can not be parsed.
+	ErrInvalidOptionValue = errors.New("invalid parameter value")
+
+	// ErrInvalidCABundle is returned when the CA bundle can not be read
+	// or does not contain PEM-encoded certificates.
+	ErrInvalidCABundle = errors.New("invalid ca bundle")
+
+	// ErrInvalidVersionConstraint is returned when the version option is
+	// not a valid semantic version constraint.
+	ErrInvalidVersionConstraint = errors.New("invalid version constraint")
+
+	// ErrInvalidFilter is returned when the filter option is not a valid
+	// Consul filter expression.
+	ErrInvalidFilter = errors.New("invalid filter expression")
+
+	// ErrZeroPort is returned when an instance of the service is
+	// registered with port 0 and the port-zero option is error.
+	ErrZeroPort = errors.New("instance registered with port 0")
+
+	// ErrServiceNotFound is returned by [CheckService] when no instance
+	// of the service is registered. Resolvers of targets with the strict
+	// option report it to the gRPC channel.
+	ErrServiceNotFound = errors.New("service not found")
+
+	// ErrTooManyInstances is returned by [Lookup] and reported to the
+	// gRPC channel when a service resolved to more instances than the
+	// max-instances option allows, the max-instances-policy is hold and
+	// no addresses were passed to the channel before.
+	ErrTooManyInstances = errors.New("too many instances")
+
+	// ErrRetryBudgetExhausted is reported to the gRPC channel when the
+	// queries of a resolver failed for longer than the budget configured
+	// with [WithRetryBudget] allows. The resolver stops afterwards.
+	ErrRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+	// ErrMissingDatacenter is returned when a resolver is built in
+	// agentless mode for a target without the dc option.
+	ErrMissingDatacenter = errors.New("datacenter is missing")
+)
+
+// UnsupportedOptionError is returned when the target URL contains a query
+// parameter that is not supported by the resolver.
+type UnsupportedOptionError struct {
+	// Name is the name of the unsupported query parameter.
+	Name string
+}
+
+func (e *UnsupportedOptionError) Error() string {
+	return fmt.Sprintf("unsupported parameter: '%s'", e.Name)
+}
@@ -0,0 +1,40 @@
+package consul
+
+import "sync/atomic"
+
+// EventChannel is a [StatsHandler] that passes the events to a buffered
+// channel, for tooling and tests that assert on the behavior of resolvers
+// without parsing logs. It can be configured with [WithStatsHandler].
+//
+// Events are dropped when the channel is full, the resolvers never wait for
+// the receiver.
+type EventChannel struct {
+	ch      chan Event
+	dropped atomic.Uint64
+}
+
+// NewEventChannel returns an EventChannel whose channel buffers size events.
+func NewEventChannel(size int) *EventChannel {
+	return &EventChannel{ch: make(chan Event, size)}
+}
+
+// HandleEvent passes ev to the channel or drops it if the channel is full.
+func (e *EventChannel) HandleEvent(ev Event) {
+	select {
+	case e.ch <- ev:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// Events returns the channel that receives the events.
+// It is never closed.
+func (e *EventChannel) Events() <-chan Event {
+	return e.ch
+}
+
+// Dropped returns the number of events that were dropped because the
+// channel was full.
+func (e *EventChannel) Dropped() uint64 {
+	return e.dropped.Load()
+}
@@ -0,0 +1,74 @@
+package consul
+
+import (
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestEventChannel(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{{Address: "127.0.0.1", Port: 1}})
+	health.SetRespIndex(10)
+
+	target, err := ParseTarget("consul:///event-channel-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := NewEventChannel(16)
+	r, err := newConsulResolver(mocks.NewClientConn(), target, &builderOptions{clock: newFakeClock(), statsHandler: events})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	r.poll()
+	health.SetRespIndex(5)
+	r.poll()
+
+	var got []Event
+	for len(events.Events()) > 0 {
+		got = append(got, <-events.Events())
+	}
+
+	if len(got) != 6 {
+		t.Fatalf("got %d events, expected 6: %+v", len(got), got)
+	}
+
+	if ev, ok := got[2].(*StateUpdated); !ok || len(ev.Addresses) != 1 {
+		t.Errorf("third event is %+v, expected *StateUpdated with 1 address", got[2])
+	}
+
+	if ev, ok := got[5].(*IndexReset); !ok || ev.WaitIndex != 5 || ev.PreviousIndex != 10 || ev.Service != "event-channel-test" {
+		t.Errorf("last event is %+v, expected *IndexReset from index 10 to 5", got[5])
+	}
+
+	if d := events.Dropped(); d != 0 {
+		t.Errorf("%d events were dropped, expected none", d)
+	}
+}
+
+func TestEventChannelDropsEventsWhenFull(t *testing.T) {
+	events := NewEventChannel(1)
+
+	events.HandleEvent(&QueryStarted{Service: "a"})
+	events.HandleEvent(&QueryStarted{Service: "b"})
+
+	if ev := <-events.Events(); ev.ServiceName() != "a" {
+		t.Errorf("received event of service %q, expected the first event of a", ev.ServiceName())
+	}
+
+	if d := events.Dropped(); d != 1 {
+		t.Errorf("%d events were dropped, expected 1", d)
+	}
+}
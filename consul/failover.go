@@ -0,0 +1,140 @@
+package consul
+
+import (
+	"sync"
+
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+// buildFailover builds a resolver for a target with the failover-dcs option.
+// It runs a resolver per datacenter and passes the addresses of the first
+// datacenter that has instances to cc.
+func (b *resolverBuilder) buildFailover(target resolver.Target, t *Target, cc resolver.ClientConn) (resolver.Resolver, error) {
+	f := failoverResolver{cc: cc}
+
+	dcs := append([]string{t.DC}, t.FailoverDCs...)
+	for _, dc := range dcs {
+		dcTarget := t.clone()
+		dcTarget.DC = dc
+		dcTarget.FailoverDCs = nil
+
+		child := failoverChild{parent: &f}
+
+		r, err := newConsulResolver(&child, dcTarget, &b.opts)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+
+		r.dialTarget = target.URL.String()
+		r.datacenterAttribute = true
+		child.resolver = r
+		f.children = append(f.children, &child)
+	}
+
+	for _, child := range f.children {
+		child.resolver.start()
+	}
+
+	return &f, nil
+}
+
+// failoverResolver resolves a target with the failover-dcs option. The
+// children are ordered by the priority of their datacenters, the first one
+// resolves the datacenter of the target.
+type failoverResolver struct {
+	cc       resolver.ClientConn
+	children []*failoverChild
+
+	// mu serializes the updates of cc and protects the states of the
+	// children.
+	mu sync.Mutex
+}
+
+func (f *failoverResolver) ResolveNow(opts resolver.ResolveNowOptions) {
+	for _, child := range f.children {
+		child.resolver.ResolveNow(opts)
+	}
+}
+
+func (f *failoverResolver) Close() {
+	for _, child := range f.children {
+		child.resolver.Close()
+	}
+}
+
+// updateLocked passes the state of the first child that resolved its
+// datacenter to addresses to cc. While a child with a higher priority did
+// not resolve its datacenter or fail yet, nothing is passed, to not fail over
+// during the startup of the resolver.
+func (f *failoverResolver) updateLocked() error {
+	var resolved bool
+	var lastErr error
+
+	for _, child := range f.children {
+		if child.state == nil {
+			if child.err == nil {
+				return nil
+			}
+
+			lastErr = child.err
+			continue
+		}
+		resolved = true
+
+		if len(child.state.Addresses) != 0 {
+			return f.cc.UpdateState(*child.state)
+		}
+	}
+
+	if !resolved {
+		f.cc.ReportError(lastErr)
+		return nil
+	}
+
+	return f.cc.UpdateState(resolver.State{})
+}
+
+// failoverChild is the [resolver.ClientConn] of the resolver of a datacenter
+// of a target with the failover-dcs option.
+type failoverChild struct {
+	parent   *failoverResolver
+	resolver *consulResolver
+
+	// state and err are protected by parent.mu.
+	state *resolver.State
+	err   error
+}
+
+func (c *failoverChild) UpdateState(state resolver.State) error {
+	c.parent.mu.Lock()
+	defer c.parent.mu.Unlock()
+
+	c.state = &state
+
+	return c.parent.updateLocked()
+}
+
+// ReportError records err. It is only reported to the gRPC channel if none
+// of the datacenters were resolved, otherwise the addresses of the
+// datacenters are kept.
+func (c *failoverChild) ReportError(err error) {
+	c.parent.mu.Lock()
+	defer c.parent.mu.Unlock()
+
+	c.err = err
+	if c.state == nil {
+		_ = c.parent.updateLocked()
+	}
+}
+
+func (c *failoverChild) NewAddress(addresses []resolver.Address) {
+	_ = c.UpdateState(resolver.State{Addresses: addresses})
+}
+
+func (c *failoverChild) NewServiceConfig(string) {}
+
+func (c *failoverChild) ParseServiceConfig(sc string) *serviceconfig.ParseResult {
+	return c.parent.cc.ParseServiceConfig(sc)
+}
@@ -0,0 +1,119 @@
+package consul
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestFailoverTargetResolvesFailoverDatacenter(t *testing.T) {
+	local := mocks.NewConsulHealthClient()
+	local.SetRespEntries([]*consul.ServiceEntry{})
+	remote := mocks.NewConsulHealthClient()
+	remote.SetRespEntries([]*consul.ServiceEntry{{
+		Node:    &consul.Node{Node: "node-1", Datacenter: "us-east"},
+		Service: &consul.AgentService{ID: "a", Address: "10.0.1.1", Port: 1},
+	}})
+
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			if cfg.Datacenter == "us-east" {
+				return remote, nil
+			}
+			return local, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	cc := mocks.NewClientConn()
+	r, err := NewBuilder().Build(resolver.Target{URL: url.URL{Path: "/user-service", RawQuery: "failover-dcs=us-east"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err)
+	}
+	defer r.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(cc.Addrs()) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("resolved to %v, expected the address of the failover datacenter", addrStrings(cc.Addrs()))
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	addr := cc.Addrs()[0]
+	if dc, ok := Datacenter(addr); addr.Addr != "10.0.1.1:1" || !ok || dc != "us-east" {
+		t.Errorf("resolved to %s in datacenter %q (%t), expected 10.0.1.1:1 in us-east", addr.Addr, dc, ok)
+	}
+}
+
+func TestFailoverTargetPrefersDatacentersInOrder(t *testing.T) {
+	cc := mocks.NewClientConn()
+	f := failoverResolver{cc: cc}
+	primary := failoverChild{parent: &f}
+	secondary := failoverChild{parent: &f}
+	f.children = []*failoverChild{&primary, &secondary}
+
+	primaryAddrs := []resolver.Address{{Addr: "10.0.0.1:1"}}
+	secondaryAddrs := []resolver.Address{{Addr: "10.0.1.1:1"}}
+
+	if err := secondary.UpdateState(resolver.State{Addresses: secondaryAddrs}); err != nil {
+		t.Fatal("UpdateState() failed:", err)
+	}
+	if cnt := cc.UpdateStateCallCnt(); cnt != 0 {
+		t.Fatalf("UpdateState was called %d times before the primary datacenter was resolved, expected 0", cnt)
+	}
+
+	if err := primary.UpdateState(resolver.State{}); err != nil {
+		t.Fatal("UpdateState() failed:", err)
+	}
+	if got := addrStrings(cc.Addrs()); len(got) != 1 || got[0] != "10.0.1.1:1" {
+		t.Errorf("resolved to %v, expected the address of the secondary datacenter", got)
+	}
+
+	if err := primary.UpdateState(resolver.State{Addresses: primaryAddrs}); err != nil {
+		t.Fatal("UpdateState() failed:", err)
+	}
+	if got := addrStrings(cc.Addrs()); len(got) != 1 || got[0] != "10.0.0.1:1" {
+		t.Errorf("resolved to %v, expected the address of the primary datacenter", got)
+	}
+
+	primary.ReportError(errors.New("query failed"))
+	if got := addrStrings(cc.Addrs()); len(got) != 1 || got[0] != "10.0.0.1:1" {
+		t.Errorf("resolved to %v after a failed query, expected the previous addresses of the primary datacenter", got)
+	}
+	if err := cc.LastReportedError(); err != nil {
+		t.Errorf("error %v was reported while a datacenter was resolved", err)
+	}
+}
+
+func TestFailoverTargetReportsErrorIfNoDatacenterIsResolved(t *testing.T) {
+	cc := mocks.NewClientConn()
+	f := failoverResolver{cc: cc}
+	primary := failoverChild{parent: &f}
+	secondary := failoverChild{parent: &f}
+	f.children = []*failoverChild{&primary, &secondary}
+
+	primary.ReportError(errors.New("primary failed"))
+	if err := cc.LastReportedError(); err != nil {
+		t.Fatalf("error %v was reported before all datacenters failed", err)
+	}
+
+	queryErr := errors.New("secondary failed")
+	secondary.ReportError(queryErr)
+	if err := cc.LastReportedError(); !errors.Is(err, queryErr) {
+		t.Errorf("reported error is %v, expected %v", err, queryErr)
+	}
+}
+
+func TestFailoverIsNotSupportedForCompositeTargets(t *testing.T) {
+	_, err := NewBuilder().Build(resolver.Target{URL: url.URL{Path: "/svc-v1@90+svc-v2@10", RawQuery: "failover-dcs=us-east"}}, mocks.NewClientConn(), resolver.BuildOptions{})
+	if !errors.Is(err, ErrInvalidOptionValue) {
+		t.Errorf("Build() returned %v, expected %v", err, ErrInvalidOptionValue)
+	}
+}
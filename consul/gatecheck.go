@@ -0,0 +1,96 @@
+package consul
+
+import (
+	"strconv"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/grpclog"
+)
+
+// gateCheckRetryInterval is the time waited before the gate check is
+// queried again after a failed query.
+const gateCheckRetryInterval = 5 * time.Second
+
+type consulChecksEndpoint interface {
+	State(state string, q *consul.QueryOptions) (consul.HealthChecks, *consul.QueryMeta, error)
+}
+
+// consulCreateChecksClientFn can be overwritten in tests to make
+// newConsulResolver() return a different consulChecksEndpoint implementation
+var consulCreateChecksClientFn = func(cfg *consul.Config) (consulChecksEndpoint, error) {
+	clt, err := consul.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return clt.Health(), nil
+}
+
+// gateCheckWatcher watches the checks with the ID gateCheck with blocking
+// queries until the resolver is closed. Addresses are only passed to the
+// ClientConn while all of them are passing.
+func (c *consulResolver) gateCheckWatcher() {
+	defer c.wgStop.Done()
+
+	opts := (&consul.QueryOptions{
+		WaitTime: c.waitTime,
+		Filter:   "CheckID == " + strconv.Quote(c.gateCheck),
+	}).WithContext(c.ctx)
+
+	for {
+		c.setToken(opts)
+		checks, meta, err := c.consulChecks.State(consul.HealthAny, opts)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+
+			c.log.warningf("grpc-consul-resolver: querying gate check '%s' failed, retrying in %s: %v",
+				c.gateCheck, gateCheckRetryInterval, redactError(err, c.secrets))
+			opts.WaitIndex = 0
+
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-c.clock.After(gateCheckRetryInterval):
+				continue
+			}
+		}
+
+		if meta.LastIndex < opts.WaitIndex {
+			opts.WaitIndex = 0
+		} else {
+			opts.WaitIndex = meta.LastIndex
+		}
+
+		passing := gateCheckPassing(checks)
+		if c.gateOpen.Swap(passing) == passing {
+			continue
+		}
+
+		grpclog.Infof("grpc-consul-resolver: gate check '%s' of service '%s' changed to passing=%t",
+			c.gateCheck, c.service, passing)
+
+		if passing {
+			// publish the addresses that were held back
+			c.restartQuery()
+		}
+	}
+}
+
+// gateCheckPassing returns true if checks contains at least 1 check and all
+// of them are passing.
+func gateCheckPassing(checks consul.HealthChecks) bool {
+	if len(checks) == 0 {
+		return false
+	}
+
+	for _, hc := range checks {
+		if hc.Status != consul.HealthPassing {
+			return false
+		}
+	}
+
+	return true
+}
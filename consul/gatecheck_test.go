@@ -0,0 +1,112 @@
+package consul
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+// fakeChecks returns the check with the configured status. Blocking queries
+// return when the status changed.
+type fakeChecks struct {
+	mu      sync.Mutex
+	changed *sync.Cond
+	status  string
+	index   uint64
+	filters []string
+}
+
+func newFakeChecks(status string) *fakeChecks {
+	c := fakeChecks{status: status, index: 1}
+	c.changed = sync.NewCond(&c.mu)
+	return &c
+}
+
+func (c *fakeChecks) setStatus(status string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.status = status
+	c.index++
+	c.changed.Broadcast()
+}
+
+func (c *fakeChecks) State(_ string, q *consul.QueryOptions) (consul.HealthChecks, *consul.QueryMeta, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.filters = append(c.filters, q.Filter)
+
+	stop := context.AfterFunc(q.Context(), func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.changed.Broadcast()
+	})
+	defer stop()
+
+	for c.index <= q.WaitIndex && q.Context().Err() == nil {
+		c.changed.Wait()
+	}
+
+	if err := q.Context().Err(); err != nil {
+		return nil, nil, err
+	}
+
+	return consul.HealthChecks{{CheckID: "db-migrations-done", Status: c.status}}, &consul.QueryMeta{LastIndex: c.index}, nil
+}
+
+func TestGateCheckHoldsBackAddresses(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{{Address: "127.0.0.1", Port: 1}})
+
+	checks := newFakeChecks(consul.HealthCritical)
+	oldChecksFn := consulCreateChecksClientFn
+	consulCreateChecksClientFn = func(cfg *consul.Config) (consulChecksEndpoint, error) {
+		return checks, nil
+	}
+	t.Cleanup(func() { consulCreateChecksClientFn = oldChecksFn })
+
+	cc := mocks.NewClientConn()
+	target := resolver.Target{URL: url.URL{Path: "/user-service", RawQuery: "gate-check=db-migrations-done"}}
+	r, err := NewBuilder().Build(target, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err)
+	}
+	defer r.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	if cnt := cc.UpdateStateCallCnt(); cnt != 0 {
+		t.Fatalf("addresses were passed %d times while the gate check is critical", cnt)
+	}
+
+	checks.setStatus(consul.HealthPassing)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cc.UpdateStateCallCnt() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("addresses were not passed after the gate check passed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	checks.mu.Lock()
+	filter := checks.filters[0]
+	checks.mu.Unlock()
+	if filter != `CheckID == "db-migrations-done"` {
+		t.Errorf("checks were queried with filter %q", filter)
+	}
+}
@@ -0,0 +1,42 @@
+// Package grpchealth reports the health of the consul resolvers via the
+// [gRPC Health Checking Protocol].
+//
+// [gRPC Health Checking Protocol]: https://github.com/grpc/grpc/blob/master/doc/health-checking.md
+package grpchealth
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/simplesurance/grpcconsulresolver/consul"
+)
+
+// Update sets the serving status of service in srv to SERVING if all active
+// consul resolvers are healthy, otherwise to NOT_SERVING.
+func Update(srv *health.Server, service string) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if consul.AllResolversHealthy() {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+
+	srv.SetServingStatus(service, status)
+}
+
+// Run calls [Update] every interval until ctx is canceled.
+func Run(ctx context.Context, srv *health.Server, service string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		Update(srv, service)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package grpchealth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/consul"
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func servingStatus(t *testing.T, srv *health.Server, service string) healthpb.HealthCheckResponse_ServingStatus {
+	t.Helper()
+
+	resp, err := srv.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		t.Fatal("Check() failed:", err)
+	}
+
+	return resp.Status
+}
+
+func TestUpdateWithoutResolversIsServing(t *testing.T) {
+	srv := health.NewServer()
+
+	Update(srv, "discovery")
+
+	if status := servingStatus(t, srv, "discovery"); status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("serving status is %s, expected SERVING", status)
+	}
+}
+
+func TestUpdateWithFailingResolverIsNotServing(t *testing.T) {
+	consulSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "unavailable", http.StatusInternalServerError)
+	}))
+	t.Cleanup(consulSrv.Close)
+
+	cc := mocks.NewClientConn()
+	target := resolver.Target{URL: url.URL{Host: consulSrv.Listener.Addr().String(), Path: "/user-service"}}
+
+	r, err := consul.NewBuilder().Build(target, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err)
+	}
+	t.Cleanup(r.Close)
+
+	for cc.LastReportedError() == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	srv := health.NewServer()
+	Update(srv, "discovery")
+
+	if status := servingStatus(t, srv, "discovery"); status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("serving status is %s, expected NOT_SERVING", status)
+	}
+}
+
+func TestRunUpdatesStatusUntilCanceled(t *testing.T) {
+	srv := health.NewServer()
+	srv.SetServingStatus("discovery", healthpb.HealthCheckResponse_UNKNOWN)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		Run(ctx, srv, "discovery", time.Millisecond)
+		close(done)
+	}()
+
+	for servingStatus(t, srv, "discovery") != healthpb.HealthCheckResponse_SERVING {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after the context was canceled")
+	}
+}
@@ -0,0 +1,27 @@
+package consul
+
+import (
+	"math/rand"
+	"time"
+)
+
+// randomDuration returns a random duration in [0, max). It can be
+// overwritten in tests.
+var randomDuration = func(max time.Duration) time.Duration {
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+// waitUpdateJitter delays passing added addresses to the gRPC channel by a
+// random duration of up to updateJitter. It returns false if the resolver
+// was closed while waiting.
+func (c *consulResolver) waitUpdateJitter() bool {
+	d := randomDuration(c.updateJitter)
+	c.status.tracef("delaying the update by %s", d)
+
+	select {
+	case <-c.clock.After(d):
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,82 @@
+package consul
+
+import (
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestUpdateJitterDelaysAddedAddresses(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	oldRandom := randomDuration
+	t.Cleanup(func() { randomDuration = oldRandom })
+	randomDuration = func(max time.Duration) time.Duration {
+		if max != 10*time.Second {
+			t.Errorf("jitter is drawn from up to %s, expected 10s", max)
+		}
+		return 3 * time.Second
+	}
+
+	target, err := ParseTarget("consul:///jitter-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := newFakeClock()
+	cc := mocks.NewClientConn()
+	r, err := newConsulResolver(cc, target, &builderOptions{clock: clock, updateJitter: 10 * time.Second})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	health.SetRespServiceEntries([]*consul.AgentService{{Address: "10.0.0.1", Port: 1}})
+	health.SetRespIndex(1)
+	r.poll()
+	if cnt := cc.UpdateStateCallCnt(); cnt != 1 {
+		t.Fatalf("UpdateState was called %d times, expected the first addresses to be passed without delay", cnt)
+	}
+
+	health.SetRespServiceEntries([]*consul.AgentService{{Address: "10.0.0.1", Port: 1}, {Address: "10.0.0.2", Port: 1}})
+	health.SetRespIndex(2)
+	done := make(chan struct{})
+	go func() {
+		r.poll()
+		close(done)
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for clock.Timers() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("update with an added address was not delayed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if cnt := cc.UpdateStateCallCnt(); cnt != 1 {
+		t.Errorf("UpdateState was called %d times during the delay, expected 1", cnt)
+	}
+
+	clock.Advance(3 * time.Second)
+	<-done
+	if got := addrStrings(cc.Addrs()); len(got) != 2 {
+		t.Errorf("resolved to %v after the delay, expected 2 addresses", got)
+	}
+
+	health.SetRespServiceEntries([]*consul.AgentService{{Address: "10.0.0.2", Port: 1}})
+	health.SetRespIndex(3)
+	r.poll()
+	if got := addrStrings(cc.Addrs()); len(got) != 1 {
+		t.Errorf("resolved to %v, expected the removal to be passed without delay", got)
+	}
+}
@@ -0,0 +1,107 @@
+package consul
+
+import (
+	"fmt"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// KindFilter restricts the resolution to instances of a Consul service kind.
+type KindFilter int
+
+const (
+	// KindUndefined resolves instances of all kinds.
+	KindUndefined KindFilter = iota
+	// KindTypical resolves only typical services, that are not proxies or
+	// gateways.
+	KindTypical
+	// KindConnectProxy resolves only Connect proxies.
+	KindConnectProxy
+	// KindMeshGateway resolves only mesh gateways.
+	KindMeshGateway
+	// KindIngressGateway resolves only ingress gateways.
+	KindIngressGateway
+)
+
+// String returns the value of the kind target option that selects the
+// filter.
+func (k KindFilter) String() string {
+	switch k {
+	case KindTypical:
+		return "typical"
+	case KindConnectProxy:
+		return "connect-proxy"
+	case KindMeshGateway:
+		return "mesh-gateway"
+	case KindIngressGateway:
+		return "ingress-gateway"
+	default:
+		return ""
+	}
+}
+
+// MarshalText returns the value of the kind target option that selects the
+// filter.
+func (k KindFilter) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText sets the filter from the value of a kind target option.
+func (k *KindFilter) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*k = KindUndefined
+		return nil
+	}
+
+	filter, err := parseKindFilter(string(text))
+	if err != nil {
+		return err
+	}
+
+	*k = filter
+
+	return nil
+}
+
+func parseKindFilter(value string) (KindFilter, error) {
+	switch strings.ToLower(value) {
+	case "typical":
+		return KindTypical, nil
+	case "connect-proxy":
+		return KindConnectProxy, nil
+	case "mesh-gateway":
+		return KindMeshGateway, nil
+	case "ingress-gateway":
+		return KindIngressGateway, nil
+	default:
+		return KindUndefined, fmt.Errorf("%w '%s' for 'kind'", ErrInvalidOptionValue, value)
+	}
+}
+
+// serviceKind returns the Consul service kind the filter matches.
+func (k KindFilter) serviceKind() consul.ServiceKind {
+	switch k {
+	case KindConnectProxy:
+		return consul.ServiceKindConnectProxy
+	case KindMeshGateway:
+		return consul.ServiceKindMeshGateway
+	case KindIngressGateway:
+		return consul.ServiceKindIngressGateway
+	default:
+		return consul.ServiceKindTypical
+	}
+}
+
+// filterKind returns the entries whose service is of the kind.
+func filterKind(entries []*consul.ServiceEntry, kind consul.ServiceKind) []*consul.ServiceEntry {
+	result := make([]*consul.ServiceEntry, 0, len(entries))
+
+	for _, e := range entries {
+		if e.Service.Kind == kind {
+			result = append(result, e)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,86 @@
+package consul
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/grpclog"
+)
+
+// overridesRetryInterval is the time waited before the overrides key is
+// queried again after a failed query.
+const overridesRetryInterval = 5 * time.Second
+
+// overridesWatcher watches the overridesKey with blocking queries and applies
+// the overrides it contains to the baseSettings until the resolver is
+// closed.
+func (c *consulResolver) overridesWatcher() {
+	defer c.wgStop.Done()
+
+	opts := (&consul.QueryOptions{WaitTime: c.waitTime}).WithContext(c.ctx)
+	var lastValue []byte
+
+	for {
+		c.setToken(opts)
+		kv, meta, err := c.consulKV.Get(c.overridesKey, opts)
+		if err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+
+			c.log.warningf("grpc-consul-resolver: querying overrides key '%s' failed, retrying in %s: %v",
+				c.overridesKey, overridesRetryInterval, redactError(err, c.secrets))
+			opts.WaitIndex = 0
+
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-c.clock.After(overridesRetryInterval):
+				continue
+			}
+		}
+
+		if meta.LastIndex < opts.WaitIndex {
+			opts.WaitIndex = 0
+		} else {
+			opts.WaitIndex = meta.LastIndex
+		}
+
+		var value []byte
+		if kv != nil {
+			value = kv.Value
+		}
+
+		if bytes.Equal(value, lastValue) {
+			continue
+		}
+		lastValue = value
+
+		if err := c.applyOverrides(value); err != nil {
+			grpclog.Warningf("grpc-consul-resolver: ignoring invalid overrides in key '%s': %v", c.overridesKey, err)
+		}
+	}
+}
+
+// applyOverrides decodes the JSON-encoded overrides in value and applies them
+// to the baseSettings. If value is empty, the baseSettings are restored.
+func (c *consulResolver) applyOverrides(value []byte) error {
+	var o Overrides
+	if len(value) > 0 {
+		if err := json.Unmarshal(value, &o); err != nil {
+			return err
+		}
+
+		if err := o.validate(); err != nil {
+			return err
+		}
+	}
+
+	c.reconfigure(func(querySettings) querySettings {
+		return o.apply(c.baseSettings)
+	})
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package consul
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func waitForQueryFilter(t *testing.T, health *mocks.ConsulHealthClient, filter string) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		opts := health.LastQueryOptions()
+		if opts != nil && opts.Filter == filter {
+			return
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("query filter is %+v, expected %q", opts, filter)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOverridesFromKV(t *testing.T) {
+	cc := mocks.NewClientConn()
+	health := mocks.NewConsulHealthClient()
+	kv := mocks.NewConsulKVClient()
+	t.Cleanup(replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	))
+
+	oldKVFn := consulCreateKVClientFn
+	consulCreateKVClientFn = func(cfg *consul.Config) (consulKVEndpoint, error) {
+		return kv, nil
+	}
+	t.Cleanup(func() { consulCreateKVClientFn = oldKVFn })
+
+	target := url.URL{
+		Path:     "kv-test",
+		RawQuery: url.Values{"overrides-key": {"grpc/kv-test"}, "filter": {"Service.Port == 1"}}.Encode(),
+	}
+	r, err := NewBuilder().Build(resolver.Target{URL: target}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+	defer r.Close()
+
+	waitForQueryFilter(t, health, "Service.Port == 1")
+
+	kv.Set("grpc/kv-test", []byte(`{"filter": "Service.Port == 2", "health": "fallbackToUnhealthy"}`))
+	waitForQueryFilter(t, health, "Service.Port == 2")
+
+	// invalid overrides are ignored
+	kv.Set("grpc/kv-test", []byte(`{"filter": "Service.Port =="}`))
+	time.Sleep(100 * time.Millisecond)
+	waitForQueryFilter(t, health, "Service.Port == 2")
+
+	kv.Set("grpc/kv-test", nil)
+	waitForQueryFilter(t, health, "Service.Port == 1")
+}
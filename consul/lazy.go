@@ -0,0 +1,63 @@
+package consul
+
+import (
+	"context"
+	"net/url"
+	"sync/atomic"
+
+	"google.golang.org/grpc"
+)
+
+// LazyStartUnaryClientInterceptor returns an interceptor that starts the
+// resolvers of the channel that were created with [WithLazyStart], before
+// the RPC is invoked.
+func LazyStartUnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		startLazyResolvers(cc.Target())
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+// LazyStartStreamClientInterceptor returns an interceptor that starts the
+// resolvers of the channel that were created with [WithLazyStart], before
+// the stream is created.
+func LazyStartStreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		startLazyResolvers(cc.Target())
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// lazyPending is the number of lazily started resolvers whose watchers were
+// not started yet. It allows the interceptors to skip searching the active
+// resolvers when all were started.
+var lazyPending atomic.Int64
+
+// startLazyResolvers starts the watchers of the lazily started resolvers
+// that were built for target.
+func startLazyResolvers(target string) {
+	if lazyPending.Load() == 0 {
+		return
+	}
+
+	u, err := url.Parse(target)
+	if err != nil {
+		return
+	}
+
+	dialTarget := u.String()
+	for _, c := range activeResolvers.all() {
+		if c.lazy && c.dialTarget == dialTarget {
+			c.startWatching()
+		}
+	}
+}
+
+// pending returns true if c is lazily started and its watcher was not
+// started yet.
+func (c *consulResolver) pending() bool {
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+
+	return c.lazy && !c.started && !c.closed
+}
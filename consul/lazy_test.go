@@ -0,0 +1,150 @@
+package consul
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestLazyStartOnResolveNow(t *testing.T) {
+	cc := mocks.NewClientConn()
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: 1},
+	})
+
+	r, err := NewBuilder(WithLazyStart()).Build(resolver.Target{URL: url.URL{Path: "lazy-test"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+	defer r.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	if health.LastQueryOptions() != nil {
+		t.Fatal("consul was queried before ResolveNow() was called")
+	}
+
+	if h, _ := findResolverHealth("lazy-test"); !h.Pending || !h.Healthy {
+		t.Errorf("resolver health is %+v, expected a pending resolver to be healthy", h)
+	}
+
+	r.ResolveNow(resolver.ResolveNowOptions{})
+
+	for cc.UpdateStateCallCnt() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if h, _ := findResolverHealth("lazy-test"); h.Pending || !h.Healthy {
+		t.Errorf("resolver health is %+v, expected a started resolver to be healthy and not pending", h)
+	}
+}
+
+func TestLazyStartClosedBeforeStart(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	r, err := NewBuilder(WithLazyStart()).Build(resolver.Target{URL: url.URL{Path: "lazy-close-test"}}, mocks.NewClientConn(), resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+
+	r.Close()
+	r.ResolveNow(resolver.ResolveNowOptions{})
+
+	time.Sleep(50 * time.Millisecond)
+	if health.LastQueryOptions() != nil {
+		t.Error("consul was queried after the resolver was closed")
+	}
+
+	if n := lazyPending.Load(); n != 0 {
+		t.Errorf("%d lazily started resolvers are pending, expected 0", n)
+	}
+}
+
+func TestLazyStartInterceptor(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, health.NewServer())
+	go func() { _ = srv.Serve(l) }()
+	t.Cleanup(srv.Stop)
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	p, _ := strconv.Atoi(port)
+
+	consulHealth := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return consulHealth, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	consulHealth.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: p},
+	})
+
+	const target = "consul:///lazy-interceptor-test"
+	opts, err := DialOptions(target, WithLazyStart())
+	if err != nil {
+		t.Fatal("DialOptions() failed:", err)
+	}
+
+	conn, err := grpc.Dial(target, append(opts,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(LazyStartUnaryClientInterceptor()),
+	)...)
+	if err != nil {
+		t.Fatal("Dial() failed:", err)
+	}
+	defer conn.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	if consulHealth.LastQueryOptions() != nil {
+		t.Fatal("consul was queried before the first RPC")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{}); err != nil {
+		t.Fatal("health check failed:", err)
+	}
+
+	// the resolver is closed asynchronously by gRPC, wait for it to not
+	// affect other tests
+	conn.Close()
+	for {
+		if _, ok := findResolverHealth("lazy-interceptor-test"); !ok {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
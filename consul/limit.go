@@ -0,0 +1,97 @@
+package consul
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// MaxInstancesPolicy defines how a service is resolved that has more
+// instances than the max-instances target option allows.
+type MaxInstancesPolicy int
+
+const (
+	// MaxInstancesHold keeps the addresses that were passed to the gRPC
+	// channel before. If none were passed yet, an error is reported.
+	MaxInstancesHold MaxInstancesPolicy = iota
+	// MaxInstancesTruncate passes the first max-instances addresses, in
+	// the order they are passed to the gRPC channel, e.g. sorted by
+	// address.
+	MaxInstancesTruncate
+)
+
+// String returns the value of the max-instances-policy target option that
+// selects the policy.
+func (p MaxInstancesPolicy) String() string {
+	switch p {
+	case MaxInstancesTruncate:
+		return "truncate"
+	default:
+		return "hold"
+	}
+}
+
+// MarshalText returns the value of the max-instances-policy target option
+// that selects the policy.
+func (p MaxInstancesPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText sets the policy from the value of a max-instances-policy
+// target option.
+func (p *MaxInstancesPolicy) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*p = MaxInstancesHold
+		return nil
+	}
+
+	policy, err := parseMaxInstancesPolicy(string(text))
+	if err != nil {
+		return err
+	}
+
+	*p = policy
+
+	return nil
+}
+
+func parseMaxInstancesPolicy(value string) (MaxInstancesPolicy, error) {
+	switch strings.ToLower(value) {
+	case "hold":
+		return MaxInstancesHold, nil
+	case "truncate":
+		return MaxInstancesTruncate, nil
+	default:
+		return MaxInstancesHold, fmt.Errorf("%w '%s' for 'max-instances-policy'", ErrInvalidOptionValue, value)
+	}
+}
+
+// limitInstances applies the max-instances limit to the ordered addresses.
+// If the limit is exceeded, an alert is logged and the truncated addresses
+// or, with [MaxInstancesHold], an error wrapping [ErrTooManyInstances] is
+// returned.
+func (c *consulResolver) limitInstances(addresses []resolver.Address) ([]resolver.Address, error) {
+	if c.maxInstances <= 0 || len(addresses) <= c.maxInstances {
+		return addresses, nil
+	}
+
+	c.emit(&InstanceLimitExceeded{
+		Service:   c.service,
+		Instances: len(addresses),
+		Limit:     c.maxInstances,
+		Policy:    c.maxInstancesPolicy,
+	})
+
+	if c.maxInstancesPolicy == MaxInstancesTruncate {
+		c.log.warningf("grpc-consul-resolver: service '%s' resolved to %d instances, more than the limit of %d, using only the first %d",
+			c.service, len(addresses), c.maxInstances, c.maxInstances)
+		return addresses[:c.maxInstances], nil
+	}
+
+	c.log.warningf("grpc-consul-resolver: service '%s' resolved to %d instances, more than the limit of %d, keeping the previous addresses",
+		c.service, len(addresses), c.maxInstances)
+
+	return nil, fmt.Errorf("%w: service '%s' resolved to %d instances, the limit is %d",
+		ErrTooManyInstances, c.service, len(addresses), c.maxInstances)
+}
@@ -0,0 +1,112 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestMaxInstancesHoldKeepsPreviousAddresses(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	three := []*consul.AgentService{
+		{Address: "10.0.0.1", Port: 1},
+		{Address: "10.0.0.2", Port: 1},
+		{Address: "10.0.0.3", Port: 1},
+	}
+	health.SetRespServiceEntries(three)
+	health.SetRespIndex(1)
+
+	target, err := ParseTarget("consul:///max-instances-test?max-instances=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc := mocks.NewClientConn()
+	h := recordingStatsHandler{}
+	r, err := newConsulResolver(cc, target, &builderOptions{clock: newFakeClock(), statsHandler: &h})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	r.poll()
+	if cnt := cc.UpdateStateCallCnt(); cnt != 0 {
+		t.Errorf("UpdateState was called %d times, expected 0 while the limit is exceeded", cnt)
+	}
+	if err := cc.LastReportedError(); !errors.Is(err, ErrTooManyInstances) || status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("reported error is %v, expected ErrTooManyInstances with code FailedPrecondition", err)
+	}
+
+	health.SetRespServiceEntries(three[:2])
+	health.SetRespIndex(2)
+	r.poll()
+	if got := addrStrings(cc.Addrs()); len(got) != 2 {
+		t.Fatalf("resolved to %v, expected 2 addresses", got)
+	}
+
+	health.SetRespServiceEntries(three)
+	health.SetRespIndex(3)
+	r.poll()
+	if cnt := cc.UpdateStateCallCnt(); cnt != 1 {
+		t.Errorf("UpdateState was called %d times, expected 1, the previous addresses must be kept", cnt)
+	}
+
+	var limitEvents int
+	for _, ev := range h.Events() {
+		if e, ok := ev.(*InstanceLimitExceeded); ok {
+			limitEvents++
+			if e.Instances != 3 || e.Limit != 2 || e.Policy != MaxInstancesHold {
+				t.Errorf("got event %+v, expected 3 instances, limit 2 and policy hold", e)
+			}
+		}
+	}
+	if limitEvents != 2 {
+		t.Errorf("got %d InstanceLimitExceeded events, expected 2", limitEvents)
+	}
+}
+
+func TestMaxInstancesTruncate(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "10.0.0.3", Port: 1},
+		{Address: "10.0.0.1", Port: 1},
+		{Address: "10.0.0.2", Port: 1},
+	})
+
+	addrs, err := Lookup(context.Background(), "consul:///max-instances-test?max-instances=2&max-instances-policy=truncate")
+	if err != nil {
+		t.Fatal("Lookup() failed:", err)
+	}
+	if got := addrStrings(addrs); len(got) != 2 || got[0] != "10.0.0.1:1" || got[1] != "10.0.0.2:1" {
+		t.Errorf("resolved to %v, expected the first 2 sorted addresses", got)
+	}
+
+	_, err = Lookup(context.Background(), "consul:///max-instances-test?max-instances=2")
+	if !errors.Is(err, ErrTooManyInstances) {
+		t.Errorf("Lookup() returned error %v, expected ErrTooManyInstances", err)
+	}
+
+	if _, err := ParseTarget("consul:///max-instances-test?max-instances=-1"); !errors.Is(err, ErrInvalidOptionValue) {
+		t.Errorf("ParseTarget() returned error %v for a negative limit, expected ErrInvalidOptionValue", err)
+	}
+}
@@ -0,0 +1,135 @@
+package consul
+
+import (
+	"fmt"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+)
+
+// LocalNodePolicy defines how instances running on the node of the Consul
+// agent the resolver is connected to are preferred.
+type LocalNodePolicy int
+
+const (
+	// LocalNodeUndefined does not prefer instances on the local node.
+	LocalNodeUndefined LocalNodePolicy = iota
+	// LocalNodeFirst orders the addresses of instances on the local node
+	// before the other addresses.
+	LocalNodeFirst
+	// LocalNodeOnly resolves only to instances on the local node if any
+	// are available, otherwise to all instances.
+	LocalNodeOnly
+)
+
+// String returns the value of the local-node target option that selects
+// the policy.
+func (p LocalNodePolicy) String() string {
+	switch p {
+	case LocalNodeFirst:
+		return "first"
+	case LocalNodeOnly:
+		return "only"
+	default:
+		return ""
+	}
+}
+
+// MarshalText returns the value of the local-node target option that
+// selects the policy.
+func (p LocalNodePolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText sets the policy from the value of a local-node target
+// option.
+func (p *LocalNodePolicy) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*p = LocalNodeUndefined
+		return nil
+	}
+
+	policy, err := parseLocalNodePolicy(string(text))
+	if err != nil {
+		return err
+	}
+
+	*p = policy
+
+	return nil
+}
+
+func parseLocalNodePolicy(value string) (LocalNodePolicy, error) {
+	switch strings.ToLower(value) {
+	case "first":
+		return LocalNodeFirst, nil
+	case "only":
+		return LocalNodeOnly, nil
+	default:
+		return LocalNodeUndefined, fmt.Errorf("%w '%s' for 'local-node'", ErrInvalidOptionValue, value)
+	}
+}
+
+type consulAgentEndpoint interface {
+	NodeName() (string, error)
+}
+
+// consulCreateAgentClientFn can be overwritten in tests to make
+// newConsulResolver() return a different consulAgentEndpoint implementation
+var consulCreateAgentClientFn = func(cfg *consul.Config) (consulAgentEndpoint, error) {
+	clt, err := consul.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return clt.Agent(), nil
+}
+
+// localNode returns the name of the node of the Consul agent. It is
+// retrieved from the agent on the first call.
+func (c *consulResolver) localNode() (string, error) {
+	if c.localNodeName != "" {
+		return c.localNodeName, nil
+	}
+
+	name, err := c.agent.NodeName()
+	if err != nil {
+		return "", fmt.Errorf("retrieving the node name of the consul agent failed: %w", err)
+	}
+
+	c.localNodeName = name
+
+	return name, nil
+}
+
+// isLocal returns true if the instance of e runs on the node.
+func isLocal(e *consul.ServiceEntry, node string) bool {
+	return e.Node != nil && e.Node.Node == node
+}
+
+// filterLocalNode returns the entries of instances on node if there are
+// any, otherwise entries is returned unchanged.
+func filterLocalNode(entries []*consul.ServiceEntry, node string) []*consul.ServiceEntry {
+	local := make([]*consul.ServiceEntry, 0, len(entries))
+
+	for _, e := range entries {
+		if isLocal(e, node) {
+			local = append(local, e)
+		}
+	}
+
+	if len(local) != 0 {
+		return local
+	}
+
+	return entries
+}
+
+// IsLocalNode returns true if addr was resolved from an instance on the node
+// of the Consul agent the resolver is connected to.
+// It is only set for targets with the local-node option.
+func IsLocalNode(addr resolver.Address) bool {
+	local, _ := addr.BalancerAttributes.Value(localNodeAttributeKey).(bool)
+	return local
+}
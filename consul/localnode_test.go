@@ -0,0 +1,92 @@
+package consul
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+type fakeAgent struct {
+	node string
+}
+
+func (a *fakeAgent) NodeName() (string, error) {
+	return a.node, nil
+}
+
+func TestLocalNode(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	oldAgentFn := consulCreateAgentClientFn
+	consulCreateAgentClientFn = func(cfg *consul.Config) (consulAgentEndpoint, error) {
+		return &fakeAgent{node: "node-b"}, nil
+	}
+	t.Cleanup(func() { consulCreateAgentClientFn = oldAgentFn })
+
+	entry := func(node, addr string) *consul.ServiceEntry {
+		return &consul.ServiceEntry{
+			Node:    &consul.Node{Node: node},
+			Service: &consul.AgentService{Address: addr, Port: 80},
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		target  string
+		entries []*consul.ServiceEntry
+		want    []string
+	}{
+		{
+			name:    "first",
+			target:  "consul:///local-node-test?local-node=first",
+			entries: []*consul.ServiceEntry{entry("node-a", "10.0.0.1"), entry("node-b", "10.0.0.2"), entry("node-c", "10.0.0.3")},
+			want:    []string{"10.0.0.2:80", "10.0.0.1:80", "10.0.0.3:80"},
+		},
+		{
+			name:    "only",
+			target:  "consul:///local-node-test?local-node=only",
+			entries: []*consul.ServiceEntry{entry("node-a", "10.0.0.1"), entry("node-b", "10.0.0.2"), entry("node-c", "10.0.0.3")},
+			want:    []string{"10.0.0.2:80"},
+		},
+		{
+			name:    "only without local instances",
+			target:  "consul:///local-node-test?local-node=only",
+			entries: []*consul.ServiceEntry{entry("node-a", "10.0.0.1"), entry("node-c", "10.0.0.3")},
+			want:    []string{"10.0.0.1:80", "10.0.0.3:80"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			health.SetRespEntries(tc.entries)
+
+			addrs, err := Lookup(context.Background(), tc.target)
+			if err != nil {
+				t.Fatal("Lookup() failed:", err)
+			}
+
+			var got []string
+			for _, a := range addrs {
+				got = append(got, a.Addr)
+			}
+
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("resolved to %v, expected %v", got, tc.want)
+			}
+
+			for _, a := range addrs {
+				if local := IsLocalNode(a); local != (a.Addr == "10.0.0.2:80") {
+					t.Errorf("IsLocalNode(%s) returned %t", a.Addr, local)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package consul
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/grpclog"
+)
+
+// logSampleInterval is the minimum duration between two log messages with
+// the same format string of a logSampler.
+const logSampleInterval = time.Minute
+
+// logSampler rate-limits repeated log messages, e.g. of failing queries
+// during a Consul outage. The first message with a format string is logged,
+// further ones are suppressed for logSampleInterval. The next message
+// logged afterwards includes the number of suppressed messages.
+// The zero value uses the system time.
+type logSampler struct {
+	clock Clock
+
+	mu      sync.Mutex
+	entries map[string]*sampledLog
+}
+
+// sharedLog is the logSampler of all resolvers. A Consul outage that makes
+// the queries of many resolvers fail is then logged once per
+// logSampleInterval instead of once per resolver.
+var sharedLog logSampler
+
+type sampledLog struct {
+	lastLogged time.Time
+	suppressed int
+}
+
+// allow returns true if a message with the format should be logged and the
+// number of messages that were suppressed since the previous one.
+func (s *logSampler) allow(format string) (bool, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clock := s.clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	now := clock.Now()
+
+	if s.entries == nil {
+		s.entries = map[string]*sampledLog{}
+	}
+
+	e, exists := s.entries[format]
+	if !exists {
+		s.entries[format] = &sampledLog{lastLogged: now}
+		return true, 0
+	}
+
+	if now.Sub(e.lastLogged) < logSampleInterval {
+		e.suppressed++
+		return false, 0
+	}
+
+	suppressed := e.suppressed
+	e.lastLogged = now
+	e.suppressed = 0
+
+	return true, suppressed
+}
+
+func (s *logSampler) sample(format string, args []any) (string, []any, bool) {
+	ok, suppressed := s.allow(format)
+	if !ok {
+		return "", nil, false
+	}
+
+	if suppressed > 0 {
+		format += " (suppressed %d similar messages)"
+		args = append(args, suppressed)
+	}
+
+	return format, args, true
+}
+
+func (s *logSampler) warningf(format string, args ...any) {
+	if format, args, ok := s.sample(format, args); ok {
+		grpclog.Warningf(format, args...)
+	}
+}
+
+func (s *logSampler) infof(format string, args ...any) {
+	if format, args, ok := s.sample(format, args); ok {
+		grpclog.Infof(format, args...)
+	}
+}
@@ -0,0 +1,87 @@
+package consul
+
+import (
+	"testing"
+	"time"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestLogSampler(t *testing.T) {
+	clock := newFakeClock()
+	s := logSampler{clock: clock}
+
+	type result struct {
+		ok         bool
+		suppressed int
+	}
+
+	steps := []struct {
+		advance time.Duration
+		format  string
+		want    result
+	}{
+		{0, "a", result{true, 0}},
+		{time.Second, "a", result{false, 0}},
+		{time.Second, "b", result{true, 0}},
+		{time.Second, "a", result{false, 0}},
+		{logSampleInterval, "a", result{true, 2}},
+		{time.Second, "a", result{false, 0}},
+		{logSampleInterval, "a", result{true, 1}},
+		{logSampleInterval, "a", result{true, 0}},
+	}
+
+	for i, step := range steps {
+		clock.Advance(step.advance)
+
+		ok, suppressed := s.allow(step.format)
+		if got := (result{ok, suppressed}); got != step.want {
+			t.Errorf("step %d: allow(%q) returned %+v, expected %+v", i, step.format, got, step.want)
+		}
+	}
+}
+
+func TestLogSamplerAppendsSuppressedCount(t *testing.T) {
+	clock := newFakeClock()
+	s := logSampler{clock: clock}
+
+	s.sample("query failed: %v", []any{"err"})
+	s.sample("query failed: %v", []any{"err"})
+	clock.Advance(logSampleInterval)
+
+	format, args, ok := s.sample("query failed: %v", []any{"err"})
+	if !ok {
+		t.Fatal("message was suppressed after the sample interval")
+	}
+
+	if format != "query failed: %v (suppressed %d similar messages)" || len(args) != 2 || args[1] != 1 {
+		t.Errorf("sample() returned format %q with args %v, expected the suppressed count to be appended", format, args)
+	}
+}
+
+func TestResolversShareLogSampler(t *testing.T) {
+	var resolvers []*consulResolver
+	for _, target := range []string{"consul:///log-test-a", "consul:///log-test-b"} {
+		pt, err := ParseTarget(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := newConsulResolver(mocks.NewClientConn(), pt, &builderOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(r.Close)
+
+		resolvers = append(resolvers, r)
+	}
+
+	const format = "log-test: resolving service '%s' failed"
+	if ok, _ := resolvers[0].log.allow(format); !ok {
+		t.Fatal("first message was suppressed")
+	}
+
+	if ok, _ := resolvers[1].log.allow(format); ok {
+		t.Error("the same message of another resolver was not suppressed")
+	}
+}
@@ -0,0 +1,99 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// Lookup resolves the service of the consul:// target once, with the same
+// rules as the resolver, and returns its addresses sorted by their Addr
+// field, or in the order returned by Consul for targets with the
+// preserve-order option.
+// It does not wait for changes and can be used by CLIs, health checks or to
+// validate a target before dialing it.
+func Lookup(ctx context.Context, target string, opts ...BuilderOption) ([]resolver.Address, error) {
+	t, err := ParseTarget(target)
+	if err != nil {
+		return nil, err
+	}
+
+	var bopts builderOptions
+	for _, o := range opts {
+		o(&bopts)
+	}
+
+	r, err := newConsulResolver(nil, t, &bopts)
+	if err != nil {
+		return nil, err
+	}
+	defer r.cancel()
+
+	settings := r.settings
+	q := r.queryOpts.WithContext(ctx)
+	q.WaitTime = 0
+	q.Filter = settings.filter
+
+	addrs, _, err := r.query(q, &settings)
+	if err != nil {
+		return nil, err
+	}
+
+	r.orderAddresses(addrs)
+	addrs, err = r.limitInstances(addrs)
+	if err != nil {
+		return nil, err
+	}
+	if r.enricher != nil {
+		addrs = r.enricher.enrich(ctx, r.service, addrs, r.addressKey)
+	}
+
+	return addrs, nil
+}
+
+// CheckService verifies that the consul:// target can be resolved: that
+// Consul is reachable, that it accepts the token of the target and that at
+// least one instance of the service matches the target, regardless of its
+// health. It returns [ErrServiceNotFound] if no instance matches.
+// It does not wait for changes and is intended for pre-flight checks on
+// startup and smoke tests of dial configurations.
+func CheckService(ctx context.Context, target string, opts ...BuilderOption) error {
+	t, err := ParseTarget(target)
+	if err != nil {
+		return err
+	}
+
+	var bopts builderOptions
+	for _, o := range opts {
+		o(&bopts)
+	}
+
+	r, err := newConsulResolver(nil, t, &bopts)
+	if err != nil {
+		return err
+	}
+	defer r.cancel()
+
+	q := r.queryOpts.WithContext(ctx)
+	q.WaitTime = 0
+	q.Filter = r.settings.filter
+
+	if r.tokens != nil {
+		q.Token, err = r.tokens.get(ctx)
+		if err != nil {
+			return redactError(err, r.secrets)
+		}
+	}
+
+	entries, _, err := r.fetch(r.settings.tags, false, q)
+	if err != nil {
+		return fmt.Errorf("querying service '%s' failed: %w", r.service, redactError(err, r.secrets))
+	}
+
+	if len(entries) == 0 {
+		return fmt.Errorf("%w: '%s'", ErrServiceNotFound, r.service)
+	}
+
+	return nil
+}
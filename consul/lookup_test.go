@@ -0,0 +1,88 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestLookup(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: 2},
+		{Address: "127.0.0.1", Port: 1},
+		{Address: "127.0.0.1", Port: 3, Meta: map[string]string{DrainingMetaKey: "true"}},
+	})
+
+	addrs, err := Lookup(context.Background(), "consul:///lookup-test?filter=Service.Port+!%3D+0")
+	if err != nil {
+		t.Fatal("Lookup() failed:", err)
+	}
+
+	if len(addrs) != 2 || addrs[0].Addr != "127.0.0.1:1" || addrs[1].Addr != "127.0.0.1:2" {
+		t.Errorf("Lookup() returned %+v, expected 127.0.0.1:1 and 127.0.0.1:2", addrs)
+	}
+
+	opts := health.LastQueryOptions()
+	if opts.WaitIndex != 0 || opts.Filter != "Service.Port != 0" {
+		t.Errorf("query options are %+v, expected a non-blocking query with the filter of the target", opts)
+	}
+
+	if h := ResolversHealth(); len(h) != 0 {
+		t.Errorf("ResolversHealth() returned %+v, Lookup() must not register a resolver", h)
+	}
+
+	queryErr := errors.New("query failed")
+	health.SetRespError(queryErr)
+	if _, err := Lookup(context.Background(), "consul:///lookup-test"); !errors.Is(err, queryErr) {
+		t.Errorf("Lookup() returned error %v, expected %v", err, queryErr)
+	}
+}
+
+func TestCheckService(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: 1},
+	})
+
+	if err := CheckService(context.Background(), "consul:///check-test?tags=primary"); err != nil {
+		t.Fatal("CheckService() failed:", err)
+	}
+
+	if opts := health.LastQueryOptions(); opts.WaitIndex != 0 {
+		t.Errorf("query options are %+v, expected a non-blocking query", opts)
+	}
+
+	health.SetRespServiceEntries(nil)
+	if err := CheckService(context.Background(), "consul:///check-test"); !errors.Is(err, ErrServiceNotFound) {
+		t.Errorf("CheckService() returned error %v, expected %v", err, ErrServiceNotFound)
+	}
+
+	health.SetRespError(consul.StatusError{Code: 403, Body: "ACL not found"})
+	var se consul.StatusError
+	if err := CheckService(context.Background(), "consul:///check-test"); !errors.As(err, &se) || se.Code != 403 {
+		t.Errorf("CheckService() returned error %v, expected status error 403", err)
+	}
+
+	if err := CheckService(context.Background(), "dns:///check-test"); !errors.Is(err, ErrUnsupportedURLScheme) {
+		t.Errorf("CheckService() returned error %v, expected %v", err, ErrUnsupportedURLScheme)
+	}
+}
@@ -0,0 +1,58 @@
+package consul
+
+import (
+	"context"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// connectionMonitor checks the connection to Consul every monitorInterval
+// until the resolver is closed.
+func (c *consulResolver) connectionMonitor() {
+	defer c.wgStop.Done()
+
+	ticker := c.clock.NewTicker(c.monitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		if err := c.checkConnection(); err != nil {
+			if c.ctx.Err() != nil {
+				return
+			}
+
+			c.log.warningf("grpc-consul-resolver: checking connection to consul for service '%s' failed, reconnecting: %v",
+				c.service, err)
+			c.reconnect()
+		}
+	}
+}
+
+// checkConnection requests the Raft leader from Consul with a timeout of
+// monitorInterval.
+func (c *consulResolver) checkConnection() error {
+	ctx, cancel := context.WithTimeout(c.ctx, c.monitorInterval)
+	defer cancel()
+
+	start := c.clock.Now()
+	_, err := c.consulStatus.LeaderWithQueryOptions((&consul.QueryOptions{}).WithContext(ctx))
+	err = redactError(err, c.secrets)
+	c.emit(&ConnectionChecked{Service: c.service, Duration: c.clock.Now().Sub(start), Err: err})
+
+	return err
+}
+
+// reconnect closes the idle connections to Consul and restarts the running
+// query, to make it use a new connection.
+func (c *consulResolver) reconnect() {
+	if c.transport != nil {
+		c.transport.CloseIdleConnections()
+	}
+
+	c.restartQuery()
+}
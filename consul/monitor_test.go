@@ -0,0 +1,63 @@
+package consul
+
+import (
+	"errors"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+type fakeStatusEndpoint struct {
+	fail atomic.Bool
+}
+
+func (s *fakeStatusEndpoint) LeaderWithQueryOptions(_ *consul.QueryOptions) (string, error) {
+	if s.fail.Load() {
+		return "", errors.New("connection reset")
+	}
+
+	return "127.0.0.1:8300", nil
+}
+
+func TestConnectionMonitor(t *testing.T) {
+	cc := mocks.NewClientConn()
+	health := mocks.NewConsulHealthClient()
+	t.Cleanup(replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	))
+
+	status := fakeStatusEndpoint{}
+	oldStatusFn := consulCreateStatusClientFn
+	consulCreateStatusClientFn = func(cfg *consul.Config) (consulStatusEndpoint, error) {
+		return &status, nil
+	}
+	t.Cleanup(func() { consulCreateStatusClientFn = oldStatusFn })
+
+	stats := recordingStatsHandler{}
+	b := NewBuilder(WithConnectionMonitor(10*time.Millisecond), WithStatsHandler(&stats))
+	r, err := b.Build(resolver.Target{URL: url.URL{Path: "test"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+	defer r.Close()
+
+	waitForEvent(t, &stats, func(ev Event) bool {
+		e, ok := ev.(*ConnectionChecked)
+		return ok && e.Err == nil
+	})
+
+	status.fail.Store(true)
+
+	waitForEvent(t, &stats, func(ev Event) bool {
+		e, ok := ev.(*ConnectionChecked)
+		return ok && e.Err != nil
+	})
+}
@@ -0,0 +1,156 @@
+package consul
+
+import (
+	"sync"
+	"time"
+)
+
+type muxState int
+
+const (
+	// muxStateIdle is the state of resolvers waiting for ResolveNow() to
+	// be called after a query failed.
+	muxStateIdle muxState = iota
+	muxStateQueued
+	muxStateRunning
+)
+
+// multiplexer runs the queries of many resolvers with a fixed number of
+// worker goroutines instead of one goroutine per resolver.
+// Resolvers are queried in FIFO order. Blocking queries are limited to
+// waitTime, to give resolvers in the queue a chance to be queried.
+type multiplexer struct {
+	concurrency int
+	waitTime    time.Duration
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []*consulResolver
+	states  map[*consulResolver]muxState
+	started bool
+}
+
+func newMultiplexer(concurrency int, waitTime time.Duration) *multiplexer {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	m := multiplexer{
+		concurrency: concurrency,
+		waitTime:    waitTime,
+		states:      map[*consulResolver]muxState{},
+	}
+	m.cond = sync.NewCond(&m.mu)
+
+	return &m
+}
+
+// add schedules the first query of c.
+// The workers are started when the first resolver is added, they run until
+// the process terminates.
+func (m *multiplexer) add(c *consulResolver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.started {
+		for i := 0; i < m.concurrency; i++ {
+			go m.worker()
+		}
+		m.started = true
+	}
+
+	m.enqueueLocked(c)
+}
+
+func (m *multiplexer) enqueueLocked(c *consulResolver) {
+	m.states[c] = muxStateQueued
+	m.queue = append(m.queue, c)
+	m.cond.Signal()
+}
+
+// resolveNow schedules a query for c if it is idle. If c is queued or
+// running, a query is scheduled after the current one failed.
+func (m *multiplexer) resolveNow(c *consulResolver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, exists := m.states[c]
+	if !exists {
+		return
+	}
+
+	if st == muxStateIdle {
+		m.enqueueLocked(c)
+		return
+	}
+
+	select {
+	case c.resolveNow <- struct{}{}:
+	default:
+	}
+}
+
+// remove stops scheduling queries for c. c.ctx must have been canceled
+// before. c.wgStop.Done() is called when no query of c is running
+// anymore.
+func (m *multiplexer) remove(c *consulResolver) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	st, exists := m.states[c]
+	if !exists {
+		return
+	}
+
+	switch st {
+	case muxStateRunning:
+		// the worker calls c.wgStop.Done() when the query returned
+		return
+	case muxStateQueued:
+		for i, qc := range m.queue {
+			if qc == c {
+				m.queue = append(m.queue[:i], m.queue[i+1:]...)
+				break
+			}
+		}
+	}
+
+	delete(m.states, c)
+	c.wgStop.Done()
+}
+
+func (m *multiplexer) worker() {
+	for {
+		m.mu.Lock()
+		for len(m.queue) == 0 {
+			m.cond.Wait()
+		}
+
+		c := m.queue[0]
+		m.queue[0] = nil
+		m.queue = m.queue[1:]
+		m.states[c] = muxStateRunning
+		m.mu.Unlock()
+
+		again := c.ctx.Err() == nil && c.poll()
+
+		m.mu.Lock()
+		switch {
+		case c.ctx.Err() != nil:
+			delete(m.states, c)
+			c.wgStop.Done()
+
+		case again:
+			m.enqueueLocked(c)
+
+		default:
+			select {
+			case <-c.resolveNow:
+				m.enqueueLocked(c)
+			default:
+				m.states[c] = muxStateIdle
+			}
+		}
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,97 @@
+package consul
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestMultiplexedWatches(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespEntries([]*consul.ServiceEntry{
+		{
+			Service: &consul.AgentService{
+				Address: "127.0.0.1",
+				Port:    1,
+			},
+		},
+	})
+
+	b := NewBuilder(WithMultiplexedWatches(1, time.Second))
+
+	var ccs []*mocks.ClientConn
+	var resolvers []resolver.Resolver
+
+	for _, svc := range []string{"a", "b", "c"} {
+		cc := mocks.NewClientConn()
+		r, err := b.Build(resolver.Target{URL: url.URL{Path: svc}}, cc, resolver.BuildOptions{})
+		if err != nil {
+			t.Fatal("Build() failed:", err.Error())
+		}
+
+		ccs = append(ccs, cc)
+		resolvers = append(resolvers, r)
+	}
+
+	for _, cc := range ccs {
+		for cc.UpdateStateCallCnt() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	health.SetRespError(errors.New("query failed"))
+	for _, cc := range ccs {
+		for cc.LastReportedError() == nil {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	health.SetRespError(nil)
+	health.SetRespEntries([]*consul.ServiceEntry{
+		{
+			Service: &consul.AgentService{
+				Address: "127.0.0.2",
+				Port:    1,
+			},
+		},
+	})
+
+	for i, r := range resolvers {
+		r.ResolveNow(resolver.ResolveNowOptions{})
+
+		for ccs[i].UpdateStateCallCnt() != 2 {
+			time.Sleep(time.Millisecond)
+		}
+
+		if addrs := ccs[i].Addrs(); len(addrs) != 1 || addrs[0].Addr != "127.0.0.2:1" {
+			t.Errorf("resolved addresses are %+v, expected [127.0.0.2:1]", addrs)
+		}
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		for _, r := range resolvers {
+			r.Close()
+		}
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("closing resolvers timed out")
+	}
+}
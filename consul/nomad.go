@@ -0,0 +1,287 @@
+package consul
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-cleanhttp"
+	"google.golang.org/grpc/resolver"
+)
+
+const nomadScheme = "nomad"
+
+// defaultNomadAddr is the address of the Nomad agent that is used when
+// neither the target nor the NOMAD_ADDR environment variable specify one.
+const defaultNomadAddr = "127.0.0.1:4646"
+
+// NewNomadBuilder returns a builder for a resolver of services registered
+// in the native service discovery of [Nomad].
+// It resolves targets in the format:
+//
+//	nomad://[<nomad-server>]/<serviceName>[.<namespace>][?<OPT>[&<OPT>]...]
+//
+// The scheme, tags, token, filter, tls-verify, ca-bundle and proxy options
+// of consul:// targets are supported. Filter expressions are evaluated by
+// Nomad and refer to the fields of Nomad service registrations.
+// Nomad does not report the health of services in its service API, all
+// registered instances are resolved.
+//
+// If nomad-server, scheme, token or namespace are not specified, the
+// NOMAD_ADDR, NOMAD_TOKEN and NOMAD_NAMESPACE environment variables are
+// used. The NOMAD_REGION environment variable selects the region.
+//
+// [Nomad]: https://developer.hashicorp.com/nomad/docs/networking/service-discovery
+func NewNomadBuilder(opts ...BuilderOption) resolver.Builder {
+	// <serviceName>.<namespace> is the target format of Nomad services
+	b := resolverBuilder{scheme: nomadScheme, profile: &Target{DottedNamespace: true}}
+	b.applyOpts(opts)
+	b.opts.nomad = true
+
+	return &b
+}
+
+// validateNomad returns an error if t uses settings that are not supported
+// by Nomad.
+func (t *Target) validateNomad() error {
+	if t.Partition != "" {
+		return fmt.Errorf("%w: nomad does not support partitions", ErrInvalidServicePath)
+	}
+
+	unsupported := []struct {
+		name string
+		set  bool
+	}{
+		{"dc", t.DC != ""},
+		{"segment", t.Segment != ""},
+		{"version", t.Version != ""},
+		{"overrides-key", t.OverridesKey != ""},
+		{"consul-srv", t.ConsulSRV},
+		{"port-name", t.PortName != ""},
+		{"prefer-node-address", t.PreferNodeAddress},
+		{"local-node", t.LocalNode != LocalNodeUndefined},
+		{"translate-wan-addrs", t.DisableWANTranslation},
+		{"gate-check", t.GateCheck != ""},
+		{"kind", t.Kind != KindUndefined},
+		{"dual-stack", t.DualStack},
+		{"check-types", len(t.CheckTypes) != 0},
+		{"min-healthy-fraction", t.MinHealthyFraction != 0},
+		{"failure-tolerance", t.FailureTolerance != 0},
+		{"failover-dcs", len(t.FailoverDCs) != 0},
+	}
+
+	for _, o := range unsupported {
+		if o.set {
+			return &UnsupportedOptionError{Name: o.name}
+		}
+	}
+
+	return nil
+}
+
+// nomadClient queries the HTTP API of Nomad. It implements the
+// consulHealthEndpoint and consulStatusEndpoint interfaces, to run the
+// resolver with Nomad instead of Consul.
+type nomadClient struct {
+	baseURL   string
+	token     string
+	namespace string
+	region    string
+	client    *http.Client
+}
+
+func newNomadClient(cfg *consul.Config) (*nomadClient, error) {
+	addr := cfg.Address
+	if addr == "" {
+		addr = os.Getenv("NOMAD_ADDR")
+	}
+	if addr == "" {
+		addr = defaultNomadAddr
+	}
+
+	scheme := cfg.Scheme
+	if strings.Contains(addr, "://") {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return nil, fmt.Errorf("parsing nomad address '%s' failed: %w", addr, err)
+		}
+
+		if scheme == "" {
+			scheme = u.Scheme
+		}
+		addr = u.Host
+	}
+	if scheme == "" {
+		scheme = "http"
+	}
+
+	token := cfg.Token
+	if token == "" {
+		token = os.Getenv("NOMAD_TOKEN")
+	}
+
+	// as the consul client, only set up the TLS configuration of the
+	// transport when it was not done by the caller
+	if cfg.Transport == nil {
+		cfg.Transport = cleanhttp.DefaultPooledTransport()
+	}
+	if cfg.Transport.TLSClientConfig == nil {
+		tlsConfig, err := consul.SetupTLSConfig(&cfg.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Transport.TLSClientConfig = tlsConfig
+	}
+
+	return &nomadClient{
+		baseURL:   scheme + "://" + addr,
+		token:     token,
+		namespace: os.Getenv("NOMAD_NAMESPACE"),
+		region:    os.Getenv("NOMAD_REGION"),
+		client:    &http.Client{Transport: cfg.Transport},
+	}, nil
+}
+
+// nomadServiceRegistration is an instance of a service registered in Nomad.
+type nomadServiceRegistration struct {
+	ID          string
+	ServiceName string
+	Namespace   string
+	NodeID      string
+	Datacenter  string
+	Tags        []string
+	Address     string
+	Port        int
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+// ServiceMultipleTags returns the instances of the Nomad service that have
+// all tags. passingOnly is ignored, Nomad does not report the health of
+// instances.
+func (n *nomadClient) ServiceMultipleTags(service string, tags []string, _ bool, q *consul.QueryOptions) ([]*consul.ServiceEntry, *consul.QueryMeta, error) {
+	var regs []nomadServiceRegistration
+
+	index, err := n.get(q, "/v1/service/"+url.PathEscape(service), &regs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries := make([]*consul.ServiceEntry, 0, len(regs))
+	for _, r := range regs {
+		if !hasAllTags(r.Tags, tags) {
+			continue
+		}
+
+		entries = append(entries, &consul.ServiceEntry{
+			Node: &consul.Node{ID: r.NodeID, Datacenter: r.Datacenter},
+			Service: &consul.AgentService{
+				ID:          r.ID,
+				Service:     r.ServiceName,
+				Namespace:   r.Namespace,
+				Datacenter:  r.Datacenter,
+				Tags:        r.Tags,
+				Address:     r.Address,
+				Port:        r.Port,
+				CreateIndex: r.CreateIndex,
+				ModifyIndex: r.ModifyIndex,
+			},
+		})
+	}
+
+	return entries, &consul.QueryMeta{LastIndex: index}, nil
+}
+
+// LeaderWithQueryOptions returns the address of the Nomad leader.
+func (n *nomadClient) LeaderWithQueryOptions(q *consul.QueryOptions) (string, error) {
+	var leader string
+
+	_, err := n.get(q, "/v1/status/leader", &leader)
+
+	return leader, err
+}
+
+func hasAllTags(have, want []string) bool {
+	for _, t := range want {
+		if !slices.Contains(have, t) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// get sends a GET request for path with the parameters from q to Nomad,
+// decodes the JSON response into result and returns the index of the
+// response.
+func (n *nomadClient) get(q *consul.QueryOptions, path string, result any) (uint64, error) {
+	params := url.Values{}
+
+	namespace := q.Namespace
+	if namespace == "" {
+		namespace = n.namespace
+	}
+	if namespace != "" {
+		params.Set("namespace", namespace)
+	}
+	if n.region != "" {
+		params.Set("region", n.region)
+	}
+	if q.AllowStale {
+		params.Set("stale", "")
+	}
+	if q.Filter != "" {
+		params.Set("filter", q.Filter)
+	}
+	if q.WaitIndex != 0 {
+		params.Set("index", strconv.FormatUint(q.WaitIndex, 10))
+	}
+	if q.WaitTime != 0 {
+		params.Set("wait", fmt.Sprintf("%dms", q.WaitTime.Milliseconds()))
+	}
+
+	req, err := http.NewRequestWithContext(q.Context(), http.MethodGet, n.baseURL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return 0, err
+	}
+
+	token := q.Token
+	if token == "" {
+		token = n.token
+	}
+	if token != "" {
+		req.Header.Set("X-Nomad-Token", token)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return 0, consul.StatusError{Code: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return 0, fmt.Errorf("decoding nomad response failed: %w", err)
+	}
+
+	var index uint64
+	if v := resp.Header.Get("X-Nomad-Index"); v != "" {
+		index, err = strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("parsing X-Nomad-Index header '%s' failed: %w", v, err)
+		}
+	}
+
+	return index, nil
+}
@@ -0,0 +1,166 @@
+package consul
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+// fakeNomad serves the service registrations of the api service in the
+// namespace prod, like the Nomad HTTP API.
+func fakeNomad(t *testing.T, regs []nomadServiceRegistration) *httptest.Server {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Nomad-Token") != "secret" {
+			http.Error(w, "Permission denied", http.StatusForbidden)
+			return
+		}
+
+		if r.URL.Path != "/v1/service/api" || r.URL.Query().Get("namespace") != "prod" {
+			http.NotFound(w, r)
+			return
+		}
+
+		// simulate a blocking query that does not return changes
+		if r.URL.Query().Get("index") == "7" {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+
+		w.Header().Set("X-Nomad-Index", "7")
+		_ = json.NewEncoder(w).Encode(regs)
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+func TestNomadBuilder(t *testing.T) {
+	srv := fakeNomad(t, []nomadServiceRegistration{
+		{ID: "a", ServiceName: "api", Namespace: "prod", Tags: []string{"grpc"}, Address: "10.0.0.1", Port: 8080, CreateIndex: 5, ModifyIndex: 6},
+		{ID: "b", ServiceName: "api", Namespace: "prod", Tags: []string{"http"}, Address: "10.0.0.2", Port: 8080},
+	})
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := resolver.Target{URL: url.URL{
+		Scheme:   "nomad",
+		Host:     u.Host,
+		Path:     "/api.prod",
+		RawQuery: "tags=grpc&token=secret",
+	}}
+
+	cc := mocks.NewClientConn()
+	r, err := NewNomadBuilder().Build(target, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err)
+	}
+	defer r.Close()
+
+	for cc.UpdateStateCallCnt() == 0 {
+		if err := cc.LastReportedError(); err != nil {
+			t.Fatal("resolver reported error:", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	addrs := cc.Addrs()
+	if len(addrs) != 1 || addrs[0].Addr != "10.0.0.1:8080" {
+		t.Fatalf("resolved to %+v, expected 10.0.0.1:8080", addrs)
+	}
+
+	if id, _ := ServiceID(addrs[0]); id != "a" {
+		t.Errorf("service id of address is %q, expected a", id)
+	}
+
+	h, ok := findResolverHealth("api")
+	if !ok {
+		t.Fatal("resolver is missing in ResolversHealth()")
+	}
+
+	if !strings.HasPrefix(h.Target, "nomad://") || strings.Contains(h.Target, "secret") {
+		t.Errorf("resolver health target is %q, expected a nomad:// target without the token", h.Target)
+	}
+}
+
+func TestNomadBuilderReportsErrors(t *testing.T) {
+	srv := fakeNomad(t, nil)
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc := mocks.NewClientConn()
+	r, err := NewNomadBuilder().Build(resolver.Target{URL: url.URL{Scheme: "nomad", Host: u.Host, Path: "/api.prod"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err)
+	}
+	defer r.Close()
+
+	for cc.LastReportedError() == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := cc.LastReportedError(); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("resolver reported error %q with code %s, expected PermissionDenied", err, status.Code(err))
+	}
+}
+
+func TestNomadBuilderUnsupportedOptions(t *testing.T) {
+	tests := []struct {
+		target  string
+		wantErr error
+	}{
+		{"nomad:///api?dc=eu", &UnsupportedOptionError{Name: "dc"}},
+		{"nomad:///api?version=%5E1.0", &UnsupportedOptionError{Name: "version"}},
+		{"nomad:///api?overrides-key=x", &UnsupportedOptionError{Name: "overrides-key"}},
+		{"nomad:///api?check-types=grpc", &UnsupportedOptionError{Name: "check-types"}},
+		{"nomad:///api?min-healthy-fraction=0.3", &UnsupportedOptionError{Name: "min-healthy-fraction"}},
+		{"nomad:///api?failure-tolerance=30s", &UnsupportedOptionError{Name: "failure-tolerance"}},
+		{"nomad:///api?failover-dcs=us-east", &UnsupportedOptionError{Name: "failover-dcs"}},
+		{"nomad:///team-a/prod/api", ErrInvalidServicePath},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			u, err := url.Parse(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = NewNomadBuilder().Build(resolver.Target{URL: *u}, mocks.NewClientConn(), resolver.BuildOptions{})
+
+			var optErr *UnsupportedOptionError
+			if errors.As(tt.wantErr, &optErr) {
+				var gotErr *UnsupportedOptionError
+				if !errors.As(err, &gotErr) || gotErr.Name != optErr.Name {
+					t.Errorf("Build() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Build() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
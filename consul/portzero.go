@@ -0,0 +1,80 @@
+package consul
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PortMetaKey is the key of the service meta field that contains the port
+// of an instance when it is resolved with [PortZeroUseMetaPort].
+const PortMetaKey = "port"
+
+// PortZeroPolicy defines how instances that are registered with port 0 are
+// resolved.
+type PortZeroPolicy int
+
+const (
+	// PortZeroUndefined resolves instances with port 0 to an address with
+	// port 0.
+	PortZeroUndefined PortZeroPolicy = iota
+	// PortZeroSkip does not resolve instances with port 0.
+	PortZeroSkip
+	// PortZeroError fails the resolution of the service when an instance
+	// has port 0. The error wraps [ErrZeroPort].
+	PortZeroError
+	// PortZeroUseMetaPort resolves instances with port 0 to the port in
+	// their [PortMetaKey] service meta field. Instances without a valid
+	// port in the field are not resolved.
+	PortZeroUseMetaPort
+)
+
+// String returns the value of the port-zero target option that selects the
+// policy.
+func (p PortZeroPolicy) String() string {
+	switch p {
+	case PortZeroSkip:
+		return "skip"
+	case PortZeroError:
+		return "error"
+	case PortZeroUseMetaPort:
+		return "use-meta-port"
+	default:
+		return ""
+	}
+}
+
+// MarshalText returns the value of the port-zero target option that selects
+// the policy.
+func (p PortZeroPolicy) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText sets the policy from the value of a port-zero target option.
+func (p *PortZeroPolicy) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*p = PortZeroUndefined
+		return nil
+	}
+
+	policy, err := parsePortZeroPolicy(string(text))
+	if err != nil {
+		return err
+	}
+
+	*p = policy
+
+	return nil
+}
+
+func parsePortZeroPolicy(value string) (PortZeroPolicy, error) {
+	switch strings.ToLower(value) {
+	case "skip":
+		return PortZeroSkip, nil
+	case "error":
+		return PortZeroError, nil
+	case "use-meta-port":
+		return PortZeroUseMetaPort, nil
+	default:
+		return PortZeroUndefined, fmt.Errorf("%w '%s' for 'port-zero'", ErrInvalidOptionValue, value)
+	}
+}
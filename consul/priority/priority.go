@@ -0,0 +1,84 @@
+// Package priority provides a gRPC load balancer that only sends requests to
+// the Consul service instances with the highest priority that are
+// available.
+//
+// The priorities are attached to the addresses by the consul resolver for
+// targets with the priority-tags option, e.g. for
+// priority-tags=primary,secondary instances with the tag primary have a
+// higher priority than instances with the tag secondary. Requests are
+// distributed round-robin across the instances of the highest priority that
+// have a ready connection. Instances of the next priority are only used when
+// no instance of a higher one is ready. Addresses without a priority have
+// the lowest one.
+//
+// The balancer is registered with the name [Name] and can be selected with
+// the service config:
+//
+//	grpc.Dial("consul://127.0.0.1:8500/user-service?priority-tags=primary,secondary",
+//		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"consul_priority":{}}]}`),
+//	)
+package priority
+
+import (
+	"math"
+	"math/rand"
+	"sync/atomic"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/consul"
+)
+
+// Name is the name of the balancer registered by the package.
+const Name = "consul_priority"
+
+func init() {
+	balancer.Register(base.NewBalancerBuilder(Name, &pickerBuilder{}, base.Config{HealthCheck: true}))
+}
+
+type pickerBuilder struct{}
+
+func (*pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	best := math.MaxInt
+	var scs []balancer.SubConn
+	for sc, sci := range info.ReadySCs {
+		p := priority(sci.Address)
+		if p < best {
+			best = p
+			scs = scs[:0]
+		}
+		if p == best {
+			scs = append(scs, sc)
+		}
+	}
+
+	p := &picker{scs: scs}
+	p.next.Store(uint32(rand.Intn(len(scs))))
+
+	return p
+}
+
+// priority returns the priority of addr, or [math.MaxInt] if it has none.
+func priority(addr resolver.Address) int {
+	if p, ok := consul.Priority(addr); ok {
+		return p
+	}
+
+	return math.MaxInt
+}
+
+type picker struct {
+	scs  []balancer.SubConn
+	next atomic.Uint32
+}
+
+func (p *picker) Pick(balancer.PickInfo) (balancer.PickResult, error) {
+	i := p.next.Add(1)
+	return balancer.PickResult{SubConn: p.scs[int(i)%len(p.scs)]}, nil
+}
@@ -0,0 +1,113 @@
+package priority
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/consul"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+// lookup resolves the services via a fake Consul server with the
+// priority-tags=primary,secondary target option, to get addresses with the
+// priorities set by the consul resolver.
+func lookup(t *testing.T, services ...*consulapi.AgentService) []resolver.Address {
+	t.Helper()
+
+	entries := make([]*consulapi.ServiceEntry, 0, len(services))
+	for _, s := range services {
+		entries = append(entries, &consulapi.ServiceEntry{Service: s})
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Consul-Index", "1")
+		_ = json.NewEncoder(w).Encode(entries)
+	}))
+	t.Cleanup(srv.Close)
+
+	addrs, err := consul.Lookup(context.Background(),
+		fmt.Sprintf("consul://%s/user-service?priority-tags=primary,secondary", srv.Listener.Addr()))
+	if err != nil {
+		t.Fatal("Lookup() failed:", err)
+	}
+
+	return addrs
+}
+
+// pickedAddrs builds a picker for the ready addresses and returns the
+// addresses it picks for 100 requests.
+func pickedAddrs(t *testing.T, ready []resolver.Address) map[string]int {
+	t.Helper()
+
+	info := base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{}}
+	for _, a := range ready {
+		info.ReadySCs[&fakeSubConn{addr: a.Addr}] = base.SubConnInfo{Address: a}
+	}
+
+	p := (&pickerBuilder{}).Build(info)
+
+	result := map[string]int{}
+	for i := 0; i < 100; i++ {
+		res, err := p.Pick(balancer.PickInfo{Ctx: context.Background()})
+		if err != nil {
+			t.Fatal("Pick() failed:", err)
+		}
+		result[res.SubConn.(*fakeSubConn).addr]++
+	}
+
+	return result
+}
+
+func TestOnlyHighestAvailablePriorityIsUsed(t *testing.T) {
+	addrs := lookup(t,
+		&consulapi.AgentService{ID: "p1", Address: "10.0.0.1", Port: 80, Tags: []string{"primary"}},
+		&consulapi.AgentService{ID: "p2", Address: "10.0.0.2", Port: 80, Tags: []string{"primary"}},
+		&consulapi.AgentService{ID: "s1", Address: "10.0.1.1", Port: 80, Tags: []string{"secondary"}},
+		&consulapi.AgentService{ID: "u1", Address: "10.0.2.1", Port: 80},
+	)
+
+	byAddr := map[string]resolver.Address{}
+	for _, a := range addrs {
+		byAddr[a.Addr] = a
+	}
+
+	for _, tc := range []struct {
+		ready []string
+		want  []string
+	}{
+		{[]string{"10.0.0.1:80", "10.0.0.2:80", "10.0.1.1:80", "10.0.2.1:80"}, []string{"10.0.0.1:80", "10.0.0.2:80"}},
+		{[]string{"10.0.0.2:80", "10.0.1.1:80"}, []string{"10.0.0.2:80"}},
+		{[]string{"10.0.1.1:80", "10.0.2.1:80"}, []string{"10.0.1.1:80"}},
+		{[]string{"10.0.2.1:80"}, []string{"10.0.2.1:80"}},
+	} {
+		t.Run(fmt.Sprint(tc.ready), func(t *testing.T) {
+			var ready []resolver.Address
+			for _, a := range tc.ready {
+				ready = append(ready, byAddr[a])
+			}
+
+			picked := pickedAddrs(t, ready)
+			if len(picked) != len(tc.want) {
+				t.Errorf("picked %v, expected only %v", picked, tc.want)
+			}
+			for _, a := range tc.want {
+				if picked[a] == 0 {
+					t.Errorf("%s was not picked, picks: %v", a, picked)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package consul
+
+import (
+	"context"
+	"net"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+)
+
+// ProtocolMetaKey is the key of the service meta field that contains the
+// protocol an instance accepts connections with, [ProtocolH2] or
+// [ProtocolH2C]. It is attached to the address of the instance and can be
+// retrieved with [Protocol] and [ProtocolFromDialContext].
+const ProtocolMetaKey = "protocol"
+
+const (
+	// ProtocolH2 is the protocol of instances that accept HTTP/2
+	// connections over TLS.
+	ProtocolH2 = "h2"
+	// ProtocolH2C is the protocol of instances that accept HTTP/2
+	// connections without TLS, e.g. because a service mesh sidecar
+	// terminates TLS in front of them.
+	ProtocolH2C = "h2c"
+)
+
+// Protocol returns the protocol from the [ProtocolMetaKey] service meta
+// field of the instance addr was resolved from.
+func Protocol(addr resolver.Address) (string, bool) {
+	p, ok := addr.Attributes.Value(protocolAttributeKey).(string)
+	return p, ok
+}
+
+// ProtocolFromDialContext returns the protocol from the [ProtocolMetaKey]
+// service meta field of the instance a connection is established to. It can
+// be called with the context passed to the dialer configured with
+// [google.golang.org/grpc.WithContextDialer] or to transport credentials.
+func ProtocolFromDialContext(ctx context.Context) (string, bool) {
+	p, ok := credentials.ClientHandshakeInfoFromContext(ctx).Attributes.Value(protocolAttributeKey).(string)
+	return p, ok
+}
+
+// ProtocolCredentials returns transport credentials for mixed fleets, where
+// some instances terminate TLS and others accept plaintext connections.
+// Connections to instances whose [ProtocolMetaKey] service meta field is
+// [ProtocolH2C] are established without TLS, creds are used for all other
+// instances.
+func ProtocolCredentials(creds credentials.TransportCredentials) credentials.TransportCredentials {
+	return &protocolCredentials{
+		TransportCredentials: creds,
+		plaintext:            insecure.NewCredentials(),
+	}
+}
+
+type protocolCredentials struct {
+	credentials.TransportCredentials
+	plaintext credentials.TransportCredentials
+}
+
+func (c *protocolCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	if p, _ := ProtocolFromDialContext(ctx); p == ProtocolH2C {
+		return c.plaintext.ClientHandshake(ctx, authority, conn)
+	}
+
+	return c.TransportCredentials.ClientHandshake(ctx, authority, conn)
+}
+
+func (c *protocolCredentials) Clone() credentials.TransportCredentials {
+	return &protocolCredentials{
+		TransportCredentials: c.TransportCredentials.Clone(),
+		plaintext:            c.plaintext,
+	}
+}
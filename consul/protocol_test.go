@@ -0,0 +1,129 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+// rejectingCredentials fails all client handshakes and records that they
+// were attempted.
+type rejectingCredentials struct {
+	credentials.TransportCredentials
+	handshakes chan struct{}
+}
+
+func (c *rejectingCredentials) ClientHandshake(context.Context, string, net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	select {
+	case c.handshakes <- struct{}{}:
+	default:
+	}
+
+	return nil, nil, errors.New("handshake rejected")
+}
+
+func (c *rejectingCredentials) Clone() credentials.TransportCredentials {
+	return c
+}
+
+func TestProtocolCredentials(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := grpc.NewServer()
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	port := lis.Addr().(*net.TCPAddr).Port
+
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	dial := func(meta map[string]string) (*grpc.ClientConn, *rejectingCredentials) {
+		t.Helper()
+
+		health.SetRespServiceEntries([]*consul.AgentService{{Address: "127.0.0.1", Port: port, Meta: meta}})
+
+		creds := rejectingCredentials{
+			TransportCredentials: insecure.NewCredentials(),
+			handshakes:           make(chan struct{}, 1),
+		}
+
+		conn, err := grpc.Dial("consul:///user-service",
+			grpc.WithResolvers(NewBuilder()),
+			grpc.WithTransportCredentials(ProtocolCredentials(&creds)),
+		)
+		if err != nil {
+			t.Fatal("Dial() failed:", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+
+		conn.Connect()
+
+		return conn, &creds
+	}
+
+	conn, creds := dial(map[string]string{ProtocolMetaKey: "H2C"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for s := conn.GetState(); s != connectivity.Ready; s = conn.GetState() {
+		if !conn.WaitForStateChange(ctx, s) {
+			t.Fatalf("connection to h2c instance is %s, expected %s", s, connectivity.Ready)
+		}
+	}
+	if len(creds.handshakes) != 0 {
+		t.Error("the wrapped credentials were used for a h2c instance")
+	}
+
+	_, creds = dial(map[string]string{ProtocolMetaKey: ProtocolH2})
+	select {
+	case <-creds.handshakes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the wrapped credentials were not used for a h2 instance")
+	}
+}
+
+func TestProtocolAttribute(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "10.0.0.1", Port: 1, Meta: map[string]string{ProtocolMetaKey: "h2c"}},
+		{Address: "10.0.0.2", Port: 1},
+	})
+
+	addrs, err := Lookup(context.Background(), "consul:///user-service")
+	if err != nil {
+		t.Fatal("Lookup() failed:", err)
+	}
+
+	for i, want := range []string{ProtocolH2C, ""} {
+		p, ok := Protocol(addrs[i])
+		if p != want || ok != (want != "") {
+			t.Errorf("Protocol() of %s returned %q, %t, expected %q", addrs[i].Addr, p, ok, want)
+		}
+	}
+}
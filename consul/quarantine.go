@@ -0,0 +1,147 @@
+package consul
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// quarantine contains the addresses that were reported as failing via
+// [ReportAddressFailure] and are not passed to the ClientConn until their
+// quarantine expired.
+type quarantine struct {
+	clock Clock
+	// duration is the quarantine of an address after its first failure,
+	// it is doubled with every repeated failure up to maxDuration.
+	duration    time.Duration
+	maxDuration time.Duration
+
+	mu sync.Mutex
+	// published are the addresses that were passed to filter the last
+	// time.
+	published map[string]struct{}
+	entries   map[string]*quarantineEntry
+}
+
+type quarantineEntry struct {
+	until       time.Time
+	lastFailure time.Time
+	failures    int
+}
+
+func newQuarantine(clock Clock, duration, maxDuration time.Duration) *quarantine {
+	return &quarantine{
+		clock:       clock,
+		duration:    duration,
+		maxDuration: max(duration, maxDuration),
+		published:   map[string]struct{}{},
+		entries:     map[string]*quarantineEntry{},
+	}
+}
+
+// report quarantines addr. It returns the duration of the quarantine and
+// false if addr is not one of the addresses of the resolver.
+// The duration is doubled for every failure that is reported within
+// maxDuration after the previous one, failures decay when no failure is
+// reported for maxDuration.
+func (q *quarantine) report(addr string) (time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, exists := q.published[addr]; !exists {
+		return 0, false
+	}
+
+	now := q.clock.Now()
+
+	e := q.entries[addr]
+	if e == nil || now.Sub(e.lastFailure) >= q.maxDuration {
+		e = &quarantineEntry{}
+		q.entries[addr] = e
+	}
+
+	d := q.duration
+	for i := 0; i < e.failures && d < q.maxDuration; i++ {
+		d *= 2
+	}
+	d = min(d, q.maxDuration)
+
+	e.failures++
+	e.lastFailure = now
+	e.until = now.Add(d)
+
+	return d, true
+}
+
+// filter returns the addresses that are not quarantined. If all addresses
+// are quarantined, addresses is returned unchanged, a failing address is
+// better than none.
+func (q *quarantine) filter(addresses []resolver.Address) []resolver.Address {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := q.clock.Now()
+
+	clear(q.published)
+	for _, a := range addresses {
+		q.published[a.Addr] = struct{}{}
+	}
+
+	for addr, e := range q.entries {
+		if now.Sub(e.lastFailure) >= q.maxDuration {
+			delete(q.entries, addr)
+		}
+	}
+
+	result := make([]resolver.Address, 0, len(addresses))
+	for _, a := range addresses {
+		if e := q.entries[a.Addr]; e != nil && now.Before(e.until) {
+			continue
+		}
+
+		result = append(result, a)
+	}
+
+	if len(result) == 0 {
+		return addresses
+	}
+
+	return result
+}
+
+// reportFailure quarantines addr if it is an address of the resolver and
+// passes the remaining addresses to the ClientConn.
+func (c *consulResolver) reportFailure(addr string) {
+	d, ok := c.quarantine.report(addr)
+	if !ok {
+		return
+	}
+
+	c.log.infof("grpc-consul-resolver: address %s of service '%s' was reported as failing, quarantining it for %s",
+		addr, c.service, d)
+	c.emit(&AddressQuarantined{Service: c.service, Addr: addr, Duration: d})
+
+	c.restartQuery()
+	// pass the address to the ClientConn again when the quarantine
+	// expired
+	c.clock.AfterFunc(d, func() {
+		if c.ctx.Err() == nil {
+			c.restartQuery()
+		}
+	})
+}
+
+// ReportAddressFailure reports that connections to addr, in the host:port
+// form of [resolver.Address.Addr], are failing at the transport level.
+// Resolvers created by builders with [WithAddressQuarantine] that resolved
+// to the address stop passing it to their ClientConn until its quarantine
+// expired, e.g. to bridge the time until the Consul health checks of the
+// instance fail.
+func ReportAddressFailure(addr string) {
+	for _, c := range activeResolvers.all() {
+		if c.quarantine != nil {
+			c.reportFailure(addr)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package consul
+
+import (
+	"net/url"
+	"slices"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func addrStrings(addrs []resolver.Address) []string {
+	result := make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		result = append(result, a.Addr)
+	}
+
+	return result
+}
+
+func TestQuarantineDurationGrowsAndDecays(t *testing.T) {
+	clock := newFakeClock()
+	q := newQuarantine(clock, time.Second, 4*time.Second)
+	addrs := []resolver.Address{{Addr: "10.0.0.1:80"}, {Addr: "10.0.0.2:80"}}
+
+	if _, ok := q.report("10.0.0.1:80"); ok {
+		t.Fatal("address that was not published was quarantined")
+	}
+
+	q.filter(addrs)
+
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 4 * time.Second} {
+		d, ok := q.report("10.0.0.1:80")
+		if !ok {
+			t.Fatalf("failure %d was not reported", i)
+		}
+		if d != want {
+			t.Errorf("failure %d quarantined the address for %s, expected %s", i, d, want)
+		}
+	}
+
+	if got := addrStrings(q.filter(addrs)); !slices.Equal(got, []string{"10.0.0.2:80"}) {
+		t.Errorf("filter() returned %v while 10.0.0.1:80 is quarantined", got)
+	}
+
+	clock.Advance(4 * time.Second)
+	if got := addrStrings(q.filter(addrs)); len(got) != 2 {
+		t.Errorf("filter() returned %v after the quarantine expired", got)
+	}
+
+	if d, _ := q.report("10.0.0.1:80"); d != time.Second {
+		t.Errorf("failure after no failures were reported for the max duration quarantined the address for %s, expected 1s", d)
+	}
+}
+
+func TestQuarantinePassesAllAddressesIfAllAreQuarantined(t *testing.T) {
+	q := newQuarantine(newFakeClock(), time.Minute, time.Minute)
+	addrs := []resolver.Address{{Addr: "10.0.0.1:80"}, {Addr: "10.0.0.2:80"}}
+
+	q.filter(addrs)
+	q.report("10.0.0.1:80")
+	q.report("10.0.0.2:80")
+
+	if got := addrStrings(q.filter(addrs)); len(got) != 2 {
+		t.Errorf("filter() returned %v, expected all addresses when all are quarantined", got)
+	}
+}
+
+func TestReportAddressFailureQuarantinesAddress(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "10.0.0.1", Port: 80},
+		{Address: "10.0.0.2", Port: 80},
+	})
+
+	cc := mocks.NewClientConn()
+	target := resolver.Target{URL: url.URL{Path: "/quarantine-test"}}
+	r, err := NewBuilder(WithAddressQuarantine(200*time.Millisecond, time.Second)).Build(target, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err)
+	}
+	defer r.Close()
+
+	waitForAddrs := func(want ...string) {
+		t.Helper()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			got := addrStrings(cc.Addrs())
+			if slices.Equal(got, want) {
+				return
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatalf("resolved to %v, expected %v", got, want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	waitForAddrs("10.0.0.1:80", "10.0.0.2:80")
+
+	ReportAddressFailure("10.0.0.1:80")
+	waitForAddrs("10.0.0.2:80")
+
+	// the address is passed again after the quarantine expired
+	waitForAddrs("10.0.0.1:80", "10.0.0.2:80")
+}
@@ -0,0 +1,94 @@
+package consul
+
+import (
+	"slices"
+)
+
+// Overrides changes the settings of running resolvers.
+// Fields that are unset keep their current value.
+//
+// The struct can be decoded from JSON or YAML.
+type Overrides struct {
+	// Tags replaces the tags that services must have. When it is nil the
+	// tags are not changed, an empty non-nil slice removes all tags.
+	Tags []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	// Health replaces the health filter when it is not
+	// [HealthFilterUndefined].
+	Health HealthFilter `json:"health,omitempty" yaml:"health,omitempty"`
+	// Filter replaces the Consul filter expression when it is not nil.
+	// A pointer to an empty string removes the filter.
+	Filter *string `json:"filter,omitempty" yaml:"filter,omitempty"`
+}
+
+// validate returns an error if o contains invalid values.
+func (o *Overrides) validate() error {
+	if o.Filter != nil {
+		return validateFilter(*o.Filter)
+	}
+
+	return nil
+}
+
+// apply returns a copy of s with the overrides applied.
+func (o *Overrides) apply(s querySettings) querySettings {
+	if o.Tags != nil {
+		s.tags = slices.Clone(o.Tags)
+	}
+
+	if o.Health != HealthFilterUndefined {
+		s.healthFilter = o.Health
+	}
+
+	if o.Filter != nil {
+		s.filter = *o.Filter
+	}
+
+	return s
+}
+
+// Reconfigure applies o to all active resolvers of the Consul service.
+// Running blocking queries of the resolvers are interrupted, the changes take
+// effect immediately.
+// For resolvers with an overrides key, the changes are replaced when the
+// value of the key changes.
+// It returns the number of resolvers that were changed.
+func Reconfigure(service string, o *Overrides) (int, error) {
+	if err := o.validate(); err != nil {
+		return 0, err
+	}
+
+	var cnt int
+	for _, c := range activeResolvers.all() {
+		if c.service != service {
+			continue
+		}
+
+		c.reconfigure(o.apply)
+		cnt++
+	}
+
+	return cnt, nil
+}
+
+// reconfigure replaces the settings of the resolver with the result of
+// update and interrupts the running query.
+func (c *consulResolver) reconfigure(update func(querySettings) querySettings) {
+	c.mu.Lock()
+	c.settings = update(c.settings)
+	c.mu.Unlock()
+
+	c.restartQuery()
+}
+
+// restartQuery interrupts the running query and starts a new one.
+func (c *consulResolver) restartQuery() {
+	c.mu.Lock()
+	if c.cancelQuery != nil {
+		c.cancelQuery()
+	}
+	c.mu.Unlock()
+
+	// trigger a query for resolvers that are not polling because the
+	// last query failed
+	c.triggerQuery()
+}
@@ -0,0 +1,79 @@
+package consul
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestReconfigureChangesFilter(t *testing.T) {
+	cc := mocks.NewClientConn()
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	r, err := NewBuilder().Build(resolver.Target{URL: url.URL{Path: "reconfigure-test"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+	defer r.Close()
+
+	for cc.UpdateStateCallCnt() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	filter := `Service.Meta.version == "2"`
+	cnt, err := Reconfigure("reconfigure-test", &Overrides{Filter: &filter})
+	if err != nil {
+		t.Fatal("Reconfigure() failed:", err)
+	}
+
+	if cnt != 1 {
+		t.Errorf("Reconfigure() changed %d resolvers, expected 1", cnt)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for health.LastQueryOptions().Filter != filter {
+		if time.Now().After(deadline) {
+			t.Fatalf("query filter is %q, expected %q", health.LastQueryOptions().Filter, filter)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReconfigureRejectsInvalidFilter(t *testing.T) {
+	filter := "Service.Meta.version =="
+	_, err := Reconfigure("reconfigure-test", &Overrides{Filter: &filter})
+	if !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("Reconfigure() returned error %v, expected ErrInvalidFilter", err)
+	}
+}
+
+func TestOverridesApply(t *testing.T) {
+	filter := ""
+	s := querySettings{
+		tags:         []string{"primary"},
+		healthFilter: HealthFilterOnlyHealthy,
+		filter:       "Service.Port == 1",
+	}
+
+	s = (&Overrides{Health: HealthFilterFallbackToUnhealthy}).apply(s)
+	if len(s.tags) != 1 || s.healthFilter != HealthFilterFallbackToUnhealthy || s.filter != "Service.Port == 1" {
+		t.Errorf("unexpected settings after changing the health filter: %+v", s)
+	}
+
+	s = (&Overrides{Tags: []string{}, Filter: &filter}).apply(s)
+	if len(s.tags) != 0 || s.filter != "" {
+		t.Errorf("unexpected settings after removing tags and filter: %+v", s)
+	}
+}
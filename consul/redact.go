@@ -0,0 +1,106 @@
+package consul
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// redacted replaces secrets, like ACL tokens and proxy passwords, in log
+// messages and errors.
+const redacted = "[redacted]"
+
+// redactedError is an error whose message does not contain secrets. It
+// unwraps to the original error, to keep it inspectable with [errors.Is] and
+// [errors.As].
+type redactedError struct {
+	msg string
+	err error
+}
+
+func (e *redactedError) Error() string {
+	return e.msg
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
+
+// redactError returns err with all secrets replaced in its message.
+// It returns err unchanged if the message does not contain any of them.
+func redactError(err error, secrets []string) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := err.Error()
+	redactedMsg := redactString(msg, secrets)
+	if redactedMsg == msg {
+		return err
+	}
+
+	return &redactedError{msg: redactedMsg, err: err}
+}
+
+func redactString(s string, secrets []string) string {
+	for _, secret := range secrets {
+		if secret != "" {
+			s = strings.ReplaceAll(s, secret, redacted)
+		}
+	}
+
+	return s
+}
+
+// secrets returns the values of t that must not appear in logs and errors,
+// in their plain and URL-encoded form.
+func (t *Target) secrets() []string {
+	var result []string
+
+	add := func(s string) {
+		if s == "" {
+			return
+		}
+
+		result = append(result, s)
+		if escaped := url.QueryEscape(s); escaped != s {
+			result = append(result, escaped)
+		}
+	}
+
+	add(t.Token)
+	if u, err := url.Parse(t.Proxy); err == nil && u.User != nil {
+		if password, ok := u.User.Password(); ok {
+			add(password)
+		}
+	}
+
+	return result
+}
+
+// redactURLError removes the query of the URL that failed to parse from
+// err, it can contain the token.
+func redactURLError(err error) error {
+	var ue *url.Error
+	if !errors.As(err, &ue) {
+		return err
+	}
+
+	target, query, found := strings.Cut(ue.URL, "?")
+	if !found || query == "" {
+		return err
+	}
+
+	return &url.Error{Op: ue.Op, URL: target + "?" + redacted, Err: ue.Err}
+}
+
+// redactProxyURL returns proxy with its password replaced, proxy is returned
+// unchanged if it can not be parsed.
+func redactProxyURL(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil || u.User == nil {
+		return proxy
+	}
+
+	return u.Redacted()
+}
@@ -0,0 +1,315 @@
+package consul
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// registry contains all resolvers that were built and not closed yet.
+type registry struct {
+	mu        sync.Mutex
+	resolvers map[*consulResolver]struct{}
+}
+
+var activeResolvers = registry{resolvers: map[*consulResolver]struct{}{}}
+
+func (r *registry) add(c *consulResolver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.resolvers[c] = struct{}{}
+}
+
+func (r *registry) remove(c *consulResolver) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.resolvers, c)
+}
+
+func (r *registry) all() []*consulResolver {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := make([]*consulResolver, 0, len(r.resolvers))
+	for c := range r.resolvers {
+		result = append(result, c)
+	}
+
+	return result
+}
+
+// resolverStatus contains the result of the last queries of a resolver.
+type resolverStatus struct {
+	clock Clock
+
+	mu          sync.Mutex
+	addresses   int
+	lastSuccess time.Time
+	lastUpdate  time.Time
+	lastErr     error
+	// queryStarted is the time when the running query was started, it
+	// is zero while no query is running.
+	queryStarted time.Time
+	// lastFailure and lastFailureErr are not reset by successful
+	// queries.
+	lastFailure    time.Time
+	lastFailureErr error
+	// recentChanges contains the times of the updates with changed
+	// addresses in the last churnWindow.
+	recentChanges    []time.Time
+	addressesAdded   uint64
+	addressesRemoved uint64
+	trace            traceRing
+	// balancerRejections is the number of updates the gRPC channel
+	// returned an error for, lastRejection is the last one of them.
+	balancerRejections uint64
+	lastRejection      *BalancerRejection
+}
+
+// churnWindow is the duration over which updates are counted for
+// [ResolverHealth.UpdatesLastMinute].
+const churnWindow = time.Minute
+
+func (s *resolverStatus) queryStarting() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queryStarted = s.clock.Now()
+}
+
+func (s *resolverStatus) queryReturned() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queryStarted = time.Time{}
+}
+
+func (s *resolverStatus) querySucceeded(addresses int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addresses = addresses
+	s.lastSuccess = s.clock.Now()
+	s.lastErr = nil
+}
+
+func (s *resolverStatus) queryFailed(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastErr = err
+	s.lastFailure = s.clock.Now()
+	s.lastFailureErr = err
+}
+
+func (s *resolverStatus) stateUpdated(changed bool, added, removed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastUpdate = s.clock.Now()
+	s.addressesAdded += uint64(added)
+	s.addressesRemoved += uint64(removed)
+
+	s.pruneChangesLocked()
+	if changed {
+		s.recentChanges = append(s.recentChanges, s.lastUpdate)
+	}
+}
+
+func (s *resolverStatus) balancerRejected(addresses []resolver.Address, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	addrs := make([]string, 0, len(addresses))
+	for _, a := range addresses {
+		addrs = append(addrs, a.Addr)
+	}
+
+	s.balancerRejections++
+	s.lastRejection = &BalancerRejection{
+		Time:      s.clock.Now(),
+		Err:       err,
+		Addresses: addrs,
+	}
+}
+
+// BalancerRejection describes an update of addresses that the gRPC channel
+// returned an error for, usually because the load balancer can not use the
+// addresses.
+type BalancerRejection struct {
+	// Time is when the update was rejected.
+	Time time.Time
+	// Err is the error returned by [resolver.ClientConn.UpdateState].
+	Err error
+	// Addresses are the addresses that were passed to the channel.
+	Addresses []string
+}
+
+// pruneChangesLocked removes the times from recentChanges that are older
+// than churnWindow.
+func (s *resolverStatus) pruneChangesLocked() {
+	cutoff := s.clock.Now().Add(-churnWindow)
+
+	i := 0
+	for i < len(s.recentChanges) && !s.recentChanges[i].After(cutoff) {
+		i++
+	}
+
+	s.recentChanges = s.recentChanges[i:]
+}
+
+// ResolverHealth describes the health and state of an active resolver.
+type ResolverHealth struct {
+	// Target is the target URL of the resolver without the token.
+	Target string
+	// Service is the name of the resolved Consul service.
+	Service string
+	// Healthy is true if the last query succeeded, the service resolved
+	// to at least one address and the running query, if any, was not
+	// started longer than twice the blocking query wait time ago.
+	// Resolvers waiting for their next query, e.g. in the queue of
+	// [WithMultiplexedWatches], stay healthy.
+	// Resolvers that are pending are reported as healthy.
+	Healthy bool
+	// Pending is true for resolvers created with [WithLazyStart] that
+	// were not started yet.
+	Pending bool
+	// Addresses is the number of addresses the service resolved to in
+	// the last successful query.
+	Addresses int
+	// LastSuccess is the time when the last query succeeded, it is zero
+	// if no query succeeded yet.
+	LastSuccess time.Time
+	// LastUpdate is the time when addresses were passed to the gRPC
+	// channel the last time, it is zero if it did not happen yet.
+	LastUpdate time.Time
+	// Err is the error of the last query, nil if it succeeded.
+	Err error
+	// LastError is the error of the last failed query, it is also set
+	// when later queries succeeded.
+	LastError error
+	// LastErrorTime is the time when the last query failed, it is zero
+	// if no query failed yet.
+	LastErrorTime time.Time
+	// UpdatesLastMinute is the number of updates with changed addresses
+	// that were passed to the gRPC channel in the last minute. A high
+	// number indicates flapping registrations.
+	UpdatesLastMinute int
+	// AddressesAdded and AddressesRemoved are the total number of
+	// addresses that were added to and removed from the gRPC channel.
+	AddressesAdded   uint64
+	AddressesRemoved uint64
+	// WaitTime is the maximum duration the blocking queries of the
+	// resolver wait for changes.
+	WaitTime time.Duration
+	// Trace contains the last 64 significant events of the resolver,
+	// like failed queries, address updates and restarts of the blocking
+	// query, ordered from the oldest to the newest.
+	Trace []TraceEvent
+	// BalancerRejections is the number of address updates the gRPC
+	// channel returned an error for.
+	BalancerRejections uint64
+	// LastBalancerRejection is the last address update the gRPC channel
+	// returned an error for, nil if it did not happen yet.
+	LastBalancerRejection *BalancerRejection
+}
+
+func (c *consulResolver) health() ResolverHealth {
+	pending := c.pending()
+
+	c.status.mu.Lock()
+	defer c.status.mu.Unlock()
+
+	c.status.pruneChangesLocked()
+
+	return ResolverHealth{
+		Target:  c.redactedTarget,
+		Service: c.service,
+		Healthy: pending || c.status.lastErr == nil &&
+			c.status.addresses > 0 &&
+			(c.status.queryStarted.IsZero() || c.clock.Now().Sub(c.status.queryStarted) < 2*c.waitTime),
+		Pending:       pending,
+		Addresses:     c.status.addresses,
+		LastSuccess:   c.status.lastSuccess,
+		LastUpdate:    c.status.lastUpdate,
+		Err:           c.status.lastErr,
+		LastError:     c.status.lastFailureErr,
+		LastErrorTime: c.status.lastFailure,
+
+		UpdatesLastMinute: len(c.status.recentChanges),
+		AddressesAdded:    c.status.addressesAdded,
+		AddressesRemoved:  c.status.addressesRemoved,
+		WaitTime:          c.waitTime,
+		Trace:             c.status.trace.all(),
+
+		BalancerRejections:    c.status.balancerRejections,
+		LastBalancerRejection: c.status.lastRejection,
+	}
+}
+
+// ResolversHealth returns the health of all resolvers that were built and
+// not closed yet, ordered by their targets.
+// It can be used to include the state of the service discovery in readiness
+// probes or to dump it for debugging.
+func ResolversHealth() []ResolverHealth {
+	resolvers := activeResolvers.all()
+
+	result := make([]ResolverHealth, 0, len(resolvers))
+	for _, r := range resolvers {
+		result = append(result, r.health())
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Target < result[j].Target
+	})
+
+	return result
+}
+
+// AllResolversHealthy returns true if all resolvers returned by
+// [ResolversHealth] are healthy.
+func AllResolversHealthy() bool {
+	for _, h := range ResolversHealth() {
+		if !h.Healthy {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Shutdown closes all active resolvers and waits until their goroutines
+// terminated. If ctx is done before, its error is returned and the resolvers
+// are closed in the background.
+// The gRPC channels of the resolvers do not receive address updates
+// anymore, Shutdown should only be called when the process terminates.
+func Shutdown(ctx context.Context) error {
+	done := make(chan struct{})
+
+	go func() {
+		var wg sync.WaitGroup
+
+		for _, c := range activeResolvers.all() {
+			wg.Add(1)
+			go func(c *consulResolver) {
+				defer wg.Done()
+				c.Close()
+			}(c)
+		}
+
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
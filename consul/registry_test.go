@@ -0,0 +1,178 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func findResolverHealth(service string) (ResolverHealth, bool) {
+	for _, h := range ResolversHealth() {
+		if h.Service == service {
+			return h, true
+		}
+	}
+
+	return ResolverHealth{}, false
+}
+
+func TestResolversHealth(t *testing.T) {
+	cc := mocks.NewClientConn()
+	newAddressCallCnt := cc.UpdateStateCallCnt()
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespEntries([]*consul.ServiceEntry{
+		{
+			Service: &consul.AgentService{
+				Address: "127.0.0.1",
+				Port:    1,
+			},
+		},
+	})
+
+	target := resolver.Target{URL: url.URL{Path: "health-test", RawQuery: "token=secret"}}
+	r, err := NewBuilder().Build(target, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+
+	for newAddressCallCnt == cc.UpdateStateCallCnt() {
+		time.Sleep(time.Millisecond)
+	}
+
+	h, ok := findResolverHealth("health-test")
+	if !ok {
+		t.Fatal("resolver is missing in ResolversHealth()")
+	}
+
+	if !h.Healthy || h.Addresses != 1 || h.LastSuccess.IsZero() || h.LastUpdate.IsZero() {
+		t.Errorf("resolver health is %+v, expected healthy with 1 address", h)
+	}
+
+	if h.Target != "consul:///health-test?health=healthy" {
+		t.Errorf("resolver health target is %q, expected consul:///health-test?health=healthy", h.Target)
+	}
+
+	queryErr := errors.New("query failed")
+	health.SetRespError(queryErr)
+	r.ResolveNow(resolver.ResolveNowOptions{})
+
+	for cc.LastReportedError() == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	h, _ = findResolverHealth("health-test")
+	if h.Healthy || !errors.Is(h.Err, queryErr) || !errors.Is(h.LastError, queryErr) || h.LastErrorTime.IsZero() {
+		t.Errorf("resolver health is %+v, expected unhealthy with error %v", h, queryErr)
+	}
+
+	health.SetRespError(nil)
+	r.ResolveNow(resolver.ResolveNowOptions{})
+
+	for {
+		h, _ = findResolverHealth("health-test")
+		if h.Err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if !errors.Is(h.LastError, queryErr) {
+		t.Errorf("resolver health is %+v, expected the last error to be kept after a successful query", h)
+	}
+
+	r.Close()
+
+	if _, ok := findResolverHealth("health-test"); ok {
+		t.Error("closed resolver is returned by ResolversHealth()")
+	}
+}
+
+func TestHealthFreshnessDependsOnRunningQuery(t *testing.T) {
+	clock := newFakeClock()
+	c := consulResolver{clock: clock, waitTime: time.Minute, status: resolverStatus{clock: clock}}
+
+	c.status.queryStarting()
+	c.status.queryReturned()
+	c.status.querySucceeded(1)
+
+	clock.Advance(time.Hour)
+	if h := c.health(); !h.Healthy {
+		t.Errorf("resolver health is %+v, expected a resolver waiting for its next query to be healthy", h)
+	}
+
+	c.status.queryStarting()
+	clock.Advance(time.Minute)
+	if h := c.health(); !h.Healthy {
+		t.Errorf("resolver health is %+v, expected a running blocking query to be healthy", h)
+	}
+
+	clock.Advance(time.Minute)
+	if h := c.health(); h.Healthy {
+		t.Errorf("resolver health is %+v, expected a query running for twice the wait time to be unhealthy", h)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	b := NewBuilder(WithMultiplexedWatches(1, time.Second))
+	for _, service := range []string{"shutdown-a", "shutdown-b"} {
+		cc := mocks.NewClientConn()
+		r, err := b.Build(resolver.Target{URL: url.URL{Path: service}}, cc, resolver.BuildOptions{})
+		if err != nil {
+			t.Fatal("Build() failed:", err.Error())
+		}
+		defer r.Close()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := Shutdown(ctx); err != nil {
+		t.Fatal("Shutdown() failed:", err)
+	}
+
+	if h := ResolversHealth(); len(h) != 0 {
+		t.Errorf("ResolversHealth() returned %+v after Shutdown(), expected no active resolvers", h)
+	}
+}
+
+func TestResolverHealthChurn(t *testing.T) {
+	clock := newFakeClock()
+	c := consulResolver{clock: clock, status: resolverStatus{clock: clock}}
+
+	c.status.stateUpdated(true, 2, 0)
+	clock.Advance(30 * time.Second)
+	c.status.stateUpdated(true, 1, 1)
+	c.status.stateUpdated(false, 0, 0)
+
+	h := c.health()
+	if h.UpdatesLastMinute != 2 || h.AddressesAdded != 3 || h.AddressesRemoved != 1 {
+		t.Errorf("resolver health is %+v, expected 2 updates in the last minute, 3 added and 1 removed addresses", h)
+	}
+
+	clock.Advance(31 * time.Second)
+	if h := c.health(); h.UpdatesLastMinute != 1 {
+		t.Errorf("resolver health reports %d updates in the last minute, expected 1", h.UpdatesLastMinute)
+	}
+}
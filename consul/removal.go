@@ -0,0 +1,92 @@
+package consul
+
+import (
+	"fmt"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+)
+
+// RemovalReason describes why an address is removed from the gRPC channel.
+type RemovalReason int
+
+const (
+	// RemovalDeregistered is the reason for addresses of instances that
+	// are not registered in Consul anymore, or whose registration does
+	// not match the tags of the target anymore.
+	RemovalDeregistered RemovalReason = iota
+	// RemovalUnhealthy is the reason for addresses of instances whose
+	// health checks are not passing.
+	RemovalUnhealthy
+	// RemovalFiltered is the reason for addresses of registered and
+	// healthy instances that are excluded by other options, e.g.
+	// because they are draining, do not match the version constraint or
+	// filter expression, or are quarantined.
+	RemovalFiltered
+)
+
+func (r RemovalReason) String() string {
+	switch r {
+	case RemovalDeregistered:
+		return "deregistered"
+	case RemovalUnhealthy:
+		return "unhealthy"
+	case RemovalFiltered:
+		return "filtered"
+	default:
+		return fmt.Sprintf("RemovalReason(%d)", int(r))
+	}
+}
+
+// RemovedAddress is an address that is removed from the gRPC channel.
+type RemovedAddress struct {
+	Address resolver.Address
+	Reason  RemovalReason
+}
+
+// RemovalFunc is called with the addresses of service that are removed from
+// the gRPC channel, before the channel receives the update.
+type RemovalFunc func(service string, removed []RemovedAddress)
+
+// instanceHealth maps the service IDs of the instances returned by Consul
+// to true if their checks are passing.
+func instanceHealth(entries []*consul.ServiceEntry) map[string]bool {
+	result := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		result[e.Service.ID] = e.Checks.AggregatedStatus() == consul.HealthPassing
+	}
+
+	return result
+}
+
+// removedAddresses returns the addresses in old whose key is not in updated,
+// with the reason of their removal. health is the result of
+// [instanceHealth] for the last query.
+// Addresses without a service ID are reported as deregistered.
+func removedAddresses(old, updated []resolver.Address, key addressKey, health map[string]bool) []RemovedAddress {
+	keep := make(map[string]struct{}, len(updated))
+	for _, a := range updated {
+		keep[key(a)] = struct{}{}
+	}
+
+	var result []RemovedAddress
+	for _, a := range old {
+		if _, exists := keep[key(a)]; exists {
+			continue
+		}
+
+		reason := RemovalDeregistered
+		if id, ok := ServiceID(a); ok {
+			if passing, registered := health[id]; registered {
+				reason = RemovalFiltered
+				if !passing {
+					reason = RemovalUnhealthy
+				}
+			}
+		}
+
+		result = append(result, RemovedAddress{Address: a, Reason: reason})
+	}
+
+	return result
+}
@@ -0,0 +1,96 @@
+package consul
+
+import (
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestRemovalFuncReportsReasons(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	entry := func(id, addr, status string, meta map[string]string) *consul.ServiceEntry {
+		return &consul.ServiceEntry{
+			Service: &consul.AgentService{ID: id, Address: addr, Port: 1, Meta: meta},
+			Checks:  consul.HealthChecks{{Name: "grpc", Status: status}},
+		}
+	}
+
+	health.SetRespEntries([]*consul.ServiceEntry{
+		entry("a", "10.0.0.1", consul.HealthPassing, nil),
+		entry("b", "10.0.0.2", consul.HealthPassing, nil),
+		entry("c", "10.0.0.3", consul.HealthPassing, nil),
+		entry("d", "10.0.0.4", consul.HealthPassing, nil),
+	})
+	health.SetRespIndex(1)
+
+	target, err := ParseTarget("consul:///removal-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int
+	got := map[string]RemovalReason{}
+	opts := builderOptions{
+		clock: newFakeClock(),
+		removalFunc: func(service string, removed []RemovedAddress) {
+			calls++
+			if service != "removal-test" {
+				t.Errorf("removal func was called for service %q, expected removal-test", service)
+			}
+			for _, r := range removed {
+				got[r.Address.Addr] = r.Reason
+			}
+		},
+	}
+
+	cc := mocks.NewClientConn()
+	r, err := newConsulResolver(cc, target, &opts)
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	r.poll()
+	if calls != 0 {
+		t.Errorf("removal func was called %d times for the first update, expected 0", calls)
+	}
+
+	health.SetRespEntries([]*consul.ServiceEntry{
+		entry("b", "10.0.0.2", consul.HealthCritical, nil),
+		entry("c", "10.0.0.3", consul.HealthPassing, map[string]string{DrainingMetaKey: "true"}),
+		entry("d", "10.0.0.4", consul.HealthPassing, nil),
+	})
+	health.SetRespIndex(2)
+	r.poll()
+
+	if calls != 1 {
+		t.Fatalf("removal func was called %d times, expected 1", calls)
+	}
+
+	want := map[string]RemovalReason{
+		"10.0.0.1:1": RemovalDeregistered,
+		"10.0.0.2:1": RemovalUnhealthy,
+		"10.0.0.3:1": RemovalFiltered,
+	}
+	if len(got) != len(want) {
+		t.Errorf("removed addresses are %v, expected %v", got, want)
+	}
+	for addr, reason := range want {
+		if got[addr] != reason {
+			t.Errorf("removal reason of %s is %s, expected %s", addr, got[addr], reason)
+		}
+	}
+
+	if addrs := addrStrings(cc.Addrs()); len(addrs) != 1 || addrs[0] != "10.0.0.4:1" {
+		t.Errorf("resolved to %v, expected only the healthy instance 10.0.0.4:1", addrs)
+	}
+}
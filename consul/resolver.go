@@ -5,24 +5,99 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/hashicorp/consul/api"
 	consul "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-cleanhttp"
 	"google.golang.org/grpc/grpclog"
 	"google.golang.org/grpc/resolver"
 )
 
-type healthFilter int
+// HealthFilter defines which instances of a service are resolved depending
+// on their health status.
+type HealthFilter int
 
 const (
-	healthFilterUndefined healthFilter = iota
-	healthFilterOnlyHealthy
-	healthFilterFallbackToUnhealthy
+	// HealthFilterUndefined selects the default filter,
+	// HealthFilterOnlyHealthy.
+	HealthFilterUndefined HealthFilter = iota
+	// HealthFilterOnlyHealthy resolves only to instances with passing
+	// health checks.
+	HealthFilterOnlyHealthy
+	// HealthFilterFallbackToUnhealthy resolves to all instances if none
+	// with passing health checks are available.
+	HealthFilterFallbackToUnhealthy
 )
 
+// String returns the value of the health target option that selects the
+// filter.
+func (h HealthFilter) String() string {
+	switch h {
+	case HealthFilterOnlyHealthy:
+		return "healthy"
+	case HealthFilterFallbackToUnhealthy:
+		return "fallbackToUnhealthy"
+	default:
+		return ""
+	}
+}
+
+// MarshalText returns the value of the health target option that selects
+// the filter.
+func (h HealthFilter) MarshalText() ([]byte, error) {
+	return []byte(h.String()), nil
+}
+
+// UnmarshalText sets the filter from the value of a health target option.
+func (h *HealthFilter) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*h = HealthFilterUndefined
+		return nil
+	}
+
+	health, err := parseHealthFilter(string(text))
+	if err != nil {
+		return err
+	}
+
+	*h = health
+
+	return nil
+}
+
+func parseHealthFilter(value string) (HealthFilter, error) {
+	switch strings.ToLower(value) {
+	case "healthy":
+		return HealthFilterOnlyHealthy, nil
+	case "fallbacktounhealthy":
+		return HealthFilterFallbackToUnhealthy, nil
+	default:
+		return HealthFilterUndefined, fmt.Errorf("%w: '%s'", ErrInvalidHealthFilter, value)
+	}
+}
+
+// defaultWaitTime is the maximum duration a blocking query waits for
+// changes.
+const defaultWaitTime = 10 * time.Minute
+
+// minAdaptiveWaitTime is the wait time of blocking queries after a query
+// failed. It is doubled with every successful query until the wait time of
+// the resolver is reached again.
+const minAdaptiveWaitTime = 30 * time.Second
+
+// segmentNodeMetaKey is the node meta key that Consul Enterprise sets to the
+// name of the network segment a node is a member of.
+const segmentNodeMetaKey = "consul-network-segment"
+
 type consulResolver struct {
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -30,18 +105,163 @@ type consulResolver struct {
 	wgStop     sync.WaitGroup
 	resolveNow chan struct{}
 
-	tags         []string
-	service      string
-	healthFilter healthFilter
+	service           string
+	versionConstraint *semver.Constraints
+	portName          string
+	preferNodeAddr    bool
+	requireSvcAddr    bool
+	portZero          PortZeroPolicy
+	localNodePolicy   LocalNodePolicy
+	lanAddrs          bool
+	kind              KindFilter
+	dualStack         bool
+	priorityTags      []string
+	preserveOrder     bool
+	checkTypes        []string
+	strict            bool
+	agent             consulAgentEndpoint
+	addressKey        addressKey
+	checkStatuses     bool
+	serviceEntries    bool
+	agentless         bool
+	removalFunc       RemovalFunc
+	updateJitter      time.Duration
+	// minHealthyFraction is 0 if the min-healthy-fraction option is
+	// not set.
+	minHealthyFraction float64
+	// warmUp is nil if the slow-start option is not set.
+	warmUp *warmUp
+	// tolerance is nil if the failure-tolerance option is not set.
+	tolerance *failureTolerance
+	// datacenterAttribute is true for the resolvers of targets with the
+	// failover-dcs option.
+	datacenterAttribute bool
+	// config is the static part of the effective configuration.
+	config ResolverConfig
+
+	// mu protects settings and cancelQuery.
+	mu       sync.Mutex
+	settings querySettings
+	// cancelQuery cancels the running query.
+	cancelQuery context.CancelFunc
+
+	// baseSettings are the settings from the target, overrides from
+	// the overridesKey are applied to them.
+	baseSettings querySettings
+	consulKV     consulKVEndpoint
+	overridesKey string
+
+	// gateCheck is the ID of the checks that must pass before addresses
+	// are passed to the ClientConn, gateOpen is true while they pass.
+	gateCheck    string
+	consulChecks consulChecksEndpoint
+	gateOpen     atomic.Bool
+
+	// maxInstances is 0 if the number of instances is not limited.
+	maxInstances       int
+	maxInstancesPolicy MaxInstancesPolicy
 
-	clientConn   resolver.ClientConn
-	consulHealth consulHealthEndpoint
+	// selfCheckInterval, selfCheckOpts and consulCatalog are set if the
+	// catalog self-check is enabled.
+	selfCheckInterval time.Duration
+	selfCheckOpts     consul.QueryOptions
+	consulCatalog     consulCatalogEndpoint
+
+	consulStatus    consulStatusEndpoint
+	transport       *http.Transport
+	monitorInterval time.Duration
+
+	// lazy defers starting the watcher until startWatching is called.
+	lazy bool
+	// dialTarget is the target URL of the gRPC channel, it is used to
+	// find the resolvers of a channel in the lazy start interceptors.
+	dialTarget string
+	// startMu protects started and closed.
+	startMu sync.Mutex
+	started bool
+	closed  bool
+
+	clientConn resolver.ClientConn
+	backend    DiscoveryBackend
+
+	stats StatsHandler
+	mux   *multiplexer
+
+	redactedTarget    string
+	secrets           []string
+	waitTime          time.Duration
+	republishInterval time.Duration
+	updateGate        func(old, updated resolver.State) bool
+	status            resolverStatus
+	clock             Clock
+	readyFunc         func(service string)
+	log               *logSampler
+	stuckThreshold    time.Duration
+	stuckRefresh      bool
+	enricher          *enricher
+	// resolveNowThrottle is nil if ResolveNow calls are not throttled.
+	resolveNowThrottle *resolveNowThrottle
+	// quarantine is nil if addresses are not quarantined.
+	quarantine *quarantine
+	// tokens is nil if the resolver does not use a TokenSource.
+	tokens *tokenRenewer
+	// confirmed is the time when the last query succeeded, it is
+	// attached to all addresses. It is nil if the confirmation attribute
+	// is disabled.
+	confirmed *confirmation
+	// maxAddressAge is the duration after which unconfirmed addresses
+	// are refreshed, refreshPending is true if the next query must
+	// not block.
+	maxAddressAge  time.Duration
+	refreshPending atomic.Bool
+
+	// queryOpts, lastReportedAddresses, lastReportTime, ready and
+	// indexChangedAt are only accessed by the goroutine that runs poll().
+	queryOpts             *consul.QueryOptions
+	lastReportedAddresses []resolver.Address
+	lastReportTime        time.Time
+	ready                 bool
+	// tokenRetried is true if the last query was retried because
+	// Consul rejected the token.
+	tokenRetried bool
+	// retryBudget is nil if failing queries are retried without limit.
+	retryBudget *retryBudget
+	// warmUpWait is the duration until the weight of a warming up
+	// instance is increased the next time, 0 if none warms up.
+	warmUpWait time.Duration
+	// toleranceWait is the duration until the failure tolerance of an
+	// instance expires, 0 if none is tolerated.
+	toleranceWait time.Duration
+	// localNodeName is the cached name of the node of the Consul agent.
+	localNodeName string
+	// instanceHealth is the result of [instanceHealth] for the last
+	// query, it is only set when removalFunc is set.
+	instanceHealth map[string]bool
+	// indexChangedAt is the time when the index returned by Consul
+	// changed the last time.
+	indexChangedAt time.Time
+}
+
+// querySettings are the settings of a resolver that can be changed while it
+// is running.
+type querySettings struct {
+	tags         []string
+	healthFilter HealthFilter
+	filter       string
 }
 
 type consulHealthEndpoint interface {
 	ServiceMultipleTags(service string, tags []string, passingOnly bool, q *consul.QueryOptions) ([]*consul.ServiceEntry, *consul.QueryMeta, error)
 }
 
+type consulStatusEndpoint interface {
+	LeaderWithQueryOptions(q *consul.QueryOptions) (string, error)
+}
+
+type consulKVEndpoint interface {
+	Get(key string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error)
+}
+
 // consulCreateHealthClientFn can be overwritten in tests to make
 // newConsulResolver() return a different consulHealthEndpoint implementation
 var consulCreateHealthClientFn = func(cfg *consul.Config) (consulHealthEndpoint, error) {
@@ -53,53 +273,461 @@ var consulCreateHealthClientFn = func(cfg *consul.Config) (consulHealthEndpoint,
 	return clt.Health(), nil
 }
 
-func newConsulResolver(
-	cc resolver.ClientConn,
-	scheme, consulAddr, consulService string,
-	tags []string,
-	healthFilter healthFilter,
-	token string,
-	dc string,
-) (*consulResolver, error) {
+// consulCreateKVClientFn can be overwritten in tests to make
+// newConsulResolver() return a different consulKVEndpoint implementation
+var consulCreateKVClientFn = func(cfg *consul.Config) (consulKVEndpoint, error) {
+	clt, err := consul.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return clt.KV(), nil
+}
+
+// consulCreateStatusClientFn can be overwritten in tests to make
+// newConsulResolver() return a different consulStatusEndpoint implementation
+var consulCreateStatusClientFn = func(cfg *consul.Config) (consulStatusEndpoint, error) {
+	clt, err := consul.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return clt.Status(), nil
+}
+
+func newConsulResolver(cc resolver.ClientConn, target *Target, opts *builderOptions) (*consulResolver, error) {
+	if opts.agentless {
+		if err := target.validateAgentless(opts.servers); err != nil {
+			return nil, err
+		}
+	}
+
+	token := target.Token
+	if token == "" && opts.tokenFunc != nil {
+		token = opts.tokenFunc(target.Service)
+	}
+
 	cfg := consul.Config{
 		Token:   token,
-		Scheme:  scheme,
-		Address: consulAddr,
+		Scheme:  target.Scheme,
+		Address: target.ConsulAddr,
 
-		Datacenter: dc,
+		Datacenter: target.DC,
 
-		WaitTime: 10 * time.Minute,
+		WaitTime: defaultWaitTime,
+
+		TLSConfig: consul.TLSConfig{
+			Address:  target.TLS.ServerName,
+			CAFile:   target.TLS.CAFile,
+			CertFile: target.TLS.CertFile,
+			KeyFile:  target.TLS.KeyFile,
+
+			InsecureSkipVerify: target.TLS.InsecureSkipVerify,
+		},
 	}
 
-	health, err := consulCreateHealthClientFn(&cfg)
+	transportTLS, err := newTransportTLS(&target.TLS)
 	if err != nil {
-		return nil, fmt.Errorf("creating consul client failed. %v", err)
+		return nil, err
+	}
+
+	timeouts := target.Timeouts.withDefaults(opts.timeouts)
+
+	if transportTLS != nil || target.ConsulSRV || target.Proxy != "" || opts.monitorInterval > 0 ||
+		!timeouts.isZero() || !opts.pool.isZero() || len(opts.servers) != 0 {
+		// The consul client only sets up the TLS configuration
+		// of the transport when it is created by it. Passing our
+		// own transport allows to extend the TLS configuration
+		// afterwards, while the TLS settings from environment
+		// variables are still honoured.
+		cfg.Transport = cleanhttp.DefaultPooledTransport()
+	}
+
+	if target.Proxy != "" {
+		proxy, err := parseProxyURL(target.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	var srv *srvDialer
+	if target.ConsulSRV {
+		srv = newSRVDialer(target.ConsulAddr)
+		cfg.Transport.DialContext = srv.DialContext
+	} else if len(opts.servers) != 0 {
+		srv = newServersDialer(opts.servers)
+		cfg.Transport.DialContext = srv.DialContext
+	}
+
+	createHealthClient, createStatusClient := consulCreateHealthClientFn, consulCreateStatusClientFn
+	if opts.nomad {
+		createHealthClient = func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return newNomadClient(cfg)
+		}
+		createStatusClient = func(cfg *consul.Config) (consulStatusEndpoint, error) {
+			return newNomadClient(cfg)
+		}
+	}
+
+	config := newResolverConfig(&cfg, target, opts, target.Token == "" && token != "")
+
+	var backend DiscoveryBackend
+	if opts.backendFunc != nil {
+		backend, err = opts.backendFunc(target)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		health, err := createHealthClient(&cfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating consul client failed. %v", redactError(err, target.secrets()))
+		}
+
+		if n, ok := health.(*nomadClient); ok {
+			config.applyNomad(n, target)
+		}
+
+		if opts.sharedHealth != nil {
+			health = &sharedHealthEndpoint{
+				shared: opts.sharedHealth,
+				health: health,
+				endpoint: fmt.Sprintf("%s|%s|%s|%t|%s|%v",
+					cfg.Scheme, cfg.Address, cfg.Datacenter, target.ConsulSRV, target.Proxy, opts.servers),
+			}
+		}
+
+		backend = &healthBackend{health: health}
+	}
+
+	var status consulStatusEndpoint
+	if opts.monitorInterval > 0 {
+		status, err = createStatusClient(&cfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating consul client failed. %v", redactError(err, target.secrets()))
+		}
+	}
+
+	var kv consulKVEndpoint
+	if target.OverridesKey != "" {
+		kv, err = consulCreateKVClientFn(&cfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating consul client failed. %v", redactError(err, target.secrets()))
+		}
+	}
+
+	var checks consulChecksEndpoint
+	if target.GateCheck != "" {
+		checks, err = consulCreateChecksClientFn(&cfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating consul client failed. %v", redactError(err, target.secrets()))
+		}
+	}
+
+	var agent consulAgentEndpoint
+	if target.LocalNode != LocalNodeUndefined {
+		agent, err = consulCreateAgentClientFn(&cfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating consul client failed. %v", redactError(err, target.secrets()))
+		}
+	}
+
+	var catalog consulCatalogEndpoint
+	if opts.selfCheckInterval > 0 && !opts.nomad {
+		catalog, err = consulCreateCatalogClientFn(&cfg)
+		if err != nil {
+			return nil, fmt.Errorf("creating consul client failed. %v", redactError(err, target.secrets()))
+		}
+	}
+
+	if transportTLS != nil {
+		if err := transportTLS.apply(cfg.Transport); err != nil {
+			return nil, err
+		}
+	}
+
+	var nodeMeta map[string]string
+	if target.Segment != "" {
+		nodeMeta = map[string]string{segmentNodeMetaKey: target.Segment}
+	}
+
+	waitTime := defaultWaitTime
+	if target.WaitTime > 0 {
+		waitTime = target.WaitTime
+	} else if opts.waitTime > 0 {
+		waitTime = opts.waitTime
+	}
+	if opts.multiplexer != nil {
+		waitTime = min(waitTime, opts.multiplexer.waitTime)
+	}
+	if opts.republishInterval > 0 {
+		waitTime = min(waitTime, opts.republishInterval)
+	}
+
+	if cfg.Transport != nil {
+		dialer := newTransportDialer(cfg.Transport, srv)
+		timeouts.apply(cfg.Transport, dialer, waitTime)
+		opts.pool.apply(cfg.Transport, dialer)
+	}
+
+	settings := querySettings{
+		tags:         target.Tags,
+		healthFilter: target.Health,
+		filter:       target.Filter,
+	}
+
+	var queryOpts consul.QueryOptions
+	if opts.queryDefaults != nil {
+		queryOpts = *opts.queryDefaults
+		if settings.filter == "" {
+			if err := validateFilter(queryOpts.Filter); err != nil {
+				return nil, err
+			}
+			settings.filter = queryOpts.Filter
+		}
+	}
+	queryOpts.Namespace = target.Namespace
+	queryOpts.Partition = target.Partition
+	queryOpts.NodeMeta = nodeMeta
+	queryOpts.WaitTime = waitTime
+	if opts.agentless && !queryOpts.RequireConsistent {
+		queryOpts.AllowStale = true
+	}
+	config.AllowStale = queryOpts.AllowStale
+	config.RequireConsistent = queryOpts.RequireConsistent
+
+	var versionConstraint *semver.Constraints
+	if target.Version != "" {
+		versionConstraint, err = semver.NewConstraint(target.Version)
+		if err != nil {
+			return nil, fmt.Errorf("%w '%s': %w", ErrInvalidVersionConstraint, target.Version, err)
+		}
+	}
+
+	clock := opts.clock
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	key := addrKey
+	if opts.sortByServiceID {
+		key = serviceIDKey
+	}
+
+	redactedTarget := target.redactedString()
+	if opts.nomad {
+		redactedTarget = nomadScheme + strings.TrimPrefix(redactedTarget, scheme)
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &consulResolver{
-		clientConn:   cc,
-		consulHealth: health,
-		service:      consulService,
-		tags:         tags,
-		healthFilter: healthFilter,
-		ctx:          ctx,
-		cancel:       cancel,
-		resolveNow:   make(chan struct{}, 1),
-	}, nil
+	r := consulResolver{
+		queryOpts: queryOpts.WithContext(ctx),
+		mux:       opts.multiplexer,
+
+		clientConn:        cc,
+		backend:           backend,
+		service:           target.Service,
+		versionConstraint: versionConstraint,
+		portName:          target.PortName,
+		preferNodeAddr:    target.PreferNodeAddress,
+		requireSvcAddr:    target.RequireServiceAddress,
+		portZero:          target.PortZero,
+		localNodePolicy:   target.LocalNode,
+		lanAddrs:          target.DisableWANTranslation,
+		kind:              target.Kind,
+		dualStack:         target.DualStack,
+		priorityTags:      target.PriorityTags,
+		preserveOrder:     target.PreserveOrder,
+		checkTypes:        target.CheckTypes,
+		strict:            target.Strict,
+		agent:             agent,
+		addressKey:        key,
+		checkStatuses:     opts.checkStatuses,
+		serviceEntries:    opts.serviceEntries,
+		agentless:         opts.agentless,
+		removalFunc:       opts.removalFunc,
+		updateJitter:      opts.updateJitter,
+		settings:          settings,
+		baseSettings:      settings,
+		consulKV:          kv,
+		overridesKey:      target.OverridesKey,
+		gateCheck:         target.GateCheck,
+		consulChecks:      checks,
+
+		maxInstances:       target.MaxInstances,
+		maxInstancesPolicy: target.MaxInstancesPolicy,
+		config:             config,
+		minHealthyFraction: target.MinHealthyFraction,
+
+		consulStatus:    status,
+		transport:       cfg.Transport,
+		monitorInterval: opts.monitorInterval,
+		lazy:            opts.lazyStart,
+
+		ctx:        ctx,
+		cancel:     cancel,
+		resolveNow: make(chan struct{}, 1),
+		stats:      opts.statsHandler,
+
+		redactedTarget:    redactedTarget,
+		secrets:           target.secrets(),
+		waitTime:          waitTime,
+		republishInterval: opts.republishInterval,
+		updateGate:        opts.updateGate,
+		status:            resolverStatus{clock: clock},
+		clock:             clock,
+		readyFunc:         opts.readyFunc,
+		log:               &sharedLog,
+		stuckThreshold:    opts.stuckThreshold,
+		stuckRefresh:      opts.stuckRefresh,
+	}
+
+	if opts.retryAttempts > 0 || opts.retryDuration > 0 {
+		r.retryBudget = &retryBudget{attempts: opts.retryAttempts, duration: opts.retryDuration}
+	}
+
+	if target.SlowStart > 0 {
+		r.warmUp = &warmUp{window: target.SlowStart}
+	}
+
+	if target.FailureTolerance > 0 {
+		r.tolerance = &failureTolerance{window: target.FailureTolerance}
+	}
+
+	if opts.confirmation {
+		r.confirmed = &confirmation{}
+		r.maxAddressAge = opts.maxAddressAge
+	}
+
+	if opts.resolveNowInterval > 0 {
+		r.resolveNowThrottle = &resolveNowThrottle{interval: opts.resolveNowInterval}
+	}
+
+	if catalog != nil {
+		r.selfCheckInterval = opts.selfCheckInterval
+		r.consulCatalog = catalog
+		r.selfCheckOpts = consul.QueryOptions{
+			Namespace: queryOpts.Namespace,
+			Partition: queryOpts.Partition,
+			NodeMeta:  queryOpts.NodeMeta,
+		}
+	}
+
+	if target.Token == "" && opts.tokenSource != nil {
+		r.tokens = &tokenRenewer{src: opts.tokenSource, service: target.Service, clock: clock}
+	}
+
+	if opts.quarantine > 0 {
+		r.quarantine = newQuarantine(clock, opts.quarantine, opts.maxQuarantine)
+	}
+
+	if opts.enrichFunc != nil {
+		r.enricher = newEnricher(opts.enrichFunc, opts.enrichTimeout, opts.enrichTTL, clock, r.log)
+	}
+
+	return &r, nil
 }
 
 func (c *consulResolver) start() {
+	activeResolvers.add(c)
+	c.status.tracef("resolver built for %s", c.redactedTarget)
+
+	if c.lazy {
+		lazyPending.Add(1)
+		return
+	}
+
+	c.startWatching()
+}
+
+// startWatching starts the goroutines that query Consul. It returns false
+// and does nothing when they were already started or the resolver was
+// closed.
+func (c *consulResolver) startWatching() bool {
+	c.startMu.Lock()
+	defer c.startMu.Unlock()
+
+	if c.started || c.closed {
+		return false
+	}
+	c.started = true
+
+	if c.lazy {
+		lazyPending.Add(-1)
+	}
+
+	if c.overridesKey != "" {
+		c.wgStop.Add(1)
+		go c.overridesWatcher()
+	}
+
+	if c.monitorInterval > 0 {
+		c.wgStop.Add(1)
+		go c.connectionMonitor()
+	}
+
+	if c.gateCheck != "" {
+		c.wgStop.Add(1)
+		go c.gateCheckWatcher()
+	}
+
+	if c.consulCatalog != nil {
+		c.wgStop.Add(1)
+		go c.catalogSelfCheck()
+	}
+
+	if c.maxAddressAge > 0 {
+		c.wgStop.Add(1)
+		go c.addressAgeWatcher()
+	}
+
 	c.wgStop.Add(1)
+
+	if c.mux != nil {
+		c.mux.add(c)
+		return true
+	}
+
 	go c.watcher()
+
+	return true
 }
 
-func (c *consulResolver) query(opts *consul.QueryOptions) ([]resolver.Address, uint64, error) {
-	entries, meta, err := c.consulHealth.ServiceMultipleTags(c.service, c.tags, c.healthFilter == healthFilterOnlyHealthy, opts)
+func (c *consulResolver) query(opts *consul.QueryOptions, settings *querySettings) ([]resolver.Address, uint64, error) {
+	if c.tokens != nil {
+		token, err := c.tokens.get(opts.Context())
+		if err != nil {
+			err = redactError(err, c.secrets)
+			if token == "" {
+				c.log.infof("grpc-consul-resolver: resolving service name '%s' via consul failed: %v", c.service, err)
+				return nil, 0, err
+			}
+
+			c.log.warningf("grpc-consul-resolver: %v, using the current token until it expires", err)
+		}
+
+		opts.Token = token
+		// do not block beyond the renewal of the token
+		if d := c.tokens.renewIn(); d > 0 {
+			opts.WaitTime = min(opts.WaitTime, d)
+		}
+	}
+
+	// the health of instances is determined by only some of their
+	// checks when checkTypes is set, it can not be filtered by consul.
+	// The reasons of removals, the fraction of passing instances and
+	// the duration of failures can only be determined if consul returns
+	// unhealthy instances too.
+	minHealthy := c.minHealthyFraction > 0
+	filterLocally := len(c.checkTypes) != 0 || c.removalFunc != nil || minHealthy || c.tolerance != nil
+	passingOnly := settings.healthFilter == HealthFilterOnlyHealthy && !filterLocally
+
+	entries, index, err := c.fetch(settings.tags, passingOnly, opts)
 	if err != nil {
-		grpclog.Infof(
-			"grpc-consul-resolver: resolving service name '%s' via consul failed: %v\n",
+		err = redactError(err, c.secrets)
+		c.log.infof(
+			"grpc-consul-resolver: resolving service name '%s' via consul failed: %v",
 			c.service,
 			err,
 		)
@@ -107,17 +735,74 @@ func (c *consulResolver) query(opts *consul.QueryOptions) ([]resolver.Address, u
 		return nil, 0, err
 	}
 
-	if c.healthFilter == healthFilterFallbackToUnhealthy {
+	if len(c.checkTypes) != 0 {
+		entries = filterCheckTypes(entries, c.checkTypes)
+	}
+	if c.tolerance != nil {
+		entries, c.toleranceWait = c.tolerance.apply(c.clock.Now(), entries)
+	}
+	if c.removalFunc != nil {
+		c.instanceHealth = instanceHealth(entries)
+	}
+	if minHealthy {
+		entries = filterMinHealthy(entries, c.minHealthyFraction)
+	} else if filterLocally && settings.healthFilter == HealthFilterOnlyHealthy {
+		entries = filterPassing(entries)
+	}
+
+	entries = filterDraining(entries)
+
+	if c.kind != KindUndefined {
+		entries = filterKind(entries, c.kind.serviceKind())
+	}
+
+	if c.versionConstraint != nil {
+		entries = filterVersion(entries, c.versionConstraint)
+	}
+
+	if settings.healthFilter == HealthFilterFallbackToUnhealthy && !minHealthy {
 		entries = filterPreferOnlyHealthy(entries)
 	}
 
+	var localNode string
+	if c.localNodePolicy != LocalNodeUndefined {
+		localNode, err = c.localNode()
+		if err != nil {
+			err = redactError(err, c.secrets)
+			c.log.infof(
+				"grpc-consul-resolver: resolving service name '%s' via consul failed: %v",
+				c.service,
+				err,
+			)
+
+			return nil, 0, err
+		}
+
+		if c.localNodePolicy == LocalNodeOnly {
+			entries = filterLocalNode(entries, localNode)
+		}
+	}
+
 	result := make([]resolver.Address, 0, len(entries))
 	for _, e := range entries {
-		// when additional fields are set in addr, addressesEqual()
-		// must be updated to honour them
-		addr := e.Service.Address
-		if addr == "" {
-			addr = e.Node.Address
+		addr, port, nodeAddr := entryAddresses(e, c.lanAddrs)
+		fromNode := false
+		if c.preferNodeAddr && nodeAddr != "" {
+			addr = nodeAddr
+			fromNode = true
+		} else if addr == "" {
+			if c.requireSvcAddr {
+				if grpclog.V(2) {
+					grpclog.Infof(
+						"grpc-consul-resolver: service '%s' has no ServiceAddress, skipping it",
+						e.Service.ID,
+					)
+				}
+				continue
+			}
+
+			addr = nodeAddr
+			fromNode = true
 
 			if grpclog.V(2) {
 				grpclog.Infof(
@@ -128,8 +813,75 @@ func (c *consulResolver) query(opts *consul.QueryOptions) ([]resolver.Address, u
 			}
 		}
 
+		if c.portName != "" {
+			var ok bool
+			port, ok = metaPort(e.Service, PortMetaKeyPrefix+c.portName)
+			if !ok {
+				if grpclog.V(2) {
+					grpclog.Infof(
+						"grpc-consul-resolver: service '%s' has no valid '%s' port, skipping it",
+						e.Service.ID,
+						c.portName,
+					)
+				}
+				continue
+			}
+		} else if port == 0 {
+			switch c.portZero {
+			case PortZeroSkip:
+				if grpclog.V(2) {
+					grpclog.Infof("grpc-consul-resolver: service '%s' has port 0, skipping it", e.Service.ID)
+				}
+				continue
+
+			case PortZeroError:
+				return nil, 0, fmt.Errorf("%w: instance '%s' of service '%s'", ErrZeroPort, e.Service.ID, c.service)
+
+			case PortZeroUseMetaPort:
+				var ok bool
+				port, ok = metaPort(e.Service, PortMetaKey)
+				if !ok {
+					if grpclog.V(2) {
+						grpclog.Infof(
+							"grpc-consul-resolver: service '%s' has port 0 and no valid '%s' service meta field, skipping it",
+							e.Service.ID,
+							PortMetaKey,
+						)
+					}
+					continue
+				}
+			}
+		}
+
+		attrs := balancerAttributes(e, c.checkStatuses)
+		if c.datacenterAttribute {
+			if dc := datacenter(e); dc != "" {
+				attrs = attrs.WithValue(datacenterAttributeKey, dc)
+			}
+		}
+		if localNode != "" && isLocal(e, localNode) {
+			attrs = attrs.WithValue(localNodeAttributeKey, true)
+		}
+		if len(c.priorityTags) != 0 {
+			attrs = attrs.WithValue(priorityAttributeKey, priority(e.Service.Tags, c.priorityTags))
+		}
+		if c.dualStack {
+			if alts := dualStackAddrs(e, addr, port, fromNode); len(alts) != 0 {
+				attrs = attrs.WithValue(dualStackAttributeKey, alts)
+			}
+		}
+		if c.confirmed != nil {
+			attrs = attrs.WithValue(confirmedAttributeKey, c.confirmed)
+		}
+		if c.serviceEntries {
+			attrs = attrs.WithValue(serviceEntryAttributeKey, serviceEntry{entry: trimServiceEntry(e)})
+		}
+
 		result = append(result, resolver.Address{
-			Addr: net.JoinHostPort(addr, fmt.Sprint(e.Service.Port)),
+			Addr:               net.JoinHostPort(addr, strconv.Itoa(port)),
+			ServerName:         e.Service.Meta[TLSServerNameMetaKey],
+			Attributes:         addressAttributes(e),
+			BalancerAttributes: attrs,
 		})
 	}
 
@@ -137,7 +889,54 @@ func (c *consulResolver) query(opts *consul.QueryOptions) ([]resolver.Address, u
 		grpclog.Infof("grpc-consul-resolver: service '%s' resolved to '%+v'", c.service, result)
 	}
 
-	return result, meta.LastIndex, nil
+	if c.confirmed != nil {
+		c.confirmed.set(c.clock.Now())
+	}
+
+	return result, index, nil
+}
+
+// lanTaggedAddress is the key of the tagged addresses that contain the LAN
+// address of a service or node when the Consul agent translated it to the
+// WAN address.
+const lanTaggedAddress = "lan"
+
+// entryAddresses returns the service address, port and node address of e.
+// If lan is true, the LAN addresses are returned instead of the WAN addresses
+// the Consul agent translated them to for services in remote datacenters.
+func entryAddresses(e *consul.ServiceEntry, lan bool) (addr string, port int, nodeAddr string) {
+	addr, port = e.Service.Address, e.Service.Port
+	if e.Node != nil {
+		nodeAddr = e.Node.Address
+	}
+
+	if !lan {
+		return addr, port, nodeAddr
+	}
+
+	if a, exists := e.Service.TaggedAddresses[lanTaggedAddress]; exists && a.Address != "" {
+		addr = a.Address
+		if a.Port != 0 {
+			port = a.Port
+		}
+	}
+
+	if e.Node != nil && e.Node.TaggedAddresses[lanTaggedAddress] != "" {
+		nodeAddr = e.Node.TaggedAddresses[lanTaggedAddress]
+	}
+
+	return addr, port, nodeAddr
+}
+
+// metaPort returns the port in the meta field key of svc. It returns false if
+// the field is missing or not a valid port.
+func metaPort(svc *consul.AgentService, key string) (int, bool) {
+	port, err := strconv.ParseUint(svc.Meta[key], 10, 16)
+	if err != nil || port == 0 {
+		return 0, false
+	}
+
+	return int(port), true
 }
 
 // filterPreferOnlyHealthy if entries contains services with passing health
@@ -159,6 +958,124 @@ func filterPreferOnlyHealthy(entries []*consul.ServiceEntry) []*consul.ServiceEn
 	return entries
 }
 
+// filterMinHealthy returns the entries with passing health checks, or all
+// entries if the passing ones are less than fraction of them.
+func filterMinHealthy(entries []*consul.ServiceEntry, fraction float64) []*consul.ServiceEntry {
+	healthy := filterPassing(entries)
+	if float64(len(healthy)) < fraction*float64(len(entries)) {
+		return entries
+	}
+
+	return healthy
+}
+
+// filterDraining returns the entries that are not marked as draining via
+// the [DrainingMetaKey] meta field.
+func filterDraining(entries []*consul.ServiceEntry) []*consul.ServiceEntry {
+	result := make([]*consul.ServiceEntry, 0, len(entries))
+
+	for _, e := range entries {
+		if draining, _ := strconv.ParseBool(e.Service.Meta[DrainingMetaKey]); draining {
+			continue
+		}
+
+		result = append(result, e)
+	}
+
+	return result
+}
+
+// filterVersion returns the entries whose [VersionMetaKey] meta field
+// contains a version that satisfies constraint.
+func filterVersion(entries []*consul.ServiceEntry, constraint *semver.Constraints) []*consul.ServiceEntry {
+	result := make([]*consul.ServiceEntry, 0, len(entries))
+
+	for _, e := range entries {
+		v, err := semver.NewVersion(e.Service.Meta[VersionMetaKey])
+		if err != nil || !constraint.Check(v) {
+			continue
+		}
+
+		result = append(result, e)
+	}
+
+	return result
+}
+
+// addressKey returns the key that identifies an address when addresses
+// are sorted and compared.
+type addressKey func(resolver.Address) string
+
+// addrKey identifies addresses by their Addr field.
+func addrKey(a resolver.Address) string {
+	return a.Addr
+}
+
+// serviceIDKey identifies addresses by the node and service ID of the Consul
+// service instance, or by their Addr field if they are unknown.
+func serviceIDKey(a resolver.Address) string {
+	if k, ok := InstanceKey(a); ok {
+		return k
+	}
+
+	return a.Addr
+}
+
+// sortAddresses sorts addresses by their key and addresses with the same key
+// by their Addr field. Addresses of instances on the local node are ordered
+// first.
+func sortAddresses(addresses []resolver.Address, key addressKey) {
+	sort.Slice(addresses, func(i, j int) bool {
+		if li, lj := IsLocalNode(addresses[i]), IsLocalNode(addresses[j]); li != lj {
+			return li
+		}
+
+		if ki, kj := key(addresses[i]), key(addresses[j]); ki != kj {
+			return ki < kj
+		}
+
+		return addresses[i].Addr < addresses[j].Addr
+	})
+}
+
+// orderAddresses sorts addresses with [sortAddresses], or only orders the
+// addresses of instances on the local node first if the order returned by
+// Consul is preserved.
+func (c *consulResolver) orderAddresses(addresses []resolver.Address) {
+	if !c.preserveOrder {
+		sortAddresses(addresses, c.addressKey)
+		return
+	}
+
+	sort.SliceStable(addresses, func(i, j int) bool {
+		return IsLocalNode(addresses[i]) && !IsLocalNode(addresses[j])
+	})
+}
+
+// addressChurn returns the number of addresses in updated whose key is not
+// in old and the number of addresses in old whose key is not in updated.
+// Addresses with the same key are counted individually.
+func addressChurn(old, updated []resolver.Address, key addressKey) (added, removed int) {
+	oldAddrs := make(map[string]int, len(old))
+	for _, a := range old {
+		oldAddrs[key(a)]++
+	}
+
+	for _, a := range updated {
+		if oldAddrs[key(a)] > 0 {
+			oldAddrs[key(a)]--
+			continue
+		}
+		added++
+	}
+
+	for _, cnt := range oldAddrs {
+		removed += cnt
+	}
+
+	return added, removed
+}
+
 func addressesEqual(a, b []resolver.Address) bool {
 	if a == nil && b != nil {
 		return false
@@ -173,7 +1090,7 @@ func addressesEqual(a, b []resolver.Address) bool {
 	}
 
 	for i := range a {
-		if a[i].Addr != b[i].Addr {
+		if !a[i].Equal(b[i]) {
 			return false
 		}
 	}
@@ -181,94 +1098,365 @@ func addressesEqual(a, b []resolver.Address) bool {
 	return true
 }
 
-func (c *consulResolver) watcher() {
-	var lastReportedAddresses []resolver.Address
+// addressSetsEqual returns true if a and b contain the same addresses,
+// regardless of their order.
+func addressSetsEqual(a, b []resolver.Address) bool {
+	if (a == nil) != (b == nil) || len(a) != len(b) {
+		return false
+	}
+
+	remaining := make(map[string][]resolver.Address, len(a))
+	for _, addr := range a {
+		remaining[addr.Addr] = append(remaining[addr.Addr], addr)
+	}
 
-	opts := (&consul.QueryOptions{}).WithContext(c.ctx)
+	for _, addr := range b {
+		candidates := remaining[addr.Addr]
+		i := slices.IndexFunc(candidates, addr.Equal)
+		if i == -1 {
+			return false
+		}
+		remaining[addr.Addr] = slices.Delete(candidates, i, i+1)
+	}
+
+	return true
+}
 
+func (c *consulResolver) watcher() {
 	defer c.wgStop.Done()
 
 	for {
-		for {
-			var addresses []resolver.Address
-			var err error
+		for c.poll() {
+		}
 
-			lastWaitIndex := opts.WaitIndex
+		select {
+		case <-c.ctx.Done():
+			return
 
-			queryStartTime := time.Now()
-			addresses, opts.WaitIndex, err = c.query(opts)
-			if err != nil {
-				if errors.Is(err, context.Canceled) {
-					return
-				}
+		case <-c.resolveNow:
+		}
+	}
+}
 
-				// After ReportError() was called, the grpc
-				// load balancer will call ResolveNow()
-				// periodically to retry. Therefor we do not
-				// have to retry on our own by e.g.  setting
-				// the timer.
-				c.clientConn.ReportError(err)
-				break
-			}
+// poll runs one query and passes changed addresses to the ClientConn.
+// It returns true if the next query should be run immediately.
+// If false is returned, the query failed or the resolver was closed, the
+// next query must only be run after ResolveNow() was called.
+func (c *consulResolver) poll() bool {
+	settings, ctx, cancel := c.startQuery()
+	defer cancel()
 
-			if opts.WaitIndex < lastWaitIndex {
-				grpclog.Infof("grpc-consul-resolver: consul responded with a smaller waitIndex (%d) then the previous one (%d), restarting blocking query loop",
-					opts.WaitIndex, lastWaitIndex)
-				opts.WaitIndex = 0
-				continue
+	opts := c.queryOpts.WithContext(ctx)
+	opts.Filter = settings.filter
+	lastWaitIndex := opts.WaitIndex
+	if c.refreshPending.Swap(false) {
+		opts.WaitIndex = 0
+	}
+	if c.warmUpWait > 0 {
+		// return in time to increase the weight of warming up
+		// instances
+		opts.WaitTime = min(opts.WaitTime, c.warmUpWait)
+	}
+	if c.toleranceWait > 0 {
+		// return in time to exclude instances whose failure
+		// tolerance expired
+		opts.WaitTime = min(opts.WaitTime, c.toleranceWait)
+	}
+
+	c.emit(&QueryStarted{Service: c.service, WaitIndex: lastWaitIndex})
+	queryStartTime := c.clock.Now()
+	c.status.queryStarting()
+	addresses, waitIndex, err := c.query(opts, &settings)
+	c.status.queryReturned()
+	c.emit(&QueryFinished{
+		Service:   c.service,
+		Duration:  c.clock.Now().Sub(queryStartTime),
+		Addresses: len(addresses),
+		WaitIndex: waitIndex,
+		Err:       err,
+	})
+	c.queryOpts.WaitIndex = waitIndex
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			if c.ctx.Err() != nil {
+				return false
 			}
 
-			sort.Slice(addresses, func(i, j int) bool {
-				return addresses[i].Addr < addresses[j].Addr
-			})
-
-			// query() blocks until a consul internal timeout expired or
-			// data newer then the passed opts.WaitIndex is available.
-			// We check if the returned addresses changed to not call
-			// clientConn.UpdateState() unnecessary for unchanged addresses.
-			// If the service does not exist, an empty addresses slice
-			// is returned. If we never reported any resolved
-			// addresses (addresses is nil), we have to report an empty
-			// set of resolved addresses. It informs the grpc-balancer that resolution is not
-			// in progress anymore and grpc calls can failFast.
-			if addressesEqual(addresses, lastReportedAddresses) {
-				// If the consul server responds with
-				// the same data then in the last
-				// query in less than 50ms, we sleep a
-				// bit to prevent querying in a tight loop
-				// This should only happen if the consul server
-				// is buggy but better be safe. :-)
-				if lastWaitIndex == opts.WaitIndex &&
-					time.Since(queryStartTime) < 50*time.Millisecond {
-					grpclog.Warningf("grpc-consul-resolver: consul responded too fast with same data and waitIndex (%d) then in previous query, delaying next query",
-						opts.WaitIndex)
-					time.Sleep(50 * time.Millisecond)
-				}
+			// the query was canceled because the settings
+			// changed, run a new non-blocking query
+			return true
+		}
 
-				continue
+		if c.tokens != nil && isTokenRejected(err) && !c.tokenRetried {
+			// the token might have expired or was revoked,
+			// retry once with a new one
+			c.tokenRetried = true
+			c.tokens.invalidate()
+			c.status.tracef("consul rejected the token, retrying with a new one: %v", err)
+			return true
+		}
+		c.tokenRetried = false
+
+		if c.agentless && c.transport != nil {
+			// send the next query to another server
+			c.transport.CloseIdleConnections()
+		}
+
+		c.status.queryFailed(err)
+		c.status.tracef("query failed: %v", err)
+
+		if c.retryBudget != nil {
+			if budgetErr := c.retryBudget.failed(c.clock.Now(), err); budgetErr != nil {
+				c.stopExhausted(budgetErr)
+				return false
 			}
+		}
+
+		// shorten the wait time of the next queries, a
+		// connection to consul that broke again is then
+		// noticed sooner
+		c.queryOpts.WaitTime = min(minAdaptiveWaitTime, c.waitTime)
+
+		// After ReportError() was called, the grpc
+		// load balancer will call ResolveNow()
+		// periodically to retry. Therefor we do not
+		// have to retry on our own by e.g.  setting
+		// the timer.
+		err = withStatusCode(err)
+		c.clientConn.ReportError(err)
+		c.emit(&ErrorReported{Service: c.service, Err: err})
+		return false
+	}
+
+	c.tokenRetried = false
+	c.status.querySucceeded(len(addresses))
+	if c.retryBudget != nil {
+		c.retryBudget.succeeded()
+	}
+	c.queryOpts.WaitTime = min(2*c.queryOpts.WaitTime, c.waitTime)
+
+	if waitIndex < lastWaitIndex {
+		grpclog.Infof("grpc-consul-resolver: consul responded with a smaller waitIndex (%d) then the previous one (%d), restarting blocking query loop",
+			waitIndex, lastWaitIndex)
+		c.queryOpts.WaitIndex = 0
+		c.status.tracef("consul returned the smaller index %d after %d, restarted the blocking query", waitIndex, lastWaitIndex)
+		c.emit(&IndexReset{Service: c.service, WaitIndex: waitIndex, PreviousIndex: lastWaitIndex})
+		return true
+	}
+
+	c.checkStuckWatch(lastWaitIndex, waitIndex, len(addresses))
+
+	c.orderAddresses(addresses)
+	addresses, err = c.limitInstances(addresses)
+	if err != nil {
+		c.status.tracef("kept the previous addresses: %v", err)
+		if c.lastReportedAddresses == nil {
+			err = withStatusCode(err)
+			c.clientConn.ReportError(err)
+			c.emit(&ErrorReported{Service: c.service, Err: err})
+		}
 
-			err = c.clientConn.UpdateState(resolver.State{Addresses: addresses})
-			if err != nil && grpclog.V(2) {
-				// UpdateState errors can be ignored in
-				// watch-based resolvers, see
-				// https://github.com/grpc/grpc-go/issues/5048
-				// for a detailed explanation.
-				grpclog.Infof("grpc-consul-resolver: ignoring error returned by UpdateState, no other addresses available, error: %s", err)
+		return true
+	}
+	if c.warmUp != nil {
+		c.warmUpWait = c.warmUp.apply(c.clock.Now(), addresses, c.addressKey)
+	}
+	if c.enricher != nil {
+		addresses = c.enricher.enrich(c.ctx, c.service, addresses, c.addressKey)
+	}
+	if c.quarantine != nil {
+		addresses = c.quarantine.filter(addresses)
+	}
+
+	// query() blocks until a consul internal timeout expired or
+	// data newer then the passed opts.WaitIndex is available.
+	// We check if the returned addresses changed to not call
+	// clientConn.UpdateState() unnecessary for unchanged addresses.
+	// If the service does not exist, an empty addresses slice
+	// is returned. If we never reported any resolved
+	// addresses (addresses is nil), we have to report an empty
+	// set of resolved addresses. It informs the grpc-balancer that resolution is not
+	// in progress anymore and grpc calls can failFast.
+	var changed bool
+	if c.preserveOrder {
+		changed = !addressSetsEqual(addresses, c.lastReportedAddresses)
+	} else {
+		changed = !addressesEqual(addresses, c.lastReportedAddresses)
+	}
+	if !changed && !c.republishDue() {
+		// If the consul server responds with
+		// the same data then in the last
+		// query in less than 50ms, we sleep a
+		// bit to prevent querying in a tight loop
+		// This should only happen if the consul server
+		// is buggy but better be safe. :-)
+		if lastWaitIndex == waitIndex &&
+			c.clock.Now().Sub(queryStartTime) < 50*time.Millisecond {
+			c.log.warningf("grpc-consul-resolver: consul responded too fast with same data and waitIndex (%d) then in previous query, delaying next query",
+				waitIndex)
+			select {
+			case <-c.clock.After(50 * time.Millisecond):
+			case <-c.ctx.Done():
 			}
-			lastReportedAddresses = addresses
 		}
 
-		select {
-		case <-c.ctx.Done():
-			return
+		return true
+	}
 
-		case <-c.resolveNow:
+	state := resolver.State{Addresses: addresses}
+	if c.dualStack {
+		state.Endpoints = endpoints(addresses)
+	}
+	if c.gateCheck != "" && !c.gateOpen.Load() {
+		c.emit(&UpdateRejected{Service: c.service, Addresses: addresses})
+		return true
+	}
+
+	if c.updateGate != nil && !c.updateGate(resolver.State{Addresses: c.lastReportedAddresses}, state) {
+		c.emit(&UpdateRejected{Service: c.service, Addresses: addresses})
+		return true
+	}
+
+	added, removed := addressChurn(c.lastReportedAddresses, addresses, c.addressKey)
+	if c.updateJitter > 0 && added > 0 && c.lastReportedAddresses != nil {
+		if !c.waitUpdateJitter() {
+			return false
+		}
+	}
+
+	if c.removalFunc != nil {
+		if removed := removedAddresses(c.lastReportedAddresses, addresses, c.addressKey, c.instanceHealth); len(removed) != 0 {
+			c.removalFunc(c.service, removed)
+		}
+	}
+
+	c.status.stateUpdated(changed, added, removed)
+	err = c.clientConn.UpdateState(state)
+	if err != nil {
+		// UpdateState errors can be ignored in
+		// watch-based resolvers, see
+		// https://github.com/grpc/grpc-go/issues/5048
+		// for a detailed explanation.
+		// They are recorded to diagnose addresses that the
+		// balancer can not use.
+		if grpclog.V(2) {
+			grpclog.Infof("grpc-consul-resolver: ignoring error returned by UpdateState, no other addresses available, error: %s", err)
+		}
+		c.status.balancerRejected(addresses, err)
+		c.status.tracef("channel rejected %d addresses: %v", len(addresses), err)
+		c.emit(&BalancerRejected{Service: c.service, Addresses: addresses, Err: err})
+	}
+	c.emit(&StateUpdated{
+		Service:   c.service,
+		Addresses: addresses,
+		Added:     added,
+		Removed:   removed,
+		Err:       err,
+	})
+	c.lastReportedAddresses = addresses
+	c.lastReportTime = c.clock.Now()
+	if changed {
+		c.status.tracef("passed %d addresses to the channel, %d added, %d removed", len(addresses), added, removed)
+	}
+
+	if len(addresses) == 0 && (settings.healthFilter == HealthFilterOnlyHealthy || c.strict) {
+		// explain to the clients why the service resolved to no
+		// addresses, if it has unhealthy instances or, in strict
+		// mode, is not registered
+		if emptyErr := c.emptyResolutionError(&settings); emptyErr != nil {
+			emptyErr = withStatusCode(redactError(emptyErr, c.secrets))
+			c.clientConn.ReportError(emptyErr)
+			c.status.tracef("reported error: %v", emptyErr)
+			c.emit(&ErrorReported{Service: c.service, Err: emptyErr})
+		}
+	}
+
+	if err == nil && !c.ready {
+		c.ready = true
+		c.status.tracef("first resolution to %d addresses", len(addresses))
+		if c.readyFunc != nil {
+			c.readyFunc(c.service)
+		}
+	}
+
+	return true
+}
+
+// checkStuckWatch warns when Consul returned the same index for longer than
+// the stuckThreshold while the service had addresses. If stuckRefresh is
+// enabled, the next query is run non-blocking.
+func (c *consulResolver) checkStuckWatch(lastIndex, index uint64, addresses int) {
+	if c.stuckThreshold <= 0 {
+		return
+	}
+
+	now := c.clock.Now()
+	if index != lastIndex || addresses == 0 || c.indexChangedAt.IsZero() {
+		c.indexChangedAt = now
+		return
+	}
+
+	unchanged := now.Sub(c.indexChangedAt)
+	if unchanged < c.stuckThreshold {
+		return
+	}
+
+	c.log.warningf("grpc-consul-resolver: index (%d) of service %s did not change for %s, the watch might be stuck",
+		index, c.service, unchanged)
+	c.emit(&WatchStuck{Service: c.service, WaitIndex: index, Unchanged: unchanged})
+	// only warn again after another threshold passed
+	c.indexChangedAt = now
+
+	if c.stuckRefresh {
+		if c.transport != nil {
+			c.transport.CloseIdleConnections()
 		}
+		c.queryOpts.WaitIndex = 0
+		c.status.tracef("index %d did not change for %s, restarted the blocking query", index, unchanged)
 	}
 }
 
+// republishDue returns true if the republishInterval is enabled and expired
+// since the last state update.
+func (c *consulResolver) republishDue() bool {
+	return c.republishInterval > 0 && c.clock.Now().Sub(c.lastReportTime) >= c.republishInterval
+}
+
+// startQuery returns the current settings and a context for the next query.
+// The context is canceled when the settings are changed.
+func (c *consulResolver) startQuery() (querySettings, context.Context, context.CancelFunc) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ctx, cancel := context.WithCancel(c.ctx)
+	c.cancelQuery = cancel
+
+	return c.settings, ctx, cancel
+}
+
 func (c *consulResolver) ResolveNow(_ resolver.ResolveNowOptions) {
+	if c.resolveNowThrottle != nil {
+		c.throttledResolveNow()
+		return
+	}
+
+	c.triggerQuery()
+}
+
+// triggerQuery runs a query immediately if the resolver is not polling
+// because the last query failed, or starts a lazily started resolver.
+func (c *consulResolver) triggerQuery() {
+	// the watcher of a lazily started resolver runs the first query
+	// immediately when it is started
+	if c.lazy && c.startWatching() {
+		return
+	}
+
+	if c.mux != nil {
+		c.mux.resolveNow(c)
+		return
+	}
+
 	select {
 	case c.resolveNow <- struct{}{}:
 	default:
@@ -276,6 +1464,20 @@ func (c *consulResolver) ResolveNow(_ resolver.ResolveNowOptions) {
 }
 
 func (c *consulResolver) Close() {
+	c.startMu.Lock()
+	if c.lazy && !c.started && !c.closed {
+		lazyPending.Add(-1)
+	}
+	c.closed = true
+	c.startMu.Unlock()
+
 	c.cancel()
+	if c.resolveNowThrottle != nil {
+		c.resolveNowThrottle.stop()
+	}
+	if c.mux != nil {
+		c.mux.remove(c)
+	}
 	c.wgStop.Wait()
+	activeResolvers.remove(c)
 }
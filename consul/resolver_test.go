@@ -1,15 +1,23 @@
 package consul
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/hashicorp/consul/api"
 	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/status"
 
 	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
 )
@@ -61,7 +69,7 @@ func cmpResolveResults(addrs []resolver.Address, ags []*consul.AgentService) boo
 
 func resolverAddressExist(addrs []resolver.Address, wanted resolver.Address) bool {
 	for _, addr := range addrs {
-		if addr == wanted {
+		if addr.Equal(wanted) {
 			return true
 		}
 	}
@@ -512,9 +520,13 @@ func TestErrorIsReportedOnQueryErrors(t *testing.T) {
 
 	}
 
-	if err != queryErr {
+	if !errors.Is(err, queryErr) {
 		t.Fatalf("resolver error is %+v, expected %+v", err, queryErr)
 	}
+
+	if code := status.Code(err); code != codes.Unavailable {
+		t.Errorf("resolver error has status code %s, expected Unavailable", code)
+	}
 }
 
 func TestQueryResultsAreSorted(t *testing.T) {
@@ -566,3 +578,1014 @@ func TestQueryResultsAreSorted(t *testing.T) {
 
 	r.Close()
 }
+
+func TestSegmentIsPassedAsNodeMeta(t *testing.T) {
+	cc := mocks.NewClientConn()
+	newAddressCallCnt := cc.UpdateStateCallCnt()
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	r, err := NewBuilder().Build(resolver.Target{URL: url.URL{Path: "test", RawQuery: "segment=alpha"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+
+	for newAddressCallCnt == cc.UpdateStateCallCnt() {
+		time.Sleep(time.Millisecond)
+	}
+
+	r.Close()
+
+	opts := health.LastQueryOptions()
+	if opts.NodeMeta[segmentNodeMetaKey] != "alpha" {
+		t.Errorf("query NodeMeta is %+v, expected %s=alpha", opts.NodeMeta, segmentNodeMetaKey)
+	}
+}
+
+func TestQualifiedServiceIsPassedAsQueryOptions(t *testing.T) {
+	cc := mocks.NewClientConn()
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	r, err := NewBuilder().Build(resolver.Target{URL: url.URL{Path: "/team-a/billing/test"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+
+	for cc.UpdateStateCallCnt() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	r.Close()
+
+	opts := health.LastQueryOptions()
+	if opts.Partition != "team-a" || opts.Namespace != "billing" {
+		t.Errorf("query partition is %q and namespace %q, expected team-a and billing", opts.Partition, opts.Namespace)
+	}
+}
+
+func TestTargetBuilder(t *testing.T) {
+	var cfg *consul.Config
+
+	cc := mocks.NewClientConn()
+	newAddressCallCnt := cc.UpdateStateCallCnt()
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(c *consul.Config) (consulHealthEndpoint, error) {
+			cfg = c
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespEntries([]*consul.ServiceEntry{
+		{
+			Service: &consul.AgentService{
+				Address: "127.0.0.1",
+				Port:    1,
+			},
+		},
+	})
+
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	_, certPEM, keyPEM := newSelfSignedCert(t, "client")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+
+	b, err := NewTargetBuilder("payments", &Target{
+		Service: "billing",
+		TLS: TLSConfig{
+			CAFile:   "ca.pem",
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		},
+	})
+	if err != nil {
+		t.Fatal("NewTargetBuilder() failed:", err)
+	}
+
+	if b.Scheme() != "payments" {
+		t.Errorf("builder scheme is %q, expected payments", b.Scheme())
+	}
+
+	r, err := b.Build(resolver.Target{URL: url.URL{Scheme: "payments"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+
+	for newAddressCallCnt == cc.UpdateStateCallCnt() {
+		time.Sleep(time.Millisecond)
+	}
+
+	r.Close()
+
+	if addrs := cc.Addrs(); len(addrs) != 1 || addrs[0].Addr != "127.0.0.1:1" {
+		t.Errorf("resolved addresses are %+v, expected [127.0.0.1:1]", addrs)
+	}
+
+	if cfg.TLSConfig.CAFile != "ca.pem" || cfg.TLSConfig.CertFile != certFile || cfg.TLSConfig.KeyFile != keyFile {
+		t.Errorf("consul client tls config is %+v, expected files from target", cfg.TLSConfig)
+	}
+
+	if cfg.Transport == nil || cfg.Transport.TLSClientConfig.GetClientCertificate == nil {
+		t.Error("client certificate reloading is not configured for the consul client transport")
+	}
+
+	_, err = NewTargetBuilder("payments", &Target{})
+	if !errors.Is(err, ErrMissingService) {
+		t.Errorf("NewTargetBuilder() error = %v, expected %v", err, ErrMissingService)
+	}
+}
+
+func TestStateIsRepublished(t *testing.T) {
+	cc := mocks.NewClientConn()
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: 1},
+	})
+
+	b := NewBuilder(WithStateRepublishInterval(10 * time.Millisecond))
+	r, err := b.Build(resolver.Target{URL: url.URL{Path: "test"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+	defer r.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for cc.UpdateStateCallCnt() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("UpdateState() was called %d times, expected unchanged state to be republished", cc.UpdateStateCallCnt())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if wt := health.LastQueryOptions().WaitTime; wt != 10*time.Millisecond {
+		t.Errorf("query WaitTime is %s, expected it to be limited to the republish interval", wt)
+	}
+}
+
+func TestUpdateGateRejectsUpdates(t *testing.T) {
+	cc := mocks.NewClientConn()
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: 1},
+	})
+
+	stats := recordingStatsHandler{}
+	minInstances := func(_, updated resolver.State) bool {
+		return len(updated.Addresses) >= 2
+	}
+
+	b := NewBuilder(WithUpdateGate(minInstances), WithStatsHandler(&stats))
+	r, err := b.Build(resolver.Target{URL: url.URL{Path: "test"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+	defer r.Close()
+
+	waitForEvent(t, &stats, func(ev Event) bool {
+		_, ok := ev.(*UpdateRejected)
+		return ok
+	})
+
+	if cnt := cc.UpdateStateCallCnt(); cnt != 0 {
+		t.Fatalf("UpdateState() was called %d times, expected the update to be rejected", cnt)
+	}
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: 1},
+		{Address: "127.0.0.1", Port: 2},
+	})
+
+	for cc.UpdateStateCallCnt() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if addrs := cc.Addrs(); len(addrs) != 2 {
+		t.Errorf("resolved to %d addresses, expected 2", len(addrs))
+	}
+}
+
+func TestBuilderQueryOptions(t *testing.T) {
+	tests := []struct {
+		target     string
+		wantFilter string
+	}{
+		{"consul:///test", "Service.Port == 1"},
+		{"consul:///test?filter=Service.Port+%3D%3D+2", "Service.Port == 2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			cc := mocks.NewClientConn()
+			health := mocks.NewConsulHealthClient()
+			cleanup := replaceCreateHealthClientFn(
+				func(cfg *consul.Config) (consulHealthEndpoint, error) {
+					return health, nil
+				},
+			)
+			t.Cleanup(cleanup)
+
+			b := NewBuilder(WithQueryOptions(consul.QueryOptions{
+				AllowStale: true,
+				Near:       "_agent",
+				Filter:     "Service.Port == 1",
+				Datacenter: "ignored",
+			}))
+
+			u, err := url.Parse(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := b.Build(resolver.Target{URL: *u}, cc, resolver.BuildOptions{})
+			if err != nil {
+				t.Fatal("Build() failed:", err.Error())
+			}
+
+			for cc.UpdateStateCallCnt() == 0 {
+				time.Sleep(time.Millisecond)
+			}
+
+			r.Close()
+
+			opts := health.LastQueryOptions()
+			if !opts.AllowStale || opts.Near != "_agent" || opts.Datacenter != "" {
+				t.Errorf("query options are %+v, expected AllowStale and Near from the builder", opts)
+			}
+
+			if opts.Filter != tt.wantFilter {
+				t.Errorf("query filter is %q, expected %q", opts.Filter, tt.wantFilter)
+			}
+		})
+	}
+}
+
+func TestBuilderQueryOptionsInvalidFilter(t *testing.T) {
+	b := NewBuilder(WithQueryOptions(consul.QueryOptions{Filter: "Service.Port =="}))
+	_, err := b.Build(resolver.Target{URL: url.URL{Path: "test"}}, mocks.NewClientConn(), resolver.BuildOptions{})
+	if !errors.Is(err, ErrInvalidFilter) {
+		t.Errorf("Build() returned error %v, expected ErrInvalidFilter", err)
+	}
+}
+
+func TestTokenFunc(t *testing.T) {
+	tests := []struct {
+		target    string
+		wantToken string
+	}{
+		{"consul:///billing", "billing-token"},
+		{"consul:///billing?token=url-token", "url-token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			var cfg *consul.Config
+			cleanup := replaceCreateHealthClientFn(
+				func(c *consul.Config) (consulHealthEndpoint, error) {
+					cfg = c
+					return mocks.NewConsulHealthClient(), nil
+				},
+			)
+			t.Cleanup(cleanup)
+
+			b := NewBuilder(WithTokenFunc(func(service string) string {
+				return service + "-token"
+			}))
+
+			u, err := url.Parse(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			r, err := b.Build(resolver.Target{URL: *u}, mocks.NewClientConn(), resolver.BuildOptions{})
+			if err != nil {
+				t.Fatal("Build() failed:", err.Error())
+			}
+			r.Close()
+
+			if cfg.Token != tt.wantToken {
+				t.Errorf("consul client token is %q, expected %q", cfg.Token, tt.wantToken)
+			}
+		})
+	}
+}
+
+func TestDrainingInstancesAreExcluded(t *testing.T) {
+	addrs := resolveOnce(t, "consul:///user-service", []*consul.ServiceEntry{
+		{
+			Service: &consul.AgentService{
+				Address: "127.0.0.1",
+				Port:    1,
+				Meta:    map[string]string{DrainingMetaKey: "true"},
+			},
+		},
+		{
+			Service: &consul.AgentService{
+				Address: "127.0.0.1",
+				Port:    2,
+				Meta:    map[string]string{DrainingMetaKey: "false"},
+			},
+		},
+		{
+			Service: &consul.AgentService{
+				Address: "127.0.0.1",
+				Port:    3,
+			},
+		},
+	})
+
+	if len(addrs) != 2 || addrs[0].Addr != "127.0.0.1:2" || addrs[1].Addr != "127.0.0.1:3" {
+		t.Errorf("resolved to %+v, expected only the not draining instances", addrs)
+	}
+}
+
+func TestVersionConstraint(t *testing.T) {
+	entry := func(port int, version string) *consul.ServiceEntry {
+		return &consul.ServiceEntry{
+			Service: &consul.AgentService{
+				Address: "127.0.0.1",
+				Port:    port,
+				Meta:    map[string]string{VersionMetaKey: version},
+			},
+		}
+	}
+
+	addrs := resolveOnce(t, "consul:///user-service?version=%5E1.4", []*consul.ServiceEntry{
+		entry(1, "1.3.9"),
+		entry(2, "1.4.0"),
+		entry(3, "v1.7.2"),
+		entry(4, "2.0.0"),
+		entry(5, "invalid"),
+		entry(6, ""),
+	})
+
+	if len(addrs) != 2 || addrs[0].Addr != "127.0.0.1:2" || addrs[1].Addr != "127.0.0.1:3" {
+		t.Errorf("resolved to %+v, expected only instances with versions matching ^1.4", addrs)
+	}
+}
+
+func TestReadyFuncIsCalledOnce(t *testing.T) {
+	cc := mocks.NewClientConn()
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: 1},
+	})
+
+	var readyCnt atomic.Int32
+	ready := func(service string) {
+		if service != "test" {
+			t.Errorf("ready function was called with service %q, expected test", service)
+		}
+		readyCnt.Add(1)
+	}
+
+	b := NewBuilder(WithReadyFunc(ready), WithStateRepublishInterval(time.Millisecond))
+	r, err := b.Build(resolver.Target{URL: url.URL{Path: "test"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+	defer r.Close()
+
+	for cc.UpdateStateCallCnt() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if cnt := readyCnt.Load(); cnt != 1 {
+		t.Errorf("ready function was called %d times, expected 1", cnt)
+	}
+}
+
+func TestAddressChurn(t *testing.T) {
+	addrs := func(a ...string) []resolver.Address {
+		result := make([]resolver.Address, 0, len(a))
+		for _, addr := range a {
+			result = append(result, resolver.Address{Addr: addr})
+		}
+		return result
+	}
+
+	tests := []struct {
+		name        string
+		old         []resolver.Address
+		updated     []resolver.Address
+		wantAdded   int
+		wantRemoved int
+	}{
+		{"initial", nil, addrs("a:1", "b:1"), 2, 0},
+		{"unchanged", addrs("a:1", "b:1"), addrs("a:1", "b:1"), 0, 0},
+		{"replaced", addrs("a:1", "b:1"), addrs("a:1", "c:1"), 1, 1},
+		{"all removed", addrs("a:1", "b:1"), addrs(), 0, 2},
+		{"duplicate removed", addrs("a:1", "a:1"), addrs("a:1"), 0, 1},
+		{"duplicate added", addrs("a:1"), addrs("a:1", "a:1", "b:1"), 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed := addressChurn(tt.old, tt.updated, addrKey)
+			if added != tt.wantAdded || removed != tt.wantRemoved {
+				t.Errorf("addressChurn() returned %d added, %d removed, expected %d added, %d removed",
+					added, removed, tt.wantAdded, tt.wantRemoved)
+			}
+		})
+	}
+}
+
+func TestProxyIsUsed(t *testing.T) {
+	var transport *http.Transport
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			transport = cfg.Transport
+			return mocks.NewConsulHealthClient(), nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	target, err := ParseTarget("consul://consul.internal:8500/test?proxy=http%3A%2F%2Fuser%3Asecret%40proxy.internal%3A3128")
+	if err != nil {
+		t.Fatal("ParseTarget() failed:", err)
+	}
+
+	r, err := newConsulResolver(mocks.NewClientConn(), target, &builderOptions{})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	if transport == nil || transport.Proxy == nil {
+		t.Fatal("consul client was created without a proxy")
+	}
+
+	proxy, err := transport.Proxy(&http.Request{URL: &url.URL{Scheme: "http", Host: "consul.internal:8500"}})
+	if err != nil {
+		t.Fatal("Proxy() failed:", err)
+	}
+
+	if proxy == nil || proxy.Host != "proxy.internal:3128" {
+		t.Errorf("consul is connected via proxy %v, expected proxy.internal:3128", proxy)
+	}
+
+	if strings.Contains(r.redactedTarget, "secret") {
+		t.Errorf("redacted target %q contains the proxy password", r.redactedTarget)
+	}
+}
+
+func TestWaitTime(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		opts  []BuilderOption
+		want  time.Duration
+	}{
+		{"default", "", nil, defaultWaitTime},
+		{"builder", "", []BuilderOption{WithWaitTime(time.Minute)}, time.Minute},
+		{"multiplexer", "", []BuilderOption{WithWaitTime(time.Minute), WithMultiplexedWatches(1, 30*time.Second)}, 30 * time.Second},
+		{"republish", "", []BuilderOption{WithWaitTime(time.Minute), WithStateRepublishInterval(20 * time.Second)}, 20 * time.Second},
+		{"target", "wait=2m", nil, 2 * time.Minute},
+		{"targetOverridesBuilder", "wait=2m", []BuilderOption{WithWaitTime(time.Minute)}, 2 * time.Minute},
+		{"targetMultiplexer", "wait=2m", []BuilderOption{WithMultiplexedWatches(1, 30*time.Second)}, 30 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			health := mocks.NewConsulHealthClient()
+			cleanup := replaceCreateHealthClientFn(
+				func(cfg *consul.Config) (consulHealthEndpoint, error) {
+					return health, nil
+				},
+			)
+			t.Cleanup(cleanup)
+
+			r, err := NewBuilder(tt.opts...).Build(resolver.Target{URL: url.URL{Path: "wait-time-test", RawQuery: tt.query}}, mocks.NewClientConn(), resolver.BuildOptions{})
+			if err != nil {
+				t.Fatal("Build() failed:", err.Error())
+			}
+			defer r.Close()
+
+			for health.LastQueryOptions() == nil {
+				time.Sleep(time.Millisecond)
+			}
+
+			if wt := health.LastQueryOptions().WaitTime; wt != tt.want {
+				t.Errorf("query wait time is %s, expected %s", wt, tt.want)
+			}
+
+			if h, _ := findResolverHealth("wait-time-test"); h.WaitTime != tt.want {
+				t.Errorf("resolver health reports wait time %s, expected %s", h.WaitTime, tt.want)
+			}
+		})
+	}
+}
+
+func TestWaitTimeIsShortenedAfterErrors(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	target, err := ParseTarget("consul:///adaptive-wait-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := newConsulResolver(mocks.NewClientConn(), target, &builderOptions{waitTime: 4 * minAdaptiveWaitTime})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	health.SetRespServiceEntries([]*consul.AgentService{{Address: "127.0.0.1", Port: 1}})
+	health.SetRespError(errors.New("unavailable"))
+	r.poll()
+	health.SetRespError(nil)
+
+	for i, want := range []time.Duration{
+		minAdaptiveWaitTime,
+		2 * minAdaptiveWaitTime,
+		4 * minAdaptiveWaitTime,
+		4 * minAdaptiveWaitTime,
+	} {
+		r.poll()
+
+		if wt := health.LastQueryOptions().WaitTime; wt != want {
+			t.Errorf("query %d after the error had wait time %s, expected %s", i+1, wt, want)
+		}
+	}
+}
+
+func TestPortName(t *testing.T) {
+	entry := func(host string, meta map[string]string) *consul.ServiceEntry {
+		return &consul.ServiceEntry{
+			Service: &consul.AgentService{
+				Address: host,
+				Port:    8080,
+				Meta:    meta,
+			},
+		}
+	}
+
+	addrs := resolveOnce(t, "consul:///user-service?port-name=grpc", []*consul.ServiceEntry{
+		entry("127.0.0.1", map[string]string{PortMetaKeyPrefix + "grpc": "9090", PortMetaKeyPrefix + "admin": "9000"}),
+		entry("127.0.0.2", map[string]string{PortMetaKeyPrefix + "admin": "9000"}),
+		entry("127.0.0.3", map[string]string{PortMetaKeyPrefix + "grpc": "70000"}),
+		entry("127.0.0.4", map[string]string{PortMetaKeyPrefix + "grpc": "0"}),
+	})
+
+	if len(addrs) != 1 || addrs[0].Addr != "127.0.0.1:9090" {
+		t.Errorf("resolved to %+v, expected only 127.0.0.1:9090", addrs)
+	}
+}
+
+func TestSortByServiceID(t *testing.T) {
+	cc := mocks.NewClientConn()
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{ID: "b", Address: "127.0.0.1", Port: 1},
+		{ID: "a", Address: "127.0.0.2", Port: 1},
+	})
+
+	stats := recordingStatsHandler{}
+	b := NewBuilder(WithSortByServiceID(), WithStatsHandler(&stats))
+	r, err := b.Build(resolver.Target{URL: url.URL{Path: "test"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+	defer r.Close()
+
+	for cc.UpdateStateCallCnt() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if addrs := cc.Addrs(); len(addrs) != 2 || addrs[0].Addr != "127.0.0.2:1" || addrs[1].Addr != "127.0.0.1:1" {
+		t.Fatalf("resolved to %+v, expected the addresses to be sorted by service ID", addrs)
+	}
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{ID: "b", Address: "127.0.0.3", Port: 1},
+		{ID: "a", Address: "127.0.0.2", Port: 1},
+	})
+
+	waitForEvent(t, &stats, func(ev Event) bool {
+		e, ok := ev.(*StateUpdated)
+		return ok && len(e.Addresses) == 2 && e.Addresses[1].Addr == "127.0.0.3:1"
+	})
+
+	for _, ev := range stats.Events() {
+		if e, ok := ev.(*StateUpdated); ok && e.Addresses[1].Addr == "127.0.0.3:1" && (e.Added != 0 || e.Removed != 0) {
+			t.Errorf("address change of an instance was reported as %d added and %d removed addresses, expected 0", e.Added, e.Removed)
+		}
+	}
+}
+
+func TestSortByServiceIDIsStableForDuplicateIDs(t *testing.T) {
+	addr := func(node, addr string) resolver.Address {
+		e := consul.ServiceEntry{
+			Node:    &consul.Node{Node: node},
+			Service: &consul.AgentService{ID: "web"},
+		}
+		return resolver.Address{Addr: addr, BalancerAttributes: balancerAttributes(&e, false)}
+	}
+
+	a := []resolver.Address{addr("node-b", "10.0.0.1:80"), addr("node-a", "10.0.0.2:80"), addr("node-a", "10.0.0.3:80")}
+	b := []resolver.Address{a[2], a[1], a[0]}
+
+	sortAddresses(a, serviceIDKey)
+	sortAddresses(b, serviceIDKey)
+
+	if !addressesEqual(a, b) {
+		t.Errorf("sorting the same addresses in different orders resulted in %+v and %+v", a, b)
+	}
+
+	if a[0].Addr != "10.0.0.2:80" || a[2].Addr != "10.0.0.1:80" {
+		t.Errorf("addresses are sorted as %+v, expected them to be sorted by node and address", a)
+	}
+
+	if added, removed := addressChurn(a[:2], a[1:], serviceIDKey); added != 1 || removed != 1 {
+		t.Errorf("addressChurn() returned %d added, %d removed, expected 1 added and 1 removed", added, removed)
+	}
+}
+
+func TestStuckWatchDetection(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: 1},
+	})
+	health.SetRespIndex(10)
+
+	target, err := ParseTarget("consul:///stuck-watch-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := newFakeClock()
+	h := recordingStatsHandler{}
+	r, err := newConsulResolver(mocks.NewClientConn(), target, &builderOptions{
+		clock:        clock,
+		statsHandler: &h,
+		// republishing the unchanged addresses after every query
+		// prevents that poll() waits on the fake clock because the
+		// mock responds too fast with the same index
+		republishInterval: time.Nanosecond,
+		stuckThreshold:    time.Hour,
+		stuckRefresh:      true,
+	})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	stuckEvents := func() []*WatchStuck {
+		var res []*WatchStuck
+		for _, ev := range h.Events() {
+			if e, ok := ev.(*WatchStuck); ok {
+				res = append(res, e)
+			}
+		}
+		return res
+	}
+
+	r.poll()
+	clock.Advance(30 * time.Minute)
+	r.poll()
+	if evs := stuckEvents(); len(evs) != 0 {
+		t.Fatalf("got %d WatchStuck events before the threshold passed, expected none", len(evs))
+	}
+
+	clock.Advance(31 * time.Minute)
+	r.poll()
+	evs := stuckEvents()
+	if len(evs) != 1 {
+		t.Fatalf("got %d WatchStuck events after the threshold passed, expected 1", len(evs))
+	}
+	if evs[0].WaitIndex != 10 || evs[0].Unchanged != 61*time.Minute {
+		t.Errorf("got event with WaitIndex %d and Unchanged %s, expected 10 and 1h1m0s",
+			evs[0].WaitIndex, evs[0].Unchanged)
+	}
+
+	r.poll()
+	if wi := health.LastQueryOptions().WaitIndex; wi != 0 {
+		t.Errorf("query after the watch was detected as stuck had WaitIndex %d, expected a non-blocking query", wi)
+	}
+
+	health.SetRespIndex(11)
+	r.poll()
+	clock.Advance(59 * time.Minute)
+	r.poll()
+	if evs := stuckEvents(); len(evs) != 1 {
+		t.Errorf("got %d WatchStuck events after the index changed, expected 1", len(evs))
+	}
+}
+
+func TestNodeAddressOptions(t *testing.T) {
+	entries := []*consul.ServiceEntry{
+		{
+			Node: &consul.Node{
+				Address:         "10.0.0.1",
+				TaggedAddresses: map[string]string{"lan": "192.168.1.1"},
+			},
+			Service: &consul.AgentService{
+				Address:         "172.17.0.2",
+				Port:            8080,
+				TaggedAddresses: map[string]consul.ServiceAddress{"lan": {Address: "192.168.0.2", Port: 7070}},
+			},
+		},
+		{
+			Node:    &consul.Node{Address: "10.0.0.2"},
+			Service: &consul.AgentService{Port: 8080},
+		},
+	}
+
+	for _, tc := range []struct {
+		target string
+		want   []string
+	}{
+		{"consul:///user-service", []string{"10.0.0.2:8080", "172.17.0.2:8080"}},
+		{"consul:///user-service?prefer-node-address=true", []string{"10.0.0.1:8080", "10.0.0.2:8080"}},
+		{"consul:///user-service?require-service-address=true", []string{"172.17.0.2:8080"}},
+		{"consul:///user-service?translate-wan-addrs=false", []string{"10.0.0.2:8080", "192.168.0.2:7070"}},
+		{"consul:///user-service?translate-wan-addrs=false&prefer-node-address=true", []string{"10.0.0.2:8080", "192.168.1.1:7070"}},
+	} {
+		t.Run(tc.target, func(t *testing.T) {
+			addrs := resolveOnce(t, tc.target, entries)
+
+			var got []string
+			for _, a := range addrs {
+				got = append(got, a.Addr)
+			}
+
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("resolved to %v, expected %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestPortZeroPolicy(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{ID: "a", Address: "10.0.0.1", Port: 8080},
+		{ID: "b", Address: "10.0.0.2", Port: 0, Meta: map[string]string{PortMetaKey: "9090"}},
+		{ID: "c", Address: "10.0.0.3", Port: 0},
+	})
+
+	for _, tc := range []struct {
+		policy string
+		want   []string
+	}{
+		{"", []string{"10.0.0.1:8080", "10.0.0.2:0", "10.0.0.3:0"}},
+		{"skip", []string{"10.0.0.1:8080"}},
+		{"use-meta-port", []string{"10.0.0.1:8080", "10.0.0.2:9090"}},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			target := "consul:///port-zero-test"
+			if tc.policy != "" {
+				target += "?port-zero=" + tc.policy
+			}
+
+			addrs, err := Lookup(context.Background(), target)
+			if err != nil {
+				t.Fatal("Lookup() failed:", err)
+			}
+
+			var got []string
+			for _, a := range addrs {
+				got = append(got, a.Addr)
+			}
+
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("resolved to %v, expected %v", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		_, err := Lookup(context.Background(), "consul:///port-zero-test?port-zero=error")
+		if !errors.Is(err, ErrZeroPort) {
+			t.Fatalf("Lookup() returned error %v, expected ErrZeroPort", err)
+		}
+
+		if c := status.Code(withStatusCode(err)); c != codes.FailedPrecondition {
+			t.Errorf("error has status code %s, expected FailedPrecondition", c)
+		}
+	})
+}
+
+func TestKindFilter(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{ID: "web", Address: "10.0.0.1", Port: 8080},
+		{ID: "web-sidecar-proxy", Kind: consul.ServiceKindConnectProxy, Address: "10.0.0.1", Port: 21000},
+		{ID: "mesh-gateway", Kind: consul.ServiceKindMeshGateway, Address: "10.0.0.2", Port: 8443},
+	})
+
+	for _, tc := range []struct {
+		kind string
+		want []string
+	}{
+		{"", []string{"10.0.0.1:21000", "10.0.0.1:8080", "10.0.0.2:8443"}},
+		{"typical", []string{"10.0.0.1:8080"}},
+		{"connect-proxy", []string{"10.0.0.1:21000"}},
+		{"mesh-gateway", []string{"10.0.0.2:8443"}},
+		{"ingress-gateway", nil},
+	} {
+		t.Run(tc.kind, func(t *testing.T) {
+			target := "consul:///kind-test"
+			if tc.kind != "" {
+				target += "?kind=" + tc.kind
+			}
+
+			addrs, err := Lookup(context.Background(), target)
+			if err != nil {
+				t.Fatal("Lookup() failed:", err)
+			}
+
+			var got []string
+			for _, a := range addrs {
+				got = append(got, a.Addr)
+			}
+
+			if !slices.Equal(got, tc.want) {
+				t.Errorf("resolved to %v, expected %v", got, tc.want)
+			}
+		})
+	}
+
+	if _, err := ParseTarget("consul:///kind-test?kind=api-gateway"); !errors.Is(err, ErrInvalidOptionValue) {
+		t.Errorf("ParseTarget() returned error %v for an unknown kind, expected ErrInvalidOptionValue", err)
+	}
+}
+
+func TestPreserveOrder(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "10.0.0.3", Port: 1},
+		{Address: "10.0.0.1", Port: 1},
+		{Address: "10.0.0.2", Port: 1},
+	})
+	health.SetRespIndex(1)
+
+	target, err := ParseTarget("consul:///preserve-order-test?preserve-order=true")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc := mocks.NewClientConn()
+	r, err := newConsulResolver(cc, target, &builderOptions{clock: newFakeClock()})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	addrStrings := func() []string {
+		var res []string
+		for _, a := range cc.Addrs() {
+			res = append(res, a.Addr)
+		}
+		return res
+	}
+
+	r.poll()
+	if got, want := addrStrings(), []string{"10.0.0.3:1", "10.0.0.1:1", "10.0.0.2:1"}; !slices.Equal(got, want) {
+		t.Fatalf("resolved to %v, expected the order returned by consul %v", got, want)
+	}
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "10.0.0.1", Port: 1},
+		{Address: "10.0.0.2", Port: 1},
+		{Address: "10.0.0.3", Port: 1},
+	})
+	health.SetRespIndex(2)
+	r.poll()
+	if cnt := cc.UpdateStateCallCnt(); cnt != 1 {
+		t.Errorf("UpdateState was called %d times, expected 1, a changed order must not cause an update", cnt)
+	}
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "10.0.0.2", Port: 1},
+		{Address: "10.0.0.4", Port: 1},
+		{Address: "10.0.0.1", Port: 1},
+	})
+	health.SetRespIndex(3)
+	r.poll()
+	if got, want := addrStrings(), []string{"10.0.0.2:1", "10.0.0.4:1", "10.0.0.1:1"}; !slices.Equal(got, want) {
+		t.Errorf("resolved to %v after the addresses changed, expected %v", got, want)
+	}
+}
+
+func TestMinHealthyFraction(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	entries := func(statuses ...string) []*consul.ServiceEntry {
+		var result []*consul.ServiceEntry
+		for i, s := range statuses {
+			result = append(result, &consul.ServiceEntry{
+				Service: &consul.AgentService{Address: fmt.Sprintf("10.0.0.%d", i+1), Port: 1},
+				Checks:  consul.HealthChecks{{Name: "grpc", Status: s}},
+			})
+		}
+		return result
+	}
+
+	health.SetRespEntries(entries(consul.HealthPassing, consul.HealthWarning, consul.HealthCritical, consul.HealthCritical))
+	health.SetRespIndex(1)
+
+	target, err := ParseTarget("consul:///min-healthy-test?min-healthy-fraction=0.3")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc := mocks.NewClientConn()
+	r, err := newConsulResolver(cc, target, &builderOptions{clock: newFakeClock()})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	r.poll()
+	if got := addrStrings(cc.Addrs()); len(got) != 4 {
+		t.Errorf("resolved to %v, expected all 4 instances when only 1 of 4 is passing", got)
+	}
+
+	health.SetRespEntries(entries(consul.HealthPassing, consul.HealthPassing, consul.HealthCritical, consul.HealthCritical))
+	health.SetRespIndex(2)
+	r.poll()
+	if got, want := addrStrings(cc.Addrs()), []string{"10.0.0.1:1", "10.0.0.2:1"}; !slices.Equal(got, want) {
+		t.Errorf("resolved to %v, expected only the passing instances %v", got, want)
+	}
+}
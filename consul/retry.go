@@ -0,0 +1,57 @@
+package consul
+
+import (
+	"fmt"
+	"time"
+)
+
+// retryBudget limits how long a resolver retries failing queries.
+// It is only accessed by the goroutine that runs poll().
+type retryBudget struct {
+	// attempts is the maximum number of consecutive failed queries, 0
+	// if it is not limited.
+	attempts int
+	// duration is the maximum time since the first of the consecutive
+	// failed queries, 0 if it is not limited.
+	duration time.Duration
+
+	failures     int
+	firstFailure time.Time
+}
+
+// failed records a failed query. If the budget is exhausted, an error
+// wrapping [ErrRetryBudgetExhausted] and err is returned.
+func (b *retryBudget) failed(now time.Time, err error) error {
+	if b.failures == 0 {
+		b.firstFailure = now
+	}
+	b.failures++
+
+	failingFor := now.Sub(b.firstFailure)
+	if (b.attempts > 0 && b.failures >= b.attempts) || (b.duration > 0 && failingFor >= b.duration) {
+		return fmt.Errorf("%w: %d queries failed within %s: %w", ErrRetryBudgetExhausted, b.failures, failingFor, err)
+	}
+
+	return nil
+}
+
+// succeeded resets the budget.
+func (b *retryBudget) succeeded() {
+	b.failures = 0
+	b.firstFailure = time.Time{}
+}
+
+// stopExhausted reports err to the ClientConn and stops the resolver, after
+// its retry budget was exhausted.
+func (c *consulResolver) stopExhausted(err error) {
+	c.log.warningf("grpc-consul-resolver: resolving service '%s' failed, stopping: %v", c.service, err)
+	c.status.tracef("stopped: %v", err)
+
+	err = withStatusCode(err)
+	c.clientConn.ReportError(err)
+	c.emit(&ErrorReported{Service: c.service, Err: err})
+
+	// the goroutines of the resolver terminate and ResolveNow() does not
+	// start new queries, Close() still has to be called
+	c.cancel()
+}
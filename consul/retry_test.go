@@ -0,0 +1,84 @@
+package consul
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestRetryBudget(t *testing.T) {
+	queryErr := errors.New("connection refused")
+
+	for _, tc := range []struct {
+		name string
+		opts builderOptions
+		// steps are the durations the clock is advanced before each
+		// failed query
+		steps []time.Duration
+	}{
+		{"attempts", builderOptions{retryAttempts: 3}, []time.Duration{0, time.Hour, time.Hour}},
+		{"duration", builderOptions{retryDuration: time.Minute}, []time.Duration{0, 30 * time.Second, 31 * time.Second}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			health := mocks.NewConsulHealthClient()
+			cleanup := replaceCreateHealthClientFn(
+				func(cfg *consul.Config) (consulHealthEndpoint, error) {
+					return health, nil
+				},
+			)
+			t.Cleanup(cleanup)
+
+			health.SetRespServiceEntries([]*consul.AgentService{{Address: "127.0.0.1", Port: 1}})
+			health.SetRespIndex(1)
+
+			target, err := ParseTarget("consul:///retry-budget-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			clock := newFakeClock()
+			cc := mocks.NewClientConn()
+			opts := tc.opts
+			opts.clock = clock
+			r, err := newConsulResolver(cc, target, &opts)
+			if err != nil {
+				t.Fatal("newConsulResolver() failed:", err)
+			}
+			defer r.Close()
+
+			// a successful query between failures resets the budget
+			health.SetRespError(queryErr)
+			r.poll()
+			health.SetRespError(nil)
+			health.SetRespIndex(2)
+			r.poll()
+			health.SetRespError(queryErr)
+
+			for i, d := range tc.steps {
+				clock.Advance(d)
+				r.poll()
+
+				last := i == len(tc.steps)-1
+				reported := cc.LastReportedError()
+				if exhausted := errors.Is(reported, ErrRetryBudgetExhausted); exhausted != last {
+					t.Fatalf("after %d failed queries the reported error is %v, expected ErrRetryBudgetExhausted: %t", i+1, reported, last)
+				}
+			}
+
+			reported := cc.LastReportedError()
+			if !errors.Is(reported, queryErr) || status.Code(reported) != codes.FailedPrecondition {
+				t.Errorf("reported error is %v, expected one wrapping %v with code FailedPrecondition", reported, queryErr)
+			}
+
+			if r.ctx.Err() == nil {
+				t.Error("resolver was not stopped after the retry budget was exhausted")
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+// Package ringhash provides a gRPC load balancer that maps the keys of
+// requests with consistent hashing onto a ring of the Consul service
+// instances, for cache-affinity workloads.
+//
+// Instances are placed on the ring by their node name and service ID, as
+// attached to the addresses by the consul resolver, and occupy a share of the
+// ring proportional to their Consul service weight. Ring entries with the same
+// hash are ordered by the address. Addresses without an instance key are
+// placed by their address, addresses without a weight have the weight
+// 1. The share of instances that warm up because of the slow-start target
+// option is reduced by their [consul.WarmUpFactor]. The weights of instances
+// of composite targets are multiplied by their [consul.SplitWeight]. Only
+// instances with a ready connection are part of the ring.
+//
+// The balancer is registered with the name [Name] and uses the value of the
+// [DefaultHeader] metadata header as key. It can be selected with the service
+// config:
+//
+//	grpc.Dial("consul://127.0.0.1:8500/user-service",
+//		grpc.WithDefaultServiceConfig(`{"loadBalancingConfig": [{"consul_ring_hash":{}}]}`),
+//	)
+package ringhash
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/consul"
+	"github.com/simplesurance/grpcconsulresolver/consul/affinity"
+	"github.com/simplesurance/grpcconsulresolver/internal/keyhash"
+)
+
+// Name is the name of the balancer registered by the package.
+const Name = "consul_ring_hash"
+
+// DefaultHeader is the metadata header that contains the key of requests
+// sent via the balancer registered by the package.
+const DefaultHeader = "x-ring-hash-key"
+
+const (
+	// entriesPerWeight is the number of ring entries of an instance per
+	// unit of its weight.
+	entriesPerWeight = 100
+	// maxRingSize is the maximum number of entries of the ring, the
+	// entries of the instances are scaled down to it.
+	maxRingSize = 1 << 16
+)
+
+func init() {
+	balancer.Register(NewBuilder(Name, affinity.MetadataKey(DefaultHeader)))
+}
+
+// NewBuilder returns a balancer builder with the name that determines the
+// key of requests with key. It can be registered with [balancer.Register] to
+// use a different key than the balancer registered by the package.
+// Requests with an empty key are sent to a random instance.
+func NewBuilder(name string, key affinity.KeyFunc) balancer.Builder {
+	return base.NewBalancerBuilder(name, &pickerBuilder{key: key}, base.Config{HealthCheck: true})
+}
+
+type pickerBuilder struct {
+	key affinity.KeyFunc
+}
+
+type ringEntry struct {
+	hash uint64
+	id   string
+	addr string
+	sc   balancer.SubConn
+}
+
+func (b *pickerBuilder) Build(info base.PickerBuildInfo) balancer.Picker {
+	if len(info.ReadySCs) == 0 {
+		return base.NewErrPicker(balancer.ErrNoSubConnAvailable)
+	}
+
+	type instance struct {
+		sc     balancer.SubConn
+		id     string
+		addr   string
+		weight float64
+	}
+
+	instances := make([]instance, 0, len(info.ReadySCs))
+	var totalWeight float64
+	for sc, sci := range info.ReadySCs {
+		w := weight(sci.Address)
+		instances = append(instances, instance{sc: sc, id: instanceID(sci.Address), addr: sci.Address.Addr, weight: w})
+		totalWeight += w
+	}
+
+	scale := 1.0
+	if totalWeight*entriesPerWeight > maxRingSize {
+		scale = maxRingSize / (totalWeight * entriesPerWeight)
+	}
+
+	var ring []ringEntry
+	for _, inst := range instances {
+		entries := max(1, int(inst.weight*entriesPerWeight*scale))
+		for i := 0; i < entries; i++ {
+			ring = append(ring, ringEntry{
+				hash: keyhash.Sum64(inst.id, strconv.Itoa(i)),
+				id:   inst.id,
+				addr: inst.addr,
+				sc:   inst.sc,
+			})
+		}
+	}
+
+	sort.Slice(ring, func(i, j int) bool {
+		if ring[i].hash != ring[j].hash {
+			return ring[i].hash < ring[j].hash
+		}
+
+		if ring[i].id != ring[j].id {
+			return ring[i].id < ring[j].id
+		}
+
+		return ring[i].addr < ring[j].addr
+	})
+
+	scs := make([]balancer.SubConn, 0, len(instances))
+	for _, inst := range instances {
+		scs = append(scs, inst.sc)
+	}
+
+	return &picker{key: b.key, ring: ring, scs: scs}
+}
+
+// instanceID returns the Consul instance key of addr, or its address if it
+// is unknown.
+func instanceID(addr resolver.Address) string {
+	if k, ok := consul.InstanceKey(addr); ok {
+		return k
+	}
+
+	return addr.Addr
+}
+
+// weight returns the Consul service weight of addr, or 1 if it is unknown,
+// reduced by its warm-up factor and multiplied by its split weight.
+func weight(addr resolver.Address) float64 {
+	w := 1.0
+	if cw, ok := consul.Weight(addr); ok {
+		w = float64(cw)
+	}
+
+	if f, ok := consul.WarmUpFactor(addr); ok {
+		w *= f
+	}
+
+	if s, ok := consul.SplitWeight(addr); ok {
+		w *= s
+	}
+
+	return w
+}
+
+type picker struct {
+	key  affinity.KeyFunc
+	ring []ringEntry
+	scs  []balancer.SubConn
+}
+
+func (p *picker) Pick(info balancer.PickInfo) (balancer.PickResult, error) {
+	key := p.key(info.Ctx, info.FullMethodName)
+	if key == "" {
+		return balancer.PickResult{SubConn: p.scs[rand.Intn(len(p.scs))]}, nil
+	}
+
+	h := keyhash.Sum64(key)
+	i := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+	if i == len(p.ring) {
+		i = 0
+	}
+
+	return balancer.PickResult{SubConn: p.ring[i].sc}, nil
+}
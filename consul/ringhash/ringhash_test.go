@@ -0,0 +1,178 @@
+package ringhash
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/balancer"
+	"google.golang.org/grpc/balancer/base"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/consul"
+	"github.com/simplesurance/grpcconsulresolver/consul/affinity"
+)
+
+type fakeSubConn struct {
+	balancer.SubConn
+	addr string
+}
+
+// lookup resolves the services via a fake Consul server, to get addresses
+// with the attributes set by the consul resolver.
+func lookup(t *testing.T, services ...*consulapi.AgentService) []resolver.Address {
+	t.Helper()
+
+	entries := make([]*consulapi.ServiceEntry, 0, len(services))
+	for _, s := range services {
+		entries = append(entries, &consulapi.ServiceEntry{Service: s})
+	}
+
+	return lookupEntries(t, entries)
+}
+
+// lookupEntries resolves entries via a fake Consul server.
+func lookupEntries(t *testing.T, entries []*consulapi.ServiceEntry) []resolver.Address {
+	t.Helper()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Consul-Index", "1")
+		_ = json.NewEncoder(w).Encode(entries)
+	}))
+	t.Cleanup(srv.Close)
+
+	addrs, err := consul.Lookup(context.Background(), fmt.Sprintf("consul://%s/cache", srv.Listener.Addr()))
+	if err != nil {
+		t.Fatal("Lookup() failed:", err)
+	}
+
+	return addrs
+}
+
+func service(id, addr string, weight int) *consulapi.AgentService {
+	return &consulapi.AgentService{
+		ID:      id,
+		Address: addr,
+		Port:    80,
+		Weights: consulapi.AgentWeights{Passing: weight, Warning: 1},
+	}
+}
+
+func buildPicker(addrs []resolver.Address) balancer.Picker {
+	info := base.PickerBuildInfo{ReadySCs: map[balancer.SubConn]base.SubConnInfo{}}
+	for _, a := range addrs {
+		info.ReadySCs[&fakeSubConn{addr: a.Addr}] = base.SubConnInfo{Address: a}
+	}
+
+	return (&pickerBuilder{key: affinity.MetadataKey(DefaultHeader)}).Build(info)
+}
+
+func pickAll(t *testing.T, p balancer.Picker, keys int) []string {
+	t.Helper()
+
+	result := make([]string, keys)
+	for i := range result {
+		ctx := metadata.AppendToOutgoingContext(context.Background(), DefaultHeader, fmt.Sprintf("object-%d", i))
+
+		res, err := p.Pick(balancer.PickInfo{Ctx: ctx, FullMethodName: "/cache/Get"})
+		if err != nil {
+			t.Fatal("Pick() failed:", err)
+		}
+
+		result[i] = res.SubConn.(*fakeSubConn).addr
+	}
+
+	return result
+}
+
+func TestKeysAreDistributedByWeight(t *testing.T) {
+	p := buildPicker(lookup(t,
+		service("a", "10.0.0.1", 1),
+		service("b", "10.0.0.2", 3),
+	))
+
+	first := pickAll(t, p, 4000)
+	second := pickAll(t, p, 4000)
+
+	perInstance := map[string]int{}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("key %d was picked for %s and %s", i, first[i], second[i])
+		}
+		perInstance[first[i]]++
+	}
+
+	// instance b has 3 times the weight of a and should get about 75%
+	// of the keys
+	if share := float64(perInstance["10.0.0.2:80"]) / 4000; share < 0.65 || share > 0.85 {
+		t.Errorf("instance with 75%% of the weight got %.2f of the keys, distribution: %v", share, perInstance)
+	}
+}
+
+func TestOnlyKeysOfRemovedInstanceMove(t *testing.T) {
+	before := pickAll(t, buildPicker(lookup(t,
+		service("a", "10.0.0.1", 1),
+		service("b", "10.0.0.2", 1),
+		service("c", "10.0.0.3", 1),
+	)), 500)
+	after := pickAll(t, buildPicker(lookup(t,
+		service("a", "10.0.0.1", 1),
+		service("b", "10.0.0.2", 1),
+	)), 500)
+
+	for i := range before {
+		if before[i] != "10.0.0.3:80" && before[i] != after[i] {
+			t.Errorf("key %d moved from %s to %s, expected only keys of the removed instance to move", i, before[i], after[i])
+		}
+	}
+}
+
+func TestSameServiceIDOnDifferentNodes(t *testing.T) {
+	var entries []*consulapi.ServiceEntry
+	for i := 1; i <= 3; i++ {
+		entries = append(entries, &consulapi.ServiceEntry{
+			Node:    &consulapi.Node{Node: fmt.Sprintf("node-%d", i)},
+			Service: service("cache", fmt.Sprintf("10.0.0.%d", i), 1),
+		})
+	}
+	addrs := lookupEntries(t, entries)
+
+	first := pickAll(t, buildPicker(addrs), 300)
+
+	perInstance := map[string]int{}
+	for _, a := range first {
+		perInstance[a]++
+	}
+
+	if len(perInstance) != 3 {
+		t.Errorf("keys were distributed to %v, expected all 3 instances", perInstance)
+	}
+
+	for n := 0; n < 10; n++ {
+		next := pickAll(t, buildPicker(addrs), 300)
+		for i := range first {
+			if first[i] != next[i] {
+				t.Fatalf("key %d was picked for %s and %s after rebuilding the picker", i, first[i], next[i])
+			}
+		}
+	}
+}
+
+func TestCollidingInstancesArePickedDeterministically(t *testing.T) {
+	addrs := lookup(t, service("cache", "10.0.0.1", 1), service("cache", "10.0.0.2", 1))
+
+	first := pickAll(t, buildPicker(addrs), 100)
+	for n := 0; n < 10; n++ {
+		next := pickAll(t, buildPicker(addrs), 100)
+		for i := range first {
+			if first[i] != next[i] {
+				t.Fatalf("key %d was picked for %s and %s after rebuilding the picker", i, first[i], next[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package consul
+
+import (
+	"sort"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+type consulCatalogEndpoint interface {
+	ServiceMultipleTags(service string, tags []string, q *consul.QueryOptions) ([]*consul.CatalogService, *consul.QueryMeta, error)
+}
+
+// consulCreateCatalogClientFn can be overwritten in tests to make
+// newConsulResolver() return a different consulCatalogEndpoint implementation
+var consulCreateCatalogClientFn = func(cfg *consul.Config) (consulCatalogEndpoint, error) {
+	clt, err := consul.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return clt.Catalog(), nil
+}
+
+// catalogSelfCheck compares the instances returned by the health and the
+// catalog endpoints every selfCheckInterval until the resolver is closed.
+func (c *consulResolver) catalogSelfCheck() {
+	defer c.wgStop.Done()
+
+	ticker := c.clock.NewTicker(c.selfCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C():
+		}
+
+		c.compareCatalog()
+	}
+}
+
+// compareCatalog queries the instances of the service from the health and
+// the catalog endpoint and reports instances that are only returned by one
+// of them.
+func (c *consulResolver) compareCatalog() {
+	c.mu.Lock()
+	tags := c.settings.tags
+	c.mu.Unlock()
+
+	opts := c.selfCheckOpts.WithContext(c.ctx)
+	c.setToken(opts)
+
+	entries, _, err := c.fetch(tags, false, opts)
+	if err != nil {
+		if c.ctx.Err() == nil {
+			c.log.warningf("grpc-consul-resolver: querying health of service '%s' for the catalog self-check failed: %v",
+				c.service, redactError(err, c.secrets))
+		}
+		return
+	}
+
+	catalog, _, err := c.consulCatalog.ServiceMultipleTags(c.service, tags, opts)
+	if err != nil {
+		if c.ctx.Err() == nil {
+			c.log.warningf("grpc-consul-resolver: querying catalog of service '%s' for the catalog self-check failed: %v",
+				c.service, redactError(err, c.secrets))
+		}
+		return
+	}
+
+	inHealth := make(map[string]struct{}, len(entries))
+	for _, e := range entries {
+		node := ""
+		if e.Node != nil {
+			node = e.Node.Node
+		}
+		inHealth[node+"/"+e.Service.ID] = struct{}{}
+	}
+
+	var missingFromHealth []string
+	for _, s := range catalog {
+		key := s.Node + "/" + s.ServiceID
+		if _, exists := inHealth[key]; exists {
+			delete(inHealth, key)
+			continue
+		}
+		missingFromHealth = append(missingFromHealth, key)
+	}
+
+	missingFromCatalog := make([]string, 0, len(inHealth))
+	for key := range inHealth {
+		missingFromCatalog = append(missingFromCatalog, key)
+	}
+
+	if len(missingFromHealth) == 0 && len(missingFromCatalog) == 0 {
+		return
+	}
+
+	sort.Strings(missingFromHealth)
+	sort.Strings(missingFromCatalog)
+
+	c.log.warningf("grpc-consul-resolver: health and catalog of service '%s' differ, instances missing from health: %v, missing from catalog: %v",
+		c.service, missingFromHealth, missingFromCatalog)
+	c.emit(&CatalogMismatch{
+		Service:            c.service,
+		MissingFromHealth:  missingFromHealth,
+		MissingFromCatalog: missingFromCatalog,
+	})
+}
@@ -0,0 +1,105 @@
+package consul
+
+import (
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+type fakeCatalog struct {
+	mu       sync.Mutex
+	services []*consul.CatalogService
+}
+
+func (c *fakeCatalog) ServiceMultipleTags(_ string, _ []string, _ *consul.QueryOptions) ([]*consul.CatalogService, *consul.QueryMeta, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.services, &consul.QueryMeta{}, nil
+}
+
+func (c *fakeCatalog) set(services ...*consul.CatalogService) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.services = services
+}
+
+func TestCatalogSelfCheckReportsMismatches(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespEntries([]*consul.ServiceEntry{
+		{Node: &consul.Node{Node: "node-1"}, Service: &consul.AgentService{ID: "a"}},
+		{Node: &consul.Node{Node: "node-3"}, Service: &consul.AgentService{ID: "c"}},
+	})
+
+	catalog := &fakeCatalog{}
+	oldCatalogFn := consulCreateCatalogClientFn
+	consulCreateCatalogClientFn = func(cfg *consul.Config) (consulCatalogEndpoint, error) {
+		return catalog, nil
+	}
+	t.Cleanup(func() { consulCreateCatalogClientFn = oldCatalogFn })
+
+	target, err := ParseTarget("consul:///self-check-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := recordingStatsHandler{}
+	r, err := newConsulResolver(mocks.NewClientConn(), target, &builderOptions{
+		statsHandler:      &h,
+		selfCheckInterval: time.Minute,
+	})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	mismatches := func() []*CatalogMismatch {
+		var res []*CatalogMismatch
+		for _, ev := range h.Events() {
+			if e, ok := ev.(*CatalogMismatch); ok {
+				res = append(res, e)
+			}
+		}
+		return res
+	}
+
+	catalog.set(
+		&consul.CatalogService{Node: "node-1", ServiceID: "a"},
+		&consul.CatalogService{Node: "node-2", ServiceID: "b"},
+	)
+	r.compareCatalog()
+
+	evs := mismatches()
+	if len(evs) != 1 {
+		t.Fatalf("got %d CatalogMismatch events, expected 1", len(evs))
+	}
+	if !slices.Equal(evs[0].MissingFromHealth, []string{"node-2/b"}) {
+		t.Errorf("instances missing from health are %v, expected [node-2/b]", evs[0].MissingFromHealth)
+	}
+	if !slices.Equal(evs[0].MissingFromCatalog, []string{"node-3/c"}) {
+		t.Errorf("instances missing from catalog are %v, expected [node-3/c]", evs[0].MissingFromCatalog)
+	}
+
+	catalog.set(
+		&consul.CatalogService{Node: "node-1", ServiceID: "a"},
+		&consul.CatalogService{Node: "node-3", ServiceID: "c"},
+	)
+	r.compareCatalog()
+
+	if evs := mismatches(); len(evs) != 1 {
+		t.Errorf("got %d CatalogMismatch events after the catalog matched health, expected still 1", len(evs))
+	}
+}
@@ -0,0 +1,51 @@
+package consul
+
+import (
+	"reflect"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+)
+
+// serviceEntry is the attribute value of a trimmed [consul.ServiceEntry].
+type serviceEntry struct {
+	entry *consul.ServiceEntry
+}
+
+// Equal returns true if o is a serviceEntry with equal content. It allows
+// comparing attributes containing the entry, to not pass unchanged
+// addresses to the gRPC channel again.
+func (s serviceEntry) Equal(o any) bool {
+	os, ok := o.(serviceEntry)
+	return ok && reflect.DeepEqual(s.entry, os.entry)
+}
+
+// trimServiceEntry returns a copy of e without the fields of its health
+// checks that change frequently without changing their status, like their
+// output, and without their definitions.
+func trimServiceEntry(e *consul.ServiceEntry) *consul.ServiceEntry {
+	result := *e
+
+	result.Checks = make(consul.HealthChecks, 0, len(e.Checks))
+	for _, c := range e.Checks {
+		tc := *c
+		tc.Output = ""
+		tc.Notes = ""
+		tc.Definition = consul.HealthCheckDefinition{}
+		tc.CreateIndex = 0
+		tc.ModifyIndex = 0
+		result.Checks = append(result.Checks, &tc)
+	}
+
+	return &result
+}
+
+// ServiceEntryOf returns the Consul service entry addr was resolved from.
+// The output, notes and definitions of its health checks are removed.
+// The entry is shared by all users of the address and must not be modified.
+// It is only available when the builder was created with
+// [WithServiceEntryAttribute].
+func ServiceEntryOf(addr resolver.Address) (*consul.ServiceEntry, bool) {
+	s, ok := addr.BalancerAttributes.Value(serviceEntryAttributeKey).(serviceEntry)
+	return s.entry, ok
+}
@@ -0,0 +1,170 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// sharedHealth runs identical health queries of the resolvers of a builder
+// only once. The queries are sent without tags, the tags of each resolver
+// are matched locally, so resolvers that watch the same service with
+// different tags share the queries too.
+// Identical blocking queries of resolvers converge: after the first
+// response they wait for the same index and join the same in-flight query.
+type sharedHealth struct {
+	mu    sync.Mutex
+	calls map[healthQueryKey]*healthCall
+}
+
+func newSharedHealth() *sharedHealth {
+	return &sharedHealth{calls: map[healthQueryKey]*healthCall{}}
+}
+
+// healthQueryKey identifies health queries with the same result.
+type healthQueryKey struct {
+	// endpoint identifies the Consul agent or servers and the
+	// datacenter.
+	endpoint    string
+	service     string
+	passingOnly bool
+	token       string
+	waitIndex   uint64
+	// options contains the other query options that change the result.
+	options string
+}
+
+// healthCall is an in-flight health query.
+type healthCall struct {
+	done    chan struct{}
+	entries []*consul.ServiceEntry
+	meta    *consul.QueryMeta
+	err     error
+
+	// waiters is the number of callers waiting for the result, the
+	// query is canceled when it drops to 0.
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// sharedHealthEndpoint is the consulHealthEndpoint of a resolver that joins
+// the queries of other resolvers with the same endpoint.
+type sharedHealthEndpoint struct {
+	shared   *sharedHealth
+	health   consulHealthEndpoint
+	endpoint string
+}
+
+func (s *sharedHealthEndpoint) ServiceMultipleTags(service string, tags []string, passingOnly bool, q *consul.QueryOptions) ([]*consul.ServiceEntry, *consul.QueryMeta, error) {
+	key := healthQueryKey{
+		endpoint:    s.endpoint,
+		service:     service,
+		passingOnly: passingOnly,
+		token:       q.Token,
+		waitIndex:   q.WaitIndex,
+		options: fmt.Sprintf("%s|%s|%s|%s|%v|%t|%t|%t|%s|%s",
+			q.Datacenter, q.Namespace, q.Partition, q.Filter, q.NodeMeta,
+			q.AllowStale, q.RequireConsistent, q.UseCache, q.MaxAge, q.Near),
+	}
+
+	call := s.shared.join(key, func(ctx context.Context) ([]*consul.ServiceEntry, *consul.QueryMeta, error) {
+		qc := *q
+		return s.health.ServiceMultipleTags(service, nil, passingOnly, qc.WithContext(ctx))
+	})
+
+	ctx := q.Context()
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		s.shared.leave(key, call)
+		return nil, nil, ctx.Err()
+	}
+
+	if call.err != nil {
+		return nil, nil, call.err
+	}
+
+	return filterTags(call.entries, tags), call.meta, nil
+}
+
+// join returns the in-flight call for key or starts a new one that runs
+// query.
+func (s *sharedHealth) join(
+	key healthQueryKey,
+	query func(context.Context) ([]*consul.ServiceEntry, *consul.QueryMeta, error),
+) *healthCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if call, exists := s.calls[key]; exists {
+		call.waiters++
+		return call
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	call := healthCall{done: make(chan struct{}), waiters: 1, cancel: cancel}
+	s.calls[key] = &call
+
+	go func() {
+		entries, meta, err := query(ctx)
+
+		s.mu.Lock()
+		if s.calls[key] == &call {
+			delete(s.calls, key)
+		}
+		s.mu.Unlock()
+
+		call.entries, call.meta, call.err = entries, meta, err
+		cancel()
+		close(call.done)
+	}()
+
+	return &call
+}
+
+// leave removes a waiter from call, the call is canceled if it was the last
+// one.
+func (s *sharedHealth) leave(key healthQueryKey, call *healthCall) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	call.waiters--
+	if call.waiters > 0 {
+		return
+	}
+
+	if s.calls[key] == call {
+		delete(s.calls, key)
+	}
+	call.cancel()
+}
+
+// filterTags returns the entries whose service has all tags. Tags are
+// matched case-insensitively, like by Consul.
+func filterTags(entries []*consul.ServiceEntry, tags []string) []*consul.ServiceEntry {
+	result := make([]*consul.ServiceEntry, 0, len(entries))
+
+	for _, e := range entries {
+		if hasAllTagsFold(e.Service.Tags, tags) {
+			result = append(result, e)
+		}
+	}
+
+	return result
+}
+
+// hasAllTagsFold returns true if serviceTags contains all tags, ignoring
+// their case.
+func hasAllTagsFold(serviceTags, tags []string) bool {
+	for _, t := range tags {
+		if !slices.ContainsFunc(serviceTags, func(st string) bool { return strings.EqualFold(st, t) }) {
+			return false
+		}
+	}
+
+	return true
+}
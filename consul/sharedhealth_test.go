@@ -0,0 +1,156 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// blockingHealth is a consulHealthEndpoint whose queries block until
+// release is closed or their context is canceled.
+type blockingHealth struct {
+	release chan struct{}
+	entries []*consul.ServiceEntry
+
+	mu       sync.Mutex
+	calls    int
+	canceled int
+	tags     [][]string
+}
+
+func (h *blockingHealth) ServiceMultipleTags(_ string, tags []string, _ bool, q *consul.QueryOptions) ([]*consul.ServiceEntry, *consul.QueryMeta, error) {
+	h.mu.Lock()
+	h.calls++
+	h.tags = append(h.tags, tags)
+	h.mu.Unlock()
+
+	select {
+	case <-h.release:
+		return h.entries, &consul.QueryMeta{LastIndex: 2}, nil
+	case <-q.Context().Done():
+		h.mu.Lock()
+		h.canceled++
+		h.mu.Unlock()
+		return nil, nil, q.Context().Err()
+	}
+}
+
+func (h *blockingHealth) stats() (calls, canceled int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.calls, h.canceled
+}
+
+// waitForWaiters waits until the in-flight queries of s have n waiters.
+func waitForWaiters(t *testing.T, s *sharedHealth, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		var waiters int
+		for _, c := range s.calls {
+			waiters += c.waiters
+		}
+		s.mu.Unlock()
+
+		if waiters == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("in-flight queries have %d waiters, expected %d", waiters, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestSharedHealthQueriesFilterTagsLocally(t *testing.T) {
+	health := blockingHealth{
+		release: make(chan struct{}),
+		entries: []*consul.ServiceEntry{
+			{Service: &consul.AgentService{ID: "primary-1", Tags: []string{"Primary"}}},
+			{Service: &consul.AgentService{ID: "backup-1", Tags: []string{"backup"}}},
+			{Service: &consul.AgentService{ID: "both-1", Tags: []string{"primary", "backup"}}},
+		},
+	}
+	shared := newSharedHealth()
+
+	results := make([][]string, 2)
+	var wg sync.WaitGroup
+	for i, tags := range [][]string{{"primary"}, {"backup"}} {
+		endpoint := sharedHealthEndpoint{shared: shared, health: &health, endpoint: "127.0.0.1:8500"}
+
+		wg.Add(1)
+		go func(i int, tags []string) {
+			defer wg.Done()
+
+			q := consul.QueryOptions{WaitIndex: 1}
+			entries, meta, err := endpoint.ServiceMultipleTags("user-service", tags, true, q.WithContext(context.Background()))
+			if err != nil || meta.LastIndex != 2 {
+				t.Errorf("ServiceMultipleTags() returned index %v and error %v, expected index 2", meta, err)
+				return
+			}
+			for _, e := range entries {
+				results[i] = append(results[i], e.Service.ID)
+			}
+		}(i, tags)
+	}
+
+	waitForWaiters(t, shared, 2)
+	close(health.release)
+	wg.Wait()
+
+	if calls, _ := health.stats(); calls != 1 || health.tags[0] != nil {
+		t.Errorf("consul was queried %d times with tags %v, expected 1 query without tags", calls, health.tags)
+	}
+
+	want := [][]string{{"primary-1", "both-1"}, {"backup-1", "both-1"}}
+	for i := range want {
+		if len(results[i]) != len(want[i]) || results[i][0] != want[i][0] || results[i][1] != want[i][1] {
+			t.Errorf("resolver %d got instances %v, expected %v", i, results[i], want[i])
+		}
+	}
+}
+
+func TestSharedHealthQueryCanceledByLastWaiter(t *testing.T) {
+	health := blockingHealth{release: make(chan struct{})}
+	shared := newSharedHealth()
+	endpoint := sharedHealthEndpoint{shared: shared, health: &health, endpoint: "127.0.0.1:8500"}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+
+	errs := make(chan error, 2)
+	for _, ctx := range []context.Context{ctx1, ctx2} {
+		go func(ctx context.Context) {
+			_, _, err := endpoint.ServiceMultipleTags("user-service", nil, true, (&consul.QueryOptions{}).WithContext(ctx))
+			errs <- err
+		}(ctx)
+	}
+	waitForWaiters(t, shared, 2)
+
+	cancel1()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled query returned %v, expected %v", err, context.Canceled)
+	}
+	waitForWaiters(t, shared, 1)
+	if _, canceled := health.stats(); canceled != 0 {
+		t.Error("the shared query was canceled while a resolver still waits for it")
+	}
+
+	cancel2()
+	<-errs
+	deadline := time.Now().Add(5 * time.Second)
+	for _, canceled := health.stats(); canceled != 1; _, canceled = health.stats() {
+		if time.Now().After(deadline) {
+			t.Fatal("the shared query was not canceled after all resolvers left")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
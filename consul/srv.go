@@ -0,0 +1,84 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// lookupSRV can be overwritten in tests to return fake SRV records.
+var lookupSRV = net.DefaultResolver.LookupSRV
+
+// srvDialer establishes connections to the targets of the SRV records of a
+// DNS name, or to a fixed list of servers. Each connection is established to
+// the next target, if it fails the other targets are tried.
+type srvDialer struct {
+	name string
+	// servers are the addresses of the servers, if set name is not
+	// looked up.
+	servers []string
+	dialer  net.Dialer
+	next    atomic.Uint32
+}
+
+func newSRVDialer(name string) *srvDialer {
+	// ConsulAddr can contain a port, it is ignored, the ports of the
+	// SRV records are used.
+	if host, _, err := net.SplitHostPort(name); err == nil {
+		name = host
+	}
+
+	return &srvDialer{name: name}
+}
+
+// newServersDialer returns a dialer that connects to the servers in turn.
+func newServersDialer(servers []string) *srvDialer {
+	return &srvDialer{servers: servers}
+}
+
+// targets returns the addresses to connect to.
+func (d *srvDialer) targets(ctx context.Context) ([]string, error) {
+	if len(d.servers) != 0 {
+		return d.servers, nil
+	}
+
+	_, records, err := lookupSRV(ctx, "", "", d.name)
+	if err != nil {
+		return nil, fmt.Errorf("looking up SRV records of %s failed: %w", d.name, err)
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no SRV records found for %s", d.name)
+	}
+
+	result := make([]string, 0, len(records))
+	for _, rec := range records {
+		result = append(result, net.JoinHostPort(strings.TrimSuffix(rec.Target, "."), strconv.Itoa(int(rec.Port))))
+	}
+
+	return result, nil
+}
+
+// DialContext connects to one of the targets, addr is ignored.
+func (d *srvDialer) DialContext(ctx context.Context, network, _ string) (net.Conn, error) {
+	targets, err := d.targets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	start := int(d.next.Add(1))
+	for i := range targets {
+		conn, err := d.dialer.DialContext(ctx, network, targets[(start+i)%len(targets)])
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+
+	return nil, errors.Join(errs...)
+}
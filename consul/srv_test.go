@@ -0,0 +1,142 @@
+package consul
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func listen(t *testing.T) net.Listener {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	return l
+}
+
+func replaceLookupSRV(t *testing.T, listeners ...net.Listener) {
+	t.Helper()
+
+	old := lookupSRV
+	t.Cleanup(func() { lookupSRV = old })
+
+	lookupSRV = func(_ context.Context, _, _, name string) (string, []*net.SRV, error) {
+		if name != "consul.service.internal" {
+			t.Errorf("SRV records of %q were looked up, expected consul.service.internal", name)
+		}
+
+		var result []*net.SRV
+		for _, l := range listeners {
+			_, port, _ := net.SplitHostPort(l.Addr().String())
+			p, _ := strconv.Atoi(port)
+			result = append(result, &net.SRV{Target: "127.0.0.1.", Port: uint16(p)})
+		}
+
+		return name, result, nil
+	}
+}
+
+func TestSRVDialerRotatesTargets(t *testing.T) {
+	l1 := listen(t)
+	l2 := listen(t)
+	replaceLookupSRV(t, l1, l2)
+
+	d := newSRVDialer("consul.service.internal:8500")
+
+	seen := map[string]int{}
+	for i := 0; i < 4; i++ {
+		conn, err := d.DialContext(context.Background(), "tcp", "ignored:1")
+		if err != nil {
+			t.Fatal("DialContext() failed:", err)
+		}
+		seen[conn.RemoteAddr().String()]++
+		_ = conn.Close()
+	}
+
+	if seen[l1.Addr().String()] != 2 || seen[l2.Addr().String()] != 2 {
+		t.Errorf("connections were established to %v, expected 2 to each target", seen)
+	}
+}
+
+func TestServersDialerSkipsUnreachableServers(t *testing.T) {
+	l1 := listen(t)
+	down := listen(t)
+	_ = down.Close()
+
+	d := newServersDialer([]string{down.Addr().String(), l1.Addr().String()})
+
+	for i := 0; i < 2; i++ {
+		conn, err := d.DialContext(context.Background(), "tcp", "ignored:1")
+		if err != nil {
+			t.Fatal("DialContext() failed:", err)
+		}
+		if got := conn.RemoteAddr().String(); got != l1.Addr().String() {
+			t.Errorf("connection was established to %s, expected %s", got, l1.Addr())
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestSRVDialerSkipsFailingTargets(t *testing.T) {
+	l1 := listen(t)
+	l2 := listen(t)
+	replaceLookupSRV(t, l1, l2)
+	_ = l1.Close()
+
+	d := newSRVDialer("consul.service.internal")
+
+	for i := 0; i < 2; i++ {
+		conn, err := d.DialContext(context.Background(), "tcp", "ignored:1")
+		if err != nil {
+			t.Fatal("DialContext() failed:", err)
+		}
+
+		if conn.RemoteAddr().String() != l2.Addr().String() {
+			t.Errorf("connection was established to %s, expected %s", conn.RemoteAddr(), l2.Addr())
+		}
+		_ = conn.Close()
+	}
+}
+
+func TestSRVDialerIsUsedByConsulClient(t *testing.T) {
+	var cfg *consul.Config
+	cleanup := replaceCreateHealthClientFn(
+		func(c *consul.Config) (consulHealthEndpoint, error) {
+			cfg = c
+			return mocks.NewConsulHealthClient(), nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	u := url.URL{Host: "consul.service.internal", Path: "test", RawQuery: "consul-srv=true"}
+	r, err := NewBuilder().Build(resolver.Target{URL: u}, mocks.NewClientConn(), resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+	r.Close()
+
+	if cfg.Transport == nil || cfg.Transport.DialContext == nil {
+		t.Error("consul client transport has no DialContext function")
+	}
+}
@@ -0,0 +1,209 @@
+package consul
+
+import (
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// StatsHandler receives events about the operation of resolvers.
+// It can be used to adapt the resolver to any monitoring system.
+//
+// HandleEvent is called synchronously from the resolver goroutines,
+// implementations must be safe for concurrent use and should return quickly.
+type StatsHandler interface {
+	HandleEvent(Event)
+}
+
+// Event is an event passed to a [StatsHandler].
+// It is one of [*QueryStarted], [*QueryFinished], [*StateUpdated],
+// [*UpdateRejected], [*ErrorReported], [*ConnectionChecked], [*WatchStuck],
+// [*ResolveNowDeferred], [*AddressQuarantined], [*CatalogMismatch],
+// [*BalancerRejected], [*InstanceLimitExceeded] or [*IndexReset].
+type Event interface {
+	// ServiceName returns the name of the Consul service the event
+	// belongs to.
+	ServiceName() string
+}
+
+// QueryStarted is emitted before a query to Consul is sent.
+type QueryStarted struct {
+	Service string
+	// WaitIndex is the index passed with the blocking query, it is 0 for
+	// non-blocking queries.
+	WaitIndex uint64
+}
+
+// ServiceName returns the name of the Consul service.
+func (e *QueryStarted) ServiceName() string { return e.Service }
+
+// QueryFinished is emitted after a query to Consul returned.
+type QueryFinished struct {
+	Service  string
+	Duration time.Duration
+	// Addresses is the number of addresses the service resolved to.
+	Addresses int
+	// WaitIndex is the index returned by Consul.
+	WaitIndex uint64
+	// Err is the error returned by the query, nil on success.
+	Err error
+}
+
+// ServiceName returns the name of the Consul service.
+func (e *QueryFinished) ServiceName() string { return e.Service }
+
+// StateUpdated is emitted after new addresses were passed to the gRPC
+// ClientConn.
+type StateUpdated struct {
+	Service   string
+	Addresses []resolver.Address
+	// Added is the number of addresses that were not part of the
+	// previous update.
+	Added int
+	// Removed is the number of addresses of the previous update that
+	// are not part of this one.
+	Removed int
+	// Err is the error returned by [resolver.ClientConn.UpdateState].
+	Err error
+}
+
+// ServiceName returns the name of the Consul service.
+func (e *StateUpdated) ServiceName() string { return e.Service }
+
+// UpdateRejected is emitted when the update gate configured with
+// [WithUpdateGate] rejected passing addresses to the gRPC ClientConn, or
+// when they were held back because the check of the gate-check target option
+// is not passing.
+type UpdateRejected struct {
+	Service   string
+	Addresses []resolver.Address
+}
+
+// ServiceName returns the name of the Consul service.
+func (e *UpdateRejected) ServiceName() string { return e.Service }
+
+// ErrorReported is emitted after an error was reported to the gRPC
+// ClientConn.
+type ErrorReported struct {
+	Service string
+	// Err is the reported error, its gRPC status code can be retrieved
+	// with [google.golang.org/grpc/status.Code].
+	Err error
+}
+
+// ServiceName returns the name of the Consul service.
+func (e *ErrorReported) ServiceName() string { return e.Service }
+
+// ConnectionChecked is emitted after the connection to Consul was checked by
+// the monitor enabled with [WithConnectionMonitor].
+type ConnectionChecked struct {
+	Service  string
+	Duration time.Duration
+	// Err is the error returned by the check, nil on success.
+	Err error
+}
+
+// ServiceName returns the name of the Consul service.
+func (e *ConnectionChecked) ServiceName() string { return e.Service }
+
+// WatchStuck is emitted when the index returned by Consul did not change for
+// longer than the threshold configured with [WithStuckWatchDetection].
+type WatchStuck struct {
+	Service string
+	// WaitIndex is the index that did not change.
+	WaitIndex uint64
+	// Unchanged is the duration since the index changed the last time.
+	Unchanged time.Duration
+}
+
+// ServiceName returns the name of the Consul service.
+func (e *WatchStuck) ServiceName() string { return e.Service }
+
+func (c *consulResolver) emit(ev Event) {
+	if c.stats != nil {
+		c.stats.HandleEvent(ev)
+	}
+}
+
+// ResolveNowDeferred is emitted when a ResolveNow call of the gRPC channel
+// was deferred because of the interval configured with
+// [WithResolveNowInterval].
+type ResolveNowDeferred struct {
+	Service string
+	// Delay is the time until the deferred query is run.
+	Delay time.Duration
+}
+
+// ServiceName returns the name of the Consul service.
+func (e *ResolveNowDeferred) ServiceName() string { return e.Service }
+
+// AddressQuarantined is emitted when an address was quarantined after it was
+// reported via [ReportAddressFailure].
+type AddressQuarantined struct {
+	Service string
+	Addr    string
+	// Duration is the time until the address is passed to the gRPC
+	// ClientConn again.
+	Duration time.Duration
+}
+
+// ServiceName returns the name of the Consul service.
+func (e *AddressQuarantined) ServiceName() string { return e.Service }
+
+// CatalogMismatch is emitted by the self-check enabled with
+// [WithCatalogSelfCheck] when the health and the catalog endpoint of Consul
+// returned different instances of a service. Instances are identified as
+// <node>/<service-id>.
+type CatalogMismatch struct {
+	Service string
+	// MissingFromHealth are the instances that are only returned by the
+	// catalog endpoint, e.g. because the registration of their checks
+	// failed.
+	MissingFromHealth []string
+	// MissingFromCatalog are the instances that are only returned by the
+	// health endpoint.
+	MissingFromCatalog []string
+}
+
+// ServiceName returns the name of the Consul service.
+func (e *CatalogMismatch) ServiceName() string { return e.Service }
+
+// BalancerRejected is emitted when [resolver.ClientConn.UpdateState]
+// returned an error for the addresses passed to the gRPC channel, usually
+// because the load balancer can not use them. It is emitted before the
+// [StateUpdated] event of the update.
+type BalancerRejected struct {
+	Service   string
+	Addresses []resolver.Address
+	Err       error
+}
+
+// ServiceName returns the name of the Consul service.
+func (e *BalancerRejected) ServiceName() string { return e.Service }
+
+// InstanceLimitExceeded is emitted when the service resolved to more
+// instances than the max-instances target option allows.
+type InstanceLimitExceeded struct {
+	Service string
+	// Instances is the number of instances the service resolved to.
+	Instances int
+	// Limit is the value of the max-instances option.
+	Limit int
+	// Policy is the policy that was applied.
+	Policy MaxInstancesPolicy
+}
+
+// ServiceName returns the name of the Consul service.
+func (e *InstanceLimitExceeded) ServiceName() string { return e.Service }
+
+// IndexReset is emitted when Consul returned a smaller index than the one of
+// the previous query, e.g. after the agent was restarted, and the blocking
+// query is restarted.
+type IndexReset struct {
+	Service       string
+	WaitIndex     uint64
+	PreviousIndex uint64
+}
+
+// ServiceName returns the name of the Consul service.
+func (e *IndexReset) ServiceName() string { return e.Service }
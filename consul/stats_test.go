@@ -0,0 +1,176 @@
+package consul
+
+import (
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+type recordingStatsHandler struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (h *recordingStatsHandler) HandleEvent(ev Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.events = append(h.events, ev)
+}
+
+func (h *recordingStatsHandler) Events() []Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]Event(nil), h.events...)
+}
+
+func TestStatsHandlerReceivesEvents(t *testing.T) {
+	cc := mocks.NewClientConn()
+	newAddressCallCnt := cc.UpdateStateCallCnt()
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespEntries([]*consul.ServiceEntry{
+		{
+			Service: &consul.AgentService{
+				Address: "127.0.0.1",
+				Port:    1,
+			},
+		},
+	})
+
+	h := recordingStatsHandler{}
+	r, err := NewBuilder(WithStatsHandler(&h)).Build(resolver.Target{URL: url.URL{Path: "test"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+
+	for newAddressCallCnt == cc.UpdateStateCallCnt() {
+		time.Sleep(time.Millisecond)
+	}
+
+	queryErr := errors.New("query failed")
+	health.SetRespError(queryErr)
+	r.ResolveNow(resolver.ResolveNowOptions{})
+
+	for cc.LastReportedError() == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	r.Close()
+
+	events := h.Events()
+	if len(events) < 5 {
+		t.Fatalf("got %d events, expected at least 5: %+v", len(events), events)
+	}
+
+	if _, ok := events[0].(*QueryStarted); !ok {
+		t.Errorf("first event is %T, expected *QueryStarted", events[0])
+	}
+
+	if ev, ok := events[1].(*QueryFinished); !ok || ev.Addresses != 1 || ev.Err != nil {
+		t.Errorf("second event is %+v, expected successful *QueryFinished with 1 address", events[1])
+	}
+
+	if ev, ok := events[2].(*StateUpdated); !ok || len(ev.Addresses) != 1 {
+		t.Errorf("third event is %+v, expected *StateUpdated with 1 address", events[2])
+	}
+
+	var errEv *ErrorReported
+	for _, ev := range events {
+		if ev.ServiceName() != "test" {
+			t.Errorf("event %+v has service name %q, expected test", ev, ev.ServiceName())
+		}
+
+		if e, ok := ev.(*ErrorReported); ok {
+			errEv = e
+		}
+	}
+
+	if errEv == nil || !errors.Is(errEv.Err, queryErr) {
+		t.Errorf("ErrorReported event is %+v, expected one with error %v", errEv, queryErr)
+	}
+}
+
+// waitForEvent waits until h received an event for that match returns true.
+func waitForEvent(t *testing.T, h *recordingStatsHandler, match func(Event) bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, ev := range h.Events() {
+			if match(ev) {
+				return
+			}
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatal("expected event was not received")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBalancerRejectionIsRecorded(t *testing.T) {
+	cc := mocks.NewClientConn()
+	balancerErr := errors.New("balancer can not use addresses")
+	cc.SetUpdateStateError(balancerErr)
+
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespEntries([]*consul.ServiceEntry{
+		{
+			Service: &consul.AgentService{
+				Address: "127.0.0.1",
+				Port:    1,
+			},
+		},
+	})
+
+	h := recordingStatsHandler{}
+	r, err := NewBuilder(WithStatsHandler(&h)).Build(resolver.Target{URL: url.URL{Path: "test"}}, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+	t.Cleanup(r.Close)
+
+	waitForEvent(t, &h, func(ev Event) bool {
+		e, ok := ev.(*BalancerRejected)
+		return ok && errors.Is(e.Err, balancerErr) && len(e.Addresses) == 1
+	})
+
+	var rh ResolverHealth
+	for _, h := range ResolversHealth() {
+		if h.Service == "test" {
+			rh = h
+		}
+	}
+
+	if rh.BalancerRejections == 0 {
+		t.Error("BalancerRejections is 0, expected at least 1")
+	}
+
+	rej := rh.LastBalancerRejection
+	if rej == nil || !errors.Is(rej.Err, balancerErr) || len(rej.Addresses) != 1 || rej.Addresses[0] != "127.0.0.1:1" {
+		t.Errorf("LastBalancerRejection is %+v, expected rejection of 127.0.0.1:1 with error %v", rej, balancerErr)
+	}
+}
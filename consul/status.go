@@ -0,0 +1,84 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusError is an error with a gRPC status code.
+// It is returned by [status.FromError] and [status.Code] for the errors the
+// resolvers report to the gRPC channel. The original error can be retrieved
+// with [errors.Unwrap].
+type statusError struct {
+	code codes.Code
+	err  error
+}
+
+func (e *statusError) Error() string {
+	return e.err.Error()
+}
+
+func (e *statusError) Unwrap() error {
+	return e.err
+}
+
+// GRPCStatus returns the gRPC status of the error.
+func (e *statusError) GRPCStatus() *status.Status {
+	return status.New(e.code, e.err.Error())
+}
+
+// withStatusCode wraps err with the gRPC status code that describes it:
+// PermissionDenied and Unauthenticated for rejected ACL tokens,
+// InvalidArgument for invalid queries, NotFound for services that are not
+// registered in strict mode and requests Consul answered with 404,
+// ResourceExhausted for requests rejected by the rate limit of Consul,
+// FailedPrecondition for instances registered with port 0, services
+// exceeding max-instances and exhausted retry budgets, DeadlineExceeded for
+// timeouts and Unavailable for network and server errors and services
+// without healthy instances.
+func withStatusCode(err error) error {
+	return &statusError{code: statusCode(err), err: err}
+}
+
+func statusCode(err error) codes.Code {
+	if errors.Is(err, ErrRetryBudgetExhausted) {
+		return codes.FailedPrecondition
+	}
+
+	if errors.Is(err, ErrServiceNotFound) {
+		return codes.NotFound
+	}
+
+	var se consul.StatusError
+	if errors.As(err, &se) {
+		switch se.Code {
+		case http.StatusUnauthorized:
+			return codes.Unauthenticated
+		case http.StatusForbidden:
+			return codes.PermissionDenied
+		case http.StatusBadRequest:
+			return codes.InvalidArgument
+		case http.StatusNotFound:
+			return codes.NotFound
+		case http.StatusTooManyRequests:
+			return codes.ResourceExhausted
+		default:
+			return codes.Unavailable
+		}
+	}
+
+	if errors.Is(err, ErrZeroPort) || errors.Is(err, ErrTooManyInstances) {
+		return codes.FailedPrecondition
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return codes.DeadlineExceeded
+	}
+
+	return codes.Unavailable
+}
@@ -0,0 +1,45 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWithStatusCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want codes.Code
+	}{
+		{consul.StatusError{Code: 403, Body: "Permission denied"}, codes.PermissionDenied},
+		{consul.StatusError{Code: 401}, codes.Unauthenticated},
+		{consul.StatusError{Code: 400, Body: "Failed to create boolean expression evaluator"}, codes.InvalidArgument},
+		{consul.StatusError{Code: 404}, codes.NotFound},
+		{consul.StatusError{Code: 429, Body: "rate limit exceeded"}, codes.ResourceExhausted},
+		{consul.StatusError{Code: 500, Body: "No cluster leader"}, codes.Unavailable},
+		{fmt.Errorf("%w: 'user-service'", ErrServiceNotFound), codes.NotFound},
+		{ErrZeroPort, codes.FailedPrecondition},
+		{fmt.Errorf("query failed: %w", context.DeadlineExceeded), codes.DeadlineExceeded},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, codes.Unavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			err := withStatusCode(tt.err)
+
+			if code := status.Code(err); code != tt.want {
+				t.Errorf("status code is %s, expected %s", code, tt.want)
+			}
+
+			if !errors.Is(err, tt.err) {
+				t.Error("wrapped error does not match the original error")
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package consul
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/serviceconfig"
+)
+
+const (
+	subscribeMinRetryInterval = time.Second
+	subscribeMaxRetryInterval = 30 * time.Second
+)
+
+// Subscribe watches the service of the consul:// target and sends its
+// addresses in the form host:port to the returned channel when they change.
+// It allows non-gRPC clients, like HTTP reverse proxies or connection pools,
+// to use the same service discovery as the gRPC clients.
+//
+// The channel has a buffer size of 1. If the receiver does not keep up, only
+// the latest addresses are kept. Failed queries are retried with an
+// exponential backoff.
+// The returned function stops watching the service and closes the channel.
+func Subscribe(target string, opts ...BuilderOption) (<-chan []string, func(), error) {
+	t, err := ParseTarget(target)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var bopts builderOptions
+	for _, o := range opts {
+		o(&bopts)
+	}
+
+	cc := subscriberConn{ch: make(chan []string, 1)}
+	r, err := newConsulResolver(&cc, t, &bopts)
+	if err != nil {
+		return nil, nil, err
+	}
+	cc.resolver = r
+	cc.clock = r.clock
+
+	r.start()
+
+	var once sync.Once
+	stop := func() {
+		once.Do(func() {
+			r.Close()
+			cc.close()
+		})
+	}
+
+	return cc.ch, stop, nil
+}
+
+// subscriberConn is a [resolver.ClientConn] that sends the addresses of a
+// resolver to a channel.
+type subscriberConn struct {
+	resolver *consulResolver
+	clock    Clock
+
+	mu            sync.Mutex
+	ch            chan []string
+	closed        bool
+	retryInterval time.Duration
+	retryTimer    Timer
+}
+
+func (s *subscriberConn) UpdateState(state resolver.State) error {
+	addrs := make([]string, 0, len(state.Addresses))
+	for _, a := range state.Addresses {
+		addrs = append(addrs, a.Addr)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.retryInterval = 0
+
+	// replace the value in the buffer if it was not received yet
+	select {
+	case <-s.ch:
+	default:
+	}
+	s.ch <- addrs
+
+	return nil
+}
+
+// ReportError schedules a new query after the backoff interval.
+// Without a gRPC balancer, nothing else calls ResolveNow() to retry.
+func (s *subscriberConn) ReportError(error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	switch {
+	case s.retryInterval == 0:
+		s.retryInterval = subscribeMinRetryInterval
+	case s.retryInterval < subscribeMaxRetryInterval:
+		s.retryInterval = min(2*s.retryInterval, subscribeMaxRetryInterval)
+	}
+
+	s.retryTimer = s.clock.AfterFunc(s.retryInterval, func() {
+		s.resolver.ResolveNow(resolver.ResolveNowOptions{})
+	})
+}
+
+func (s *subscriberConn) NewAddress(addresses []resolver.Address) {
+	_ = s.UpdateState(resolver.State{Addresses: addresses})
+}
+
+func (s *subscriberConn) NewServiceConfig(string) {}
+
+func (s *subscriberConn) ParseServiceConfig(string) *serviceconfig.ParseResult {
+	return &serviceconfig.ParseResult{}
+}
+
+func (s *subscriberConn) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.retryTimer != nil {
+		s.retryTimer.Stop()
+	}
+
+	s.closed = true
+	close(s.ch)
+}
@@ -0,0 +1,107 @@
+package consul
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func receive(t *testing.T, ch <-chan []string) []string {
+	t.Helper()
+
+	select {
+	case addrs := <-ch:
+		return addrs
+	case <-time.After(5 * time.Second):
+		t.Fatal("no addresses received")
+		return nil
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: 1},
+	})
+
+	ch, stop, err := Subscribe("consul:///subscribe-test")
+	if err != nil {
+		t.Fatal("Subscribe() failed:", err)
+	}
+
+	if addrs := receive(t, ch); !reflect.DeepEqual(addrs, []string{"127.0.0.1:1"}) {
+		t.Errorf("received %v, expected [127.0.0.1:1]", addrs)
+	}
+
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: 1},
+		{Address: "127.0.0.1", Port: 2},
+	})
+
+	if addrs := receive(t, ch); !reflect.DeepEqual(addrs, []string{"127.0.0.1:1", "127.0.0.1:2"}) {
+		t.Errorf("received %v, expected [127.0.0.1:1 127.0.0.1:2]", addrs)
+	}
+
+	stop()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("channel was not closed after stop was called")
+		}
+	}
+}
+
+func TestSubscribeRetriesFailedQueries(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespError(errors.New("query failed"))
+
+	ch, stop, err := Subscribe("consul:///subscribe-test")
+	if err != nil {
+		t.Fatal("Subscribe() failed:", err)
+	}
+	defer stop()
+
+	for h, _ := findResolverHealth("subscribe-test"); h.Err == nil; h, _ = findResolverHealth("subscribe-test") {
+		time.Sleep(time.Millisecond)
+	}
+
+	health.SetRespError(nil)
+	health.SetRespServiceEntries([]*consul.AgentService{
+		{Address: "127.0.0.1", Port: 1},
+	})
+
+	if addrs := receive(t, ch); len(addrs) != 1 {
+		t.Errorf("received %v, expected 1 address after the query was retried", addrs)
+	}
+}
+
+func TestSubscribeInvalidTarget(t *testing.T) {
+	if _, _, err := Subscribe("consul:///svc?health=sometimes"); !errors.Is(err, ErrInvalidHealthFilter) {
+		t.Errorf("Subscribe() returned error %v, expected ErrInvalidHealthFilter", err)
+	}
+}
@@ -0,0 +1,487 @@
+package consul
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/hashicorp/go-bexpr"
+)
+
+// Target describes a consul resolver target.
+// It can be converted to a correctly escaped target URL that can be passed
+// to [google.golang.org/grpc.Dial], [ParseTarget] converts a target URL to a
+// Target.
+//
+// The struct can be decoded from JSON or YAML to configure a resolver that is
+// registered via [NewTargetBuilder].
+type Target struct {
+	// ConsulAddr is the address of the Consul server, if empty the
+	// default is used.
+	ConsulAddr string `json:"consulAddr,omitempty" yaml:"consulAddr,omitempty"`
+	// ConsulSRV defines that ConsulAddr is a DNS name with SRV records
+	// that point to the Consul servers.
+	ConsulSRV bool `json:"consulSRV,omitempty" yaml:"consulSRV,omitempty"`
+	// Service is the name of the service to resolve.
+	Service string `json:"service,omitempty" yaml:"service,omitempty"`
+	// Namespace is the Consul Enterprise namespace of the service.
+	Namespace string `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	// Partition is the Consul Enterprise admin partition of the service.
+	Partition string `json:"partition,omitempty" yaml:"partition,omitempty"`
+	// DottedNamespace enables parsing service paths in the format
+	// <service>.<namespace>. When it is disabled, dots are part of the
+	// service name.
+	DottedNamespace bool `json:"dottedNamespace,omitempty" yaml:"dottedNamespace,omitempty"`
+	// Scheme is the scheme used to connect to Consul, http or https.
+	Scheme string `json:"scheme,omitempty" yaml:"scheme,omitempty"`
+	// Tags limits resolution to instances having all of the tags.
+	// Tags must not contain commas.
+	Tags []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+	// Health defines how instances are filtered by their health status.
+	Health HealthFilter `json:"health,omitempty" yaml:"health,omitempty"`
+	// Token is the ACL token used for Consul API requests.
+	Token string `json:"token,omitempty" yaml:"token,omitempty"`
+	// DC is the datacenter to query.
+	DC string `json:"dc,omitempty" yaml:"dc,omitempty"`
+	// Segment limits resolution to instances in the network segment.
+	Segment string `json:"segment,omitempty" yaml:"segment,omitempty"`
+	// Filter is a Consul filter expression instances must match.
+	Filter string `json:"filter,omitempty" yaml:"filter,omitempty"`
+	// Version is a semantic version constraint, like ^1.4, the value of
+	// the version service meta field of instances must satisfy.
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	// OverridesKey is the Consul KV key of a JSON-encoded [Overrides]
+	// document. The key is watched and the overrides are applied to the
+	// settings of the target when it changes.
+	OverridesKey string `json:"overridesKey,omitempty" yaml:"overridesKey,omitempty"`
+	// Proxy is the URL of an HTTP, HTTPS or SOCKS5 proxy used to connect
+	// to Consul. If empty, the proxy is configured via the HTTP_PROXY,
+	// HTTPS_PROXY and NO_PROXY environment variables.
+	Proxy string `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+	// PortName selects the port that is resolved from the service meta
+	// field [PortMetaKeyPrefix]<PortName> instead of the service port.
+	// Instances without a valid port in the field are not resolved.
+	PortName string `json:"portName,omitempty" yaml:"portName,omitempty"`
+	// PreferNodeAddress resolves instances to the address of their node,
+	// even if a service address is registered.
+	PreferNodeAddress bool `json:"preferNodeAddress,omitempty" yaml:"preferNodeAddress,omitempty"`
+	// RequireServiceAddress skips instances without a service address
+	// instead of resolving them to the address of their node.
+	RequireServiceAddress bool `json:"requireServiceAddress,omitempty" yaml:"requireServiceAddress,omitempty"`
+	// PortZero defines how instances registered with port 0 are
+	// resolved. It does not apply when PortName is set.
+	PortZero PortZeroPolicy `json:"portZero,omitempty" yaml:"portZero,omitempty"`
+	// LocalNode defines how instances on the node of the Consul agent are
+	// preferred.
+	LocalNode LocalNodePolicy `json:"localNode,omitempty" yaml:"localNode,omitempty"`
+	// DisableWANTranslation resolves instances in remote datacenters to
+	// their LAN addresses, instead of the WAN addresses the Consul agent
+	// translates them to when translate_wan_addrs is enabled.
+	DisableWANTranslation bool `json:"disableWANTranslation,omitempty" yaml:"disableWANTranslation,omitempty"`
+	// GateCheck is the ID of a Consul health check, addresses are only
+	// passed to the gRPC channel while all checks with the ID pass.
+	GateCheck string `json:"gateCheck,omitempty" yaml:"gateCheck,omitempty"`
+	// Kind restricts the resolution to instances of a Consul service kind.
+	Kind KindFilter `json:"kind,omitempty" yaml:"kind,omitempty"`
+	// DualStack passes the addresses of both IP families of instances
+	// with lan_ipv4 and lan_ipv6 or wan_ipv4 and wan_ipv6 tagged
+	// addresses to the gRPC channel, as addresses of the same endpoint.
+	DualStack bool `json:"dualStack,omitempty" yaml:"dualStack,omitempty"`
+	// Timeouts are the timeouts of the HTTP connections to Consul, they
+	// take precedence over the ones configured with [WithHTTPTimeouts].
+	Timeouts HTTPTimeouts `json:"timeouts,omitempty" yaml:"timeouts,omitempty"`
+	// PriorityTags are tags ordered by priority. Addresses of instances
+	// are labeled with the index of the first of the tags they carry, it
+	// can be retrieved with [Priority]. Tags must not contain commas.
+	PriorityTags []string `json:"priorityTags,omitempty" yaml:"priorityTags,omitempty"`
+	// PreserveOrder passes the addresses to the gRPC channel in the order
+	// returned by Consul instead of sorting them. Addresses are only
+	// passed again when the set of addresses changed.
+	PreserveOrder bool `json:"preserveOrder,omitempty" yaml:"preserveOrder,omitempty"`
+	// CheckTypes are the types of health checks, e.g. grpc and http,
+	// that are considered for the health of instances. Checks of other
+	// types are ignored. If it is empty, all checks are considered.
+	CheckTypes []string `json:"checkTypes,omitempty" yaml:"checkTypes,omitempty"`
+	// SlowStart is the window during which the weight of instances that
+	// newly appeared is increased to their full weight, 0 disables it.
+	SlowStart time.Duration `json:"slowStart,omitempty" yaml:"slowStart,omitempty"`
+	// MaxInstances is the maximum number of instances the service is
+	// expected to resolve to, 0 disables the limit. MaxInstancesPolicy
+	// defines how a service with more instances is resolved.
+	MaxInstances       int                `json:"maxInstances,omitempty" yaml:"maxInstances,omitempty"`
+	MaxInstancesPolicy MaxInstancesPolicy `json:"maxInstancesPolicy,omitempty" yaml:"maxInstancesPolicy,omitempty"`
+	// MinHealthyFraction is the minimum fraction of the registered
+	// instances that must be passing, otherwise instances with warning
+	// and critical checks are resolved too. 0 disables it.
+	MinHealthyFraction float64 `json:"minHealthyFraction,omitempty" yaml:"minHealthyFraction,omitempty"`
+	// FailureTolerance is the duration the checks of instances must be
+	// failing before the instances are considered unhealthy, 0 disables
+	// it.
+	FailureTolerance time.Duration `json:"failureTolerance,omitempty" yaml:"failureTolerance,omitempty"`
+	// FailoverDCs are the datacenters the service is resolved in, in
+	// order, when no instance is available in DC. The datacenter of each
+	// address is attached to it and returned by [Datacenter].
+	FailoverDCs []string `json:"failoverDCs,omitempty" yaml:"failoverDCs,omitempty"`
+	// Strict reports an error with the gRPC status code NotFound to the
+	// channel when no instance of the service is registered.
+	Strict bool `json:"strict,omitempty" yaml:"strict,omitempty"`
+	// WaitTime is the maximum duration the blocking queries of the
+	// resolver wait for changes. If it is 0, the wait time configured
+	// via [WithWaitTime] is used.
+	WaitTime time.Duration `json:"waitTime,omitempty" yaml:"waitTime,omitempty"`
+	// TLS configures the HTTPS connection to Consul.
+	// Only InsecureSkipVerify and CABundleFile can be expressed in a
+	// target URL, [Target.URL] omits the other settings.
+	TLS TLSConfig `json:"tls,omitempty" yaml:"tls,omitempty"`
+}
+
+// TLSConfig configures the HTTPS connection to Consul.
+// Empty fields are set to the defaults of the Consul client.
+type TLSConfig struct {
+	// ServerName is the server name used to verify the certificate of
+	// the Consul server.
+	ServerName string `json:"serverName,omitempty" yaml:"serverName,omitempty"`
+	// CAFile is the path to a PEM-encoded CA certificate file.
+	// It replaces the system certificate pool.
+	CAFile string `json:"caFile,omitempty" yaml:"caFile,omitempty"`
+	// CABundleFile is the path to a file with PEM-encoded CA
+	// certificates that are added to the system certificate pool or to
+	// the certificates from CAFile.
+	CABundleFile string `json:"caBundleFile,omitempty" yaml:"caBundleFile,omitempty"`
+	// CABundlePEM contains PEM-encoded CA certificates that are added like
+	// the ones from CABundleFile.
+	CABundlePEM []byte `json:"-" yaml:"-"`
+	// CertFile is the path to a PEM-encoded client certificate.
+	// The certificate and key are reloaded when the files change.
+	CertFile string `json:"certFile,omitempty" yaml:"certFile,omitempty"`
+	// KeyFile is the path to the PEM-encoded private key of the client
+	// certificate.
+	KeyFile string `json:"keyFile,omitempty" yaml:"keyFile,omitempty"`
+	// InsecureSkipVerify disables verification of the certificate of the
+	// Consul server.
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty" yaml:"insecureSkipVerify,omitempty"`
+}
+
+// clone returns a deep copy of t.
+func (t *Target) clone() *Target {
+	c := *t
+	c.Tags = append([]string(nil), t.Tags...)
+	c.PriorityTags = append([]string(nil), t.PriorityTags...)
+	c.CheckTypes = append([]string(nil), t.CheckTypes...)
+	c.FailoverDCs = append([]string(nil), t.FailoverDCs...)
+	c.TLS.CABundlePEM = append([]byte(nil), t.TLS.CABundlePEM...)
+
+	return &c
+}
+
+// normalize converts case-insensitive settings to their canonical form.
+func (t *Target) normalize() {
+	t.Scheme = strings.ToLower(t.Scheme)
+}
+
+func (t *Target) setDefaults() {
+	const defHealthFilter = HealthFilterOnlyHealthy
+
+	if t.Health == HealthFilterUndefined {
+		t.Health = defHealthFilter
+	}
+}
+
+func (t *Target) validate() error {
+	if t.Service == "" {
+		return ErrMissingService
+	}
+
+	if t.Scheme != "" && t.Scheme != "http" && t.Scheme != "https" {
+		return fmt.Errorf("%w '%s'", ErrUnsupportedScheme, t.Scheme)
+	}
+
+	if t.ConsulSRV && t.ConsulAddr == "" {
+		return fmt.Errorf("%w: consul-srv requires a consul-server", ErrInvalidOptionValue)
+	}
+
+	if t.PreferNodeAddress && t.RequireServiceAddress {
+		return fmt.Errorf("%w: prefer-node-address and require-service-address are mutually exclusive", ErrInvalidOptionValue)
+	}
+
+	if t.SlowStart < 0 {
+		return fmt.Errorf("%w: slow-start must not be negative", ErrInvalidOptionValue)
+	}
+
+	if t.MaxInstances < 0 {
+		return fmt.Errorf("%w: max-instances must not be negative", ErrInvalidOptionValue)
+	}
+
+	if t.MinHealthyFraction < 0 || t.MinHealthyFraction > 1 {
+		return fmt.Errorf("%w: min-healthy-fraction must be between 0 and 1", ErrInvalidOptionValue)
+	}
+
+	if t.FailureTolerance < 0 {
+		return fmt.Errorf("%w: failure-tolerance must not be negative", ErrInvalidOptionValue)
+	}
+
+	if slices.Contains(t.FailoverDCs, "") {
+		return fmt.Errorf("%w: failover-dcs must not contain empty datacenter names", ErrInvalidOptionValue)
+	}
+
+	if t.WaitTime < 0 {
+		return fmt.Errorf("%w: wait must not be negative", ErrInvalidOptionValue)
+	}
+
+	if t.Timeouts.Dial < 0 || t.Timeouts.TLSHandshake < 0 || t.Timeouts.ResponseHeader < 0 {
+		return fmt.Errorf("%w: timeouts must not be negative", ErrInvalidOptionValue)
+	}
+
+	if err := validateFilter(t.Filter); err != nil {
+		return err
+	}
+
+	if t.Version != "" {
+		if _, err := semver.NewConstraint(t.Version); err != nil {
+			return fmt.Errorf("%w '%s': %w", ErrInvalidVersionConstraint, t.Version, err)
+		}
+	}
+
+	if t.Proxy != "" {
+		if _, err := parseProxyURL(t.Proxy); err != nil {
+			return err
+		}
+	}
+
+	if err := t.TLS.validateCABundle(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// URL returns the target as consul:// URL.
+func (t *Target) URL() *url.URL {
+	q := url.Values{}
+
+	if t.Scheme != "" {
+		q.Set("scheme", t.Scheme)
+	}
+	if len(t.Tags) > 0 {
+		q.Set("tags", strings.Join(t.Tags, ","))
+	}
+	if t.Health != HealthFilterUndefined {
+		q.Set("health", t.Health.String())
+	}
+	if t.Token != "" {
+		q.Set("token", t.Token)
+	}
+	if t.DC != "" {
+		q.Set("dc", t.DC)
+	}
+	if t.Segment != "" {
+		q.Set("segment", t.Segment)
+	}
+	if t.Filter != "" {
+		q.Set("filter", t.Filter)
+	}
+	if t.ConsulSRV {
+		q.Set("consul-srv", "true")
+	}
+	if t.Version != "" {
+		q.Set("version", t.Version)
+	}
+	if t.OverridesKey != "" {
+		q.Set("overrides-key", t.OverridesKey)
+	}
+	if t.Proxy != "" {
+		q.Set("proxy", t.Proxy)
+	}
+	if t.PortName != "" {
+		q.Set("port-name", t.PortName)
+	}
+	if t.PreferNodeAddress {
+		q.Set("prefer-node-address", "true")
+	}
+	if t.RequireServiceAddress {
+		q.Set("require-service-address", "true")
+	}
+	if t.PortZero != PortZeroUndefined {
+		q.Set("port-zero", t.PortZero.String())
+	}
+	if t.LocalNode != LocalNodeUndefined {
+		q.Set("local-node", t.LocalNode.String())
+	}
+	if t.DisableWANTranslation {
+		q.Set("translate-wan-addrs", "false")
+	}
+	if t.GateCheck != "" {
+		q.Set("gate-check", t.GateCheck)
+	}
+	if t.Kind != KindUndefined {
+		q.Set("kind", t.Kind.String())
+	}
+	if t.DualStack {
+		q.Set("dual-stack", "true")
+	}
+	if len(t.PriorityTags) > 0 {
+		q.Set("priority-tags", strings.Join(t.PriorityTags, ","))
+	}
+	if t.PreserveOrder {
+		q.Set("preserve-order", "true")
+	}
+	if len(t.CheckTypes) > 0 {
+		q.Set("check-types", strings.Join(t.CheckTypes, ","))
+	}
+	if t.SlowStart != 0 {
+		q.Set("slow-start", t.SlowStart.String())
+	}
+	if t.MaxInstances != 0 {
+		q.Set("max-instances", strconv.Itoa(t.MaxInstances))
+	}
+	if t.MaxInstancesPolicy != MaxInstancesHold {
+		q.Set("max-instances-policy", t.MaxInstancesPolicy.String())
+	}
+	if t.MinHealthyFraction != 0 {
+		q.Set("min-healthy-fraction", strconv.FormatFloat(t.MinHealthyFraction, 'g', -1, 64))
+	}
+	if t.FailureTolerance != 0 {
+		q.Set("failure-tolerance", t.FailureTolerance.String())
+	}
+	if len(t.FailoverDCs) > 0 {
+		q.Set("failover-dcs", strings.Join(t.FailoverDCs, ","))
+	}
+	if t.Strict {
+		q.Set("strict", "true")
+	}
+	if t.WaitTime != 0 {
+		q.Set("wait", t.WaitTime.String())
+	}
+	if t.Timeouts.Dial != 0 {
+		q.Set("dial-timeout", t.Timeouts.Dial.String())
+	}
+	if t.Timeouts.TLSHandshake != 0 {
+		q.Set("tls-handshake-timeout", t.Timeouts.TLSHandshake.String())
+	}
+	if t.Timeouts.ResponseHeader != 0 {
+		q.Set("response-header-timeout", t.Timeouts.ResponseHeader.String())
+	}
+	if t.DottedNamespace {
+		q.Set("dotted-namespace", "true")
+	}
+	if t.TLS.InsecureSkipVerify {
+		q.Set("tls-verify", "false")
+	}
+	if t.TLS.CABundleFile != "" {
+		q.Set("ca-bundle", t.TLS.CABundleFile)
+	}
+
+	return &url.URL{
+		Scheme:   scheme,
+		Host:     t.ConsulAddr,
+		Path:     "/" + t.servicePath(),
+		RawQuery: q.Encode(),
+	}
+}
+
+// servicePath returns the service name, qualified with the namespace and
+// partition when they are set or when the service name is ambiguous.
+func (t *Target) servicePath() string {
+	if t.Partition == "" && t.Namespace == "" && (!t.DottedNamespace || !strings.Contains(t.Service, ".")) {
+		return t.Service
+	}
+
+	return t.Partition + "/" + t.Namespace + "/" + t.Service
+}
+
+// parseServicePath sets the Service, Namespace and Partition fields from
+// a service path in one of the formats:
+// <service>, <partition>/<namespace>/<service> or, if t.DottedNamespace is
+// enabled, <service>.<namespace>.
+func (t *Target) parseServicePath(path string) error {
+	if path == "" {
+		return ErrMissingService
+	}
+
+	if strings.ContainsAny(path, "@+") {
+		return fmt.Errorf("%w '%s': composite targets are only supported by the resolver", ErrInvalidServicePath, path)
+	}
+
+	if !strings.Contains(path, "/") {
+		if i := strings.LastIndexByte(path, '.'); i != -1 && t.DottedNamespace {
+			t.Service, t.Namespace = path[:i], path[i+1:]
+		} else {
+			t.Service = path
+		}
+	} else {
+		parts := strings.Split(path, "/")
+		if len(parts) != 3 {
+			return fmt.Errorf("%w '%s': must be <service> or <partition>/<namespace>/<service>",
+				ErrInvalidServicePath, path)
+		}
+
+		t.Partition, t.Namespace, t.Service = parts[0], parts[1], parts[2]
+	}
+
+	if t.Service == "" {
+		return ErrMissingService
+	}
+
+	return nil
+}
+
+// redactedString returns the target as consul:// URL string without the
+// token.
+func (t *Target) redactedString() string {
+	r := *t
+	r.Token = ""
+	r.Proxy = redactProxyURL(r.Proxy)
+
+	return r.String()
+}
+
+// String returns the target as consul:// URL string.
+func (t *Target) String() string {
+	return t.URL().String()
+}
+
+// parseProxyURL parses the URL of a proxy and returns an error wrapping
+// [ErrInvalidOptionValue] if it is not an http, https or socks5 URL.
+func parseProxyURL(proxy string) (*url.URL, error) {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		// the error contains the URL, it is omitted to not expose a
+		// password in it
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = ue.Err
+		}
+		return nil, fmt.Errorf("%w for 'proxy': %w", ErrInvalidOptionValue, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("%w '%s' for 'proxy': unsupported scheme '%s'", ErrInvalidOptionValue, u.Redacted(), u.Scheme)
+	}
+
+	if u.Host == "" {
+		return nil, fmt.Errorf("%w '%s' for 'proxy': missing host", ErrInvalidOptionValue, u.Redacted())
+	}
+
+	return u, nil
+}
+
+// validateFilter returns an error if filter is not a valid bexpr
+// expression.
+func validateFilter(filter string) error {
+	if filter == "" {
+		return nil
+	}
+
+	if _, err := bexpr.CreateEvaluator(filter); err != nil {
+		return fmt.Errorf("%w '%s': %w", ErrInvalidFilter, filter, err)
+	}
+
+	return nil
+}
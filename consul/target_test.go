@@ -0,0 +1,131 @@
+package consul
+
+import (
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTargetURLRoundTrip(t *testing.T) {
+	caPath := filepath.Join(t.TempDir(), "internal ca.pem")
+	_, certPEM, _ := newSelfSignedCert(t, "internal-ca")
+	writeFile(t, caPath, certPEM)
+
+	target := Target{
+		ConsulAddr: "127.0.0.1:8500",
+		Service:    "user-service",
+		Scheme:     "https",
+		Tags:       []string{"primary", "a&b=c"},
+		Health:     HealthFilterFallbackToUnhealthy,
+		Token:      "to+ken/=",
+		DC:         "dc 1",
+		Segment:    "alpha",
+		Filter:     `Service.Meta.version == "2" and "x" in Service.Tags`,
+		TLS:        TLSConfig{InsecureSkipVerify: true, CABundleFile: caPath},
+
+		Version:           "^1.4",
+		ConsulSRV:         true,
+		OverridesKey:      "grpc/user-service/overrides",
+		Proxy:             "http://proxy.internal:3128",
+		PortName:          "grpc",
+		PreferNodeAddress: true,
+		PortZero:          PortZeroUseMetaPort,
+		LocalNode:         LocalNodeOnly,
+
+		DisableWANTranslation: true,
+		GateCheck:             "db-migrations-done",
+		Kind:                  KindConnectProxy,
+		DualStack:             true,
+		PriorityTags:          []string{"primary", "secondary"},
+		PreserveOrder:         true,
+		CheckTypes:            []string{"grpc", "http"},
+		SlowStart:             time.Minute,
+		MaxInstances:          50,
+		MaxInstancesPolicy:    MaxInstancesTruncate,
+		MinHealthyFraction:    0.3,
+		FailureTolerance:      30 * time.Second,
+		FailoverDCs:           []string{"dc 2", "dc3"},
+		Strict:                true,
+		WaitTime:              time.Minute,
+		Timeouts:              HTTPTimeouts{Dial: 5 * time.Second, TLSHandshake: 10 * time.Second, ResponseHeader: time.Minute},
+	}
+
+	got, err := ParseTarget(target.String())
+	if err != nil {
+		t.Fatal("ParseTarget() failed:", err)
+	}
+
+	if !reflect.DeepEqual(got, &target) {
+		t.Errorf("parsed target is %+v, want %+v", got, &target)
+	}
+}
+
+func TestTargetURLQualifiedServiceRoundTrip(t *testing.T) {
+	targets := []Target{
+		{Service: "user-service", Namespace: "billing", Health: HealthFilterOnlyHealthy},
+		{Service: "user-service", Partition: "team-a", Health: HealthFilterOnlyHealthy},
+		{Service: "user.service", Health: HealthFilterOnlyHealthy},
+		{Service: "user.service", DottedNamespace: true, Health: HealthFilterOnlyHealthy},
+		{Service: "user-service", Namespace: "billing", DottedNamespace: true, Health: HealthFilterOnlyHealthy},
+	}
+
+	for _, target := range targets {
+		t.Run(target.String(), func(t *testing.T) {
+			got, err := ParseTarget(target.String())
+			if err != nil {
+				t.Fatal("ParseTarget() failed:", err)
+			}
+
+			if !reflect.DeepEqual(got, &target) {
+				t.Errorf("parsed target is %+v, want %+v", got, &target)
+			}
+		})
+	}
+}
+
+func TestTargetURLOmitsUnsetOptions(t *testing.T) {
+	target := Target{Service: "metrics"}
+
+	if s := target.String(); s != "consul:///metrics" {
+		t.Errorf("target url is %q, want consul:///metrics", s)
+	}
+}
+
+func TestTargetValidateRejectsNegativeWaitTime(t *testing.T) {
+	target := Target{Service: "user-service", WaitTime: -time.Second}
+
+	if _, err := NewTargetBuilder("wait-test", &target); !errors.Is(err, ErrInvalidOptionValue) {
+		t.Errorf("NewTargetBuilder() returned error %v for a negative wait time, expected ErrInvalidOptionValue", err)
+	}
+}
+
+func TestTargetJSON(t *testing.T) {
+	const cfg = `{
+		"service": "user-service",
+		"tags": ["primary"],
+		"health": "fallbackToUnhealthy",
+		"tls": {"caFile": "/etc/consul/ca.pem"}
+	}`
+
+	var target Target
+	if err := json.Unmarshal([]byte(cfg), &target); err != nil {
+		t.Fatal("unmarshaling target failed:", err)
+	}
+
+	want := Target{
+		Service: "user-service",
+		Tags:    []string{"primary"},
+		Health:  HealthFilterFallbackToUnhealthy,
+		TLS:     TLSConfig{CAFile: "/etc/consul/ca.pem"},
+	}
+	if !reflect.DeepEqual(target, want) {
+		t.Errorf("unmarshaled target is %+v, want %+v", target, want)
+	}
+
+	if err := json.Unmarshal([]byte(`{"health": "sometimes"}`), &target); !errors.Is(err, ErrInvalidHealthFilter) {
+		t.Errorf("unmarshaling invalid health filter returned %v, want %v", err, ErrInvalidHealthFilter)
+	}
+}
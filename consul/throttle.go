@@ -0,0 +1,80 @@
+package consul
+
+import (
+	"sync"
+	"time"
+)
+
+// resolveNowThrottle limits how often ResolveNow calls of the gRPC channel
+// trigger queries. Calls within the interval after the last triggered query
+// are deferred until the interval expired, multiple deferred calls are
+// coalesced into one query.
+type resolveNowThrottle struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	last    time.Time
+	pending Timer
+	stopped bool
+}
+
+// allow returns true if a query can be triggered now. Otherwise it schedules
+// trigger to be called when the interval expired, unless it is already
+// scheduled, and returns false together with the delay.
+func (t *resolveNowThrottle) allow(clock Clock, trigger func()) (bool, time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.stopped {
+		return false, 0
+	}
+
+	now := clock.Now()
+	wait := t.last.Add(t.interval).Sub(now)
+	if t.last.IsZero() || wait <= 0 {
+		t.last = now
+		return true, 0
+	}
+
+	if t.pending == nil {
+		t.pending = clock.AfterFunc(wait, func() {
+			t.mu.Lock()
+			t.pending = nil
+			stopped := t.stopped
+			t.last = clock.Now()
+			t.mu.Unlock()
+
+			if !stopped {
+				trigger()
+			}
+		})
+	}
+
+	return false, wait
+}
+
+// stop cancels a deferred query and prevents scheduling new ones.
+func (t *resolveNowThrottle) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.stopped = true
+	if t.pending != nil {
+		t.pending.Stop()
+		t.pending = nil
+	}
+}
+
+// throttledResolveNow triggers a query if the ResolveNow interval allows
+// it, otherwise the query is deferred.
+func (c *consulResolver) throttledResolveNow() {
+	ok, delay := c.resolveNowThrottle.allow(c.clock, c.triggerQuery)
+	if ok {
+		c.triggerQuery()
+		return
+	}
+
+	if delay > 0 {
+		c.emit(&ResolveNowDeferred{Service: c.service, Delay: delay})
+	}
+}
@@ -0,0 +1,80 @@
+package consul
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResolveNowThrottleDefersAndCoalescesCalls(t *testing.T) {
+	clock := newFakeClock()
+	throttle := resolveNowThrottle{interval: 10 * time.Second}
+
+	triggered := make(chan struct{}, 10)
+	trigger := func() { triggered <- struct{}{} }
+
+	if ok, _ := throttle.allow(clock, trigger); !ok {
+		t.Fatal("first call was throttled")
+	}
+
+	clock.Advance(4 * time.Second)
+	for i := 0; i < 3; i++ {
+		ok, delay := throttle.allow(clock, trigger)
+		if ok {
+			t.Fatalf("call %d within the interval was not throttled", i)
+		}
+		if delay != 6*time.Second {
+			t.Errorf("call %d was deferred by %s, expected 6s", i, delay)
+		}
+	}
+
+	if n := clock.Timers(); n != 1 {
+		t.Fatalf("%d deferred queries are scheduled, expected the calls to be coalesced into 1", n)
+	}
+
+	clock.Advance(6 * time.Second)
+	select {
+	case <-triggered:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deferred query was not triggered after the interval expired")
+	}
+
+	// the deferred query starts a new interval
+	if ok, _ := throttle.allow(clock, trigger); ok {
+		t.Error("call directly after the deferred query was not throttled")
+	}
+
+	clock.Advance(10 * time.Second)
+	<-triggered
+
+	clock.Advance(10 * time.Second)
+	if ok, _ := throttle.allow(clock, trigger); !ok {
+		t.Error("call after the interval expired was throttled")
+	}
+}
+
+func TestResolveNowThrottleStopCancelsDeferredQuery(t *testing.T) {
+	clock := newFakeClock()
+	throttle := resolveNowThrottle{interval: time.Second}
+
+	var triggered atomic.Int32
+	trigger := func() { triggered.Add(1) }
+
+	throttle.allow(clock, trigger)
+	throttle.allow(clock, trigger)
+	throttle.stop()
+
+	clock.Advance(time.Minute)
+
+	if ok, _ := throttle.allow(clock, trigger); ok {
+		t.Error("call after stop() was not throttled")
+	}
+
+	if n := triggered.Load(); n != 0 {
+		t.Errorf("%d queries were triggered after stop(), expected none", n)
+	}
+
+	if n := clock.Timers(); n != 0 {
+		t.Errorf("%d deferred queries are scheduled after stop(), expected none", n)
+	}
+}
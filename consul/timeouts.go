@@ -0,0 +1,115 @@
+package consul
+
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
+// dialTimeout and dialKeepAlive are the dial timeout and keep-alive period
+// of connections to Consul used by the transport of the Consul client.
+const (
+	dialTimeout   = 30 * time.Second
+	dialKeepAlive = 30 * time.Second
+)
+
+// HTTPTimeouts are the timeouts of the HTTP connections to Consul.
+// Fields that are zero keep the defaults of the Consul client.
+type HTTPTimeouts struct {
+	// Dial is the maximum duration for establishing a TCP connection.
+	Dial time.Duration `json:"dial,omitempty" yaml:"dial,omitempty"`
+	// TLSHandshake is the maximum duration of the TLS handshake.
+	TLSHandshake time.Duration `json:"tlsHandshake,omitempty" yaml:"tlsHandshake,omitempty"`
+	// ResponseHeader is the maximum duration to wait for the response
+	// headers of a request, after it was sent. Blocking queries can wait
+	// additionally for the blocking query wait time.
+	ResponseHeader time.Duration `json:"responseHeader,omitempty" yaml:"responseHeader,omitempty"`
+}
+
+func (t *HTTPTimeouts) isZero() bool {
+	return *t == HTTPTimeouts{}
+}
+
+// withDefaults returns t with the fields that are zero set to the ones of
+// defaults.
+func (t HTTPTimeouts) withDefaults(defaults HTTPTimeouts) HTTPTimeouts {
+	if t.Dial == 0 {
+		t.Dial = defaults.Dial
+	}
+	if t.TLSHandshake == 0 {
+		t.TLSHandshake = defaults.TLSHandshake
+	}
+	if t.ResponseHeader == 0 {
+		t.ResponseHeader = defaults.ResponseHeader
+	}
+
+	return t
+}
+
+// newTransportDialer returns the dialer of the connections of transport.
+// If srv is not nil, its dialer is returned, otherwise a new one with the
+// defaults of the Consul client is set as dial function of transport.
+func newTransportDialer(transport *http.Transport, srv *srvDialer) *net.Dialer {
+	if srv != nil {
+		return &srv.dialer
+	}
+
+	dialer := &net.Dialer{Timeout: dialTimeout, KeepAlive: dialKeepAlive}
+	transport.DialContext = dialer.DialContext
+
+	return dialer
+}
+
+// apply sets the timeouts on transport and dialer. waitTime is the maximum
+// wait time of the blocking queries sent via transport.
+func (t *HTTPTimeouts) apply(transport *http.Transport, dialer *net.Dialer, waitTime time.Duration) {
+	if t.Dial > 0 {
+		dialer.Timeout = t.Dial
+	}
+
+	if t.TLSHandshake > 0 {
+		transport.TLSHandshakeTimeout = t.TLSHandshake
+	}
+
+	if t.ResponseHeader > 0 {
+		// Consul adds a random jitter of up to 1/16 of the wait time
+		// to blocking queries
+		transport.ResponseHeaderTimeout = t.ResponseHeader + waitTime + waitTime/16
+	}
+}
+
+// ConnectionPool configures the pool of HTTP connections to Consul.
+// Fields that are zero keep the defaults of the Consul client.
+type ConnectionPool struct {
+	// MaxIdleConns is the maximum number of idle connections.
+	MaxIdleConns int
+	// MaxIdleConnsPerHost is the maximum number of idle connections to
+	// a Consul server.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is the time after which idle connections are
+	// closed.
+	IdleConnTimeout time.Duration
+	// KeepAlive is the interval of TCP keep-alive probes, a negative
+	// value disables them.
+	KeepAlive time.Duration
+}
+
+func (p *ConnectionPool) isZero() bool {
+	return *p == ConnectionPool{}
+}
+
+// apply sets the pool settings on transport and dialer.
+func (p *ConnectionPool) apply(transport *http.Transport, dialer *net.Dialer) {
+	if p.MaxIdleConns > 0 {
+		transport.MaxIdleConns = p.MaxIdleConns
+	}
+	if p.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = p.MaxIdleConnsPerHost
+	}
+	if p.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = p.IdleConnTimeout
+	}
+	if p.KeepAlive != 0 {
+		dialer.KeepAlive = p.KeepAlive
+	}
+}
@@ -0,0 +1,100 @@
+package consul
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestHTTPTimeoutsAreAppliedToTransport(t *testing.T) {
+	var transport *http.Transport
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			transport = cfg.Transport
+			return mocks.NewConsulHealthClient(), nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	target, err := ParseTarget("consul://consul.internal:8500/test?tls-handshake-timeout=20s&response-header-timeout=1m")
+	if err != nil {
+		t.Fatal("ParseTarget() failed:", err)
+	}
+
+	opts := builderOptions{
+		waitTime: 16 * time.Minute,
+		timeouts: HTTPTimeouts{TLSHandshake: 5 * time.Second, ResponseHeader: time.Second, Dial: time.Second},
+	}
+	r, err := newConsulResolver(mocks.NewClientConn(), target, &opts)
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	if transport == nil {
+		t.Fatal("resolver does not use its own transport")
+	}
+
+	if transport.TLSHandshakeTimeout != 20*time.Second {
+		t.Errorf("TLS handshake timeout is %s, expected the 20s from the target", transport.TLSHandshakeTimeout)
+	}
+
+	// the wait time of blocking queries and the jitter Consul adds to
+	// it are added to the response header timeout
+	if want := time.Minute + 17*time.Minute; transport.ResponseHeaderTimeout != want {
+		t.Errorf("response header timeout is %s, expected %s", transport.ResponseHeaderTimeout, want)
+	}
+
+	if transport.DialContext == nil {
+		t.Error("dial function with the timeout of the builder is not set")
+	}
+}
+
+func TestInvalidTimeoutIsRejected(t *testing.T) {
+	for _, target := range []string{
+		"consul:///test?dial-timeout=-1s",
+		"consul:///test?response-header-timeout=abc",
+	} {
+		if _, err := ParseTarget(target); err == nil {
+			t.Errorf("ParseTarget(%q) succeeded, expected an error", target)
+		}
+	}
+}
+
+func TestConnectionPoolIsAppliedToTransport(t *testing.T) {
+	var transport *http.Transport
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			transport = cfg.Transport
+			return mocks.NewConsulHealthClient(), nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	target, err := ParseTarget("consul:///test")
+	if err != nil {
+		t.Fatal("ParseTarget() failed:", err)
+	}
+
+	opts := builderOptions{
+		pool: ConnectionPool{MaxIdleConns: 500, MaxIdleConnsPerHost: 100, IdleConnTimeout: 5 * time.Minute},
+	}
+	r, err := newConsulResolver(mocks.NewClientConn(), target, &opts)
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	if transport == nil {
+		t.Fatal("resolver does not use its own transport")
+	}
+
+	if transport.MaxIdleConns != 500 || transport.MaxIdleConnsPerHost != 100 || transport.IdleConnTimeout != 5*time.Minute {
+		t.Errorf("transport has MaxIdleConns %d, MaxIdleConnsPerHost %d and IdleConnTimeout %s, expected the settings of the pool",
+			transport.MaxIdleConns, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+	}
+}
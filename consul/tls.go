@@ -0,0 +1,192 @@
+package consul
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// transportTLS contains TLS settings that are not supported by the consul
+// client. They are applied to the transport of the client after it was
+// created.
+type transportTLS struct {
+	caBundle     []byte
+	certReloader *certReloader
+}
+
+// newTransportTLS returns the settings from t that must be applied to the
+// transport of the consul client. If none are required, nil is returned.
+func newTransportTLS(t *TLSConfig) (*transportTLS, error) {
+	var result transportTLS
+
+	caBundle, err := t.caBundle()
+	if err != nil {
+		return nil, err
+	}
+	result.caBundle = caBundle
+
+	if t.CertFile != "" && t.KeyFile != "" {
+		result.certReloader, err = newCertReloader(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(result.caBundle) == 0 && result.certReloader == nil {
+		return nil, nil
+	}
+
+	return &result, nil
+}
+
+func (t *transportTLS) apply(transport *http.Transport) error {
+	if transport.TLSClientConfig == nil {
+		transport.TLSClientConfig = &tls.Config{}
+	}
+
+	if len(t.caBundle) != 0 {
+		if err := appendCABundle(transport.TLSClientConfig, t.caBundle); err != nil {
+			return err
+		}
+	}
+
+	if t.certReloader != nil {
+		transport.TLSClientConfig.GetClientCertificate = t.certReloader.GetClientCertificate
+	}
+
+	return nil
+}
+
+// caBundle returns the PEM-encoded CA certificates that are added to the
+// certificate pool used to verify the Consul server.
+func (t *TLSConfig) caBundle() ([]byte, error) {
+	if t.CABundleFile == "" {
+		return t.CABundlePEM, nil
+	}
+
+	pem, err := os.ReadFile(t.CABundleFile)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCABundle, err)
+	}
+
+	return append(append([]byte{}, t.CABundlePEM...), pem...), nil
+}
+
+var errNoCertsInCABundle = fmt.Errorf("%w: no PEM-encoded certificates found", ErrInvalidCABundle)
+
+// validateCABundle checks that the CA bundle of t can be read and contains
+// certificates.
+func (t *TLSConfig) validateCABundle() error {
+	pem, err := t.caBundle()
+	if err != nil {
+		return err
+	}
+
+	if len(pem) == 0 {
+		return nil
+	}
+
+	if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+		return errNoCertsInCABundle
+	}
+
+	return nil
+}
+
+// appendCABundle adds the certificates in pem to the root CAs of cfg.
+// If cfg has no root CAs configured, the certificates are added to a copy of
+// the system certificate pool.
+func appendCABundle(cfg *tls.Config, pem []byte) error {
+	pool := cfg.RootCAs
+	if pool == nil {
+		var err error
+
+		pool, err = x509.SystemCertPool()
+		if err != nil {
+			return fmt.Errorf("loading system certificate pool failed: %w", err)
+		}
+	} else {
+		pool = pool.Clone()
+	}
+
+	if !pool.AppendCertsFromPEM(pem) {
+		return errNoCertsInCABundle
+	}
+
+	cfg.RootCAs = pool
+
+	return nil
+}
+
+// certReloader provides the client certificate for TLS handshakes.
+// The certificate is reloaded from the files when their modification time
+// changed, this allows to rotate short-lived certificates without
+// recreating the consul client.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu          sync.Mutex
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+
+	log *logSampler
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := certReloader{certFile: certFile, keyFile: keyFile, log: &sharedLog}
+
+	if err := r.reloadIfChanged(); err != nil {
+		return nil, err
+	}
+
+	return &r, nil
+}
+
+// reloadIfChanged loads the certificate if the modification time of one of
+// the files changed. r.mu must be held by the caller or r must not be shared
+// yet.
+func (r *certReloader) reloadIfChanged() error {
+	certStat, err := os.Stat(r.certFile)
+	if err != nil {
+		return fmt.Errorf("loading client certificate failed: %w", err)
+	}
+
+	keyStat, err := os.Stat(r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading client certificate key failed: %w", err)
+	}
+
+	if r.cert != nil && certStat.ModTime().Equal(r.certModTime) && keyStat.ModTime().Equal(r.keyModTime) {
+		return nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("loading client certificate failed: %w", err)
+	}
+
+	r.cert = &cert
+	r.certModTime = certStat.ModTime()
+	r.keyModTime = keyStat.ModTime()
+
+	return nil
+}
+
+// GetClientCertificate can be used as [tls.Config.GetClientCertificate].
+// If reloading the changed certificate fails, the previous one is returned.
+func (r *certReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err := r.reloadIfChanged(); err != nil {
+		r.log.warningf("grpc-consul-resolver: %s, using previous client certificate", err)
+	}
+
+	return r.cert, nil
+}
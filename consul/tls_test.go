@@ -0,0 +1,229 @@
+package consul
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func newSelfSignedCert(t *testing.T, cn string) (cert *x509.Certificate, certPEM, keyPEM []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl := x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err = x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cert,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCABundleIsAddedToRootCAs(t *testing.T) {
+	var cfg *consul.Config
+
+	cert, certPEM, _ := newSelfSignedCert(t, "internal-ca")
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	writeFile(t, caPath, certPEM)
+
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(c *consul.Config) (consulHealthEndpoint, error) {
+			cfg = c
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	cc := mocks.NewClientConn()
+	target := resolver.Target{URL: url.URL{Path: "test", RawQuery: "ca-bundle=" + url.QueryEscape(caPath)}}
+
+	r, err := NewBuilder().Build(target, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+	r.Close()
+
+	if cfg.Transport == nil || cfg.Transport.TLSClientConfig == nil {
+		t.Fatal("consul client transport has no tls config")
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{Roots: cfg.Transport.TLSClientConfig.RootCAs})
+	if err != nil {
+		t.Errorf("certificate from ca bundle is not trusted: %s", err)
+	}
+}
+
+func TestInvalidCABundleIsRejectedByParseTarget(t *testing.T) {
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	writeFile(t, caPath, []byte("no certificate"))
+
+	for _, path := range []string{caPath, filepath.Join(t.TempDir(), "missing.pem")} {
+		_, err := ParseTarget("consul://localhost/test?ca-bundle=" + url.QueryEscape(path))
+		if !errors.Is(err, ErrInvalidCABundle) {
+			t.Errorf("ParseTarget() with ca bundle %s returned %v, expected %v", path, err, ErrInvalidCABundle)
+		}
+	}
+
+	_, err := NewTargetBuilder("payments", &Target{Service: "test", TLS: TLSConfig{CABundlePEM: []byte("no certificate")}})
+	if !errors.Is(err, ErrInvalidCABundle) {
+		t.Errorf("NewTargetBuilder() returned %v, expected %v", err, ErrInvalidCABundle)
+	}
+}
+
+func TestInvalidCABundleFailsBuild(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(c *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	writeFile(t, caPath, []byte("no certificate"))
+
+	for _, path := range []string{caPath, filepath.Join(t.TempDir(), "missing.pem")} {
+		target := resolver.Target{URL: url.URL{Path: "test", RawQuery: "ca-bundle=" + url.QueryEscape(path)}}
+
+		_, err := NewBuilder().Build(target, mocks.NewClientConn(), resolver.BuildOptions{})
+		if err == nil {
+			t.Errorf("Build() with ca bundle %s succeeded, expected an error", path)
+		}
+	}
+}
+
+func TestClientCertificateIsReloaded(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+
+	cert1, certPEM, keyPEM := newSelfSignedCert(t, "client-1")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+
+	r, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal("newCertReloader() failed:", err)
+	}
+
+	tlsCert, err := r.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal("GetClientCertificate() failed:", err)
+	}
+	if !bytesEqualCert(tlsCert, cert1) {
+		t.Fatal("GetClientCertificate() did not return the initial certificate")
+	}
+
+	cert2, certPEM, keyPEM := newSelfSignedCert(t, "client-2")
+	writeFile(t, certFile, certPEM)
+	writeFile(t, keyFile, keyPEM)
+
+	modTime := time.Now().Add(time.Minute)
+	for _, f := range []string{certFile, keyFile} {
+		if err := os.Chtimes(f, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tlsCert, err = r.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal("GetClientCertificate() failed:", err)
+	}
+	if !bytesEqualCert(tlsCert, cert2) {
+		t.Error("GetClientCertificate() did not return the rotated certificate")
+	}
+
+	writeFile(t, certFile, []byte("broken"))
+	modTime = modTime.Add(time.Minute)
+	if err := os.Chtimes(certFile, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	tlsCert, err = r.GetClientCertificate(nil)
+	if err != nil {
+		t.Fatal("GetClientCertificate() failed:", err)
+	}
+	if !bytesEqualCert(tlsCert, cert2) {
+		t.Error("GetClientCertificate() did not return the previous certificate when loading failed")
+	}
+}
+
+func bytesEqualCert(tlsCert *tls.Certificate, cert *x509.Certificate) bool {
+	return len(tlsCert.Certificate) > 0 && bytes.Equal(tlsCert.Certificate[0], cert.Raw)
+}
+
+func TestTLSVerifyFalseDisablesVerification(t *testing.T) {
+	var cfg *consul.Config
+
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(c *consul.Config) (consulHealthEndpoint, error) {
+			cfg = c
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	target := resolver.Target{URL: url.URL{Path: "test", RawQuery: "scheme=https&tls-verify=false"}}
+
+	r, err := NewBuilder().Build(target, mocks.NewClientConn(), resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err.Error())
+	}
+	r.Close()
+
+	if !cfg.TLSConfig.InsecureSkipVerify {
+		t.Error("consul client tls config has InsecureSkipVerify disabled, expected it to be enabled")
+	}
+}
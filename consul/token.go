@@ -0,0 +1,117 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// TokenSource acquires ACL tokens that expire, e.g. via a Consul auth
+// method.
+//
+// Token is called from the resolver goroutines and must be safe for
+// concurrent use.
+type TokenSource interface {
+	// Token returns a token for the service and the time when it
+	// expires. A zero expiration time means the token does not expire.
+	Token(ctx context.Context, service string) (token string, expires time.Time, err error)
+}
+
+// tokenRenewer provides the current token of a [TokenSource] to a resolver.
+// The token is renewed after 90% of its lifetime passed.
+type tokenRenewer struct {
+	src     TokenSource
+	service string
+	clock   Clock
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+	renewAt time.Time
+}
+
+// get returns the current token, it is acquired from the source if there
+// is none or it is due for renewal.
+// If the renewal fails while the current token did not expire yet, the
+// current token is returned together with the error.
+func (r *tokenRenewer) get(ctx context.Context) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.clock.Now()
+	if r.token != "" && (r.renewAt.IsZero() || now.Before(r.renewAt)) {
+		return r.token, nil
+	}
+
+	token, expires, err := r.src.Token(ctx, r.service)
+	if err != nil || token == "" {
+		if err == nil {
+			err = errors.New("token source returned an empty token")
+		}
+		err = fmt.Errorf("acquiring ACL token for service '%s' failed: %w", r.service, err)
+
+		if r.token != "" && now.Before(r.expires) {
+			return r.token, err
+		}
+
+		return "", err
+	}
+
+	r.token, r.expires, r.renewAt = token, expires, time.Time{}
+	if !expires.IsZero() {
+		r.renewAt = now.Add(expires.Sub(now) * 9 / 10)
+	}
+
+	return token, nil
+}
+
+// renewIn returns the duration until the current token must be renewed.
+// It returns 0 if the token does not expire.
+func (r *tokenRenewer) renewIn() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.renewAt.IsZero() {
+		return 0
+	}
+
+	return max(r.renewAt.Sub(r.clock.Now()), time.Second)
+}
+
+// invalidate discards the current token, the next call of get acquires a
+// new one.
+func (r *tokenRenewer) invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.token = ""
+}
+
+// isTokenRejected returns true if err is the response of Consul or Nomad
+// to a request with an expired or unknown ACL token.
+func isTokenRejected(err error) bool {
+	var se consul.StatusError
+	return errors.As(err, &se) && se.Code == http.StatusForbidden
+}
+
+// setToken sets the token of the token source on opts, if the resolver
+// uses one. Errors are logged, the token of the client is used then.
+func (c *consulResolver) setToken(opts *consul.QueryOptions) {
+	if c.tokens == nil {
+		return
+	}
+
+	token, err := c.tokens.get(opts.Context())
+	if err != nil {
+		c.log.warningf("grpc-consul-resolver: %v", redactError(err, c.secrets))
+	}
+
+	if token != "" {
+		opts.Token = token
+	}
+}
@@ -0,0 +1,138 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+// fakeTokenSource returns the tokens token-1, token-2, ... that expire after
+// ttl.
+type fakeTokenSource struct {
+	clock Clock
+	ttl   time.Duration
+
+	mu    sync.Mutex
+	calls int
+	err   error
+}
+
+func (s *fakeTokenSource) Token(_ context.Context, _ string) (string, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.calls++
+	if s.err != nil {
+		return "", time.Time{}, s.err
+	}
+
+	return fmt.Sprintf("token-%d", s.calls), s.clock.Now().Add(s.ttl), nil
+}
+
+func (s *fakeTokenSource) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.err = err
+}
+
+func TestTokenRenewer(t *testing.T) {
+	clock := newFakeClock()
+	src := fakeTokenSource{clock: clock, ttl: 10 * time.Minute}
+	r := tokenRenewer{src: &src, service: "token-test", clock: clock}
+
+	expectToken := func(want string, wantErr bool) {
+		t.Helper()
+
+		token, err := r.get(context.Background())
+		if token != want || (err != nil) != wantErr {
+			t.Fatalf("get() returned token %q and error %v, expected token %q and error: %t", token, err, want, wantErr)
+		}
+	}
+
+	expectToken("token-1", false)
+	if d := r.renewIn(); d != 9*time.Minute {
+		t.Errorf("token is renewed in %s, expected after 90%% of its lifetime", d)
+	}
+
+	clock.Advance(8 * time.Minute)
+	expectToken("token-1", false)
+
+	clock.Advance(time.Minute)
+	expectToken("token-2", false)
+
+	// the current token is used while renewals fail, until it expired
+	src.setErr(errors.New("auth method unavailable"))
+	clock.Advance(9 * time.Minute)
+	expectToken("token-2", true)
+
+	clock.Advance(time.Minute)
+	expectToken("", true)
+
+	src.setErr(nil)
+	expectToken("token-5", false)
+
+	r.invalidate()
+	expectToken("token-6", false)
+}
+
+func TestRejectedTokenIsRenewed(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{{Address: "127.0.0.1", Port: 1}})
+
+	target, err := ParseTarget("consul:///token-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := newFakeClock()
+	src := fakeTokenSource{clock: clock, ttl: 5 * time.Minute}
+	cc := mocks.NewClientConn()
+	r, err := newConsulResolver(cc, target, &builderOptions{
+		clock:       clock,
+		tokenSource: &src,
+	})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	if !r.poll() {
+		t.Fatal("query failed")
+	}
+	if token := health.LastQueryOptions().Token; token != "token-1" {
+		t.Errorf("query was sent with token %q, expected token-1", token)
+	}
+	if wt := health.LastQueryOptions().WaitTime; wt != 4*time.Minute+30*time.Second {
+		t.Errorf("blocking query waits for %s, expected it to end when the token is renewed", wt)
+	}
+
+	health.SetRespError(consul.StatusError{Code: 403, Body: "ACL not found"})
+	if !r.poll() {
+		t.Fatal("query that failed because the token was rejected was not retried")
+	}
+
+	if r.poll() {
+		t.Fatal("query was retried again after the new token was also rejected")
+	}
+	if token := health.LastQueryOptions().Token; token != "token-2" {
+		t.Errorf("retried query was sent with token %q, expected token-2", token)
+	}
+	if cc.LastReportedError() == nil {
+		t.Error("rejected token was not reported as error")
+	}
+}
@@ -0,0 +1,82 @@
+package consul
+
+import (
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// failureTolerance treats instances with failing health checks as passing
+// until their checks failed for longer than window, to not exclude them
+// because of short failures.
+// It is only accessed by the goroutine that runs poll().
+type failureTolerance struct {
+	window time.Duration
+	// failingSince contains the time when the checks of instances were
+	// seen failing the first time, by their node and service ID. It is
+	// zero for instances that were failing in the first query, they are
+	// not tolerated because it is unknown since when they are failing.
+	failingSince map[string]time.Time
+}
+
+// apply returns entries where the instances that are failing for less than
+// the window are replaced by copies whose failing checks are passing. It
+// also returns the duration until the tolerance of the next instance
+// expires, 0 if none is tolerated.
+// Instances in maintenance mode are never tolerated.
+func (f *failureTolerance) apply(now time.Time, entries []*consul.ServiceEntry) ([]*consul.ServiceEntry, time.Duration) {
+	initial := f.failingSince == nil
+
+	failing := make(map[string]time.Time, len(f.failingSince))
+	result := make([]*consul.ServiceEntry, 0, len(entries))
+	var next time.Duration
+
+	for _, e := range entries {
+		status := e.Checks.AggregatedStatus()
+		if status != consul.HealthWarning && status != consul.HealthCritical {
+			result = append(result, e)
+			continue
+		}
+
+		key := instanceKey(e)
+		since, exists := f.failingSince[key]
+		if !exists && !initial {
+			since = now
+		}
+		failing[key] = since
+
+		elapsed := now.Sub(since)
+		if since.IsZero() || elapsed >= f.window {
+			result = append(result, e)
+			continue
+		}
+
+		result = append(result, withPassingChecks(e))
+
+		if remaining := f.window - elapsed; next == 0 || remaining < next {
+			next = remaining
+		}
+	}
+
+	f.failingSince = failing
+
+	return result, next
+}
+
+// withPassingChecks returns a copy of e whose warning and critical checks are
+// passing.
+func withPassingChecks(e *consul.ServiceEntry) *consul.ServiceEntry {
+	result := *e
+
+	result.Checks = make(consul.HealthChecks, 0, len(e.Checks))
+	for _, c := range e.Checks {
+		if c.Status == consul.HealthWarning || c.Status == consul.HealthCritical {
+			pc := *c
+			pc.Status = consul.HealthPassing
+			c = &pc
+		}
+		result.Checks = append(result.Checks, c)
+	}
+
+	return &result
+}
@@ -0,0 +1,98 @@
+package consul
+
+import (
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestFailureTolerance(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	entries := func(statusB string) []*consul.ServiceEntry {
+		return []*consul.ServiceEntry{
+			{
+				Service: &consul.AgentService{ID: "a", Address: "10.0.0.1", Port: 1},
+				Checks:  consul.HealthChecks{{Name: "grpc", Status: consul.HealthPassing}},
+			},
+			{
+				Service: &consul.AgentService{ID: "b", Address: "10.0.0.2", Port: 1},
+				Checks:  consul.HealthChecks{{Name: "grpc", Status: statusB}},
+			},
+			{
+				Service: &consul.AgentService{ID: "c", Address: "10.0.0.3", Port: 1},
+				Checks:  consul.HealthChecks{{Name: "grpc", Status: consul.HealthCritical}},
+			},
+		}
+	}
+
+	health.SetRespEntries(entries(consul.HealthPassing))
+	health.SetRespIndex(1)
+
+	target, err := ParseTarget("consul:///tolerance-test?failure-tolerance=30s")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := newFakeClock()
+	cc := mocks.NewClientConn()
+	r, err := newConsulResolver(cc, target, &builderOptions{clock: clock})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	r.poll()
+	if got := addrStrings(cc.Addrs()); len(got) != 2 {
+		t.Fatalf("resolved to %v, expected the 2 passing instances, failing instances of the first query must not be tolerated", got)
+	}
+
+	health.SetRespEntries(entries(consul.HealthCritical))
+	health.SetRespIndex(2)
+	r.poll()
+	if got := addrStrings(cc.Addrs()); len(got) != 2 || got[1] != "10.0.0.2:1" {
+		t.Errorf("resolved to %v, expected the newly failing instance 10.0.0.2:1 to be tolerated", got)
+	}
+	if r.toleranceWait != 30*time.Second {
+		t.Errorf("next query waits at most %s, expected 30s until the tolerance expires", r.toleranceWait)
+	}
+
+	clock.Advance(20 * time.Second)
+	health.SetRespIndex(3)
+	r.poll()
+	if got := addrStrings(cc.Addrs()); len(got) != 2 {
+		t.Errorf("resolved to %v after 20s, expected the failing instance to still be tolerated", got)
+	}
+	if r.toleranceWait != 10*time.Second {
+		t.Errorf("next query waits at most %s, expected 10s until the tolerance expires", r.toleranceWait)
+	}
+
+	clock.Advance(10 * time.Second)
+	health.SetRespIndex(4)
+	r.poll()
+	if got := addrStrings(cc.Addrs()); len(got) != 1 || got[0] != "10.0.0.1:1" {
+		t.Errorf("resolved to %v after 30s, expected only the passing instance 10.0.0.1:1", got)
+	}
+	if r.toleranceWait != 0 {
+		t.Errorf("next query waits at most %s, expected no limit when no instance is tolerated", r.toleranceWait)
+	}
+
+	health.SetRespEntries(entries(consul.HealthPassing))
+	health.SetRespIndex(5)
+	r.poll()
+	health.SetRespEntries(entries(consul.HealthWarning))
+	health.SetRespIndex(6)
+	r.poll()
+	if got := addrStrings(cc.Addrs()); len(got) != 2 {
+		t.Errorf("resolved to %v, expected the tolerance to restart after the instance recovered", got)
+	}
+}
@@ -0,0 +1,50 @@
+package consul
+
+import (
+	"fmt"
+	"time"
+)
+
+// traceSize is the number of events that are kept in the trace of a
+// resolver.
+const traceSize = 64
+
+// TraceEvent is a significant event in the history of a resolver, like a
+// failed query or an update of the addresses.
+type TraceEvent struct {
+	Time    time.Time
+	Message string
+}
+
+// traceRing contains the last traceSize events of a resolver.
+type traceRing struct {
+	events [traceSize]TraceEvent
+	next   int
+	full   bool
+}
+
+func (r *traceRing) add(e TraceEvent) {
+	r.events[r.next] = e
+	r.next = (r.next + 1) % traceSize
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// all returns the events ordered from the oldest to the newest.
+func (r *traceRing) all() []TraceEvent {
+	if !r.full {
+		return append([]TraceEvent(nil), r.events[:r.next]...)
+	}
+
+	return append(append([]TraceEvent(nil), r.events[r.next:]...), r.events[:r.next]...)
+}
+
+// tracef adds an event with the formatted message to the trace of the
+// resolver. Messages must not contain secrets.
+func (s *resolverStatus) tracef(format string, args ...any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trace.add(TraceEvent{Time: s.clock.Now(), Message: fmt.Sprintf(format, args...)})
+}
@@ -0,0 +1,87 @@
+package consul
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestTraceRingKeepsLastEvents(t *testing.T) {
+	var r traceRing
+
+	for i := 0; i < traceSize+10; i++ {
+		r.add(TraceEvent{Message: fmt.Sprint(i)})
+	}
+
+	events := r.all()
+	if len(events) != traceSize {
+		t.Fatalf("trace contains %d events, expected %d", len(events), traceSize)
+	}
+
+	if events[0].Message != "10" || events[traceSize-1].Message != fmt.Sprint(traceSize+9) {
+		t.Errorf("trace contains the events %s to %s, expected the last %d ones oldest first",
+			events[0].Message, events[traceSize-1].Message, traceSize)
+	}
+}
+
+func TestResolverHealthContainsTrace(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	health.SetRespServiceEntries([]*consul.AgentService{{Address: "10.0.0.1", Port: 80}})
+
+	cc := mocks.NewClientConn()
+	target := resolver.Target{URL: url.URL{Path: "/trace-test", RawQuery: "token=secret-token"}}
+	r, err := NewBuilder().Build(target, cc, resolver.BuildOptions{})
+	if err != nil {
+		t.Fatal("Build() failed:", err)
+	}
+	defer r.Close()
+
+	traceOf := func() []string {
+		for _, h := range ResolversHealth() {
+			if h.Service != "trace-test" {
+				continue
+			}
+
+			var result []string
+			for _, e := range h.Trace {
+				result = append(result, e.Message)
+			}
+			return result
+		}
+
+		return nil
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(traceOf()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("trace contains only %v", traceOf())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	trace := traceOf()
+	for i, prefix := range []string{"resolver built for ", "passed 1 addresses to the channel", "first resolution to 1 addresses"} {
+		if !strings.HasPrefix(trace[i], prefix) {
+			t.Errorf("trace event %d is %q, expected it to start with %q", i, trace[i], prefix)
+		}
+	}
+
+	if strings.Contains(strings.Join(trace, "\n"), "secret-token") {
+		t.Errorf("trace contains the token: %v", trace)
+	}
+}
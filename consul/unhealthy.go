@@ -0,0 +1,157 @@
+package consul
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+const (
+	// maxReportedChecks is the maximum number of failing checks that are
+	// listed in a [NoHealthyInstancesError].
+	maxReportedChecks = 5
+	// maxCheckOutputLen is the maximum length of the output of a failing
+	// check that is included in a [NoHealthyInstancesError].
+	maxCheckOutputLen = 120
+)
+
+// FailingCheck summarizes a health check that is failing on instances of a
+// service.
+type FailingCheck struct {
+	Name   string
+	Status string
+	// Instances is the number of instances the check is failing on.
+	Instances int
+	// Output is the truncated output of the check on one of the
+	// instances.
+	Output string
+}
+
+// NoHealthyInstancesError is reported when a service has instances but none
+// of them pass their health checks.
+type NoHealthyInstancesError struct {
+	Service string
+	// Instances is the number of unhealthy instances.
+	Instances int
+	// Checks are the failing checks, ordered by the number of instances
+	// they are failing on. At most 5 are included.
+	Checks []FailingCheck
+}
+
+func (e *NoHealthyInstancesError) Error() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "service '%s' has no healthy instances, %d are unhealthy", e.Service, e.Instances)
+
+	for i, c := range e.Checks {
+		if i == 0 {
+			sb.WriteString(": ")
+		} else {
+			sb.WriteString(", ")
+		}
+
+		fmt.Fprintf(&sb, "check '%s' is %s on %d instances", c.Name, c.Status, c.Instances)
+		if c.Output != "" {
+			fmt.Fprintf(&sb, " (%s)", c.Output)
+		}
+	}
+
+	return sb.String()
+}
+
+// emptyResolutionError explains why the service resolved to no addresses.
+// It queries the instances of the service including the unhealthy ones.
+// If none are registered and the strict option is set, an error wrapping
+// [ErrServiceNotFound] is returned. If there are any and only healthy
+// instances are resolved, a NoHealthyInstancesError that describes their
+// failing checks is returned, otherwise nil.
+func (c *consulResolver) emptyResolutionError(settings *querySettings) error {
+	opts := (&consul.QueryOptions{
+		Namespace: c.queryOpts.Namespace,
+		Partition: c.queryOpts.Partition,
+		NodeMeta:  c.queryOpts.NodeMeta,
+		Filter:    settings.filter,
+	}).WithContext(c.ctx)
+	c.setToken(opts)
+
+	entries, _, err := c.fetch(settings.tags, false, opts)
+	if err != nil {
+		return nil
+	}
+
+	if len(entries) == 0 {
+		if c.strict {
+			return fmt.Errorf("%w: '%s'", ErrServiceNotFound, c.service)
+		}
+		return nil
+	}
+
+	if settings.healthFilter != HealthFilterOnlyHealthy {
+		return nil
+	}
+
+	if len(c.checkTypes) != 0 {
+		entries = filterCheckTypes(entries, c.checkTypes)
+	}
+
+	return noHealthyInstancesError(c.service, entries)
+}
+
+func noHealthyInstancesError(service string, entries []*consul.ServiceEntry) *NoHealthyInstancesError {
+	type checkKey struct{ name, status string }
+
+	checks := map[checkKey]*FailingCheck{}
+	for _, e := range entries {
+		for _, hc := range e.Checks {
+			if hc.Status == consul.HealthPassing {
+				continue
+			}
+
+			k := checkKey{name: hc.Name, status: hc.Status}
+			fc := checks[k]
+			if fc == nil {
+				fc = &FailingCheck{Name: hc.Name, Status: hc.Status, Output: truncate(strings.TrimSpace(hc.Output), maxCheckOutputLen)}
+				checks[k] = fc
+			}
+			fc.Instances++
+		}
+	}
+
+	result := make([]FailingCheck, 0, len(checks))
+	for _, fc := range checks {
+		result = append(result, *fc)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Instances != result[j].Instances {
+			return result[i].Instances > result[j].Instances
+		}
+		if result[i].Name != result[j].Name {
+			return result[i].Name < result[j].Name
+		}
+		return result[i].Status < result[j].Status
+	})
+
+	if len(result) > maxReportedChecks {
+		result = result[:maxReportedChecks]
+	}
+
+	return &NoHealthyInstancesError{Service: service, Instances: len(entries), Checks: result}
+}
+
+// truncate shortens s to at most n bytes, without splitting UTF-8 encoded
+// runes.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	return s[:n] + "..."
+}
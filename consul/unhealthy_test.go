@@ -0,0 +1,149 @@
+package consul
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+// unhealthyHealthClient returns its entries only for queries that include
+// unhealthy instances.
+type unhealthyHealthClient struct {
+	entries []*consul.ServiceEntry
+}
+
+func (c *unhealthyHealthClient) ServiceMultipleTags(_ string, _ []string, passingOnly bool, _ *consul.QueryOptions) ([]*consul.ServiceEntry, *consul.QueryMeta, error) {
+	if passingOnly {
+		return []*consul.ServiceEntry{}, &consul.QueryMeta{LastIndex: 1}, nil
+	}
+
+	return c.entries, &consul.QueryMeta{LastIndex: 1}, nil
+}
+
+func TestNoHealthyInstancesErrorIsReported(t *testing.T) {
+	entry := func(id string, checks ...*consul.HealthCheck) *consul.ServiceEntry {
+		return &consul.ServiceEntry{
+			Service: &consul.AgentService{ID: id, Address: "127.0.0.1", Port: 1},
+			Checks:  checks,
+		}
+	}
+
+	health := &unhealthyHealthClient{entries: []*consul.ServiceEntry{
+		entry("a",
+			&consul.HealthCheck{Name: "Serf Health Status", Status: consul.HealthPassing},
+			&consul.HealthCheck{Name: "grpc", Status: consul.HealthCritical, Output: "connection refused " + strings.Repeat("x", 200)},
+		),
+		entry("b",
+			&consul.HealthCheck{Name: "grpc", Status: consul.HealthCritical, Output: "connection refused"},
+			&consul.HealthCheck{Name: "disk", Status: consul.HealthWarning},
+		),
+	}}
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	target, err := ParseTarget("consul:///user-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc := mocks.NewClientConn()
+	r, err := newConsulResolver(cc, target, &builderOptions{})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	r.poll()
+
+	var nhe *NoHealthyInstancesError
+	if !errors.As(cc.LastReportedError(), &nhe) {
+		t.Fatalf("reported error is %v, expected a NoHealthyInstancesError", cc.LastReportedError())
+	}
+
+	if nhe.Instances != 2 || len(nhe.Checks) != 2 {
+		t.Fatalf("error reports %d instances and checks %+v, expected 2 instances and 2 checks", nhe.Instances, nhe.Checks)
+	}
+
+	grpcCheck := nhe.Checks[0]
+	if grpcCheck.Name != "grpc" || grpcCheck.Status != consul.HealthCritical || grpcCheck.Instances != 2 {
+		t.Errorf("first failing check is %+v, expected grpc critical on 2 instances", grpcCheck)
+	}
+
+	if len(grpcCheck.Output) > maxCheckOutputLen+len("...") {
+		t.Errorf("output of the check was not truncated, it has %d bytes", len(grpcCheck.Output))
+	}
+
+	if msg := nhe.Error(); !strings.Contains(msg, "check 'grpc' is critical on 2 instances") {
+		t.Errorf("error message %q does not describe the failing check", msg)
+	}
+}
+
+func TestNoErrorIsReportedWithoutInstances(t *testing.T) {
+	health := &unhealthyHealthClient{}
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	target, err := ParseTarget("consul:///user-service")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cc := mocks.NewClientConn()
+	r, err := newConsulResolver(cc, target, &builderOptions{})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	r.poll()
+
+	if err := cc.LastReportedError(); err != nil {
+		t.Errorf("error %v was reported for a service without instances", err)
+	}
+}
+
+func TestServiceNotFoundIsReportedInStrictMode(t *testing.T) {
+	health := &unhealthyHealthClient{}
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	for _, target := range []string{"consul:///user-service?strict=true", "consul:///user-service?strict=true&health=fallbackToUnhealthy"} {
+		t.Run(target, func(t *testing.T) {
+			pt, err := ParseTarget(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cc := mocks.NewClientConn()
+			r, err := newConsulResolver(cc, pt, &builderOptions{})
+			if err != nil {
+				t.Fatal("newConsulResolver() failed:", err)
+			}
+			defer r.Close()
+
+			r.poll()
+
+			if err := cc.LastReportedError(); !errors.Is(err, ErrServiceNotFound) || status.Code(err) != codes.NotFound {
+				t.Errorf("reported error is %v, expected ErrServiceNotFound with status code NotFound", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package consul
+
+import (
+	"time"
+
+	"google.golang.org/grpc/resolver"
+)
+
+// warmUpSteps is the number of steps in which the weight of new instances is
+// increased during the slow-start window.
+const warmUpSteps = 10
+
+// warmUp reduces the weight of instances that appeared less than window ago.
+// It is only accessed by the goroutine that runs poll().
+type warmUp struct {
+	window time.Duration
+	// firstSeen contains the time when the instances were resolved the
+	// first time by their address keys. It is zero for instances that
+	// were part of the first resolution of the service.
+	firstSeen map[string]time.Time
+}
+
+// apply sets the warm-up factor attribute of the addresses of instances that
+// are warming up. It returns the duration until the weight of
+// an instance has to be increased the next time, or 0 if no instance is
+// warming up.
+func (w *warmUp) apply(now time.Time, addresses []resolver.Address, key addressKey) time.Duration {
+	// the instances of the first resolution, e.g. after the client
+	// started, are not new
+	initial := w.firstSeen == nil
+
+	seen := make(map[string]time.Time, len(addresses))
+	var next time.Duration
+
+	for i, a := range addresses {
+		k := key(a)
+		first, exists := w.firstSeen[k]
+		if !exists && !initial {
+			first = now
+		}
+		seen[k] = first
+
+		elapsed := now.Sub(first)
+		if first.IsZero() || elapsed >= w.window {
+			continue
+		}
+
+		step := int(elapsed*warmUpSteps/w.window) + 1
+		factor := float64(step) / warmUpSteps
+
+		addresses[i].BalancerAttributes = a.BalancerAttributes.WithValue(warmUpAttributeKey, factor)
+
+		untilStep := first.Add(time.Duration(step) * w.window / warmUpSteps).Sub(now)
+		if next == 0 || untilStep < next {
+			next = untilStep
+		}
+	}
+
+	w.firstSeen = seen
+
+	return next
+}
+
+// WarmUpFactor returns the fraction of its full weight that the instance addr
+// was resolved from should receive while it warms up, for targets with the
+// slow-start option. It increases in steps from 0.1 to 1 during the
+// slow-start window. Weighted balancers multiply it with the [Weight] of
+// the instance.
+// It is only available while the instance warms up.
+func WarmUpFactor(addr resolver.Address) (float64, bool) {
+	f, ok := addr.BalancerAttributes.Value(warmUpAttributeKey).(float64)
+	return f, ok
+}
@@ -0,0 +1,108 @@
+package consul
+
+import (
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"google.golang.org/grpc/resolver"
+
+	"github.com/simplesurance/grpcconsulresolver/internal/mocks"
+)
+
+func TestSlowStart(t *testing.T) {
+	health := mocks.NewConsulHealthClient()
+	cleanup := replaceCreateHealthClientFn(
+		func(cfg *consul.Config) (consulHealthEndpoint, error) {
+			return health, nil
+		},
+	)
+	t.Cleanup(cleanup)
+
+	instances := []*consul.AgentService{
+		{Address: "10.0.0.1", Port: 1},
+		{Address: "10.0.0.2", Port: 1},
+		{Address: "10.0.0.3", Port: 1},
+	}
+	health.SetRespServiceEntries(instances[:2])
+	health.SetRespIndex(1)
+
+	target, err := ParseTarget("consul:///slow-start-test?slow-start=1m")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := newFakeClock()
+	cc := mocks.NewClientConn()
+	r, err := newConsulResolver(cc, target, &builderOptions{clock: clock})
+	if err != nil {
+		t.Fatal("newConsulResolver() failed:", err)
+	}
+	defer r.Close()
+
+	factors := func() map[string]float64 {
+		res := map[string]float64{}
+		for _, a := range cc.Addrs() {
+			if f, ok := WarmUpFactor(a); ok {
+				res[a.Addr] = f
+			}
+		}
+		return res
+	}
+
+	// a new index prevents that poll() waits on the fake clock because
+	// the mock responds too fast with the same data
+	poll := func(index uint64) {
+		health.SetRespIndex(index)
+		r.poll()
+	}
+
+	poll(1)
+	if f := factors(); len(f) != 0 {
+		t.Errorf("instances of the first resolution have warm-up factors %v, expected none", f)
+	}
+
+	health.SetRespServiceEntries(instances)
+	poll(2)
+	if f := factors(); len(f) != 1 || f["10.0.0.3:1"] != 0.1 {
+		t.Errorf("warm-up factors are %v, expected 0.1 for the new instance", f)
+	}
+
+	clock.Advance(30 * time.Second)
+	poll(3)
+	if f := factors(); len(f) != 1 || f["10.0.0.3:1"] != 0.6 {
+		t.Errorf("warm-up factors after 30s are %v, expected 0.6 for the new instance", f)
+	}
+	if wt := health.LastQueryOptions().WaitTime; wt != 6*time.Second {
+		t.Errorf("blocking query waited up to %s, expected 6s until the next warm-up step", wt)
+	}
+
+	clock.Advance(30 * time.Second)
+	poll(4)
+	if f := factors(); len(f) != 0 {
+		t.Errorf("warm-up factors after the slow-start window are %v, expected none", f)
+	}
+	if cnt := cc.UpdateStateCallCnt(); cnt != 4 {
+		t.Errorf("UpdateState was called %d times, expected 4", cnt)
+	}
+}
+
+func TestWarmUpForgetsRemovedInstances(t *testing.T) {
+	w := warmUp{window: time.Minute}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := resolver.Address{Addr: "10.0.0.1:1"}
+	b := resolver.Address{Addr: "10.0.0.2:1"}
+
+	w.apply(now, []resolver.Address{a}, addrKey)
+	w.apply(now, []resolver.Address{a, b}, addrKey)
+	w.apply(now.Add(time.Hour), []resolver.Address{a}, addrKey)
+
+	addrs := []resolver.Address{a, b}
+	if next := w.apply(now.Add(2*time.Hour), addrs, addrKey); next != time.Minute/warmUpSteps {
+		t.Errorf("apply() returned %s, expected %s until the next step", next, time.Minute/warmUpSteps)
+	}
+
+	if f, ok := WarmUpFactor(addrs[1]); !ok || f != 0.1 {
+		t.Errorf("re-added instance has warm-up factor %v, %t, expected 0.1", f, ok)
+	}
+}
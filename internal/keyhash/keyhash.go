@@ -0,0 +1,28 @@
+// Package keyhash provides the hash function used by the balancers to map
+// request keys to instances.
+package keyhash
+
+import "hash/fnv"
+
+// Sum64 returns the 64-bit hash of the concatenation of parts. Parts are
+// separated, ("ab", "c") and ("a", "bc") have different hashes.
+func Sum64(parts ...string) uint64 {
+	h := fnv.New64a()
+	for i, p := range parts {
+		if i > 0 {
+			_, _ = h.Write([]byte{0})
+		}
+		_, _ = h.Write([]byte(p))
+	}
+
+	// fnv does not distribute similar inputs well, finalize it with the
+	// mixing function of splitmix64
+	x := h.Sum64()
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+
+	return x
+}
@@ -11,8 +11,10 @@ import (
 type ClientConn struct {
 	mutex             sync.Mutex
 	addrs             []resolver.Address
+	endpoints         []resolver.Endpoint
 	newAddressCallCnt int
 	lastReportedError error
+	updateStateErr    error
 }
 
 func NewClientConn() *ClientConn {
@@ -42,9 +44,18 @@ func (t *ClientConn) UpdateState(state resolver.State) error {
 	defer t.mutex.Unlock()
 
 	t.addrs = state.Addresses
+	t.endpoints = state.Endpoints
 	t.newAddressCallCnt++
 
-	return nil
+	return t.updateStateErr
+}
+
+// SetUpdateStateError sets the error that UpdateState returns.
+func (t *ClientConn) SetUpdateStateError(err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.updateStateErr = err
 }
 
 func (t *ClientConn) NewAddress(addrs []resolver.Address) {
@@ -69,5 +80,12 @@ func (t *ClientConn) Addrs() (addrs []resolver.Address) {
 	return t.addrs
 }
 
+func (t *ClientConn) Endpoints() []resolver.Endpoint {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	return t.endpoints
+}
+
 func (*ClientConn) NewServiceConfig(string) {
 }
@@ -11,6 +11,7 @@ type ConsulHealthClient struct {
 	entries   []*consul.ServiceEntry
 	queryMeta consul.QueryMeta
 	err       error
+	lastOpts  *consul.QueryOptions
 }
 
 func NewConsulHealthClient() *ConsulHealthClient {
@@ -36,6 +37,13 @@ func (c *ConsulHealthClient) SetRespEntries(entries []*consul.ServiceEntry) {
 	c.entries = entries
 }
 
+func (c *ConsulHealthClient) SetRespIndex(index uint64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.queryMeta.LastIndex = index
+}
+
 func (c *ConsulHealthClient) SetRespError(err error) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
@@ -51,5 +59,15 @@ func (c *ConsulHealthClient) ServiceMultipleTags(_ string, _ []string, _ bool, q
 		return nil, nil, q.Context().Err()
 	}
 
+	opts := *q
+	c.lastOpts = &opts
+
 	return c.entries, &c.queryMeta, c.err
 }
+
+func (c *ConsulHealthClient) LastQueryOptions() *consul.QueryOptions {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.lastOpts
+}
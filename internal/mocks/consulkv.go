@@ -0,0 +1,55 @@
+package mocks
+
+import (
+	"sync"
+
+	consul "github.com/hashicorp/consul/api"
+)
+
+// ConsulKVClient is a KV endpoint that stores a single key.
+// Like Consul, Get blocks until the index of the key is greater then the
+// WaitIndex of the query.
+type ConsulKVClient struct {
+	mutex   sync.Mutex
+	changed chan struct{}
+	index   uint64
+	pair    *consul.KVPair
+}
+
+func NewConsulKVClient() *ConsulKVClient {
+	return &ConsulKVClient{changed: make(chan struct{}), index: 1}
+}
+
+// Set sets the value of the key, nil deletes it.
+func (c *ConsulKVClient) Set(key string, value []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.index++
+	if value == nil {
+		c.pair = nil
+	} else {
+		c.pair = &consul.KVPair{Key: key, Value: value, ModifyIndex: c.index}
+	}
+
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *ConsulKVClient) Get(_ string, q *consul.QueryOptions) (*consul.KVPair, *consul.QueryMeta, error) {
+	for {
+		c.mutex.Lock()
+		if q.WaitIndex < c.index {
+			defer c.mutex.Unlock()
+			return c.pair, &consul.QueryMeta{LastIndex: c.index}, nil
+		}
+		changed := c.changed
+		c.mutex.Unlock()
+
+		select {
+		case <-q.Context().Done():
+			return nil, nil, q.Context().Err()
+		case <-changed:
+		}
+	}
+}
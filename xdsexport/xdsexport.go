@@ -0,0 +1,160 @@
+// Package xdsexport serves the addresses of Consul services, that are
+// watched with the consul package, via the xDS Endpoint Discovery Service
+// (EDS).
+//
+// It allows Envoy proxies and other xDS clients in a fleet to reuse the
+// service discovery of the consul package:
+//
+//	s := xdsexport.NewServer()
+//	defer s.Close()
+//
+//	if err := s.Export("user-service", "consul:///user-service"); err != nil {
+//		log.Fatal(err)
+//	}
+//
+//	srv := grpc.NewServer()
+//	s.Register(srv)
+//
+// The package is a separate Go module, to not add the dependencies of the
+// Envoy control plane to the consul package.
+package xdsexport
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/grpclog"
+
+	"github.com/simplesurance/grpcconsulresolver/consul"
+)
+
+// Server serves the addresses of exported Consul services as EDS
+// ClusterLoadAssignment resources. All xDS clients receive the same
+// resources.
+type Server struct {
+	cache  *cache.LinearCache
+	xds    server.Server
+	cancel context.CancelFunc
+
+	mu    sync.Mutex
+	stops []func()
+	wg    sync.WaitGroup
+}
+
+// NewServer returns a server without exported services.
+func NewServer() *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := cache.NewLinearCache(resource.EndpointType)
+
+	return &Server{
+		cache:  c,
+		xds:    server.NewServer(ctx, c, nil),
+		cancel: cancel,
+	}
+}
+
+// Register registers the endpoint discovery service and the aggregated
+// discovery service at srv.
+func (s *Server) Register(srv *grpc.Server) {
+	endpointservice.RegisterEndpointDiscoveryServiceServer(srv, s.xds)
+	discoverygrpc.RegisterAggregatedDiscoveryServiceServer(srv, s.xds)
+}
+
+// Export watches the service of the consul:// target and serves its
+// addresses as the ClusterLoadAssignment of cluster.
+// opts configure the resolver that watches the service.
+func (s *Server) Export(cluster, target string, opts ...consul.BuilderOption) error {
+	ch, stop, err := consul.Subscribe(target, opts...)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.stops = append(s.stops, stop)
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		for addrs := range ch {
+			cla, err := loadAssignment(cluster, addrs)
+			if err != nil {
+				grpclog.Warningf("xdsexport: ignoring addresses of cluster '%s': %v", cluster, err)
+				continue
+			}
+
+			if err := s.cache.UpdateResource(cluster, cla); err != nil {
+				grpclog.Warningf("xdsexport: updating endpoints of cluster '%s' failed: %v", cluster, err)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Close stops watching the exported services and terminates the running
+// discovery streams.
+func (s *Server) Close() {
+	s.mu.Lock()
+	stops := s.stops
+	s.stops = nil
+	s.mu.Unlock()
+
+	for _, stop := range stops {
+		stop()
+	}
+
+	s.wg.Wait()
+	s.cancel()
+}
+
+// loadAssignment returns a ClusterLoadAssignment containing addrs in the
+// form host:port as healthy endpoints.
+func loadAssignment(cluster string, addrs []string) (*endpointv3.ClusterLoadAssignment, error) {
+	endpoints := make([]*endpointv3.LbEndpoint, 0, len(addrs))
+
+	for _, addr := range addrs {
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+
+		port, err := strconv.ParseUint(portStr, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in address '%s': %w", addr, err)
+		}
+
+		endpoints = append(endpoints, &endpointv3.LbEndpoint{
+			HostIdentifier: &endpointv3.LbEndpoint_Endpoint{
+				Endpoint: &endpointv3.Endpoint{
+					Address: &corev3.Address{
+						Address: &corev3.Address_SocketAddress{
+							SocketAddress: &corev3.SocketAddress{
+								Address:       host,
+								PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: uint32(port)},
+							},
+						},
+					},
+				},
+			},
+			HealthStatus: corev3.HealthStatus_HEALTHY,
+		})
+	}
+
+	return &endpointv3.ClusterLoadAssignment{
+		ClusterName: cluster,
+		Endpoints:   []*endpointv3.LocalityLbEndpoints{{LbEndpoints: endpoints}},
+	}, nil
+}
@@ -0,0 +1,103 @@
+package xdsexport
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestExportServesEndpoints(t *testing.T) {
+	consulSrv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/user-service" {
+			http.NotFound(w, r)
+			return
+		}
+
+		w.Header().Set("X-Consul-Index", "1")
+		_, _ = w.Write([]byte(`[{"Node": {"Address": "10.0.0.1"}, "Service": {"ID": "user-service-1", "Address": "10.0.0.1", "Port": 8080}}]`))
+	}))
+	t.Cleanup(consulSrv.Close)
+
+	s := NewServer()
+	t.Cleanup(s.Close)
+
+	if err := s.Export("user-service", "consul://"+consulSrv.Listener.Addr().String()+"/user-service"); err != nil {
+		t.Fatal("Export() failed:", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(s.cache.GetResources()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("endpoints of the exported service were not added to the cache")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grpcSrv := grpc.NewServer()
+	s.Register(grpcSrv)
+	go func() { _ = grpcSrv.Serve(l) }()
+	t.Cleanup(grpcSrv.Stop)
+
+	conn, err := grpc.NewClient(l.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	stream, err := endpointservice.NewEndpointDiscoveryServiceClient(conn).StreamEndpoints(ctx)
+	if err != nil {
+		t.Fatal("StreamEndpoints() failed:", err)
+	}
+
+	err = stream.Send(&discoverygrpc.DiscoveryRequest{
+		Node:          &corev3.Node{Id: "test"},
+		TypeUrl:       resource.EndpointType,
+		ResourceNames: []string{"user-service"},
+	})
+	if err != nil {
+		t.Fatal("sending discovery request failed:", err)
+	}
+
+	resp, err := stream.Recv()
+	if err != nil {
+		t.Fatal("receiving discovery response failed:", err)
+	}
+
+	if len(resp.Resources) != 1 {
+		t.Fatalf("response contains %d resources, expected 1", len(resp.Resources))
+	}
+
+	var cla endpointv3.ClusterLoadAssignment
+	if err := resp.Resources[0].UnmarshalTo(&cla); err != nil {
+		t.Fatal("unmarshaling ClusterLoadAssignment failed:", err)
+	}
+
+	eps := cla.GetEndpoints()[0].GetLbEndpoints()
+	if len(eps) != 1 {
+		t.Fatalf("ClusterLoadAssignment contains %d endpoints, expected 1", len(eps))
+	}
+
+	addr := eps[0].GetEndpoint().GetAddress().GetSocketAddress()
+	if addr.GetAddress() != "10.0.0.1" || addr.GetPortValue() != 8080 {
+		t.Errorf("endpoint address is %s:%d, expected 10.0.0.1:8080", addr.GetAddress(), addr.GetPortValue())
+	}
+}